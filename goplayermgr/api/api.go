@@ -1,10 +1,14 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	ginprometheus "github.com/zsais/go-gin-prometheus"
@@ -16,9 +20,18 @@ import (
 var playerDB *gorm.DB
 var playerDSN string
 
-// Start HTTP server
+// soft deleted players and bots older than retention are purged
+var PurgeRetention = 30 * 24 * time.Hour
+
+// Start HTTP server, JobWorkers job workers, tournament scheduler and debug session janitor
 func Serve(dsn string) {
 	router := BuildRouter(dsn)
+	rankSuites(playerDB)
+	if JobWorkers > 0 {
+		go NewJobPool(playerDB, JobWorkers).Run(context.Background())
+	}
+	go ScheduleTournaments(context.Background(), playerDB)
+	go ExpireDebugSessions(context.Background())
 
 	router.Run(":8081") // listen and serve on 0.0.0.0:8081
 }
@@ -27,6 +40,7 @@ func BuildRouter(dsn string) *gin.Engine {
 	engine := gin.New()
 	engine.Use(gin.Logger())
 	engine.Use(gin.Recovery())
+	engine.Use(requestId())
 
 	// add prometheus exporter to gin router
 	prom := ginprometheus.NewPrometheus("gin")
@@ -37,10 +51,28 @@ func BuildRouter(dsn string) *gin.Engine {
 				url = strings.Replace(url, p.Value, ":playerid", 1)
 			} else if p.Key == "botid" {
 				url = strings.Replace(url, p.Value, ":botid", 1)
+			} else if p.Key == "gameid" {
+				url = strings.Replace(url, p.Value, ":gameid", 1)
+			} else if p.Key == "sessionid" {
+				url = strings.Replace(url, p.Value, ":sessionid", 1)
+			} else if p.Key == "evaluationid" {
+				url = strings.Replace(url, p.Value, ":evaluationid", 1)
+			} else if p.Key == "jobid" {
+				url = strings.Replace(url, p.Value, ":jobid", 1)
+			} else if p.Key == "mazehash" {
+				url = strings.Replace(url, p.Value, ":mazehash", 1)
+			} else if p.Key == "tournamentid" {
+				url = strings.Replace(url, p.Value, ":tournamentid", 1)
+			} else if p.Key == "raceid" {
+				url = strings.Replace(url, p.Value, ":raceid", 1)
 			}
 		}
 		return url
 	}
+	if MetricsPort > 0 {
+		// expose metrics on a dedicated port
+		prom.SetListenAddress(fmt.Sprintf(":%d", MetricsPort))
+	}
 	prom.Use(engine)
 
 	// connect to Database
@@ -49,6 +81,16 @@ func BuildRouter(dsn string) *gin.Engine {
 
 	apigroup := engine.Group("/api")
 	addRoutes(apigroup)
+	addAuditRoutes(apigroup)
+	addRemoteRoutes(apigroup)
+	addGameRoutes(apigroup)
+	addDebugRoutes(apigroup)
+	addEvaluationRoutes(apigroup)
+	addJobRoutes(apigroup)
+	addLeaderboardRoutes(apigroup)
+	addRatingRoutes(apigroup)
+	addTournamentRoutes(apigroup)
+	addRaceRoutes(apigroup)
 
 	engine.GET("/info", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "UP"})
@@ -64,9 +106,17 @@ type AddPlayerBody struct {
 type AddBotBody struct {
 	Name     string `json:"name" binding:"required"`
 	Filename string `json:"filename" binding:"required"`
+	Language string `json:"language"`
 	Botcode  string `json:"botcode" binding:"required"`
 }
 
+type UpdateBotBody struct {
+	Name     string `json:"name"`
+	Filename string `json:"filename"`
+	Language string `json:"language"`
+	Botcode  string `json:"botcode"`
+}
+
 func addRoutes(rg *gin.RouterGroup) {
 
 	rg.GET("/players", func(c *gin.Context) {
@@ -100,6 +150,10 @@ func addRoutes(rg *gin.RouterGroup) {
 		playername := GetUserName(c.Request)
 
 		player := model.GetPlayerByName(playerDB, playername)
+		if player == nil && model.IsPlayerDeleted(playerDB, playername) {
+			c.JSON(410, gin.H{"error": "player is deleted"})
+			return
+		}
 		if player == nil {
 			c.JSON(500, "")
 			return
@@ -129,6 +183,7 @@ func addRoutes(rg *gin.RouterGroup) {
 			c.JSON(500, "[]")
 			return
 		}
+		recordAudit(c, model.AuditPlayerCreate, player.Pid, 0, nil, player)
 		c.JSON(200, player)
 	})
 
@@ -153,6 +208,9 @@ func addRoutes(rg *gin.RouterGroup) {
 			c.JSON(500, "")
 			return
 		}
+		if notModified(c, player.Version) {
+			return
+		}
 		c.JSON(200, player)
 	})
 
@@ -171,11 +229,62 @@ func addRoutes(rg *gin.RouterGroup) {
 			c.JSON(500, "")
 			return
 		}
-		player := model.DeletePlayer(playerDB, int32(pid))
+		// keep player and its bots to trace deletion
+		before := model.GetPlayer(playerDB, int32(pid))
+		version, ok := ifMatch(c, currentVersion(before))
+		if !ok {
+			return
+		}
+
+		if c.Query("hard") == "true" {
+			if before != nil && version != model.AnyVersion && version != before.Version {
+				c.JSON(412, "")
+				return
+			}
+			player := model.PurgePlayer(playerDB, int32(pid))
+			if player == nil {
+				c.JSON(500, "")
+				return
+			}
+			recordAudit(c, model.AuditPlayerPurge, player.Pid, 0, before, nil)
+			c.JSON(200, player)
+			return
+		}
+
+		player, err := model.DeletePlayerVersion(playerDB, int32(pid), version)
 		if player == nil {
+			conditionalError(c, err)
+			return
+		}
+		recordAudit(c, model.AuditPlayerDelete, player.Pid, 0, before, nil)
+		c.JSON(200, player)
+	})
+
+	rg.POST("/players/:playerid/restore", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.admin")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, err := strconv.ParseInt(c.Param("playerid"), 10, 32)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/restore: %v\n", err)
 			c.JSON(500, "")
 			return
 		}
+		version, ok := ifMatch(c, currentVersion(model.GetDeletedPlayer(playerDB, int32(pid))))
+		if !ok {
+			return
+		}
+		player, err := model.RestorePlayerVersion(playerDB, int32(pid), version)
+		if player == nil {
+			conditionalError(c, err)
+			return
+		}
+		recordAudit(c, model.AuditPlayerRestore, player.Pid, 0, nil, player)
 		c.JSON(200, player)
 	})
 
@@ -219,18 +328,35 @@ func addRoutes(rg *gin.RouterGroup) {
 		}
 
 		var body AddBotBody
-		err = c.BindJSON(&body)
+		if c.ContentType() == WasmContentType {
+			err = bindWasmBody(c, &body)
+		} else {
+			err = c.BindJSON(&body)
+		}
+		if errors.Is(err, errBodyTooLarge) {
+			c.JSON(413, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			log.Printf("Error in POST /players/:playerid/bot: %v\n", err)
 			c.JSON(500, "")
 			return
 		}
+		if body.Language == "" {
+			body.Language = model.LanguageJavaScript
+		}
+
+		if !validBotCode(c, body.Language, body.Filename, body.Botcode) {
+			return
+		}
 
-		bots := model.AddBot(playerDB, int32(pid), body.Name, body.Filename, body.Botcode)
+		bots := model.AddBotWithLanguage(playerDB, int32(pid), body.Name, body.Filename, body.Language, body.Botcode)
 		if bots == nil {
 			c.JSON(500, "")
 			return
 		}
+		recordBotUpload(body.Botcode)
+		recordAudit(c, model.AuditBotCreate, int32(pid), bots.Bid, nil, bots)
 		c.JSON(200, bots)
 	})
 
@@ -263,6 +389,9 @@ func addRoutes(rg *gin.RouterGroup) {
 			c.JSON(500, "")
 			return
 		}
+		if notModified(c, bot.Version) {
+			return
+		}
 		c.JSON(200, bot)
 	})
 
@@ -290,11 +419,91 @@ func addRoutes(rg *gin.RouterGroup) {
 			return
 		}
 
+		// check version before loading code
+		info := model.GetBot(playerDB, int32(pid), int32(bid))
+		if info == nil {
+			c.JSON(500, "")
+			return
+		}
+		if notModified(c, info.Version) {
+			return
+		}
+
 		bot := model.GetBotCode(playerDB, int32(pid), int32(bid))
 		if bot == nil {
 			c.JSON(500, "")
 			return
 		}
+		c.Header("ETag", etag(bot.Version))
+		c.JSON(200, bot)
+	})
+
+	rg.PUT("/players/:playerid/bot/:botid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		pid, err := strconv.ParseInt(c.Param("playerid"), 10, 32)
+		if err != nil {
+			log.Printf("Error in PUT /players/:playerid/bot/:botid: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+
+		bid, err := strconv.ParseInt(c.Param("botid"), 10, 32)
+		if err != nil {
+			log.Printf("Error in PUT /players/:playerid/bot/:botid: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+
+		before := model.GetBot(playerDB, int32(pid), int32(bid))
+		version, ok := ifMatch(c, currentVersion(before))
+		if !ok {
+			return
+		}
+
+		var body UpdateBotBody
+		err = c.BindJSON(&body)
+		if err != nil {
+			log.Printf("Error in PUT /players/:playerid/bot/:botid: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+
+		if before == nil {
+			c.JSON(500, "")
+			return
+		}
+
+		// code is checked with its language, language cannot change without code
+		if body.Language != "" && body.Language != before.Language && body.Botcode == "" {
+			c.JSON(400, "language cannot change without code")
+			return
+		}
+		language := body.Language
+		if language == "" {
+			language = before.Language
+		}
+		if body.Botcode != "" && !validBotCode(c, language, body.Filename, body.Botcode) {
+			return
+		}
+
+		bot, err := model.UpdateBot(playerDB, int32(pid), int32(bid), version, body.Name, body.Filename, body.Language, body.Botcode)
+		if bot == nil {
+			conditionalError(c, err)
+			return
+		}
+		if body.Botcode != "" {
+			recordBotUpload(body.Botcode)
+		}
+		recordAudit(c, model.AuditBotUpdate, int32(pid), bot.Bid, before, bot)
+		c.Header("ETag", etag(bot.Version))
 		c.JSON(200, bot)
 	})
 
@@ -322,12 +531,106 @@ func addRoutes(rg *gin.RouterGroup) {
 			return
 		}
 
-		bot := model.DeleteBot(playerDB, int32(pid), int32(int32(bid)))
+		before := model.GetBot(playerDB, int32(pid), int32(bid))
+		version, ok := ifMatch(c, currentVersion(before))
+		if !ok {
+			return
+		}
+
+		if c.Query("hard") == "true" {
+			// permanent deletion is reserved to admin
+			if !CheckRole(c.Request, "player.admin") {
+				c.String(401, "unauthorized")
+				return
+			}
+			if before != nil && version != model.AnyVersion && version != before.Version {
+				c.JSON(412, "")
+				return
+			}
+			bot := model.PurgeBot(playerDB, int32(pid), int32(bid))
+			if bot == nil {
+				c.JSON(500, "")
+				return
+			}
+			recordAudit(c, model.AuditBotPurge, int32(pid), bot.Bid, before, nil)
+			c.JSON(200, bot)
+			return
+		}
+
+		bot, err := model.DeleteBotVersion(playerDB, int32(pid), int32(bid), version)
 		if bot == nil {
+			conditionalError(c, err)
+			return
+		}
+		recordAudit(c, model.AuditBotDelete, int32(pid), bot.Bid, before, nil)
+		c.JSON(200, bot)
+	})
+
+	rg.POST("/players/:playerid/bot/:botid/restore", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		pid, err := strconv.ParseInt(c.Param("playerid"), 10, 32)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/restore: %v\n", err)
 			c.JSON(500, "")
 			return
 		}
+
+		bid, err := strconv.ParseInt(c.Param("botid"), 10, 32)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/restore: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+
+		version, ok := ifMatch(c, currentVersion(model.GetDeletedBot(playerDB, int32(pid), int32(bid))))
+		if !ok {
+			return
+		}
+		bot, err := model.RestoreBotVersion(playerDB, int32(pid), int32(bid), version)
+		if bot == nil {
+			conditionalError(c, err)
+			return
+		}
+		recordAudit(c, model.AuditBotRestore, int32(pid), bot.Bid, nil, bot)
 		c.JSON(200, bot)
 	})
 
+	rg.POST("/purge", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.admin")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		retention := PurgeRetention
+		if v := c.Query("retention"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				log.Printf("Error in POST /purge: %v\n", err)
+				c.JSON(400, "")
+				return
+			}
+			retention = d
+		}
+
+		purged := model.PurgeDeleted(playerDB, time.Now().Add(-retention))
+		if purged == nil {
+			c.JSON(500, "")
+			return
+		}
+		recordAudit(c, model.AuditPurgeDeleted, 0, 0, nil, purged)
+		c.JSON(200, purged)
+	})
+
 }
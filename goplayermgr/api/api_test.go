@@ -1,11 +1,17 @@
 package api_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +21,8 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"jc.org/playermgr/api"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/maze"
 	"jc.org/playermgr/model"
 )
 
@@ -50,6 +58,33 @@ func createToken(user string) string {
 	return fmt.Sprintf("Bearer %v", token)
 }
 
+// token of user with only roles
+func createUserToken(user string, roles ...string) string {
+	t := jwt.New(jwt.SigningMethodHS256)
+	t.Claims = &api.KeycloakClaim{
+		&jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Second * 60).Unix(),
+		},
+		[]string{""},
+		user,
+		api.KCRoles{Roles: []string{}},
+		map[string]api.KCRoles{
+			"playermgr": {Roles: roles},
+		},
+	}
+	token, _ := t.SignedString(api.TokenSigningKey)
+	return fmt.Sprintf("Bearer %v", token)
+}
+
+// send request with authorization bearer to router
+func send(method string, url string, bearer string, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Add("Authorization", bearer)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
 func init() {
 	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
 	log.SetLevel(log.DebugLevel)
@@ -69,6 +104,7 @@ func init() {
 	// force debug during unit test
 	gin.SetMode(gin.DebugMode)
 	router = api.BuildRouter(InMemoryDSN)
+	api.MazeDir = "../data/mazes"
 }
 
 func TestStatus(t *testing.T) {
@@ -372,6 +408,7 @@ func TestDelete(t *testing.T) {
 	// delete one bot
 	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v/bot/3", p.Pid), nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp := httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -388,6 +425,7 @@ func TestDelete(t *testing.T) {
 	// delete one player
 	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v", p.Pid), nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -406,6 +444,7 @@ func TestDeleteError(t *testing.T) {
 	// delete non existing player
 	req, _ := http.NewRequest("DELETE", "/api/players/1234", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp := httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -415,6 +454,7 @@ func TestDeleteError(t *testing.T) {
 	// delete non existing player
 	req, _ = http.NewRequest("DELETE", "/api/players/foo", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -424,6 +464,7 @@ func TestDeleteError(t *testing.T) {
 	// delete non existing bot
 	req, _ = http.NewRequest("DELETE", "/api/players/1/bot/1234", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -432,6 +473,7 @@ func TestDeleteError(t *testing.T) {
 
 	req, _ = http.NewRequest("DELETE", "/api/players/1/bot/foo", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -440,6 +482,7 @@ func TestDeleteError(t *testing.T) {
 
 	req, _ = http.NewRequest("DELETE", "/api/players/1234/bot/1", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -448,6 +491,7 @@ func TestDeleteError(t *testing.T) {
 
 	req, _ = http.NewRequest("DELETE", "/api/players/foo/bot/1", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -456,6 +500,7 @@ func TestDeleteError(t *testing.T) {
 
 	req, _ = http.NewRequest("DELETE", "/api/players/2/bot/2", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -480,6 +525,7 @@ func TestDeleteError(t *testing.T) {
 
 	req, _ = http.NewRequest("DELETE", "/api/players/1/bot/2", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -488,6 +534,7 @@ func TestDeleteError(t *testing.T) {
 
 	req, _ = http.NewRequest("DELETE", "/api/players/1/bot/2", nil)
 	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
 	resp = httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -495,6 +542,351 @@ func TestDeleteError(t *testing.T) {
 	assert.Equal(t, httpStatus, 500)
 }
 
+func TestRestore(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "Restored")
+	b := model.AddBot(db, p.Pid, "RestoredBot", "botfile.js", "// some code")
+
+	// delete then restore bot
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v/bot/%v", p.Pid, b.Bid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/%v/restore", p.Pid, b.Bid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/%v/restore", p.Pid, b.Bid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 500, resp.Code)
+
+	// delete then restore player
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 500, resp.Code)
+
+	// a deleted player is not created again by my info
+	req, _ = http.NewRequest("GET", "/api/players/my/info", nil)
+	req.Header.Add("Authorization", createUserToken("Restored", "player.view"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 410, resp.Code)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/restore", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("GET", "/api/players/my/info", nil)
+	req.Header.Add("Authorization", createUserToken("Restored", "player.view"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	var bots []Bot
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	json.Unmarshal(resp.Body.Bytes(), &bots)
+	assert.Equal(t, 1, len(bots))
+
+	// hard delete
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v/bot/%v?hard=true", p.Pid, b.Bid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v?hard=true", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/restore", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 500, resp.Code)
+
+	// purge
+	req, _ = http.NewRequest("POST", "/api/purge?retention=0s", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("POST", "/api/purge?retention=never", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+}
+
+func TestETag(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "Versioned")
+	b := model.AddBot(db, p.Pid, "VersionedBot", "botfile.js", "// some code")
+	botURL := fmt.Sprintf("/api/players/%v/bot/%v", p.Pid, b.Bid)
+
+	// poll code
+	req, _ := http.NewRequest("GET", botURL+"/code", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	tag := resp.Header().Get("ETag")
+	assert.Equal(t, "\"1\"", tag)
+
+	req, _ = http.NewRequest("GET", botURL+"/code", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-None-Match", tag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 304, resp.Code)
+	assert.Equal(t, 0, resp.Body.Len())
+
+	// update requires If-Match
+	body := `{"botcode": "function executeStep(room) { return {}; }"}`
+	req, _ = http.NewRequest("PUT", botURL, strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 428, resp.Code)
+
+	req, _ = http.NewRequest("PUT", botURL, strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", tag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	newTag := resp.Header().Get("ETag")
+	assert.Equal(t, "\"2\"", newTag)
+
+	// second editor still has old version
+	req, _ = http.NewRequest("PUT", botURL, strings.NewReader(`{"name": "Conflict"}`))
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", tag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 412, resp.Code)
+
+	req, _ = http.NewRequest("GET", botURL+"/code", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-None-Match", tag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	var botc BotCode
+	json.Unmarshal(resp.Body.Bytes(), &botc)
+	assert.Equal(t, "function executeStep(room) { return {}; }", botc.Botcode)
+
+	// player version changes with its bots
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-None-Match", "\"1\"")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	playerTag := resp.Header().Get("ETag")
+
+	// delete with stale version
+	req, _ = http.NewRequest("DELETE", botURL, nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", tag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 412, resp.Code)
+
+	req, _ = http.NewRequest("DELETE", botURL, nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", newTag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", playerTag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 412, resp.Code)
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "not a tag")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 412, resp.Code)
+
+	// every change requires If-Match
+	for _, method := range []string{"DELETE", "POST"} {
+		url := fmt.Sprintf("/api/players/%v", p.Pid)
+		if method == "POST" {
+			url = botURL + "/restore"
+		}
+		req, _ = http.NewRequest(method, url, nil)
+		req.Header.Add("Authorization", bearerFullRight)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, 428, resp.Code, method)
+	}
+
+	// a list matches one of its versions, deleted bot is at version 3
+	req, _ = http.NewRequest("POST", botURL+"/restore", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", `"2", "4"`)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 412, resp.Code)
+
+	req, _ = http.NewRequest("POST", botURL+"/restore", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", `"2", "3"`)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"version":4`)
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+}
+
+type AuditEntry struct {
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	PlayerId  int64  `json:"player_id"`
+	BotId     int64  `json:"bot_id"`
+	Before    string `json:"before"`
+	RequestId string `json:"request_id"`
+}
+
+func TestAudit(t *testing.T) {
+	// deletion of a player is traced with its bots
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "Audited")
+	model.AddBot(db, p.Pid, "AuditedBot", "botfile.js", "// some code")
+
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/players/%v", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("If-Match", "*")
+	req.Header.Add("X-Request-Id", "req-audit-1")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "req-audit-1", resp.Header().Get("X-Request-Id"))
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/audit?action=player.delete&playerid=%v", p.Pid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	var entries []AuditEntry
+	json.Unmarshal(resp.Body.Bytes(), &entries)
+	if len(entries) == 0 {
+		t.Fatalf("expected audit entries got \"%s\"", resp.Body.String())
+	}
+	assert.Equal(t, "Joe", entries[0].Actor)
+	assert.Equal(t, "req-audit-1", entries[0].RequestId)
+	assert.Contains(t, entries[0].Before, "AuditedBot")
+
+	// bad filter
+	req, _ = http.NewRequest("GET", "/api/audit?since=yesterday", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+
+	// audit is restricted to admin
+	req, _ = http.NewRequest("GET", "/api/audit", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 401, resp.Code)
+}
+
+func TestMetrics(t *testing.T) {
+	// request without token
+	req, _ := http.NewRequest("GET", "/api/players", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 401, resp.Code)
+
+	// request with token signed with another key
+	t2 := jwt.New(jwt.SigningMethodHS256)
+	t2.Claims = &api.KeycloakClaim{StandardClaims: &jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Second * 60).Unix()}}
+	badToken, _ := t2.SignedString([]byte("another signing key"))
+	req, _ = http.NewRequest("GET", "/api/players", nil)
+	req.Header.Add("Authorization", "Bearer "+badToken)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 401, resp.Code)
+
+	// request with expired token
+	t3 := jwt.New(jwt.SigningMethodHS256)
+	t3.Claims = &api.KeycloakClaim{StandardClaims: &jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Second * 60).Unix()}}
+	expiredToken, _ := t3.SignedString(api.TokenSigningKey)
+	req, _ = http.NewRequest("GET", "/api/players", nil)
+	req.Header.Add("Authorization", "Bearer "+expiredToken)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 401, resp.Code)
+
+	// request with valid token without role
+	t4 := jwt.New(jwt.SigningMethodHS256)
+	t4.Claims = &api.KeycloakClaim{StandardClaims: &jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Second * 60).Unix()}}
+	noRoleToken, _ := t4.SignedString(api.TokenSigningKey)
+	req, _ = http.NewRequest("GET", "/api/players", nil)
+	req.Header.Add("Authorization", "Bearer "+noRoleToken)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 401, resp.Code)
+
+	// read metrics
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	metrics := resp.Body.String()
+	for _, reason := range []string{api.AuthMissingToken, api.AuthBadSignature, api.AuthExpired, api.AuthMissingRole} {
+		if !strings.Contains(metrics, fmt.Sprintf("playermgr_auth_failures_total{reason=\"%v\"}", reason)) {
+			t.Errorf("missing auth failure metric for %v", reason)
+		}
+	}
+	assert.Contains(t, metrics, "playermgr_players ")
+	assert.Contains(t, metrics, "playermgr_bots ")
+	assert.Contains(t, metrics, "playermgr_db_query_duration_seconds_bucket{operation=\"query\"")
+}
+
 func TestCreate(t *testing.T) {
 
 }
@@ -502,3 +894,779 @@ func TestCreate(t *testing.T) {
 func TestCreateError(t *testing.T) {
 
 }
+
+func TestBotValidation(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "Validated")
+	botURL := fmt.Sprintf("/api/players/%v/bot", p.Pid)
+
+	body := `{"name": "Broken", "filename": "broken.js", "botcode": "function executeStep(room) {\n  return {"}`
+	req, _ := http.NewRequest("POST", botURL, strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+
+	var validation engine.Validation
+	json.Unmarshal(resp.Body.Bytes(), &validation)
+	assert.False(t, validation.Valid)
+	if assert.Len(t, validation.Diagnostics, 1) {
+		assert.Equal(t, engine.DiagSyntax, validation.Diagnostics[0].Code)
+		assert.Equal(t, 2, validation.Diagnostics[0].Line)
+	}
+
+	body = `{"name": "NoMain", "filename": "nomain.js", "botcode": "// some code"}`
+	req, _ = http.NewRequest("POST", botURL, strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+	assert.Contains(t, resp.Body.String(), engine.DiagNoEntryPoint)
+
+	body = `{"name": "Valid", "filename": "valid.js", "botcode": "function executeStep(room) { return {action: 'move', direction: 'right'}; }"}`
+	req, _ = http.NewRequest("POST", botURL, strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+}
+
+func TestWasmUpload(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "WasmPlayer")
+
+	// module exporting execute_step always moving right
+	module, _ := hex.DecodeString("0061736d0100000001060160017f017f030201000710010c657865637574655f73746570000" +
+		"00a0601040041020b")
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot?name=Right&filename=right.wasm", p.Pid), bytes.NewReader(module))
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("Content-Type", api.WasmContentType)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	var bot BotCode
+	json.Unmarshal(resp.Body.Bytes(), &bot)
+	code := model.GetBotCode(db, p.Pid, int32(bot.Bid))
+	if assert.NotNil(t, code) {
+		assert.Equal(t, model.LanguageWasm, code.Language)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(module), code.Botcode)
+	}
+
+	// JavaScript is not a module
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot?name=Js&filename=js.wasm", p.Pid), strings.NewReader("function executeStep(room) {}"))
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("Content-Type", api.WasmContentType)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+	assert.Contains(t, resp.Body.String(), engine.DiagLoad)
+
+	// upload is not read past maximum size
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot?name=Big&filename=big.wasm", p.Pid), bytes.NewReader(make([]byte, engine.MaxWasmSize+2)))
+	req.Header.Add("Authorization", bearerFullRight)
+	req.Header.Add("Content-Type", api.WasmContentType)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 413, resp.Code)
+}
+
+func TestRemoteBot(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "RemotePlayer")
+	secret := "a secret of the bot"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(engine.SignatureHeader) != engine.Sign(secret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"action": "move", "direction": "right"}`))
+	}))
+	defer server.Close()
+
+	// test server is on loopback
+	body := fmt.Sprintf(`{"url": "%v", "secret": "%v"}`, server.URL, secret)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/remote/check", p.Pid), strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	var validation engine.Validation
+	json.Unmarshal(resp.Body.Bytes(), &validation)
+	if assert.False(t, validation.Valid) {
+		assert.Equal(t, engine.DiagUnreachable, validation.Diagnostics[0].Code)
+	}
+	engine.AllowPrivateRemotes = true
+	defer func() { engine.AllowPrivateRemotes = false }()
+
+	// only owner checks a bot of a player
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/remote/check", p.Pid), strings.NewReader(body))
+	req.Header.Add("Authorization", createUserToken("Someone", "player.edit"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 403, resp.Code)
+
+	body = fmt.Sprintf(`{"url": "%v", "secret": "not the secret of the bot"}`, server.URL)
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/remote/check", p.Pid), strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	json.Unmarshal(resp.Body.Bytes(), &validation)
+	assert.False(t, validation.Valid)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/remote", p.Pid), strings.NewReader(`{"name": "Remote", `+body[1:]))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+
+	body = fmt.Sprintf(`{"name": "Remote", "url": "%v", "secret": "%v"}`, server.URL, secret)
+
+	// only owner registers a bot of a player
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/remote", p.Pid), strings.NewReader(body))
+	req.Header.Add("Authorization", createUserToken("Someone", "player.edit"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 403, resp.Code)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/remote", p.Pid), strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	var bot BotCode
+	json.Unmarshal(resp.Body.Bytes(), &bot)
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/code", p.Pid, bot.Bid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), server.URL)
+	assert.NotContains(t, resp.Body.String(), secret)
+}
+
+func TestGames(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "GamePlayer")
+	b := model.AddBot(db, p.Pid, "Right", "right.js", "function executeStep(room) { return { action: 'move', direction: 'right' }; }")
+
+	body := `{"maze_name": "corridor", "maze": ["+-+-+", "x   X", "+-+-+"], "seed": 3}`
+	model.UpdateBot(db, p.Pid, b.Bid, model.AnyVersion, "", "", "", "console.info('ready'); function executeStep(room) { console.warn(room.right); return { action: 'move', direction: 'right' }; }")
+	b.Version++
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/%v/games", p.Pid, b.Bid), strings.NewReader(body))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	var game model.Game
+	json.Unmarshal(resp.Body.Bytes(), &game)
+	assert.Equal(t, "success", game.State)
+	assert.Equal(t, 2, game.Steps)
+	assert.Equal(t, "corridor", game.MazeName)
+
+	// only owner plays games of a bot
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/%v/games", p.Pid, b.Bid), strings.NewReader(body))
+	req.Header.Add("Authorization", createUserToken("Someone", "player.edit"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 403, resp.Code)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/players/%v/bot/%v/games", p.Pid, b.Bid), strings.NewReader(`{"maze": ["+-+", "x |", "+-+"]}`))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games", p.Pid, b.Bid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	var games []model.Game
+	json.Unmarshal(resp.Body.Bytes(), &games)
+	assert.Equal(t, 1, len(games))
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/replay", p.Pid, b.Bid, game.Gid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), fmt.Sprintf("player%v_bot%v_game%v.json", p.Pid, b.Bid, game.Gid))
+	replay, err := engine.ReadReplay(resp.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, b.Version, replay.BotVersion)
+	assert.Equal(t, int64(3), replay.Seed)
+	assert.Equal(t, 2, len(replay.Steps))
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/console", p.Pid, b.Bid, game.Gid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	var console engine.Console
+	json.Unmarshal(resp.Body.Bytes(), &console)
+	assert.Equal(t, []engine.ConsoleEntry{
+		{Step: 0, Level: "info", Message: "ready"},
+		{Step: 1, Level: "warn", Message: "door"},
+		{Step: 2, Level: "warn", Message: "exit"},
+	}, console.Entries)
+
+	// only owner and administrators can read console
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/console", p.Pid, b.Bid, game.Gid), nil)
+	req.Header.Add("Authorization", createUserToken("Someone", "player.view"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 403, resp.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/console", p.Pid, b.Bid, game.Gid), nil)
+	req.Header.Add("Authorization", createUserToken("GamePlayer", "player.view"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/render?format=svg", p.Pid, b.Bid, game.Gid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "image/svg+xml", resp.Header().Get("Content-Type"))
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/render", p.Pid, b.Bid, game.Gid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.True(t, strings.HasPrefix(resp.Body.String(), "GIF89a"))
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/render?format=bmp", p.Pid, b.Bid, game.Gid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 400, resp.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/players/%v/bot/%v/games/%v", p.Pid, b.Bid, game.Gid+1), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestDebugSession(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "DebugPlayer")
+	b := model.AddBot(db, p.Pid, "Logger", "logger.js", "let n = 0; function executeStep(room) { console.log('step', ++n); return { action: 'move', direction: 'right' }; }")
+	path := fmt.Sprintf("/api/players/%v/bot/%v/debug", p.Pid, b.Bid)
+
+	debug := func(method string, url string, body string) (int, api.DebugState) {
+		resp := send(method, url, bearerFullRight, body)
+		var state api.DebugState
+		json.Unmarshal(resp.Body.Bytes(), &state)
+		return resp.Code, state
+	}
+
+	code, state := debug("POST", path, `{"maze": ["+-+-+-+-+", "x       X", "+-+-+-+-+"]}`)
+	assert.Equal(t, 201, code)
+	assert.Equal(t, api.DebugRunning, state.State)
+	assert.Equal(t, 0, state.Step)
+	assert.Equal(t, 16, state.MaxSteps)
+	assert.Equal(t, "entry", string(state.Room.Left))
+	session := path + "/" + state.Id
+
+	code, state = debug("POST", session+"/step", "")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 1, state.Step)
+	assert.Equal(t, 1, state.Position.Column)
+	assert.Equal(t, []engine.ConsoleEntry{{Step: 1, Level: "log", Message: "step 1"}}, state.Console.Entries)
+
+	code, state = debug("POST", session+"/run?to=3", "")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 3, state.Step)
+	assert.Equal(t, 3, len(state.Visited))
+	assert.Equal(t, api.DebugRunning, state.State)
+
+	code, _ = debug("POST", session+"/run?to=end", "")
+	assert.Equal(t, 400, code)
+
+	code, state = debug("POST", session+"/continue", "")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "success", state.State)
+	assert.Equal(t, 4, state.Result.Steps)
+
+	code, _ = debug("GET", fmt.Sprintf("/api/players/%v/bot/%v/debug/%v", p.Pid, b.Bid+1, state.Id), "")
+	assert.Equal(t, 404, code)
+
+	code, _ = debug("DELETE", session, "")
+	assert.Equal(t, 200, code)
+	code, _ = debug("GET", session, "")
+	assert.Equal(t, 404, code)
+
+	// inactive sessions expire
+	code, state = debug("POST", path, `{"maze": ["+-+-+-+-+", "x       X", "+-+-+-+-+"]}`)
+	assert.Equal(t, 201, code)
+	timeout := api.DebugSessionTimeout
+	api.DebugSessionTimeout = 0
+	code, _ = debug("GET", path+"/"+state.Id, "")
+	api.DebugSessionTimeout = timeout
+	assert.Equal(t, 404, code)
+
+	// number of sessions is limited
+	maxSessions := api.MaxDebugSessions
+	api.MaxDebugSessions = 1
+	defer func() { api.MaxDebugSessions = maxSessions }()
+	code, state = debug("POST", path, `{"maze": ["+-+-+-+-+", "x       X", "+-+-+-+-+"]}`)
+	assert.Equal(t, 201, code)
+	code, _ = debug("POST", path, `{"maze": ["+-+-+-+-+", "x       X", "+-+-+-+-+"]}`)
+	assert.Equal(t, 429, code)
+
+	// janitor closes expired sessions without waiting for a request
+	interval := api.DebugJanitorInterval
+	api.DebugJanitorInterval = time.Millisecond
+	api.DebugSessionTimeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		api.ExpireDebugSessions(ctx)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+	api.DebugSessionTimeout = timeout
+	api.DebugJanitorInterval = interval
+	code, _ = debug("GET", path+"/"+state.Id, "")
+	assert.Equal(t, 404, code)
+	code, _ = debug("POST", path, `{"maze": ["+-+-+-+-+", "x       X", "+-+-+-+-+"]}`)
+	assert.Equal(t, 201, code)
+}
+
+func TestEvaluations(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "EvalPlayer")
+	code, _ := ioutil.ReadFile("../data/bots/bot3.js")
+	b := model.AddBot(db, p.Pid, "Follower", "bot3.js", string(code))
+	path := fmt.Sprintf("/api/players/%v/bot/%v/evaluations", p.Pid, b.Bid)
+
+	req, _ := http.NewRequest("GET", "/api/suites", nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, `["basic"]`, resp.Body.String())
+
+	// only owner evaluates a bot
+	req, _ = http.NewRequest("POST", path, strings.NewReader(`{"suite": "basic", "workers": 2}`))
+	req.Header.Add("Authorization", createUserToken("Someone", "player.edit"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 403, resp.Code)
+
+	req, _ = http.NewRequest("POST", path, strings.NewReader(`{"suite": "basic", "workers": 2}`))
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 202, resp.Code)
+	var job model.Job
+	json.Unmarshal(resp.Body.Bytes(), &job)
+	assert.Equal(t, fmt.Sprintf("/api/jobs/%v", job.Jid), resp.Header().Get("Location"))
+	assert.Equal(t, model.JobQueued, job.State)
+
+	// evaluation is done by a worker
+	api.NewJobPool(db, 1).RunPending(context.Background())
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/jobs/%v", job.Jid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	json.Unmarshal(resp.Body.Bytes(), &job)
+	assert.Equal(t, model.JobSucceeded, job.State)
+	assert.Equal(t, 5, job.Done)
+	assert.Equal(t, 5, job.Total)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/jobs/%v/result", job.Jid), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	var result api.EvaluationResult
+	json.Unmarshal(resp.Body.Bytes(), &result)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("%v/%v", path, result.EvaluationId), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	var report struct {
+		model.Evaluation
+		Report engine.Evaluation `json:"report"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &report)
+	assert.Equal(t, "basic", report.Suite)
+	assert.Equal(t, 5, report.Report.Mazes)
+	assert.Equal(t, 1.0, report.Report.SuccessRate)
+	assert.Equal(t, 5, len(model.GetGames(db, p.Pid, b.Bid)))
+	assert.NotZero(t, report.Report.Results[0].GameId)
+
+	req, _ = http.NewRequest("GET", path, nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	var evaluations []model.Evaluation
+	json.Unmarshal(resp.Body.Bytes(), &evaluations)
+	assert.Equal(t, 1, len(evaluations))
+
+	// evaluation of a worker which lost its job stores nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	suite, _ := maze.FindSuite(api.MazeDir, "basic")
+	_, _, err := engine.EvaluateBot(db, p.Pid, b.Bid, suite, engine.EvalOptions{Context: ctx})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 5, len(model.GetGames(db, p.Pid, b.Bid)))
+	assert.Equal(t, 1, len(model.GetEvaluations(db, p.Pid, b.Bid)))
+
+	for _, suite := range []string{"unknown", "../mazes"} {
+		req, _ = http.NewRequest("POST", path, strings.NewReader(fmt.Sprintf(`{"suite": %q}`, suite)))
+		req.Header.Add("Authorization", bearerFullRight)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, 404, resp.Code)
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("%v/%v", path, report.Eid+1), nil)
+	req.Header.Add("Authorization", bearerFullRight)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestJobs(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "JobPlayer")
+	b := model.AddBot(db, p.Pid, "Right", "right.js", "function executeStep(room) { return { action: 'move', direction: 'right' }; }")
+	pool := api.NewJobPool(db, 1)
+
+	var job model.Job
+
+	// only owner validates a bot
+	resp := send("POST", fmt.Sprintf("/api/players/%v/bot/%v/validations", p.Pid, b.Bid), createUserToken("Someone", "player.edit"), "")
+	assert.Equal(t, 403, resp.Code)
+
+	resp = send("POST", fmt.Sprintf("/api/players/%v/bot/%v/validations", p.Pid, b.Bid), bearerFullRight, "")
+	assert.Equal(t, 202, resp.Code)
+	json.Unmarshal(resp.Body.Bytes(), &job)
+	validation := job.Jid
+
+	resp = send("POST", fmt.Sprintf("/api/players/%v/bot/%v/games", p.Pid, b.Bid), bearerFullRight, `{"maze": ["+-+-+", "x   X", "+-+-+"]}`)
+	var game model.Game
+	json.Unmarshal(resp.Body.Bytes(), &game)
+	resp = send("POST", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/render?format=svg", p.Pid, b.Bid, game.Gid), bearerFullRight, "")
+	assert.Equal(t, 202, resp.Code)
+	json.Unmarshal(resp.Body.Bytes(), &job)
+	rendering := job.Jid
+
+	resp = send("POST", fmt.Sprintf("/api/players/%v/bot/%v/games/%v/render?format=bmp", p.Pid, b.Bid, game.Gid), bearerFullRight, "")
+	assert.Equal(t, 400, resp.Code)
+
+	// result is not ready before a worker runs the job
+	resp = send("GET", fmt.Sprintf("/api/jobs/%v/result", rendering), bearerFullRight, "")
+	assert.Equal(t, 409, resp.Code)
+
+	pool.RunPending(context.Background())
+
+	resp = send("GET", fmt.Sprintf("/api/jobs/%v/result", validation), bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"valid":true`)
+
+	resp = send("GET", fmt.Sprintf("/api/jobs/%v/result", rendering), bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "image/svg+xml", resp.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(resp.Body.String(), "<svg"))
+
+	resp = send("GET", fmt.Sprintf("/api/players/%v/jobs?state=succeeded", p.Pid), bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	var list []model.Job
+	json.Unmarshal(resp.Body.Bytes(), &list)
+	assert.Equal(t, 2, len(list))
+
+	// a job of a purged bot is dead and can be retried by an administrator
+	deadJob := model.AddJob(db, &model.Job{Kind: api.JobValidation, PlayerId: p.Pid, BotId: b.Bid + 1000, MaxAttempts: 3})
+	pool.RunPending(context.Background())
+	resp = send("GET", fmt.Sprintf("/api/jobs/%v", deadJob.Jid), bearerFullRight, "")
+	json.Unmarshal(resp.Body.Bytes(), &job)
+	assert.Equal(t, model.JobDead, job.State)
+
+	resp = send("POST", fmt.Sprintf("/api/jobs/%v/retry", deadJob.Jid), createUserToken("JobPlayer", "player.view"), "")
+	assert.Equal(t, 401, resp.Code)
+	resp = send("GET", "/api/jobs?state=dead", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	resp = send("POST", fmt.Sprintf("/api/jobs/%v/retry", deadJob.Jid), bearerFullRight, "")
+	assert.Equal(t, 202, resp.Code)
+	resp = send("POST", fmt.Sprintf("/api/jobs/%v/retry", deadJob.Jid), bearerFullRight, "")
+	assert.Equal(t, 409, resp.Code)
+	resp = send("GET", fmt.Sprintf("/api/jobs/%v", deadJob.Jid+1000), bearerFullRight, "")
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestLeaderboards(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	p := model.AddPlayer(db, "Leader")
+	b := model.AddBot(db, p.Pid, "Right", "right.js", "function executeStep(room) { return { action: 'move', direction: 'right' }; }")
+
+	resp := send("POST", fmt.Sprintf("/api/players/%v/bot/%v/games", p.Pid, b.Bid), bearerFullRight, `{"maze_name": "long corridor", "maze": ["+-+-+-+", "x     X", "+-+-+-+"]}`)
+	assert.Equal(t, 200, resp.Code)
+	var game model.Game
+	json.Unmarshal(resp.Body.Bytes(), &game)
+
+	resp = send("GET", "/api/leaderboards/mazes?window=week", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), game.MazeHash)
+
+	resp = send("GET", "/api/leaderboards/mazes/"+game.MazeHash+"?per_page=5", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	var board model.Leaderboard
+	json.Unmarshal(resp.Body.Bytes(), &board)
+	assert.Equal(t, "long corridor", board.MazeName)
+	assert.Equal(t, 1, board.Total)
+	assert.Equal(t, 5, board.PerPage)
+	assert.Equal(t, model.Ranking{Rank: 1, PlayerId: p.Pid, PlayerName: "Leader", BotId: b.Bid, BotName: "Right", BotVersion: 1,
+		GameId: game.Gid, Steps: 3, OptimalSteps: 3, Score: 1, SubmittedAt: board.Entries[0].SubmittedAt}, board.Entries[0])
+
+	// maze made up by player is not ranked
+	resp = send("GET", "/api/leaderboards?window=week", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.NotContains(t, resp.Body.String(), `"bot_name":"Right"`)
+
+	model.AddRankedMazes(db, []model.RankedMaze{{Hash: game.MazeHash, Name: "long corridor"}})
+	resp = send("GET", "/api/leaderboards?window=week", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"bot_name":"Right"`)
+
+	for _, query := range []string{"window=year", "page=0", "per_page=1000"} {
+		resp = send("GET", "/api/leaderboards?"+query, bearerFullRight, "")
+		assert.Equal(t, 400, resp.Code, query)
+	}
+}
+
+func TestRatings(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	winner := model.AddPlayer(db, "RatedWinner")
+	loser := model.AddPlayer(db, "RatedLoser")
+	right := model.AddBot(db, winner.Pid, "Right", "right.js", "function executeStep(room) { return { action: 'move', direction: 'right' }; }")
+	left := model.AddBot(db, loser.Pid, "Left", "left.js", "function executeStep(room) { return { action: 'move', direction: 'left' }; }")
+
+	corridor, _ := maze.Parse([]string{"+-+-+-+-+", "x       X", "+-+-+-+-+"})
+	model.AddRankedMazes(db, []model.RankedMaze{{Hash: corridor.Hash(), Name: "rated corridor"}})
+	body := `{"maze_name": "rated corridor", "maze": ["+-+-+-+-+", "x       X", "+-+-+-+-+"]}`
+	resp := send("POST", fmt.Sprintf("/api/players/%v/bot/%v/games", winner.Pid, right.Bid), bearerFullRight, body)
+	assert.Equal(t, 200, resp.Code)
+	resp = send("POST", fmt.Sprintf("/api/players/%v/bot/%v/games", loser.Pid, left.Bid), bearerFullRight, body)
+	assert.Equal(t, 200, resp.Code)
+
+	resp = send("GET", fmt.Sprintf("/api/players/%v/bot/%v/rating", winner.Pid, right.Bid), bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	var r model.Rating
+	json.Unmarshal(resp.Body.Bytes(), &r)
+	assert.Equal(t, model.RatingBot, r.Kind)
+	assert.Equal(t, 1, r.Matches)
+	assert.True(t, r.Provisional)
+	assert.Greater(t, r.Rating, 1500.0)
+
+	resp = send("GET", fmt.Sprintf("/api/players/%v/rating/history", loser.Pid), bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	var trajectory api.RatingTrajectory
+	json.Unmarshal(resp.Body.Bytes(), &trajectory)
+	assert.Less(t, trajectory.Rating.Rating, 1500.0)
+	assert.Equal(t, 1, len(trajectory.History))
+	assert.Equal(t, 1, trajectory.History[0].Losses)
+	assert.Equal(t, "rated corridor", trajectory.History[0].MazeName)
+
+	resp = send("GET", fmt.Sprintf("/api/players/%v/bot/%v/rating/history", winner.Pid, right.Bid), bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"wins":1`)
+
+	resp = send("GET", fmt.Sprintf("/api/players/%v/bot/%v/rating", loser.Pid, right.Bid), bearerFullRight, "")
+	assert.Equal(t, 404, resp.Code)
+	resp = send("GET", "/api/players/999999/rating", bearerFullRight, "")
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestTournaments(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	code, _ := ioutil.ReadFile("../data/bots/bot3.js")
+	first := model.AddPlayer(db, "Contender1")
+	second := model.AddPlayer(db, "Contender2")
+	follower := model.AddBot(db, first.Pid, "Follower", "bot3.js", string(code))
+	right := model.AddBot(db, second.Pid, "Right", "right.js", "function executeStep(room) { return { action: 'move', direction: 'right' }; }")
+
+	deadline := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	resp := send("POST", "/api/tournaments", bearerFullRight, `{"name": "Monthly", "format": "round-robin", "suite": "basic", "mazes": ["backtracker-5x5", "prim-6x8"], "entry_deadline": "`+deadline+`"}`)
+	assert.Equal(t, 200, resp.Code)
+	var detail api.TournamentDetail
+	json.Unmarshal(resp.Body.Bytes(), &detail)
+	assert.Equal(t, model.TournamentOpen, detail.State)
+	assert.Equal(t, 2, len(detail.Mazes))
+	assert.Equal(t, "prim-6x8", detail.Mazes[1].Name)
+	tournaments := fmt.Sprintf("/api/tournaments/%v", detail.Tid)
+
+	for _, body := range []string{
+		`{"name": "Bad", "format": "knockout", "suite": "basic", "entry_deadline": "` + deadline + `"}`,
+		`{"name": "Bad", "format": "swiss", "suite": "nowhere", "entry_deadline": "` + deadline + `"}`,
+		`{"name": "Bad", "format": "swiss", "suite": "basic", "mazes": ["nowhere"], "entry_deadline": "` + deadline + `"}`,
+	} {
+		resp = send("POST", "/api/tournaments", bearerFullRight, body)
+		assert.Equal(t, 400, resp.Code, body)
+	}
+
+	resp = send("POST", tournaments+"/entries", bearerFullRight, fmt.Sprintf(`{"player_id": %v, "bot_id": %v}`, first.Pid, follower.Bid))
+	assert.Equal(t, 200, resp.Code)
+	resp = send("POST", tournaments+"/entries", bearerFullRight, fmt.Sprintf(`{"player_id": %v, "bot_id": %v}`, second.Pid, right.Bid))
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"bot_version":1`)
+	assert.NotContains(t, resp.Body.String(), "executeStep")
+	resp = send("POST", tournaments+"/entries", bearerFullRight, fmt.Sprintf(`{"player_id": %v, "bot_id": %v}`, second.Pid, follower.Bid))
+	assert.Equal(t, 404, resp.Code)
+	resp = send("DELETE", fmt.Sprintf("%v/entries/%v", tournaments, 999999), bearerFullRight, "")
+	assert.Equal(t, 404, resp.Code)
+
+	// players only register their own bots
+	req, _ := http.NewRequest("POST", tournaments+"/entries", strings.NewReader(fmt.Sprintf(`{"player_id": %v, "bot_id": %v}`, first.Pid, follower.Bid)))
+	req.Header.Add("Authorization", createUserToken("Contender2", "player.edit"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, 403, resp.Code)
+
+	resp = send("POST", tournaments+"/start", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"state":"running"`)
+	resp = send("POST", tournaments+"/entries", bearerFullRight, fmt.Sprintf(`{"player_id": %v, "bot_id": %v}`, first.Pid, follower.Bid))
+	assert.Equal(t, 409, resp.Code)
+	resp = send("POST", tournaments+"/start", bearerFullRight, "")
+	assert.Equal(t, 409, resp.Code)
+
+	// one round, played by job workers
+	api.NewJobPool(db, 1).RunPending(context.Background())
+	resp = send("GET", tournaments, bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	json.Unmarshal(resp.Body.Bytes(), &detail)
+	assert.Equal(t, model.TournamentFinished, detail.State)
+	assert.Equal(t, 1, detail.Round)
+
+	resp = send("GET", tournaments+"/matches?round=1", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	var matches []model.TournamentMatch
+	json.Unmarshal(resp.Body.Bytes(), &matches)
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, model.MatchWinA, matches[0].Result)
+	assert.Equal(t, "success", matches[0].StateA)
+	assert.NotZero(t, matches[0].GameB)
+
+	resp = send("GET", tournaments+"/standings", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	var standings []model.Standing
+	json.Unmarshal(resp.Body.Bytes(), &standings)
+	assert.Equal(t, 2, len(standings))
+	assert.Equal(t, "Follower", standings[0].BotName)
+	assert.Equal(t, 1.0, standings[0].Points)
+
+	resp = send("GET", "/api/tournaments?state=finished", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"name":"Monthly"`)
+	resp = send("POST", tournaments+"/cancel", bearerFullRight, "")
+	assert.Equal(t, 409, resp.Code)
+	resp = send("GET", "/api/tournaments/999999/standings", bearerFullRight, "")
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestRaces(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	first := model.AddPlayer(db, "Runner1")
+	second := model.AddPlayer(db, "Runner2")
+	right := model.AddBot(db, first.Pid, "Right", "right.js", "function executeStep(room) { return { action: 'move', direction: 'right' }; }")
+	// only waits when it sees the other bots
+	watcher := model.AddBot(db, second.Pid, "Watcher", "watcher.js", "function executeStep(room) { if (!room.bots) throw new Error('blind'); return { action: 'wait' }; }")
+
+	corridor := `"maze_name": "corridor", "maze": ["+-+-+-+", "x     X", "+-+-+-+"]`
+	bots := fmt.Sprintf(`[{"player_id": %v, "bot_id": %v}, {"player_id": %v, "bot_id": %v}]`, first.Pid, right.Bid, second.Pid, watcher.Bid)
+
+	// a player races only own bots
+	resp := send("POST", "/api/races", createUserToken("Runner1", "player.edit"), `{`+corridor+`, "bots": `+bots+`}`)
+	assert.Equal(t, 403, resp.Code)
+
+	resp = send("POST", "/api/races", bearerFullRight, `{`+corridor+`, "sight": true, "seed": 5, "bots": `+bots+`}`)
+	assert.Equal(t, 200, resp.Code)
+	var detail api.RaceDetail
+	json.Unmarshal(resp.Body.Bytes(), &detail)
+	assert.True(t, detail.Sight)
+	assert.Equal(t, 12, detail.Ticks)
+	assert.Equal(t, 2, len(detail.Participants))
+	assert.Equal(t, 1, detail.Participants[0].Rank)
+	assert.Equal(t, 3, detail.Participants[0].Steps)
+	assert.Equal(t, 0, detail.Participants[1].Rank)
+	assert.Equal(t, 12, detail.Participants[1].Steps)
+	assert.NotZero(t, detail.Participants[1].GameId)
+	races := fmt.Sprintf("/api/races/%v", detail.Rid)
+
+	for _, body := range []string{
+		`{` + corridor + `, "bots": [{"player_id": 1, "bot_id": 1}]}`,
+		`{"maze": ["x"], "bots": ` + bots + `}`,
+		`{` + corridor + `}`,
+	} {
+		resp = send("POST", "/api/races", bearerFullRight, body)
+		assert.Equal(t, 400, resp.Code, body)
+	}
+	resp = send("POST", "/api/races", bearerFullRight, fmt.Sprintf(`{%v, "bots": [{"player_id": %v, "bot_id": %v}, {"player_id": %v, "bot_id": 999999}]}`, corridor, first.Pid, right.Bid, second.Pid))
+	assert.Equal(t, 404, resp.Code)
+
+	// without sight the watcher fails at once
+	resp = send("POST", "/api/races", bearerFullRight, `{`+corridor+`, "bots": `+bots+`}`)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"steps":1`)
+
+	resp = send("GET", fmt.Sprintf("/api/races?player_id=%v", second.Pid), bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	var list []model.Race
+	json.Unmarshal(resp.Body.Bytes(), &list)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, detail.Rid, list[1].Rid)
+
+	resp = send("GET", races, bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"maze_name":"corridor"`)
+	resp = send("GET", "/api/races/999999", bearerFullRight, "")
+	assert.Equal(t, 404, resp.Code)
+
+	resp = send("GET", races+"/replay", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	var replay engine.RaceReplay
+	json.Unmarshal(resp.Body.Bytes(), &replay)
+	assert.Equal(t, 2, len(replay.Lanes))
+	m, _ := replay.Lanes[0].ParseMaze()
+	played, err := engine.PlayRaceReplay(m, &replay)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, played.Racers[0].Rank)
+	resp = send("GET", "/api/races/999999/replay", bearerFullRight, "")
+	assert.Equal(t, 404, resp.Code)
+
+	// lane of a purged bot is null, other lanes keep their index
+	model.PurgeBot(db, first.Pid, right.Bid)
+	resp = send("GET", races+"/replay", bearerFullRight, "")
+	assert.Equal(t, 200, resp.Code)
+	replay = engine.RaceReplay{}
+	json.Unmarshal(resp.Body.Bytes(), &replay)
+	if assert.Equal(t, 2, len(replay.Lanes)) {
+		assert.Nil(t, replay.Lanes[0])
+		assert.Equal(t, watcher.Bid, replay.Lanes[1].BotId)
+	}
+	played, err = engine.PlayRaceReplay(m, &replay)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(played.Racers)) {
+		assert.Equal(t, 1, played.Racers[0].Lane)
+		assert.Equal(t, 12, played.Racers[0].Result.Steps)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/model"
+)
+
+const requestIdHeader = "X-Request-Id"
+const requestIdKey = "requestId"
+
+/*
+	Middleware giving each request an id, reuse the one sent by client if any
+*/
+func requestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIdHeader)
+		if id == "" {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		c.Set(requestIdKey, id)
+		c.Header(requestIdHeader, id)
+		c.Next()
+	}
+}
+
+/*
+	Append an entry to audit log for the current request
+*/
+func recordAudit(c *gin.Context, action string, pid int32, bid int32, before interface{}, after interface{}) {
+	entry := &model.AuditEntry{
+		Actor:     GetUserName(c.Request),
+		Action:    action,
+		PlayerId:  pid,
+		BotId:     bid,
+		Before:    model.AuditSummary(before),
+		After:     model.AuditSummary(after),
+		ClientIP:  c.ClientIP(),
+		RequestId: c.GetString(requestIdKey),
+	}
+
+	if model.AddAuditEntry(playerDB, entry) == nil {
+		log.Printf("Error cannot record audit entry %v for request %v\n", action, entry.RequestId)
+	}
+}
+
+func addAuditRoutes(rg *gin.RouterGroup) {
+
+	rg.GET("/audit", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.admin")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		filter, err := parseAuditFilter(c)
+		if err != nil {
+			log.Printf("Error in GET /audit: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+
+		entries := model.GetAuditEntries(playerDB, filter)
+		if entries == nil {
+			c.JSON(500, "[]")
+			return
+		}
+		c.JSON(200, entries)
+	})
+}
+
+/*
+	Build audit filter from query parameters
+	actor, action, playerid, botid, since, until (RFC3339) and limit
+*/
+func parseAuditFilter(c *gin.Context) (model.AuditFilter, error) {
+	filter := model.AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+		Limit:  100,
+	}
+
+	if v := c.Query("playerid"); v != "" {
+		pid, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return filter, err
+		}
+		filter.PlayerId = int32(pid)
+	}
+	if v := c.Query("botid"); v != "" {
+		bid, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return filter, err
+		}
+		filter.BotId = int32(bid)
+	}
+	if v := c.Query("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Since = since
+	}
+	if v := c.Query("until"); v != "" {
+		until, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Until = until
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	log "github.com/sirupsen/logrus"
@@ -76,6 +77,7 @@ func CheckRole(req *http.Request, role string) bool {
 				}
 			}
 		}
+		recordAuthFailure(AuthMissingRole)
 	} else {
 		log.Errorf("Cannot get claim from token.")
 	}
@@ -117,6 +119,7 @@ func getClaim(req *http.Request, authurl string) *KeycloakClaim {
 				log.Debugf("Remove expired token from cache.")
 				// clean cache
 				delete(validTokenMap, tokenString)
+				recordAuthFailure(AuthExpired)
 				return nil
 			}
 		} else {
@@ -126,6 +129,7 @@ func getClaim(req *http.Request, authurl string) *KeycloakClaim {
 		return claim
 	} else {
 		log.Errorf("Cannot find token in request.")
+		recordAuthFailure(AuthMissingToken)
 	}
 
 	return nil
@@ -178,11 +182,13 @@ func convertToClaim(tokenAsString string, authurl string) *KeycloakClaim {
 
 	if err != nil {
 		log.Errorf("Error cannot parse token: %v\n", err)
+		recordAuthFailure(tokenErrorReason(err))
 		return nil
 	}
 
 	if !token.Valid {
 		log.Errorf("Error invalid token: %v\n", token)
+		recordAuthFailure(AuthInvalidToken)
 		return nil
 	}
 
@@ -205,30 +211,52 @@ func getKeycloakSigningKey(authurl string) *rsa.PublicKey {
 
 	if KeycloakTokenSigningKey == nil {
 		log.Debugf("Retrieve keycloak signing key: %v", authurl)
-		resp, err := http.Get(authurl)
-		if err != nil {
-			return nil
-		}
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil
-		}
-		var info KCRealmInfo
-		err = json.Unmarshal(body, &info)
-		if err != nil {
-			return nil
-		}
-		pubkeyPEM := "-----BEGIN PUBLIC KEY-----\n" + info.PublicKey + "\n-----END PUBLIC KEY-----\n"
-		KeycloakTokenSigningKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(pubkeyPEM))
+		start := time.Now()
+		key, err := fetchKeycloakSigningKey(authurl)
+		keycloakKeyFetchDuration.Observe(time.Since(start).Seconds())
 		if err != nil {
+			log.Errorf("Cannot retrieve keycloak signing key: %v", err)
+			keycloakKeyFetchErrors.Inc()
 			return nil
 		}
+		KeycloakTokenSigningKey = key
 	}
 
 	return KeycloakTokenSigningKey
 }
 
+func fetchKeycloakSigningKey(authurl string) (*rsa.PublicKey, error) {
+	resp, err := http.Get(authurl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var info KCRealmInfo
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return nil, err
+	}
+	pubkeyPEM := "-----BEGIN PUBLIC KEY-----\n" + info.PublicKey + "\n-----END PUBLIC KEY-----\n"
+	return jwt.ParseRSAPublicKeyFromPEM([]byte(pubkeyPEM))
+}
+
+// map token parsing error to auth failure reason
+func tokenErrorReason(err error) string {
+	if ve, ok := err.(*jwt.ValidationError); ok {
+		if ve.Errors&jwt.ValidationErrorExpired != 0 {
+			return AuthExpired
+		}
+		if ve.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+			return AuthBadSignature
+		}
+	}
+	return AuthInvalidToken
+}
+
 // check if token is still valid
 func checkClaimValidity(claim *KeycloakClaim) bool {
 
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+// debug sessions are closed after this inactivity period
+var DebugSessionTimeout = 10 * time.Minute
+
+// maximum number of open debug sessions
+var MaxDebugSessions = 100
+
+// how often expired debug sessions are closed
+var DebugJanitorInterval = time.Minute
+
+// states of a debug session, finished sessions have the game state
+const DebugRunning = "running"
+
+type DebugBody struct {
+	Maze []string `json:"maze" binding:"required"`
+	Seed int64    `json:"seed"`
+}
+
+/*
+	DebugSession plays a game step by step on request,
+	it holds the bot runtime until it expires
+*/
+type DebugSession struct {
+	Id       string
+	PlayerId int32
+	BotId    int32
+	game     *engine.Game
+	bot      engine.Bot
+	console  *engine.Console
+	// guarded by debugMutex, other fields by mu
+	lastUsed time.Time
+	mu       sync.Mutex
+}
+
+// DebugState is what the debugger shows of a session
+type DebugState struct {
+	Id        string          `json:"id"`
+	PlayerId  int32           `json:"player_id"`
+	BotId     int32           `json:"bot_id"`
+	State     string          `json:"state"`
+	Step      int             `json:"step"`
+	MaxSteps  int             `json:"max_steps"`
+	Position  maze.Cell       `json:"position"`
+	Room      maze.Room       `json:"room"`
+	Visited   []maze.Cell     `json:"visited"`
+	Console   *engine.Console `json:"console"`
+	Error     string          `json:"error,omitempty"`
+	Result    *engine.Result  `json:"result,omitempty"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// open debug sessions by id
+var debugSessions = map[string]*DebugSession{}
+
+// sessions being created, counted in MaxDebugSessions, guarded by debugMutex
+var debugReserved int
+var debugMutex sync.Mutex
+
+/*
+	Close sessions inactive for longer than DebugSessionTimeout
+*/
+func expireDebugSessions(now time.Time) {
+	var expired []*DebugSession
+	debugMutex.Lock()
+	for id, s := range debugSessions {
+		if now.Sub(s.lastUsed) > DebugSessionTimeout {
+			delete(debugSessions, id)
+			expired = append(expired, s)
+		}
+	}
+	debugMutex.Unlock()
+
+	// wait for a step in progress before releasing the bot
+	for _, s := range expired {
+		s.mu.Lock()
+		engine.CloseBot(s.bot)
+		s.mu.Unlock()
+	}
+}
+
+/*
+	Close expired debug sessions every DebugJanitorInterval until ctx is done,
+	so bot runtimes of abandoned sessions are released without waiting for a request
+*/
+func ExpireDebugSessions(ctx context.Context) {
+	ticker := time.NewTicker(DebugJanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expireDebugSessions(now)
+		}
+	}
+}
+
+/*
+	Reserve room for a new session, false when MaxDebugSessions are open or being created.
+	The reservation is released by addDebugSession or cancelDebugSession.
+*/
+func reserveDebugSession() bool {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	if len(debugSessions)+debugReserved >= MaxDebugSessions {
+		return false
+	}
+	debugReserved++
+	return true
+}
+
+func addDebugSession(s *DebugSession) {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	debugReserved--
+	debugSessions[s.Id] = s
+}
+
+func cancelDebugSession() {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	debugReserved--
+}
+
+/*
+	Find session of bot, it is locked and must be unlocked by caller
+*/
+func lockDebugSession(pid int32, bid int32, id string) *DebugSession {
+	expireDebugSessions(time.Now())
+
+	debugMutex.Lock()
+	s := debugSessions[id]
+	if s == nil || s.PlayerId != pid || s.BotId != bid {
+		debugMutex.Unlock()
+		return nil
+	}
+	s.lastUsed = time.Now()
+	debugMutex.Unlock()
+
+	s.mu.Lock()
+	return s
+}
+
+func closeDebugSession(s *DebugSession) {
+	debugMutex.Lock()
+	delete(debugSessions, s.Id)
+	debugMutex.Unlock()
+	engine.CloseBot(s.bot)
+}
+
+func (s *DebugSession) expiresAt() time.Time {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	return s.lastUsed.Add(DebugSessionTimeout)
+}
+
+func newSessionId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// play steps until step n or end of game
+func (s *DebugSession) runTo(n int) {
+	for s.game.Steps() < n && s.game.Step() {
+	}
+}
+
+func (s *DebugSession) state() *DebugState {
+	state := &DebugState{
+		Id:        s.Id,
+		PlayerId:  s.PlayerId,
+		BotId:     s.BotId,
+		State:     DebugRunning,
+		Step:      s.game.Steps(),
+		MaxSteps:  s.game.MaxSteps(),
+		Position:  s.game.Position(),
+		Room:      s.game.Room(),
+		Visited:   s.game.Visited(),
+		Console:   s.console,
+		Error:     s.game.Error(),
+		ExpiresAt: s.expiresAt(),
+	}
+	if s.game.Done() {
+		state.Result = s.game.Result()
+		state.State = state.Result.State
+	}
+	return state
+}
+
+func addDebugRoutes(rg *gin.RouterGroup) {
+
+	// start a debug session of bot in maze, no step is played
+	rg.POST("/players/:playerid/bot/:botid/debug", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, _, ok := gameParams(c, "POST /players/:playerid/bot/:botid/debug")
+		if !ok {
+			return
+		}
+		// sessions show bot console, reserved to its owner
+		if !isOwner(c, pid) {
+			c.String(403, "forbidden")
+			return
+		}
+
+		var body DebugBody
+		err := c.BindJSON(&body)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/debug: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+		m, err := maze.Parse(body.Maze)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		expireDebugSessions(time.Now())
+		if !reserveDebugSession() {
+			c.JSON(429, gin.H{"error": "too many debug sessions"})
+			return
+		}
+
+		console := engine.NewConsole(nil)
+		bot, err := engine.LoadBot(playerDB, pid, bid, engine.Limits{}, console)
+		if err != nil {
+			cancelDebugSession()
+			c.JSON(400, gin.H{"error": err.Error(), "console": console})
+			return
+		}
+
+		s := &DebugSession{
+			Id:       newSessionId(),
+			PlayerId: pid,
+			BotId:    bid,
+			game:     engine.NewGame(m, bot, engine.Options{Seed: body.Seed, Console: console}),
+			bot:      bot,
+			console:  console,
+			lastUsed: time.Now(),
+		}
+		addDebugSession(s)
+
+		c.JSON(201, s.state())
+	})
+
+	rg.GET("/players/:playerid/bot/:botid/debug/:sessionid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		pid, bid, _, ok := gameParams(c, "GET /players/:playerid/bot/:botid/debug/:sessionid")
+		if !ok {
+			return
+		}
+
+		s := lockDebugSession(pid, bid, c.Param("sessionid"))
+		if s == nil {
+			c.JSON(404, "")
+			return
+		}
+		defer s.mu.Unlock()
+		c.JSON(200, s.state())
+	})
+
+	/*
+		Play steps: step plays one, continue plays until the end of the game,
+		run plays until step given by query parameter to
+	*/
+	for _, command := range []string{"step", "continue", "run"} {
+		command := command
+		rg.POST("/players/:playerid/bot/:botid/debug/:sessionid/"+command, func(c *gin.Context) {
+			authorized := CheckRole(c.Request, "player.edit")
+			if !authorized {
+				c.String(401, "unauthorized")
+				return
+			}
+			pid, bid, _, ok := gameParams(c, "POST /players/:playerid/bot/:botid/debug/:sessionid/"+command)
+			if !ok {
+				return
+			}
+
+			s := lockDebugSession(pid, bid, c.Param("sessionid"))
+			if s == nil {
+				c.JSON(404, "")
+				return
+			}
+			defer s.mu.Unlock()
+
+			switch command {
+			case "step":
+				s.game.Step()
+			case "continue":
+				s.runTo(s.game.MaxSteps())
+			case "run":
+				to, err := strconv.Atoi(c.Query("to"))
+				if err != nil {
+					c.JSON(400, gin.H{"error": "query parameter to must be a step number"})
+					return
+				}
+				s.runTo(to)
+			}
+			c.JSON(200, s.state())
+		})
+	}
+
+	rg.DELETE("/players/:playerid/bot/:botid/debug/:sessionid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		pid, bid, _, ok := gameParams(c, "DELETE /players/:playerid/bot/:botid/debug/:sessionid")
+		if !ok {
+			return
+		}
+
+		s := lockDebugSession(pid, bid, c.Param("sessionid"))
+		if s == nil {
+			c.JSON(404, "")
+			return
+		}
+		defer s.mu.Unlock()
+		closeDebugSession(s)
+		c.JSON(200, s.state())
+	})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/model"
+)
+
+// ETag of a player or bot is its version
+func etag(version int32) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+func parseETag(tag string) (int32, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	v, err := strconv.ParseInt(strings.Trim(tag, "\""), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int32(v), nil
+}
+
+/*
+	Set ETag header and answer 304 when client already has this version
+*/
+func notModified(c *gin.Context, version int32) bool {
+	c.Header("ETag", etag(version))
+
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == "*" {
+			c.Status(304)
+			return true
+		}
+		v, err := parseETag(tag)
+		if err == nil && v == version {
+			c.Status(304)
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	Get version expected by client from If-Match header, current is the version of
+	the player or bot, AnyVersion when unknown. A list of ETags matches when one of them
+	is current, "*" matches any version. Answer 428 when header is missing and 412 when
+	it cannot match.
+*/
+func ifMatch(c *gin.Context, current int32) (int32, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		// concurrent edition must be detected
+		c.JSON(428, "")
+		return 0, false
+	}
+
+	versions := []int32{}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == "*" {
+			return model.AnyVersion, true
+		}
+		v, err := parseETag(tag)
+		if err != nil || v == model.AnyVersion {
+			c.JSON(412, "")
+			return 0, false
+		}
+		if v == current {
+			return v, true
+		}
+		versions = append(versions, v)
+	}
+	if current != model.AnyVersion {
+		c.JSON(412, "")
+		return 0, false
+	}
+	// the change fails if the player or bot does not exist any more
+	return versions[0], true
+}
+
+// version of a player or bot for ifMatch, AnyVersion when it does not exist
+func currentVersion(v interface{}) int32 {
+	switch v := v.(type) {
+	case *model.Player:
+		if v != nil {
+			return v.Version
+		}
+	case *model.BotWithPlayer:
+		if v != nil {
+			return v.Version
+		}
+	case *model.BotBase:
+		if v != nil {
+			return v.Version
+		}
+	}
+	return model.AnyVersion
+}
+
+// answer to a failed conditional change
+func conditionalError(c *gin.Context, err error) {
+	if err == model.ErrVersionConflict {
+		c.JSON(412, "")
+		return
+	}
+	c.JSON(500, "")
+}
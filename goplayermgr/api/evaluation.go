@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"runtime"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/jobs"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+// directory holding maze suites, a sub directory per suite
+var MazeDir = "data/mazes"
+
+// maximum number of games played in parallel by an evaluation
+var MaxEvalWorkers = runtime.NumCPU()
+
+type EvaluationBody struct {
+	Suite   string `json:"suite" binding:"required"`
+	Workers int    `json:"workers"`
+	Seed    int64  `json:"seed"`
+}
+
+// EvaluationReport is a stored evaluation with its report
+type EvaluationReport struct {
+	*model.Evaluation
+	Report json.RawMessage `json:"report"`
+}
+
+// rank mazes of suites of MazeDir, so games played in them count before any evaluation
+func rankSuites(db *gorm.DB) {
+	err := engine.RankSuites(db, MazeDir)
+	if err != nil {
+		log.Printf("Error ranking suites of %v: %v\n", MazeDir, err)
+	}
+}
+
+func addEvaluationRoutes(rg *gin.RouterGroup) {
+
+	rg.GET("/suites", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		c.JSON(200, maze.Suites(MazeDir))
+	})
+
+	// queue evaluation of current revision of bot over a maze suite
+	rg.POST("/players/:playerid/bot/:botid/evaluations", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, _, ok := gameParams(c, "POST /players/:playerid/bot/:botid/evaluations")
+		if !ok {
+			return
+		}
+		if !isOwner(c, pid) {
+			c.String(403, "forbidden")
+			return
+		}
+
+		var body EvaluationBody
+		err := c.BindJSON(&body)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/evaluations: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+		// suite is checked now, it is read again by the worker
+		_, err = maze.FindSuite(MazeDir, body.Suite)
+		if err != nil {
+			if errors.Is(err, maze.ErrUnknownSuite) {
+				c.JSON(404, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Workers <= 0 || body.Workers > MaxEvalWorkers {
+			body.Workers = MaxEvalWorkers
+		}
+
+		// games are played by a job worker
+		job, err := jobs.Enqueue(playerDB, JobEvaluation, pid, bid, body)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/evaluations: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		accepted(c, job)
+	})
+
+	rg.GET("/players/:playerid/bot/:botid/evaluations", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, _, ok := gameParams(c, "GET /players/:playerid/bot/:botid/evaluations")
+		if !ok {
+			return
+		}
+
+		evaluations := model.GetEvaluations(playerDB, pid, bid)
+		if evaluations == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, evaluations)
+	})
+
+	rg.GET("/players/:playerid/bot/:botid/evaluations/:evaluationid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, _, ok := gameParams(c, "GET /players/:playerid/bot/:botid/evaluations/:evaluationid")
+		if !ok {
+			return
+		}
+		eid, err := strconv.ParseInt(c.Param("evaluationid"), 10, 32)
+		if err != nil {
+			log.Printf("Error in GET /players/:playerid/bot/:botid/evaluations/:evaluationid: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+
+		evaluation := model.GetEvaluation(playerDB, pid, bid, int32(eid))
+		if evaluation == nil {
+			c.JSON(404, "")
+			return
+		}
+		c.JSON(200, EvaluationReport{Evaluation: evaluation, Report: json.RawMessage(evaluation.Report)})
+	})
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+	"jc.org/playermgr/render"
+)
+
+type GameBody struct {
+	MazeName string   `json:"maze_name"`
+	Maze     []string `json:"maze" binding:"required"`
+	Seed     int64    `json:"seed"`
+}
+
+/*
+	Read player, bot and game ids of path, answer 500 when one is invalid
+*/
+func gameParams(c *gin.Context, route string) (int32, int32, int32, bool) {
+	ids := make([]int32, 3)
+	for i, name := range []string{"playerid", "botid", "gameid"} {
+		if c.Param(name) == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(c.Param(name), 10, 32)
+		if err != nil {
+			log.Printf("Error in %v: %v\n", route, err)
+			c.JSON(500, "")
+			return 0, 0, 0, false
+		}
+		ids[i] = int32(id)
+	}
+	return ids[0], ids[1], ids[2], true
+}
+
+/*
+	Check if user of request is the player pid, administrators can see all players
+*/
+func isOwner(c *gin.Context, pid int32) bool {
+	if CheckRole(c.Request, "player.admin") {
+		return true
+	}
+	player := model.GetPlayer(playerDB, pid)
+	return player != nil && player.Name == GetUserName(c.Request)
+}
+
+func addGameRoutes(rg *gin.RouterGroup) {
+
+	// play a game and record its replay
+	rg.POST("/players/:playerid/bot/:botid/games", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, _, ok := gameParams(c, "POST /players/:playerid/bot/:botid/games")
+		if !ok {
+			return
+		}
+		if !isOwner(c, pid) {
+			c.String(403, "forbidden")
+			return
+		}
+
+		var body GameBody
+		err := c.BindJSON(&body)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/games: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+		m, err := maze.Parse(body.Maze)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		game, _, err := engine.PlayGame(playerDB, pid, bid, m, body.MazeName, engine.Options{Seed: body.Seed}, nil)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/games: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, game)
+	})
+
+	rg.GET("/players/:playerid/bot/:botid/games", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, _, ok := gameParams(c, "GET /players/:playerid/bot/:botid/games")
+		if !ok {
+			return
+		}
+
+		games := model.GetGames(playerDB, pid, bid)
+		if games == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, games)
+	})
+
+	rg.GET("/players/:playerid/bot/:botid/games/:gameid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, gid, ok := gameParams(c, "GET /players/:playerid/bot/:botid/games/:gameid")
+		if !ok {
+			return
+		}
+
+		game := model.GetGame(playerDB, pid, bid, gid)
+		if game == nil {
+			c.JSON(404, "")
+			return
+		}
+		c.JSON(200, game)
+	})
+
+	// download replay, named like gamemgr log files
+	rg.GET("/players/:playerid/bot/:botid/games/:gameid/replay", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, gid, ok := gameParams(c, "GET /players/:playerid/bot/:botid/games/:gameid/replay")
+		if !ok {
+			return
+		}
+
+		game := model.GetGame(playerDB, pid, bid, gid)
+		if game == nil {
+			c.JSON(404, "")
+			return
+		}
+		filename := fmt.Sprintf("player%d_bot%d_game%d.json", pid, bid, gid)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Data(200, "application/json", []byte(game.Replay))
+	})
+
+	// bot output may reveal its strategy, only its owner can read it
+	rg.GET("/players/:playerid/bot/:botid/games/:gameid/console", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, gid, ok := gameParams(c, "GET /players/:playerid/bot/:botid/games/:gameid/console")
+		if !ok {
+			return
+		}
+		if !isOwner(c, pid) {
+			c.String(403, "forbidden")
+			return
+		}
+
+		game := model.GetGame(playerDB, pid, bid, gid)
+		if game == nil {
+			c.JSON(404, "")
+			return
+		}
+		c.Data(200, "application/json", []byte(game.Console))
+	})
+
+	// animated GIF or SVG heatmap of a game
+	rg.GET("/players/:playerid/bot/:botid/games/:gameid/render", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, gid, ok := gameParams(c, "GET /players/:playerid/bot/:botid/games/:gameid/render")
+		if !ok {
+			return
+		}
+		format := c.DefaultQuery("format", render.FormatGIF)
+		cell, err := strconv.Atoi(c.DefaultQuery("cell", "0"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		game := model.GetGame(playerDB, pid, bid, gid)
+		if game == nil {
+			c.JSON(404, "")
+			return
+		}
+		replay, err := engine.ReadReplay([]byte(game.Replay))
+		if err != nil {
+			log.Printf("Error in GET /players/:playerid/bot/:botid/games/:gameid/render: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		m, err := replay.ParseMaze()
+		if err != nil {
+			log.Printf("Error in GET /players/:playerid/bot/:botid/games/:gameid/render: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+
+		var image bytes.Buffer
+		err = render.Render(&image, format, m, replay.Path(m), render.Options{CellSize: cell})
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(200, render.ContentType(format), image.Bytes())
+	})
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/jobs"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+	"jc.org/playermgr/render"
+	"jc.org/playermgr/tournament"
+)
+
+// number of job workers started by Serve, 0 leaves jobs to playermgr worker
+var JobWorkers = 2
+
+// kinds of jobs
+const (
+	JobEvaluation = "evaluation"
+	JobValidation = "validation"
+	JobRender     = "render"
+	JobTournament = "tournament"
+)
+
+// payload of a render job
+type RenderPayload struct {
+	GameId int32  `json:"game_id"`
+	Format string `json:"format"`
+	Cell   int    `json:"cell"`
+}
+
+// result of a render job
+type RenderResult struct {
+	ContentType string `json:"content_type"`
+	Image       []byte `json:"image"`
+}
+
+// result of an evaluation job
+type EvaluationResult struct {
+	EvaluationId int32 `json:"evaluation_id"`
+}
+
+// payload of a tournament job, it plays a round
+type TournamentPayload struct {
+	TournamentId int32 `json:"tournament_id"`
+}
+
+// result of a tournament job
+type TournamentResult struct {
+	TournamentId int32  `json:"tournament_id"`
+	Round        int    `json:"round"`
+	State        string `json:"state"`
+}
+
+/*
+	Pool of workers running evaluation, validation, render and tournament jobs
+*/
+func NewJobPool(db *gorm.DB, workers int) *jobs.Pool {
+	pool := jobs.NewPool(db, workers)
+
+	pool.Handle(JobEvaluation, func(ctx context.Context, job *model.Job, progress jobs.Progress) (interface{}, error) {
+		var body EvaluationBody
+		err := json.Unmarshal([]byte(job.Payload), &body)
+		if err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		suite, err := maze.FindSuite(MazeDir, body.Suite)
+		if err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		opts := engine.EvalOptions{Workers: body.Workers, Seed: body.Seed, Progress: progress, Context: ctx}
+		stored, _, err := engine.EvaluateBot(db, job.PlayerId, job.BotId, suite, opts)
+		if err != nil {
+			return nil, err
+		}
+		return EvaluationResult{EvaluationId: stored.Eid}, nil
+	})
+
+	pool.Handle(JobValidation, func(ctx context.Context, job *model.Job, progress jobs.Progress) (interface{}, error) {
+		code := model.GetBotCode(db, job.PlayerId, job.BotId)
+		if code == nil {
+			return nil, jobs.Permanent(fmt.Errorf("cannot get code of bot %v of player %v", job.BotId, job.PlayerId))
+		}
+		if code.Language == model.LanguageRemote {
+			return engine.ValidateRemote(code.URL, code.Secret, engine.Limits{}), nil
+		}
+		return engine.ValidateBot(code.Language, code.Botcode, code.Filename, engine.Limits{}), nil
+	})
+
+	pool.Handle(JobRender, func(ctx context.Context, job *model.Job, progress jobs.Progress) (interface{}, error) {
+		var body RenderPayload
+		err := json.Unmarshal([]byte(job.Payload), &body)
+		if err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		game := model.GetGame(db, job.PlayerId, job.BotId, body.GameId)
+		if game == nil {
+			return nil, jobs.Permanent(fmt.Errorf("cannot get game %v", body.GameId))
+		}
+		replay, err := engine.ReadReplay([]byte(game.Replay))
+		if err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		m, err := replay.ParseMaze()
+		if err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		var image bytes.Buffer
+		err = render.Render(&image, body.Format, m, replay.Path(m), render.Options{CellSize: body.Cell})
+		if err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		return RenderResult{ContentType: render.ContentType(body.Format), Image: image.Bytes()}, nil
+	})
+
+	// a job plays a round and queues the next one
+	pool.Handle(JobTournament, func(ctx context.Context, job *model.Job, progress jobs.Progress) (interface{}, error) {
+		var body TournamentPayload
+		err := json.Unmarshal([]byte(job.Payload), &body)
+		if err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		t, err := tournament.PlayRound(ctx, db, body.TournamentId, progress)
+		// nothing to play, e.g. cancelled or round scheduled by another worker
+		if errors.Is(err, model.ErrTournamentState) && t != nil {
+			return TournamentResult{TournamentId: t.Tid, Round: t.Round, State: t.State}, nil
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, jobs.Permanent(err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t.State == model.TournamentRunning {
+			_, err = jobs.Enqueue(db, JobTournament, 0, 0, body)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return TournamentResult{TournamentId: t.Tid, Round: t.Round, State: t.State}, nil
+	})
+
+	return pool
+}
+
+// answer 202 with queued job
+func accepted(c *gin.Context, job *model.Job) {
+	c.Header("Location", fmt.Sprintf("/api/jobs/%v", job.Jid))
+	c.JSON(202, job)
+}
+
+func jobParam(c *gin.Context, route string) (int32, bool) {
+	jid, err := strconv.ParseInt(c.Param("jobid"), 10, 32)
+	if err != nil {
+		log.Printf("Error in %v: %v\n", route, err)
+		c.JSON(500, "")
+		return 0, false
+	}
+	return int32(jid), true
+}
+
+func addJobRoutes(rg *gin.RouterGroup) {
+
+	// check stored code of bot again, like on upload
+	rg.POST("/players/:playerid/bot/:botid/validations", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, _, ok := gameParams(c, "POST /players/:playerid/bot/:botid/validations")
+		if !ok {
+			return
+		}
+		if !isOwner(c, pid) {
+			c.String(403, "forbidden")
+			return
+		}
+		if model.GetBot(playerDB, pid, bid) == nil {
+			c.JSON(404, "")
+			return
+		}
+
+		job, err := jobs.Enqueue(playerDB, JobValidation, pid, bid, struct{}{})
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/validations: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		accepted(c, job)
+	})
+
+	// render a game in the background, same query parameters as GET render
+	rg.POST("/players/:playerid/bot/:botid/games/:gameid/render", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, bid, gid, ok := gameParams(c, "POST /players/:playerid/bot/:botid/games/:gameid/render")
+		if !ok {
+			return
+		}
+		payload := RenderPayload{GameId: gid, Format: c.DefaultQuery("format", render.FormatGIF)}
+		cell, err := strconv.Atoi(c.DefaultQuery("cell", "0"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		payload.Cell = cell
+		if payload.Format != render.FormatGIF && payload.Format != render.FormatSVG {
+			c.JSON(400, gin.H{"error": render.ErrUnknownFormat.Error()})
+			return
+		}
+		if model.GetGame(playerDB, pid, bid, gid) == nil {
+			c.JSON(404, "")
+			return
+		}
+
+		job, err := jobs.Enqueue(playerDB, JobRender, pid, bid, payload)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/:botid/games/:gameid/render: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		accepted(c, job)
+	})
+
+	// all jobs, optionally of a state
+	rg.GET("/jobs", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.admin")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		list := model.GetJobs(playerDB, 0, c.Query("state"))
+		if list == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, list)
+	})
+
+	rg.GET("/players/:playerid/jobs", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, _, _, ok := gameParams(c, "GET /players/:playerid/jobs")
+		if !ok {
+			return
+		}
+
+		list := model.GetJobs(playerDB, pid, c.Query("state"))
+		if list == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, list)
+	})
+
+	// state and progress of a job
+	rg.GET("/jobs/:jobid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		jid, ok := jobParam(c, "GET /jobs/:jobid")
+		if !ok {
+			return
+		}
+
+		job := model.GetJob(playerDB, jid)
+		if job == nil {
+			c.JSON(404, "")
+			return
+		}
+		c.JSON(200, job)
+	})
+
+	// result of a succeeded job, rendered images are answered as images
+	rg.GET("/jobs/:jobid/result", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		jid, ok := jobParam(c, "GET /jobs/:jobid/result")
+		if !ok {
+			return
+		}
+
+		job := model.GetJob(playerDB, jid)
+		if job == nil {
+			c.JSON(404, "")
+			return
+		}
+		if job.State != model.JobSucceeded {
+			c.JSON(409, gin.H{"error": "job is " + job.State, "state": job.State})
+			return
+		}
+		if job.Kind == JobRender {
+			var result RenderResult
+			err := json.Unmarshal([]byte(job.Result), &result)
+			if err != nil {
+				log.Printf("Error in GET /jobs/:jobid/result: %v\n", err)
+				c.JSON(500, "")
+				return
+			}
+			c.Data(200, result.ContentType, result.Image)
+			return
+		}
+		c.Data(200, "application/json", []byte(job.Result))
+	})
+
+	// queue a dead job again
+	rg.POST("/jobs/:jobid/retry", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.admin")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		jid, ok := jobParam(c, "POST /jobs/:jobid/retry")
+		if !ok {
+			return
+		}
+
+		job := model.RetryJob(playerDB, jid)
+		if job == nil {
+			if model.GetJob(playerDB, jid) == nil {
+				c.JSON(404, "")
+				return
+			}
+			c.JSON(409, gin.H{"error": "only dead jobs can be retried"})
+			return
+		}
+		accepted(c, job)
+	})
+}
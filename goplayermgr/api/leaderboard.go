@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/model"
+)
+
+// size of a leaderboard page when not specified and maximum size
+var (
+	LeaderboardPageSize    = 20
+	MaxLeaderboardPageSize = 100
+)
+
+/*
+	Read page and per_page query parameters, answer 400 when invalid
+*/
+func pageParams(c *gin.Context) (int, int, bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(400, gin.H{"error": "page must be a positive number"})
+		return 0, 0, false
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(LeaderboardPageSize)))
+	if err != nil || perPage < 1 || perPage > MaxLeaderboardPageSize {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("per_page must be between 1 and %d", MaxLeaderboardPageSize)})
+		return 0, 0, false
+	}
+	return page, perPage, true
+}
+
+// answer leaderboard, unknown windows are client errors
+func leaderboardResponse(c *gin.Context, board interface{}, err error) {
+	if errors.Is(err, model.ErrUnknownWindow) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, "")
+		return
+	}
+	c.JSON(200, board)
+}
+
+func addLeaderboardRoutes(rg *gin.RouterGroup) {
+
+	// global ranking by mean normalized score over mazes of window
+	rg.GET("/leaderboards", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		page, perPage, ok := pageParams(c)
+		if !ok {
+			return
+		}
+
+		board, err := model.GlobalLeaderboard(playerDB, c.DefaultQuery("window", model.WindowAll), page, perPage)
+		leaderboardResponse(c, board, err)
+	})
+
+	rg.GET("/leaderboards/mazes", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		mazes, err := model.LeaderboardMazes(playerDB, c.DefaultQuery("window", model.WindowAll))
+		leaderboardResponse(c, mazes, err)
+	})
+
+	// ranking of a maze by fewest steps then earliest submission
+	rg.GET("/leaderboards/mazes/:mazehash", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		page, perPage, ok := pageParams(c)
+		if !ok {
+			return
+		}
+
+		board, err := model.MazeLeaderboard(playerDB, c.Param("mazehash"), c.DefaultQuery("window", model.WindowAll), page, perPage)
+		leaderboardResponse(c, board, err)
+	})
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"jc.org/playermgr/model"
+)
+
+// reasons used to label authentication failures
+const (
+	AuthMissingToken = "missing_token"
+	AuthExpired      = "expired"
+	AuthBadSignature = "bad_signature"
+	AuthInvalidToken = "invalid_token"
+	AuthMissingRole  = "missing_role"
+)
+
+// port used to expose prometheus metrics, 0 means on the API port
+var MetricsPort int
+
+var botUploadCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "playermgr_bot_uploads_total",
+	Help: "Number of bots uploaded.",
+})
+
+var botCodeSize = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "playermgr_bot_code_size_bytes",
+	Help:    "Size of uploaded bot code.",
+	Buckets: prometheus.ExponentialBuckets(256, 2, 10),
+})
+
+var authFailureCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "playermgr_auth_failures_total",
+	Help: "Number of rejected requests by reason.",
+}, []string{"reason"})
+
+var keycloakKeyFetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "playermgr_keycloak_key_fetch_duration_seconds",
+	Help:    "Latency of Keycloak signing key retrieval.",
+	Buckets: prometheus.DefBuckets,
+})
+
+var keycloakKeyFetchErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "playermgr_keycloak_key_fetch_errors_total",
+	Help: "Number of failed Keycloak signing key retrievals.",
+})
+
+// players and bots totals are read from the database at scrape time
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "playermgr_players",
+		Help: "Number of players.",
+	}, func() float64 {
+		return float64(model.CountPlayers(playerDB))
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "playermgr_bots",
+		Help: "Number of bots.",
+	}, func() float64 {
+		return float64(model.CountBots(playerDB))
+	})
+}
+
+func recordAuthFailure(reason string) {
+	authFailureCounter.WithLabelValues(reason).Inc()
+}
+
+func recordBotUpload(code string) {
+	botUploadCounter.Inc()
+	botCodeSize.Observe(float64(len(code)))
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+// maximum number of bots in a race
+var MaxRacers = 8
+
+// body of a race, bots race in lanes in order of Bots
+type RaceBody struct {
+	MazeName string      `json:"maze_name"`
+	Maze     []string    `json:"maze" binding:"required"`
+	Bots     []EntryBody `json:"bots" binding:"required"`
+	Seed     int64       `json:"seed"`
+	Sight    bool        `json:"sight"`
+}
+
+// RaceDetail is a race with its participants in lane order
+type RaceDetail struct {
+	*model.Race
+	Participants []model.RaceParticipant `json:"participants"`
+}
+
+func raceParam(c *gin.Context, route string) (int32, bool) {
+	rid, err := strconv.ParseInt(c.Param("raceid"), 10, 32)
+	if err != nil {
+		log.Printf("Error in %v: %v\n", route, err)
+		c.JSON(500, "")
+		return 0, false
+	}
+	return int32(rid), true
+}
+
+/*
+	Replays of the games of a race indexed by lane, lanes of purged bots are null
+	or left out after the last remaining lane. Race has no lane when all its bots were purged.
+*/
+func raceReplay(race *model.Race, participants []model.RaceParticipant) (*engine.RaceReplay, error) {
+	r := &engine.RaceReplay{Version: engine.ReplayVersion, MazeHash: race.MazeHash, Seed: race.Seed, Sight: race.Sight, Lanes: []*engine.Replay{}}
+	for _, p := range participants {
+		game := model.GetGame(playerDB, p.PlayerId, p.BotId, p.GameId)
+		if game == nil {
+			// bot purged while reading the race
+			continue
+		}
+		replay, err := engine.ReadReplay([]byte(game.Replay))
+		if err != nil {
+			return nil, err
+		}
+		for len(r.Lanes) <= p.Lane {
+			r.Lanes = append(r.Lanes, nil)
+		}
+		r.Maze = replay.Maze
+		r.Lanes[p.Lane] = replay
+	}
+	return r, nil
+}
+
+func addRaceRoutes(rg *gin.RouterGroup) {
+
+	// races, only races of a player when player_id is given
+	rg.GET("/races", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, err := strconv.ParseInt(c.DefaultQuery("player_id", "0"), 10, 32)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "player_id must be a number"})
+			return
+		}
+
+		races := model.GetRaces(playerDB, int32(pid))
+		if races == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, races)
+	})
+
+	// race current revisions of bots in a maze and record the game of each bot
+	rg.POST("/races", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		var body RaceBody
+		err := c.BindJSON(&body)
+		if err != nil {
+			log.Printf("Error in POST /races: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+		if len(body.Bots) < 2 || len(body.Bots) > MaxRacers {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("a race needs 2 to %d bots", MaxRacers)})
+			return
+		}
+		m, err := maze.Parse(body.Maze)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		codes := []*model.BotCode{}
+		for _, b := range body.Bots {
+			if !isOwner(c, b.PlayerId) {
+				c.String(403, "forbidden")
+				return
+			}
+			code := model.GetBotCode(playerDB, b.PlayerId, b.BotId)
+			if code == nil {
+				c.JSON(404, "")
+				return
+			}
+			codes = append(codes, code)
+		}
+
+		race, _, err := engine.PlayRace(playerDB, codes, m, body.MazeName, engine.RaceOptions{Seed: body.Seed, Sight: body.Sight}, nil)
+		if err != nil {
+			log.Printf("Error in POST /races: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, RaceDetail{Race: race, Participants: model.GetRaceParticipants(playerDB, race.Rid)})
+	})
+
+	rg.GET("/races/:raceid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		rid, ok := raceParam(c, "GET /races/:raceid")
+		if !ok {
+			return
+		}
+
+		race := model.GetRace(playerDB, rid)
+		if race == nil {
+			c.JSON(404, "")
+			return
+		}
+		participants := model.GetRaceParticipants(playerDB, rid)
+		if participants == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, RaceDetail{Race: race, Participants: participants})
+	})
+
+	// download replays of all lanes, to animate the race or play it again
+	rg.GET("/races/:raceid/replay", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		rid, ok := raceParam(c, "GET /races/:raceid/replay")
+		if !ok {
+			return
+		}
+
+		race := model.GetRace(playerDB, rid)
+		participants := model.GetRaceParticipants(playerDB, rid)
+		if race == nil || len(participants) == 0 {
+			c.JSON(404, "")
+			return
+		}
+		replay, err := raceReplay(race, participants)
+		if err != nil {
+			log.Printf("Error in GET /races/:raceid/replay: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		if len(replay.Lanes) == 0 {
+			c.JSON(404, "")
+			return
+		}
+		data, err := json.Marshal(replay)
+		if err != nil {
+			c.JSON(500, "")
+			return
+		}
+		filename := fmt.Sprintf("race%d.json", rid)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Data(200, "application/json", data)
+	})
+}
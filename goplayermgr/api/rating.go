@@ -0,0 +1,92 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/model"
+)
+
+// current rating with ratings after each rated game
+type RatingTrajectory struct {
+	Rating  *model.Rating         `json:"rating"`
+	History []model.RatingHistory `json:"history"`
+}
+
+/*
+	Read rated subject of route, a bot when route has a botid parameter.
+	Answer 404 when the player or the bot does not exist.
+*/
+func ratingParams(c *gin.Context, route string) (string, int32, int32, bool) {
+	if playerDB == nil {
+		playerDB = model.ConnectToDB(playerDSN)
+	}
+	pid, bid, _, ok := gameParams(c, route)
+	if !ok {
+		return "", 0, 0, false
+	}
+	if c.Param("botid") == "" {
+		if model.GetPlayer(playerDB, pid) == nil {
+			c.JSON(404, "")
+			return "", 0, 0, false
+		}
+		return model.RatingPlayer, pid, pid, true
+	}
+	if model.GetBot(playerDB, pid, bid) == nil {
+		c.JSON(404, "")
+		return "", 0, 0, false
+	}
+	return model.RatingBot, pid, bid, true
+}
+
+func ratingHandler(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		kind, pid, id, ok := ratingParams(c, route)
+		if !ok {
+			return
+		}
+
+		r := model.GetRating(playerDB, kind, pid, id)
+		if r == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, r)
+	}
+}
+
+func ratingHistoryHandler(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		kind, pid, id, ok := ratingParams(c, route)
+		if !ok {
+			return
+		}
+
+		r := model.GetRating(playerDB, kind, pid, id)
+		history := model.GetRatingHistory(playerDB, kind, id)
+		if r == nil || history == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, RatingTrajectory{Rating: r, History: history})
+	}
+}
+
+func addRatingRoutes(rg *gin.RouterGroup) {
+
+	// Glicko-2 rating of a player over the games of its bots
+	rg.GET("/players/:playerid/rating", ratingHandler("GET /players/:playerid/rating"))
+	rg.GET("/players/:playerid/rating/history", ratingHistoryHandler("GET /players/:playerid/rating/history"))
+
+	// Glicko-2 rating of a bot over all its revisions
+	rg.GET("/players/:playerid/bot/:botid/rating", ratingHandler("GET /players/:playerid/bot/:botid/rating"))
+	rg.GET("/players/:playerid/bot/:botid/rating/history", ratingHistoryHandler("GET /players/:playerid/bot/:botid/rating/history"))
+}
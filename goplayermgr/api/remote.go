@@ -0,0 +1,94 @@
+package api
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/model"
+)
+
+type RemoteBotBody struct {
+	Name   string `json:"name"`
+	URL    string `json:"url" binding:"required"`
+	Secret string `json:"secret" binding:"required"`
+}
+
+func addRemoteRoutes(rg *gin.RouterGroup) {
+
+	// conformance check of a remote bot before registering it, only by the owner of the player
+	rg.POST("/players/:playerid/bot/remote/check", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, err := strconv.ParseInt(c.Param("playerid"), 10, 32)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/remote/check: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		if !isOwner(c, int32(pid)) {
+			c.String(403, "forbidden")
+			return
+		}
+
+		var body RemoteBotBody
+		err = c.BindJSON(&body)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/remote/check: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+
+		c.JSON(200, engine.ValidateRemote(body.URL, body.Secret, engine.Limits{}))
+	})
+
+	rg.POST("/players/:playerid/bot/remote", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		pid, err := strconv.ParseInt(c.Param("playerid"), 10, 32)
+		if err != nil {
+			log.Printf("Error in POST /players/:playerid/bot/remote: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		if !isOwner(c, int32(pid)) {
+			c.String(403, "forbidden")
+			return
+		}
+
+		var body RemoteBotBody
+		err = c.BindJSON(&body)
+		if err != nil || body.Name == "" {
+			log.Printf("Error in POST /players/:playerid/bot/remote: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+
+		validation := engine.ValidateRemote(body.URL, body.Secret, engine.Limits{})
+		if !validation.Valid {
+			c.JSON(400, validation)
+			return
+		}
+
+		bot := model.AddRemoteBot(playerDB, int32(pid), body.Name, body.URL, body.Secret)
+		if bot == nil {
+			c.JSON(500, "")
+			return
+		}
+		recordAudit(c, model.AuditBotCreate, int32(pid), bot.Bid, nil, bot)
+		c.JSON(200, bot)
+	})
+}
@@ -0,0 +1,368 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"jc.org/playermgr/jobs"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+	"jc.org/playermgr/tournament"
+)
+
+// how often Serve starts tournaments whose entry deadline passed
+var TournamentPollInterval = time.Minute
+
+// body of tournament creation, mazes of suite are all used when Mazes is empty
+type TournamentBody struct {
+	Name          string    `json:"name" binding:"required"`
+	Format        string    `json:"format" binding:"required"`
+	Suite         string    `json:"suite" binding:"required"`
+	Mazes         []string  `json:"mazes"`
+	Rounds        int       `json:"rounds"`
+	EntryDeadline time.Time `json:"entry_deadline" binding:"required"`
+}
+
+// body of a registration, the current revision of the bot is registered
+type EntryBody struct {
+	PlayerId int32 `json:"player_id" binding:"required"`
+	BotId    int32 `json:"bot_id" binding:"required"`
+}
+
+// TournamentDetail is a tournament with its maze pool and entries
+type TournamentDetail struct {
+	*model.Tournament
+	Mazes   []model.TournamentMaze  `json:"mazes"`
+	Entries []model.TournamentEntry `json:"entries"`
+}
+
+/*
+	Queue the job playing the next round of a running tournament
+*/
+func enqueueRound(db *gorm.DB, tid int32) (*model.Job, error) {
+	return jobs.Enqueue(db, JobTournament, 0, 0, TournamentPayload{TournamentId: tid})
+}
+
+/*
+	Start tournaments whose entry deadline passed until ctx is done,
+	their rounds are played by job workers
+*/
+func ScheduleTournaments(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(TournamentPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, t := range tournament.StartDue(db, time.Now()) {
+			_, err := enqueueRound(db, t.Tid)
+			if err != nil {
+				log.Printf("Error starting tournament %v: %v\n", t.Tid, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func tournamentParam(c *gin.Context, route string) (int32, bool) {
+	tid, err := strconv.ParseInt(c.Param("tournamentid"), 10, 32)
+	if err != nil {
+		log.Printf("Error in %v: %v\n", route, err)
+		c.JSON(500, "")
+		return 0, false
+	}
+	return int32(tid), true
+}
+
+func tournamentDetail(c *gin.Context, tid int32) {
+	t := model.GetTournament(playerDB, tid)
+	if t == nil {
+		c.JSON(404, "")
+		return
+	}
+	mazes := model.GetTournamentMazes(playerDB, tid)
+	entries := model.GetTournamentEntries(playerDB, tid)
+	if mazes == nil || entries == nil {
+		c.JSON(500, "")
+		return
+	}
+	c.JSON(200, TournamentDetail{Tournament: t, Mazes: mazes, Entries: entries})
+}
+
+// answer tournament state errors as conflicts
+func tournamentError(c *gin.Context, route string, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(404, "")
+	case errors.Is(err, model.ErrEntriesClosed), errors.Is(err, model.ErrTournamentState):
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error in %v: %v\n", route, err)
+		c.JSON(500, "")
+	}
+}
+
+func addTournamentRoutes(rg *gin.RouterGroup) {
+
+	// tournaments, optionally of a state
+	rg.GET("/tournaments", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		list := model.GetTournaments(playerDB, c.Query("state"))
+		if list == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, list)
+	})
+
+	// create an open tournament over a maze pool
+	rg.POST("/tournaments", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.admin")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+
+		var body TournamentBody
+		err := c.BindJSON(&body)
+		if err != nil {
+			log.Printf("Error in POST /tournaments: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+		suite, err := maze.FindSuite(MazeDir, body.Suite)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		t, err := tournament.Create(playerDB, &model.Tournament{Name: body.Name, Format: body.Format, Rounds: body.Rounds, EntryDeadline: body.EntryDeadline}, suite, body.Mazes)
+		if errors.Is(err, model.ErrUnknownFormat) || errors.Is(err, maze.ErrUnknownSuite) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error in POST /tournaments: %v\n", err)
+			c.JSON(500, "")
+			return
+		}
+		tournamentDetail(c, t.Tid)
+	})
+
+	rg.GET("/tournaments/:tournamentid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		tid, ok := tournamentParam(c, "GET /tournaments/:tournamentid")
+		if !ok {
+			return
+		}
+		tournamentDetail(c, tid)
+	})
+
+	// register current revision of a bot, it replaces the entry of its player
+	rg.POST("/tournaments/:tournamentid/entries", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		tid, ok := tournamentParam(c, "POST /tournaments/:tournamentid/entries")
+		if !ok {
+			return
+		}
+		var body EntryBody
+		err := c.BindJSON(&body)
+		if err != nil {
+			log.Printf("Error in POST /tournaments/:tournamentid/entries: %v\n", err)
+			c.JSON(400, "")
+			return
+		}
+		if !isOwner(c, body.PlayerId) {
+			c.String(403, "forbidden")
+			return
+		}
+		code := model.GetBotCode(playerDB, body.PlayerId, body.BotId)
+		if code == nil || model.GetTournament(playerDB, tid) == nil {
+			c.JSON(404, "")
+			return
+		}
+
+		entry, err := model.RegisterTournamentEntry(playerDB, tid, code, time.Now())
+		if err != nil {
+			tournamentError(c, "POST /tournaments/:tournamentid/entries", err)
+			return
+		}
+		c.JSON(200, entry)
+	})
+
+	// withdraw entry of a player before the deadline
+	rg.DELETE("/tournaments/:tournamentid/entries/:playerid", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.edit")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		tid, ok := tournamentParam(c, "DELETE /tournaments/:tournamentid/entries/:playerid")
+		if !ok {
+			return
+		}
+		pid, _, _, ok := gameParams(c, "DELETE /tournaments/:tournamentid/entries/:playerid")
+		if !ok {
+			return
+		}
+		if !isOwner(c, pid) {
+			c.String(403, "forbidden")
+			return
+		}
+
+		err := model.WithdrawTournamentEntry(playerDB, tid, pid, time.Now())
+		if err != nil {
+			tournamentError(c, "DELETE /tournaments/:tournamentid/entries/:playerid", err)
+			return
+		}
+		c.JSON(200, "")
+	})
+
+	// close entries now and queue the first round
+	rg.POST("/tournaments/:tournamentid/start", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.admin")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		tid, ok := tournamentParam(c, "POST /tournaments/:tournamentid/start")
+		if !ok {
+			return
+		}
+
+		t, err := tournament.Start(playerDB, tid, time.Now())
+		if err != nil {
+			tournamentError(c, "POST /tournaments/:tournamentid/start", err)
+			return
+		}
+		if t.State == model.TournamentRunning {
+			_, err = enqueueRound(playerDB, tid)
+			if err != nil {
+				log.Printf("Error in POST /tournaments/:tournamentid/start: %v\n", err)
+				c.JSON(500, "")
+				return
+			}
+		}
+		c.JSON(200, t)
+	})
+
+	rg.POST("/tournaments/:tournamentid/cancel", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.admin")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		tid, ok := tournamentParam(c, "POST /tournaments/:tournamentid/cancel")
+		if !ok {
+			return
+		}
+		if model.GetTournament(playerDB, tid) == nil {
+			c.JSON(404, "")
+			return
+		}
+
+		err := model.CancelTournament(playerDB, tid, time.Now())
+		if err != nil {
+			tournamentError(c, "POST /tournaments/:tournamentid/cancel", err)
+			return
+		}
+		c.JSON(200, model.GetTournament(playerDB, tid))
+	})
+
+	// matches with their games, of a round when round is given
+	rg.GET("/tournaments/:tournamentid/matches", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		tid, ok := tournamentParam(c, "GET /tournaments/:tournamentid/matches")
+		if !ok {
+			return
+		}
+		round, err := strconv.Atoi(c.DefaultQuery("round", "0"))
+		if err != nil || round < 0 {
+			c.JSON(400, gin.H{"error": "round must be a positive number"})
+			return
+		}
+		if model.GetTournament(playerDB, tid) == nil {
+			c.JSON(404, "")
+			return
+		}
+
+		matches := model.GetTournamentMatches(playerDB, tid, round)
+		if matches == nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, matches)
+	})
+
+	rg.GET("/tournaments/:tournamentid/standings", func(c *gin.Context) {
+		authorized := CheckRole(c.Request, "player.view")
+		if !authorized {
+			c.String(401, "unauthorized")
+			return
+		}
+		if playerDB == nil {
+			playerDB = model.ConnectToDB(playerDSN)
+		}
+		tid, ok := tournamentParam(c, "GET /tournaments/:tournamentid/standings")
+		if !ok {
+			return
+		}
+		if model.GetTournament(playerDB, tid) == nil {
+			c.JSON(404, "")
+			return
+		}
+
+		standings, err := model.TournamentStandings(playerDB, tid)
+		if err != nil {
+			c.JSON(500, "")
+			return
+		}
+		c.JSON(200, standings)
+	})
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/model"
+)
+
+// content type of WebAssembly bot upload
+const WasmContentType = "application/wasm"
+
+var errBodyTooLarge = fmt.Errorf("bot module is larger than %d bytes", engine.MaxWasmSize)
+
+/*
+	Check uploaded bot code, answer 400 with diagnostics when it is not valid
+*/
+func validBotCode(c *gin.Context, language string, filename string, code string) bool {
+	validation := engine.ValidateBot(language, code, filename, engine.Limits{})
+	if !validation.Valid {
+		c.JSON(400, validation)
+		return false
+	}
+	return true
+}
+
+/*
+	Read a binary WebAssembly module, name and filename are query parameters.
+	Reading stops one byte after the maximum size of a module.
+*/
+func bindWasmBody(c *gin.Context, body *AddBotBody) error {
+	body.Name = c.Query("name")
+	body.Filename = c.Query("filename")
+	if body.Name == "" || body.Filename == "" {
+		return errors.New("name and filename query parameters are required")
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(engine.MaxWasmSize)+1)
+	binary, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		if len(binary) > engine.MaxWasmSize {
+			return errBodyTooLarge
+		}
+		return err
+	}
+	body.Language = model.LanguageWasm
+	body.Botcode = base64.StdEncoding.EncodeToString(binary)
+	return nil
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/user"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	"jc.org/playermgr/model"
+)
+
+var auditFilter model.AuditFilter
+var auditSince string
+var auditUntil string
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query audit log",
+	Long: `Show administrative and bot changing actions recorded in audit log.
+Most recent actions are displayed first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dsn := getDSN()
+		fmt.Printf("audit called with DSN: %v\n", dsn)
+
+		filter := auditFilter
+		if auditSince != "" {
+			since, err := time.Parse(time.RFC3339, auditSince)
+			if err != nil {
+				fmt.Printf("audit cannot read since date: %v\n", err)
+				return
+			}
+			filter.Since = since
+		}
+		if auditUntil != "" {
+			until, err := time.Parse(time.RFC3339, auditUntil)
+			if err != nil {
+				fmt.Printf("audit cannot read until date: %v\n", err)
+				return
+			}
+			filter.Until = until
+		}
+
+		db := model.ConnectToDB(dsn)
+		entries := model.GetAuditEntries(db, filter)
+		prettyJSON, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			log.Fatal("Failed to generate json", err)
+		}
+		fmt.Printf("%s\n", string(prettyJSON))
+	},
+}
+
+/*
+	Record an action done with the cli, actor is the local user
+*/
+func recordCliAudit(db *gorm.DB, action string, pid int32, bid int32, before interface{}, after interface{}) {
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor = "cli:" + u.Username
+	}
+
+	model.AddAuditEntry(db, &model.AuditEntry{
+		Actor:    actor,
+		Action:   action,
+		PlayerId: pid,
+		BotId:    bid,
+		Before:   model.AuditSummary(before),
+		After:    model.AuditSummary(after),
+	})
+}
+
+func init() {
+	auditCmd.Flags().StringVar(&auditFilter.Actor, "actor", "", "Only show actions done by actor")
+	auditCmd.Flags().StringVar(&auditFilter.Action, "action", "", "Only show action (e.g. player.delete)")
+	auditCmd.Flags().Int32Var(&auditFilter.PlayerId, "playerid", 0, "Only show actions on player")
+	auditCmd.Flags().Int32Var(&auditFilter.BotId, "botid", 0, "Only show actions on bot")
+	auditCmd.Flags().StringVar(&auditSince, "since", "", "Only show actions after date (RFC3339)")
+	auditCmd.Flags().StringVar(&auditUntil, "until", "", "Only show actions before date (RFC3339)")
+	auditCmd.Flags().IntVar(&auditFilter.Limit, "limit", 100, "Maximum number of entries")
+	rootCmd.AddCommand(auditCmd)
+}
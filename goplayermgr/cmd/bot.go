@@ -0,0 +1,433 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+var runMazeFile string
+var runBot string
+var runPlayerId int32
+var runLogFile string
+var runMaxSteps int
+var runStepTimeout time.Duration
+var runTotalTimeout time.Duration
+var runSeed int64
+var runURL string
+var runSecret string
+var runReplayFile string
+var replayFile string
+var replayLogFile string
+var evalSuite string
+var evalBot string
+var evalPlayerId int32
+var evalWorkers int
+var evalSeed int64
+var evalStepTimeout time.Duration
+var evalTotalTimeout time.Duration
+var evalJSON bool
+var raceMazeFile string
+var raceBots []string
+var raceSight bool
+var raceSeed int64
+var raceMaxSteps int
+var raceStepTimeout time.Duration
+var raceTotalTimeout time.Duration
+var raceReplayFile string
+
+// botCmd groups the commands working on bot code
+var botCmd = &cobra.Command{
+	Use:   "bot",
+	Short: "Work with bot code",
+	Long:  `Run and check bot code locally.`,
+}
+
+// botRunCmd plays a bot in a maze
+var botRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run a bot in a maze",
+	Long: `Run a bot in a maze without starting a game.
+The bot is a JavaScript file (--bot bot.js), a WebAssembly module (--bot bot.wasm),
+a remote bot (--url URL --secret SECRET) or a bot of a player in database (--player 1 --bot 2).
+The maze is a mazemgr JSON maze or an ASCII maze file.
+Prints the maze with the bot trail, the number of steps and the outcome.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+
+		m, err := maze.ReadFile(runMazeFile)
+		if err != nil {
+			log.Fatalf("Cannot read maze %v: %v", runMazeFile, err)
+		}
+
+		limits := engine.Limits{
+			MaxSteps:     runMaxSteps,
+			StepTimeout:  runStepTimeout,
+			TotalTimeout: runTotalTimeout,
+		}
+
+		// bot output and stack of errors are printed as they come
+		console := engine.NewConsole(out)
+		bot, err := loadRunBot(limits, console)
+		if err != nil {
+			log.Fatalf("Cannot load bot %v: %v", runBot, err)
+		}
+
+		opts := engine.Options{Limits: limits, Seed: runSeed, Record: runReplayFile != "", Console: console}
+		if runLogFile != "" {
+			f, err := os.Create(runLogFile)
+			if err != nil {
+				log.Fatalf("Cannot create log file %v: %v", runLogFile, err)
+			}
+			defer f.Close()
+			opts.StepLog = f
+		}
+
+		result := engine.Run(m, bot, opts)
+		engine.CloseBot(bot)
+
+		if runReplayFile != "" {
+			data, err := json.Marshal(result.Replay)
+			if err != nil {
+				log.Fatal("Failed to generate json", err)
+			}
+			err = ioutil.WriteFile(runReplayFile, data, 0644)
+			if err != nil {
+				log.Fatalf("Cannot write replay %v: %v", runReplayFile, err)
+			}
+		}
+
+		printResult(out, result)
+	},
+}
+
+// botRaceCmd races bots in the same maze
+var botRaceCmd = &cobra.Command{
+	Use:   "race",
+	Short: "Race bots in a maze",
+	Long: `Race bot files (--bot a.js --bot b.wasm) from the entry of the same maze, in lockstep.
+With --sight, JavaScript bots see the others in room.bots: their lane and the direction
+of the door leading to them, none when they are in the same room.
+Prints the rank of each bot, 0 when it did not exit, its steps and outcome.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+
+		m, err := maze.ReadFile(raceMazeFile)
+		if err != nil {
+			log.Fatalf("Cannot read maze %v: %v", raceMazeFile, err)
+		}
+		if len(raceBots) < 2 {
+			log.Fatal("A race needs at least 2 bots")
+		}
+
+		opts := engine.RaceOptions{
+			Limits: engine.Limits{
+				MaxSteps:     raceMaxSteps,
+				StepTimeout:  raceStepTimeout,
+				TotalTimeout: raceTotalTimeout,
+			},
+			Seed:   raceSeed,
+			Record: raceReplayFile != "",
+			Sight:  raceSight,
+		}
+		bots := []engine.Bot{}
+		for _, file := range raceBots {
+			bot, err := loadFileBot(file, opts.Limits, nil)
+			if err != nil {
+				log.Fatalf("Cannot load bot %v: %v", file, err)
+			}
+			defer engine.CloseBot(bot)
+			bots = append(bots, bot)
+		}
+
+		race := engine.Race(m, bots, opts)
+
+		if raceReplayFile != "" {
+			replay := engine.RaceReplay{Version: engine.ReplayVersion, MazeHash: m.Hash(), Maze: m.Render(), Seed: raceSeed, Sight: raceSight}
+			for _, r := range race.Racers {
+				replay.Lanes = append(replay.Lanes, r.Result.Replay)
+			}
+			data, err := json.Marshal(replay)
+			if err != nil {
+				log.Fatal("Failed to generate json", err)
+			}
+			err = ioutil.WriteFile(raceReplayFile, data, 0644)
+			if err != nil {
+				log.Fatalf("Cannot write replay %v: %v", raceReplayFile, err)
+			}
+		}
+
+		printRace(out, race, raceBots)
+	},
+}
+
+// botReplayCmd plays a recorded game again
+var botReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay a recorded game",
+	Long: `Play again a game recorded by bot run --replay or downloaded from
+/api/players/:playerid/bot/:botid/games/:gameid/replay, without the bot.
+A race recorded by bot race --replay or downloaded from /api/races/:raceid/replay
+is played again lane by lane.
+Fails when the game does not end like the recorded one.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+
+		data, err := ioutil.ReadFile(replayFile)
+		if err != nil {
+			log.Fatalf("Cannot read replay %v: %v", replayFile, err)
+		}
+		var raceReplay engine.RaceReplay
+		if json.Unmarshal(data, &raceReplay) == nil && len(raceReplay.Lanes) > 0 {
+			replayRace(out, &raceReplay)
+			return
+		}
+		replay, err := engine.ReadReplay(data)
+		if err != nil {
+			log.Fatalf("Cannot read replay %v: %v", replayFile, err)
+		}
+		m, err := replay.ParseMaze()
+		if err != nil {
+			log.Fatalf("Cannot read maze of replay %v: %v", replayFile, err)
+		}
+
+		var opts engine.Options
+		if replayLogFile != "" {
+			f, err := os.Create(replayLogFile)
+			if err != nil {
+				log.Fatalf("Cannot create log file %v: %v", replayLogFile, err)
+			}
+			defer f.Close()
+			opts.StepLog = f
+		}
+
+		result, err := engine.PlayReplay(m, replay, opts)
+		if err != nil {
+			log.Fatalf("Cannot replay %v: %v", replayFile, err)
+		}
+		printResult(out, result)
+	},
+}
+
+// botEvalCmd plays a bot in each maze of a suite
+var botEvalCmd = &cobra.Command{
+	Use:   "eval",
+	Short: "Evaluate a bot over a maze suite",
+	Long: `Play a bot in each maze of a suite, several games at a time, and print
+the success rate and step statistics of successful games.
+The suite is a directory of maze files or the name of a suite of the mazes directory (--mazes).
+The bot is a JavaScript or WebAssembly file (--bot bot.js) or a bot of a player
+in database (--player 1 --bot 2), games and evaluation of a player bot are stored.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+
+		suite, err := readEvalSuite(evalSuite)
+		if err != nil {
+			log.Fatalf("Cannot read suite %v: %v", evalSuite, err)
+		}
+		opts := engine.EvalOptions{
+			Limits: engine.Limits{
+				StepTimeout:  evalStepTimeout,
+				TotalTimeout: evalTotalTimeout,
+			},
+			Workers: evalWorkers,
+			Seed:    evalSeed,
+		}
+
+		var evaluation *engine.Evaluation
+		if evalPlayerId == -1 {
+			evaluation = engine.Evaluate(suite, func(console io.Writer) (engine.Bot, error) {
+				return loadFileBot(evalBot, opts.Limits, console)
+			}, opts)
+		} else {
+			bid, err := strconv.ParseInt(evalBot, 10, 32)
+			if err != nil {
+				log.Fatalf("Bot must be an ID when a player is given")
+			}
+			db := model.ConnectToDB(getDSN())
+			_, evaluation, err = engine.EvaluateBot(db, evalPlayerId, int32(bid), suite, opts)
+			if err != nil {
+				log.Fatalf("Cannot evaluate bot %v: %v", evalBot, err)
+			}
+		}
+
+		if evalJSON {
+			data, err := json.MarshalIndent(evaluation, "", "    ")
+			if err != nil {
+				log.Fatal("Failed to generate json", err)
+			}
+			fmt.Fprintln(out, string(data))
+			return
+		}
+		printEvaluation(out, evaluation)
+	},
+}
+
+// a directory or a suite of the mazes directory
+func readEvalSuite(suite string) (*maze.Suite, error) {
+	if info, err := os.Stat(suite); err == nil && info.IsDir() {
+		return maze.ReadSuite(suite)
+	}
+	return maze.FindSuite(viper.GetString("mazes.dir"), suite)
+}
+
+func printEvaluation(out io.Writer, e *engine.Evaluation) {
+	for _, r := range e.Results {
+		fmt.Fprintf(out, "%-20s %-8s steps: %5d  optimal: %5d  score: %.3f", r.Maze, r.State, r.Steps, r.OptimalSteps, r.Score)
+		if r.Error != "" {
+			fmt.Fprintf(out, "  error: %s", r.Error)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "suite: %s, mazes: %d, successes: %d (%.1f%%)\n", e.Suite, e.Mazes, e.Successes, e.SuccessRate*100)
+	fmt.Fprintf(out, "steps: mean %.1f, p50 %d, p90 %d, p95 %d\n", e.MeanSteps, e.P50Steps, e.P90Steps, e.P95Steps)
+	fmt.Fprintf(out, "optimal ratio: %.3f, mean score: %.3f, time: %v\n", e.OptimalRatio, e.MeanScore, e.Duration)
+}
+
+// print maze with bot trail and outcome of game
+func printResult(out io.Writer, result *engine.Result) {
+	fmt.Fprintln(out, strings.Join(result.BotResult.Maze, "\n"))
+	fmt.Fprintf(out, "steps: %d\n", result.Steps)
+	fmt.Fprintf(out, "state: %s\n", result.State)
+	if result.OptimalSteps > 0 {
+		fmt.Fprintf(out, "optimal steps: %d, score: %.3f\n", result.OptimalSteps, result.Score)
+	}
+	if result.Error != "" {
+		fmt.Fprintf(out, "error: %s\n", result.Error)
+	}
+	if result.Usage != nil {
+		fmt.Fprintf(out, "operations: %d, allocated: %d bytes, time: %v (max step %v)\n",
+			result.Usage.Operations, result.Usage.AllocatedBytes, result.Usage.StepsDuration, result.Usage.MaxStepDuration)
+	}
+}
+
+func replayRace(out io.Writer, replay *engine.RaceReplay) {
+	m, err := maze.Parse(replay.Maze)
+	if err != nil {
+		log.Fatalf("Cannot read maze of replay %v: %v", replayFile, err)
+	}
+	race, err := engine.PlayRaceReplay(m, replay)
+	if err != nil {
+		log.Fatalf("Cannot replay %v: %v", replayFile, err)
+	}
+	// bots of local races are not in database, purged lanes are skipped
+	names := []string{}
+	for _, lane := range replay.Lanes {
+		if lane == nil {
+			continue
+		}
+		name := ""
+		if lane.BotId != 0 {
+			name = fmt.Sprintf("player %d bot %d", lane.PlayerId, lane.BotId)
+		}
+		names = append(names, name)
+	}
+	printRace(out, race, names)
+}
+
+// one line per lane with the name of its bot
+func printRace(out io.Writer, race *engine.RaceResult, names []string) {
+	for i, r := range race.Racers {
+		fmt.Fprintf(out, "%2d  rank %d  %-20s %-8s steps: %d", r.Lane, r.Rank, names[i], r.Result.State, r.Result.Steps)
+		if r.Result.Error != "" {
+			fmt.Fprintf(out, "  error: %s", r.Result.Error)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "ticks: %d\n", race.Ticks)
+}
+
+// load bot from file or from database when a player is given
+func loadRunBot(limits engine.Limits, console io.Writer) (engine.Bot, error) {
+	if runURL != "" {
+		// URL is given by the user of the command, bot may run locally
+		engine.AllowPrivateRemotes = true
+		return engine.NewRemoteBot(runURL, runSecret, limits)
+	}
+	if runBot == "" {
+		return nil, fmt.Errorf("a bot or an URL is required")
+	}
+	if runPlayerId == -1 {
+		return loadFileBot(runBot, limits, console)
+	}
+
+	bid, err := strconv.ParseInt(runBot, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bot must be an ID when a player is given")
+	}
+	dsn := getDSN()
+	db := model.ConnectToDB(dsn)
+	return engine.LoadBot(db, runPlayerId, int32(bid), limits, console)
+}
+
+// load JavaScript or WebAssembly bot file
+func loadFileBot(file string, limits engine.Limits, console io.Writer) (engine.Bot, error) {
+	code, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(file) == ".wasm" {
+		return engine.NewWasmBot(code, limits, console)
+	}
+	return engine.NewJSBot(string(code), file, limits, console)
+}
+
+func init() {
+	botRunCmd.Flags().StringVar(&runMazeFile, "maze", "", "Maze file")
+	botRunCmd.Flags().StringVar(&runBot, "bot", "", "Bot file, or bot ID when a player is given")
+	botRunCmd.Flags().Int32Var(&runPlayerId, "player", -1, "ID of player owning the bot")
+	botRunCmd.Flags().StringVar(&runLogFile, "log", "", "Write maze after each step to this file, like gamemgr")
+	botRunCmd.Flags().IntVar(&runMaxSteps, "max-steps", 0, "Maximum number of steps (default rows*cols*4)")
+	botRunCmd.Flags().DurationVar(&runStepTimeout, "step-timeout", engine.DefaultLimits.StepTimeout, "Time budget of a step")
+	botRunCmd.Flags().DurationVar(&runTotalTimeout, "timeout", engine.DefaultLimits.TotalTimeout, "Time budget of the game")
+	botRunCmd.Flags().Int64Var(&runSeed, "seed", 0, "Seed of Math.random")
+	botRunCmd.Flags().StringVar(&runURL, "url", "", "URL of a remote bot")
+	botRunCmd.Flags().StringVar(&runSecret, "secret", "", "Secret signing requests to remote bot")
+	botRunCmd.Flags().StringVar(&runReplayFile, "replay", "", "Write a replay of the game to this file")
+	botRunCmd.MarkFlagRequired("maze")
+
+	botReplayCmd.Flags().StringVar(&replayFile, "replay", "", "Replay file")
+	botReplayCmd.Flags().StringVar(&replayLogFile, "log", "", "Write maze after each step to this file, like gamemgr")
+	botReplayCmd.MarkFlagRequired("replay")
+
+	botRaceCmd.Flags().StringVar(&raceMazeFile, "maze", "", "Maze file")
+	botRaceCmd.Flags().StringArrayVar(&raceBots, "bot", nil, "Bot file, once per lane")
+	botRaceCmd.Flags().BoolVar(&raceSight, "sight", false, "Bots see the others in room descriptions")
+	botRaceCmd.Flags().Int64Var(&raceSeed, "seed", 0, "Seed of Math.random")
+	botRaceCmd.Flags().IntVar(&raceMaxSteps, "max-steps", 0, "Maximum number of steps (default rows*cols*4)")
+	botRaceCmd.Flags().DurationVar(&raceStepTimeout, "step-timeout", engine.DefaultLimits.StepTimeout, "Time budget of a step")
+	botRaceCmd.Flags().DurationVar(&raceTotalTimeout, "timeout", engine.DefaultLimits.TotalTimeout, "Time budget of each bot")
+	botRaceCmd.Flags().StringVar(&raceReplayFile, "replay", "", "Write a replay of the race to this file")
+	botRaceCmd.MarkFlagRequired("maze")
+	botRaceCmd.MarkFlagRequired("bot")
+
+	botEvalCmd.Flags().StringVar(&evalSuite, "suite", "", "Directory of mazes or name of a suite")
+	botEvalCmd.Flags().StringVar(&evalBot, "bot", "", "Bot file, or bot ID when a player is given")
+	botEvalCmd.Flags().Int32Var(&evalPlayerId, "player", -1, "ID of player owning the bot")
+	botEvalCmd.Flags().IntVar(&evalWorkers, "workers", 0, "Number of games played in parallel (default number of CPUs)")
+	botEvalCmd.Flags().Int64Var(&evalSeed, "seed", 0, "Seed of Math.random")
+	botEvalCmd.Flags().DurationVar(&evalStepTimeout, "step-timeout", engine.DefaultLimits.StepTimeout, "Time budget of a step")
+	botEvalCmd.Flags().DurationVar(&evalTotalTimeout, "timeout", engine.DefaultLimits.TotalTimeout, "Time budget of each game")
+	botEvalCmd.Flags().BoolVar(&evalJSON, "json", false, "Print evaluation as JSON")
+	botEvalCmd.MarkFlagRequired("suite")
+	botEvalCmd.MarkFlagRequired("bot")
+
+	botCmd.AddCommand(botRunCmd)
+	botCmd.AddCommand(botEvalCmd)
+	botCmd.AddCommand(botReplayCmd)
+	botCmd.AddCommand(botRaceCmd)
+	rootCmd.AddCommand(botCmd)
+}
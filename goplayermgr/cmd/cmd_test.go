@@ -2,10 +2,15 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"strings"
 	"testing"
 
+	"jc.org/playermgr/api"
+	"jc.org/playermgr/jobs"
+	"jc.org/playermgr/maze"
 	"jc.org/playermgr/model"
 )
 
@@ -186,3 +191,276 @@ func Test_CreateCommandSQLITE(t *testing.T) {
 		t.Errorf("expected \"%s\" got \"%s\"", "", string(out))
 	}
 }
+
+func Test_AuditCommandSQLITE(t *testing.T) {
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "audit", "--action", "player.create"})
+	rootCmd.Execute()
+	out, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Error(err)
+	}
+	res := string(out)
+	if !strings.HasPrefix(res, "") {
+		t.Errorf("expected \"%s\" got \"%s\"", "", string(out))
+	}
+
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "audit", "--since", "yesterday"})
+	rootCmd.Execute()
+	out, err = ioutil.ReadAll(b)
+	if err != nil {
+		t.Error(err)
+	}
+	res = string(out)
+	if !strings.HasPrefix(res, "") {
+		t.Errorf("expected \"%s\" got \"%s\"", "", string(out))
+	}
+}
+
+func Test_RestoreCommandSQLITE(t *testing.T) {
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "restore", "--playerid", "1"})
+	rootCmd.Execute()
+	out, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Error(err)
+	}
+	res := string(out)
+	if !strings.HasPrefix(res, "") {
+		t.Errorf("expected \"%s\" got \"%s\"", "", string(out))
+	}
+
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "delete", "--playerid", "2", "--hard"})
+	rootCmd.Execute()
+	out, err = ioutil.ReadAll(b)
+	if err != nil {
+		t.Error(err)
+	}
+	res = string(out)
+	if !strings.HasPrefix(res, "") {
+		t.Errorf("expected \"%s\" got \"%s\"", "", string(out))
+	}
+
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "--retention", "0s", "purge"})
+	rootCmd.Execute()
+	out, err = ioutil.ReadAll(b)
+	if err != nil {
+		t.Error(err)
+	}
+	res = string(out)
+	if !strings.HasPrefix(res, "") {
+		t.Errorf("expected \"%s\" got \"%s\"", "", string(out))
+	}
+}
+
+func Test_FsckCommandSQLITE(t *testing.T) {
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "fsck", "--repair"})
+	rootCmd.Execute()
+	out, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Error(err)
+	}
+	res := string(out)
+	if !strings.HasPrefix(res, "") {
+		t.Errorf("expected \"%s\" got \"%s\"", "", string(out))
+	}
+}
+
+func Test_BotRunCommand(t *testing.T) {
+	mazeFile := t.TempDir() + "/maze.json"
+	err := ioutil.WriteFile(mazeFile, []byte(`{"id": 2, "configuration": {"maze": [
+		"+-+-+-+-+",
+		"| | | | |",
+		"+-+-+ +-+",
+		"x     | |",
+		"+-+ + +-+",
+		"|   |   X",
+		"+ +-+-+-+",
+		"|   | | |",
+		"+-+-+-+-+"]}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile := t.TempDir() + "/player0_bot0_game0.log"
+	replayFile := t.TempDir() + "/replay.json"
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"bot", "run", "--maze", mazeFile, "--bot", "../data/bots/bot3.js", "--log", logFile, "--replay", replayFile})
+	rootCmd.Execute()
+	out, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Error(err)
+	}
+	res := string(out)
+	if !strings.Contains(res, "state: success") {
+		t.Errorf("expected \"%s\" got \"%s\"", "state: success", res)
+	}
+	steps, err := ioutil.ReadFile(logFile)
+	if err != nil || !strings.HasPrefix(string(steps), "=== STEP 0 ====") {
+		t.Errorf("unexpected step log %v %v", string(steps), err)
+	}
+
+	replayLog := t.TempDir() + "/replay.log"
+	b = bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"bot", "replay", "--replay", replayFile, "--log", replayLog})
+	rootCmd.Execute()
+	if !strings.Contains(b.String(), "state: success") {
+		t.Errorf("expected \"%s\" got \"%s\"", "state: success", b.String())
+	}
+	replayed, _ := ioutil.ReadFile(replayLog)
+	if string(replayed) != string(steps) {
+		t.Errorf("replay log differs from game log %v", string(replayed))
+	}
+}
+
+func Test_BotEvalCommand(t *testing.T) {
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"bot", "eval", "--suite", "../data/mazes/basic", "--bot", "../data/bots/bot3.js", "--workers", "2"})
+	rootCmd.Execute()
+	res := b.String()
+	if !strings.Contains(res, "suite: basic, mazes: 5, successes: 5 (100.0%)") || !strings.Contains(res, "prim-6x8") {
+		t.Errorf("unexpected evaluation \"%s\"", res)
+	}
+}
+
+func Test_BotRaceCommand(t *testing.T) {
+	mazeFile := t.TempDir() + "/corridor.txt"
+	err := ioutil.WriteFile(mazeFile, []byte("+-+-+-+\nx     X\n+-+-+-+\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	botFile := t.TempDir() + "/wait.js"
+	ioutil.WriteFile(botFile, []byte("function executeStep(room) { return { action: room.bots ? 'wait' : 'fail' }; }"), 0600)
+	replayFile := t.TempDir() + "/race.json"
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"bot", "race", "--maze", mazeFile, "--bot", "../data/bots/bot3.js", "--bot", botFile, "--sight", "--replay", replayFile})
+	rootCmd.Execute()
+	res := b.String()
+	if !strings.Contains(res, "rank 1  ../data/bots/bot3.js") || !strings.Contains(res, "ticks: 12") {
+		t.Errorf("unexpected race \"%s\"", res)
+	}
+
+	b = bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"bot", "replay", "--replay", replayFile})
+	rootCmd.Execute()
+	if !strings.Contains(b.String(), "ticks: 12") {
+		t.Errorf("expected \"%s\" got \"%s\"", "ticks: 12", b.String())
+	}
+}
+
+func Test_WorkerCommandSQLITE(t *testing.T) {
+	db := model.ConnectToDB("file::memory:?cache=shared")
+	p := model.AddPlayer(db, "Worker")
+	bot := model.AddBot(db, p.Pid, "Right", "right.js", "function executeStep(room) { return { action: 'move', direction: 'right' }; }")
+	job, _ := jobs.Enqueue(db, api.JobValidation, p.Pid, bot.Bid, struct{}{})
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "worker", "--once"})
+	rootCmd.Execute()
+	if !strings.Contains(b.String(), "1 jobs run") {
+		t.Errorf("expected \"%s\" got \"%s\"", "1 jobs run", b.String())
+	}
+	if done := model.GetJob(db, job.Jid); done.State != model.JobSucceeded {
+		t.Errorf("job should succeed %+v", done)
+	}
+}
+
+func Test_LeaderboardCommandSQLITE(t *testing.T) {
+	db := model.ConnectToDB("file::memory:?cache=shared")
+	p := model.AddPlayer(db, "Champion")
+	bot := model.AddBot(db, p.Pid, "Winner", "winner.js", "// some code")
+	model.AddGame(db, &model.Game{PlayerId: p.Pid, BotId: bot.Bid, BotVersion: 1, MazeHash: "sha256:cmd", State: "success", Steps: 4, OptimalSteps: 4, Score: 1})
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "leaderboard", "--maze", "sha256:cmd", "--window", "week"})
+	rootCmd.Execute()
+	if !strings.Contains(b.String(), "1 bots") || !strings.Contains(b.String(), "Winner") {
+		t.Errorf("unexpected leaderboard \"%s\"", b.String())
+	}
+
+	b.Reset()
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "leaderboard", "rebuild"})
+	rootCmd.Execute()
+	if !strings.Contains(b.String(), "1 leaderboard entries") {
+		t.Errorf("unexpected rebuild \"%s\"", b.String())
+	}
+}
+
+func Test_TournamentCommandSQLITE(t *testing.T) {
+	db := model.ConnectToDB("file::memory:?cache=shared")
+	code, _ := ioutil.ReadFile("../data/bots/bot3.js")
+	first := model.AddPlayer(db, "Entrant1")
+	second := model.AddPlayer(db, "Entrant2")
+	follower := model.AddBot(db, first.Pid, "Follower", "bot3.js", string(code))
+	right := model.AddBot(db, second.Pid, "Right", "right.js", "function executeStep(room) { return { action: 'move', direction: 'right' }; }")
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "tournament", "create", "--name", "Cli cup", "--format", "round-robin",
+		"--suite", "../data/mazes/basic", "--maze", "backtracker-5x5", "--deadline", "1h"})
+	rootCmd.Execute()
+	var cup model.Tournament
+	if err := json.Unmarshal(b.Bytes(), &cup); err != nil || cup.State != model.TournamentOpen || cup.Suite != "basic" {
+		t.Fatalf("unexpected tournament \"%s\"", b.String())
+	}
+	tid := fmt.Sprint(cup.Tid)
+
+	for _, entry := range [][2]int32{{first.Pid, follower.Bid}, {second.Pid, right.Bid}} {
+		b.Reset()
+		rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "tournament", "register", tid, "--player", fmt.Sprint(entry[0]), "--bot", fmt.Sprint(entry[1])})
+		rootCmd.Execute()
+		if !strings.Contains(b.String(), `"bot_version": 1`) {
+			t.Errorf("unexpected entry \"%s\"", b.String())
+		}
+	}
+
+	b.Reset()
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "tournament", "run", tid})
+	rootCmd.Execute()
+	if !strings.Contains(b.String(), "finished after 1 rounds") || !strings.Contains(b.String(), "   1  Entrant1") {
+		t.Errorf("unexpected run \"%s\"", b.String())
+	}
+
+	b.Reset()
+	rootCmd.SetArgs([]string{"--config", "../data/conf.yaml", "tournament", "--state", "finished"})
+	rootCmd.Execute()
+	if !strings.Contains(b.String(), "Cli cup") {
+		t.Errorf("unexpected list \"%s\"", b.String())
+	}
+}
+
+func Test_MazeGenerateCommand(t *testing.T) {
+
+	b := bytes.NewBufferString("")
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"maze", "generate", "--rows", "3", "--cols", "4", "--algo", "kruskal", "--seed", "7", "--json"})
+	rootCmd.Execute()
+	out, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m, err := maze.Load(out)
+	if err != nil {
+		t.Fatalf("generated maze should load: %v\n%s", err, out)
+	}
+	expected, _ := maze.Generate(3, 4, maze.GenerateOptions{Algorithm: maze.Kruskal, Seed: 7})
+	if m.String() != expected.String() {
+		t.Errorf("expected \"%s\" got \"%s\"", expected, m)
+	}
+}
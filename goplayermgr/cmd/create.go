@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"path/filepath"
 	"strconv"
 
 	"github.com/spf13/cobra"
@@ -31,6 +32,7 @@ var createPlayerCmd = &cobra.Command{
 			db := model.ConnectToDB(dsn)
 			player := model.AddPlayer(db, args[0])
 			if player != nil {
+				recordCliAudit(db, model.AuditPlayerCreate, player.Pid, 0, nil, player)
 				prettyJSON, err := json.MarshalIndent(player, "", "    ")
 				if err != nil {
 					log.Fatal("Failed to generate json", err)
@@ -46,7 +48,7 @@ var createPlayerCmd = &cobra.Command{
 var createBotCmd = &cobra.Command{
 	Use:   "bot playerid botname botcodefile",
 	Short: "Create a new bot",
-	Long:  `Create a new bot for player playerid`,
+	Long:  `Create a new bot for player playerid, a .wasm botcodefile is a WebAssembly bot`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		if len(args) == 3 {
@@ -58,8 +60,13 @@ var createBotCmd = &cobra.Command{
 			if err != nil {
 				fmt.Printf("create bot cannot read playerid: %v\n", err)
 			} else {
-				bot := model.AddBot(db, int32(pid), args[1], args[2], "")
+				language := model.LanguageJavaScript
+				if filepath.Ext(args[2]) == ".wasm" {
+					language = model.LanguageWasm
+				}
+				bot := model.AddBotWithLanguage(db, int32(pid), args[1], args[2], language, "")
 				if bot != nil {
+					recordCliAudit(db, model.AuditBotCreate, int32(pid), bot.Bid, nil, bot)
 					prettyJSON, err := json.MarshalIndent(bot, "", "    ")
 					if err != nil {
 						log.Fatal("Failed to generate json", err)
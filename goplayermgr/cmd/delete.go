@@ -11,19 +11,34 @@ import (
 
 var playerId int32
 var botId int32
+var hardDelete bool
 
 // deleteCmd represents the delete command
 var deleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete a player or delete a bot from a player",
 	Long: `Delete a player or delete a bot from a player.
-When deleting a player its bot are deleted.`,
+When deleting a player its bot are deleted.
+Deleted player and bot can be restored until they are purged,
+use --hard to delete them permanently.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		dsn := getDSN()
 		fmt.Printf("delete called with DSN: %v\n", dsn)
 		db := model.ConnectToDB(dsn)
 		if playerId != -1 {
-			player := model.DeletePlayer(db, playerId)
+			before := model.GetPlayer(db, playerId)
+			var player *model.Player
+			if hardDelete {
+				player = model.PurgePlayer(db, playerId)
+				if player != nil {
+					recordCliAudit(db, model.AuditPlayerPurge, playerId, 0, before, nil)
+				}
+			} else {
+				player = model.DeletePlayer(db, playerId)
+				if player != nil {
+					recordCliAudit(db, model.AuditPlayerDelete, playerId, 0, before, nil)
+				}
+			}
 			prettyJSON, err := json.MarshalIndent(player, "", "    ")
 			if err != nil {
 				log.Fatal("Failed to generate json", err)
@@ -32,7 +47,19 @@ When deleting a player its bot are deleted.`,
 		}
 
 		if botId != -1 {
-			bot := model.DeleteBot(db, playerId, botId)
+			before := model.GetBot(db, playerId, botId)
+			var bot *model.BotBase
+			if hardDelete {
+				bot = model.PurgeBot(db, playerId, botId)
+				if bot != nil {
+					recordCliAudit(db, model.AuditBotPurge, playerId, botId, before, nil)
+				}
+			} else {
+				bot = model.DeleteBot(db, playerId, botId)
+				if bot != nil {
+					recordCliAudit(db, model.AuditBotDelete, playerId, botId, before, nil)
+				}
+			}
 			prettyJSON, err := json.MarshalIndent(bot, "", "    ")
 			if err != nil {
 				log.Fatal("Failed to generate json", err)
@@ -45,5 +72,6 @@ When deleting a player its bot are deleted.`,
 func init() {
 	deleteCmd.Flags().Int32Var(&playerId, "playerid", -1, "ID of player to delete")
 	deleteCmd.Flags().Int32Var(&botId, "botid", -1, "ID of bot to delete")
+	deleteCmd.Flags().BoolVar(&hardDelete, "hard", false, "Delete permanently instead of soft delete")
 	rootCmd.AddCommand(deleteCmd)
 }
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"jc.org/playermgr/model"
+)
+
+var fsckRepair bool
+
+// fsckCmd represents the fsck command
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check consistency between players and bots",
+	Long: `Search bots whose player does not exist and bots still visible
+while their player is deleted.
+With --repair orphan bots are permanently deleted, bots of deleted players
+are deleted with their player and missing foreign key is created.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dsn := getDSN()
+		fmt.Printf("fsck called with DSN: %v\n", dsn)
+		db := model.ConnectToDB(dsn)
+
+		report := model.CheckIntegrity(db, fsckRepair)
+		prettyJSON, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			log.Fatal("Failed to generate json", err)
+		}
+		fmt.Printf("%s\n", string(prettyJSON))
+	},
+}
+
+func init() {
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "Repair inconsistencies")
+	rootCmd.AddCommand(fsckCmd)
+}
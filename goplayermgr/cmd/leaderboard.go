@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/model"
+)
+
+var leaderboardMaze string
+var leaderboardWindow string
+var leaderboardPage int
+var leaderboardPerPage int
+var leaderboardJSON bool
+
+// leaderboardCmd shows rankings of bots
+var leaderboardCmd = &cobra.Command{
+	Use:   "leaderboard",
+	Short: "Show best bots",
+	Long: `Show the global ranking of bots by mean normalized score over all mazes,
+or the ranking of a maze (--maze sha256:...) by fewest steps then earliest submission.
+The window is all (all time) or week (since Monday 00:00 UTC).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := model.ConnectToDB(getDSN())
+
+		var board *model.Leaderboard
+		var err error
+		if leaderboardMaze != "" {
+			board, err = model.MazeLeaderboard(db, leaderboardMaze, leaderboardWindow, leaderboardPage, leaderboardPerPage)
+		} else {
+			board, err = model.GlobalLeaderboard(db, leaderboardWindow, leaderboardPage, leaderboardPerPage)
+		}
+		if err != nil {
+			log.Fatalf("Cannot get leaderboard: %v", err)
+		}
+
+		if leaderboardJSON {
+			printJSON(cmd.OutOrStdout(), board)
+			return
+		}
+		printLeaderboard(cmd.OutOrStdout(), board)
+	},
+}
+
+var leaderboardMazesCmd = &cobra.Command{
+	Use:   "mazes",
+	Short: "List mazes with a leaderboard",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := model.ConnectToDB(getDSN())
+		mazes, err := model.LeaderboardMazes(db, leaderboardWindow)
+		if err != nil {
+			log.Fatalf("Cannot get leaderboard mazes: %v", err)
+		}
+		printJSON(cmd.OutOrStdout(), mazes)
+	},
+}
+
+var leaderboardRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Build leaderboards and ratings again from stored games, mazes of suites are ranked first",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := model.ConnectToDB(getDSN())
+		err := engine.RankSuites(db, viper.GetString("mazes.dir"))
+		if err != nil {
+			log.Fatalf("Cannot rank maze suites: %v", err)
+		}
+		n := model.RebuildLeaderboard(db)
+		if n < 0 {
+			log.Fatal("Cannot rebuild leaderboard")
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%d leaderboard entries\n", n)
+	},
+}
+
+func printJSON(out io.Writer, v interface{}) {
+	prettyJSON, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		log.Fatal("Failed to generate json", err)
+	}
+	fmt.Fprintf(out, "%s\n", string(prettyJSON))
+}
+
+func printLeaderboard(out io.Writer, board *model.Leaderboard) {
+	if board.MazeHash != "" {
+		fmt.Fprintf(out, "maze: %s %s\n", board.MazeName, board.MazeHash)
+	}
+	fmt.Fprintf(out, "window: %s, page %d, %d bots\n", board.Window, board.Page, board.Total)
+	for _, r := range board.Entries {
+		fmt.Fprintf(out, "%4d  %-20s %-20s score: %.3f", r.Rank, r.PlayerName, r.BotName, r.Score)
+		if board.MazeHash != "" {
+			fmt.Fprintf(out, "  steps: %d  version: %d", r.Steps, r.BotVersion)
+		} else {
+			fmt.Fprintf(out, "  mazes: %d", r.Mazes)
+		}
+		fmt.Fprintf(out, "  %s\n", r.SubmittedAt.Format("2006-01-02 15:04"))
+	}
+}
+
+func init() {
+	leaderboardCmd.PersistentFlags().StringVar(&leaderboardWindow, "window", model.WindowAll, "Time window: all or week")
+	leaderboardCmd.Flags().StringVar(&leaderboardMaze, "maze", "", "Hash of maze, global ranking when not set")
+	leaderboardCmd.Flags().IntVar(&leaderboardPage, "page", 1, "Page number")
+	leaderboardCmd.Flags().IntVar(&leaderboardPerPage, "per-page", 20, "Bots per page")
+	leaderboardCmd.Flags().BoolVar(&leaderboardJSON, "json", false, "Print leaderboard as JSON")
+
+	leaderboardCmd.AddCommand(leaderboardMazesCmd)
+	leaderboardCmd.AddCommand(leaderboardRebuildCmd)
+	rootCmd.AddCommand(leaderboardCmd)
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"jc.org/playermgr/maze"
+)
+
+var generateRows int
+var generateColumns int
+var generateAlgorithm string
+var generateSeed int64
+var generateBraid float64
+var generatePlacement string
+var generateJSON bool
+
+// mazeCmd groups the commands working on mazes
+var mazeCmd = &cobra.Command{
+	Use:   "maze",
+	Short: "Work with mazes",
+	Long:  `Generate and study mazes in mazemgr ASCII format.`,
+}
+
+// mazeGenerateCmd creates a random maze
+var mazeGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a maze",
+	Long: `Generate a random maze with algorithm backtracker, prim, kruskal or eller.
+The same seed gives the same maze, when no seed is given one is chosen and printed.
+--braid removes this fraction of dead ends, adding loops.
+--json prints a mazemgr maze definition instead of the ASCII maze.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+
+		if !cmd.Flags().Changed("seed") {
+			generateSeed = time.Now().UnixNano()
+			cmd.PrintErrf("seed: %d\n", generateSeed)
+		}
+
+		m, err := maze.Generate(generateRows, generateColumns, maze.GenerateOptions{
+			Algorithm: generateAlgorithm,
+			Seed:      generateSeed,
+			Braid:     generateBraid,
+			Placement: generatePlacement,
+		})
+		if err != nil {
+			log.Fatalf("Cannot generate maze: %v", err)
+		}
+
+		if !generateJSON {
+			fmt.Fprintln(out, m.String())
+			return
+		}
+
+		name := fmt.Sprintf("%s-%d", generateAlgorithm, generateSeed)
+		description := fmt.Sprintf("%dx%d %s maze, seed %d, braid %v", m.Rows, m.Columns, generateAlgorithm, generateSeed, generateBraid)
+		prettyJSON, err := json.MarshalIndent(m.Definition(name, description), "", "    ")
+		if err != nil {
+			log.Fatal("Failed to generate json", err)
+		}
+		fmt.Fprintf(out, "%s\n", string(prettyJSON))
+	},
+}
+
+var solveMazeFile string
+var analyzeMazeFile string
+var analyzeSeed int64
+
+// mazeAnalyzeCmd prints difficulty metrics of a maze
+var mazeAnalyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Analyze maze difficulty",
+	Long: `Print a JSON report of maze difficulty: shortest path, dead ends, junctions,
+branching factor, loops, solution to area ratio and the performance of
+a left hand wall follower (like bot3.js) and of random walks.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		m, err := maze.ReadFile(analyzeMazeFile)
+		if err != nil {
+			log.Fatalf("Cannot read maze %v: %v", analyzeMazeFile, err)
+		}
+
+		prettyJSON, err := json.MarshalIndent(m.Analyze(analyzeSeed), "", "    ")
+		if err != nil {
+			log.Fatal("Failed to generate json", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s\n", string(prettyJSON))
+	},
+}
+
+// mazeSolveCmd prints a shortest path of a maze
+var mazeSolveCmd = &cobra.Command{
+	Use:   "solve",
+	Short: "Solve a maze",
+	Long: `Print a shortest path from entry to exit in mazemgr solution format
+and the minimum number of steps of a bot.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+
+		m, err := maze.ReadFile(solveMazeFile)
+		if err != nil {
+			log.Fatalf("Cannot read maze %v: %v", solveMazeFile, err)
+		}
+		solution, err := m.Solve()
+		if err != nil {
+			log.Fatalf("Cannot solve maze %v: %v", solveMazeFile, err)
+		}
+
+		fmt.Fprintln(out, strings.Join(m.RenderSolution(solution), "\n"))
+		fmt.Fprintf(out, "steps: %d\n", solution.Steps)
+	},
+}
+
+func init() {
+	mazeSolveCmd.Flags().StringVar(&solveMazeFile, "maze", "", "Maze file")
+	mazeSolveCmd.MarkFlagRequired("maze")
+	mazeCmd.AddCommand(mazeSolveCmd)
+
+	mazeAnalyzeCmd.Flags().StringVar(&analyzeMazeFile, "maze", "", "Maze file")
+	mazeAnalyzeCmd.Flags().Int64Var(&analyzeSeed, "seed", 0, "Seed of random walks")
+	mazeAnalyzeCmd.MarkFlagRequired("maze")
+	mazeCmd.AddCommand(mazeAnalyzeCmd)
+
+	mazeGenerateCmd.Flags().IntVar(&generateRows, "rows", 10, "Number of rows")
+	mazeGenerateCmd.Flags().IntVar(&generateColumns, "cols", 10, "Number of columns")
+	mazeGenerateCmd.Flags().StringVar(&generateAlgorithm, "algo", maze.Backtracker, "Algorithm: "+strings.Join(maze.Algorithms, ", "))
+	mazeGenerateCmd.Flags().Int64Var(&generateSeed, "seed", 0, "Seed of random generator")
+	mazeGenerateCmd.Flags().Float64Var(&generateBraid, "braid", 0, "Fraction of dead ends removed, from 0 to 1")
+	mazeGenerateCmd.Flags().StringVar(&generatePlacement, "placement", maze.PlaceSides, "Entry and exit placement: sides or farthest")
+	mazeGenerateCmd.Flags().BoolVar(&generateJSON, "json", false, "Print mazemgr JSON maze definition")
+
+	mazeCmd.AddCommand(mazeGenerateCmd)
+	rootCmd.AddCommand(mazeCmd)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"jc.org/playermgr/model"
+)
+
+// purgeCmd represents the purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently delete old deleted players and bots",
+	Long: `Permanently delete players and bots deleted for longer than
+the retention period.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dsn := getDSN()
+		fmt.Printf("purge called with DSN: %v\n", dsn)
+		db := model.ConnectToDB(dsn)
+
+		retention := viper.GetDuration("purge.retention")
+		purged := model.PurgeDeleted(db, time.Now().Add(-retention))
+		if purged != nil {
+			recordCliAudit(db, model.AuditPurgeDeleted, 0, 0, nil, purged)
+		}
+		prettyJSON, err := json.MarshalIndent(purged, "", "    ")
+		if err != nil {
+			log.Fatal("Failed to generate json", err)
+		}
+		fmt.Printf("%s\n", string(prettyJSON))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+}
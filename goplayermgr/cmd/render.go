@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/render"
+)
+
+var renderReplayFile string
+var renderOutFile string
+var renderFormat string
+var renderCellSize int
+var renderDelay int
+
+// renderCmd draws a recorded game
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render a game replay to GIF or SVG",
+	Long: `Draw a game recorded by bot run --replay or downloaded from the API,
+as an animated GIF of the bot moving (--format gif) or as a SVG heatmap
+of visited rooms with the bot path (--format svg).
+The format defaults to the extension of the output file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := ioutil.ReadFile(renderReplayFile)
+		if err != nil {
+			log.Fatalf("Cannot read replay %v: %v", renderReplayFile, err)
+		}
+		replay, err := engine.ReadReplay(data)
+		if err != nil {
+			log.Fatalf("Cannot read replay %v: %v", renderReplayFile, err)
+		}
+		m, err := replay.ParseMaze()
+		if err != nil {
+			log.Fatalf("Cannot read maze of replay %v: %v", renderReplayFile, err)
+		}
+
+		format := renderFormat
+		if format == "" {
+			format = strings.TrimPrefix(filepath.Ext(renderOutFile), ".")
+		}
+
+		f, err := os.Create(renderOutFile)
+		if err != nil {
+			log.Fatalf("Cannot create %v: %v", renderOutFile, err)
+		}
+		defer f.Close()
+
+		opts := render.Options{CellSize: renderCellSize, Delay: renderDelay}
+		err = render.Render(f, format, m, replay.Path(m), opts)
+		if err != nil {
+			log.Fatalf("Cannot render %v: %v", renderOutFile, err)
+		}
+	},
+}
+
+func init() {
+	renderCmd.Flags().StringVar(&renderReplayFile, "replay", "", "Replay file")
+	renderCmd.Flags().StringVarP(&renderOutFile, "out", "o", "", "Output file")
+	renderCmd.Flags().StringVar(&renderFormat, "format", "", "Image format, gif or svg")
+	renderCmd.Flags().IntVar(&renderCellSize, "cell", render.DefaultOptions.CellSize, "Size of a room in pixels")
+	renderCmd.Flags().IntVar(&renderDelay, "delay", render.DefaultOptions.Delay, "Delay between GIF frames in 100ths of second")
+	renderCmd.MarkFlagRequired("replay")
+	renderCmd.MarkFlagRequired("out")
+
+	rootCmd.AddCommand(renderCmd)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"jc.org/playermgr/model"
+)
+
+var restorePlayerId int32
+var restoreBotId int32
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a deleted player or bot",
+	Long: `Restore a deleted player or a deleted bot of a player.
+When restoring a player the bots deleted with it are restored.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dsn := getDSN()
+		fmt.Printf("restore called with DSN: %v\n", dsn)
+		db := model.ConnectToDB(dsn)
+		if restorePlayerId != -1 && restoreBotId == -1 {
+			player := model.RestorePlayer(db, restorePlayerId)
+			if player != nil {
+				recordCliAudit(db, model.AuditPlayerRestore, restorePlayerId, 0, nil, player)
+			}
+			prettyJSON, err := json.MarshalIndent(player, "", "    ")
+			if err != nil {
+				log.Fatal("Failed to generate json", err)
+			}
+			fmt.Printf("%s\n", string(prettyJSON))
+		}
+
+		if restoreBotId != -1 {
+			bot := model.RestoreBot(db, restorePlayerId, restoreBotId)
+			if bot != nil {
+				recordCliAudit(db, model.AuditBotRestore, restorePlayerId, restoreBotId, nil, bot)
+			}
+			prettyJSON, err := json.MarshalIndent(bot, "", "    ")
+			if err != nil {
+				log.Fatal("Failed to generate json", err)
+			}
+			fmt.Printf("%s\n", string(prettyJSON))
+		}
+	},
+}
+
+func init() {
+	restoreCmd.Flags().Int32Var(&restorePlayerId, "playerid", -1, "ID of player to restore")
+	restoreCmd.Flags().Int32Var(&restoreBotId, "botid", -1, "ID of bot to restore")
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"jc.org/playermgr/api"
+	"jc.org/playermgr/engine"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
@@ -22,7 +24,7 @@ var rootCmd = &cobra.Command{
 	Short:     "Player Database Manager",
 	Long:      `Player Database manager and cli.`,
 	Version:   "1.0.0",
-	ValidArgs: []string{"create", "delete", "get", "serve"},
+	ValidArgs: []string{"audit", "bot", "create", "delete", "fsck", "get", "leaderboard", "maze", "purge", "render", "restore", "serve", "tournament", "worker"},
 }
 
 // Decode command line arguments
@@ -63,6 +65,20 @@ func init() {
 	rootCmd.PersistentFlags().StringP("dsn-password", "p", "", "Player Database User password")
 	viper.BindPFlag("dsn.password", rootCmd.PersistentFlags().Lookup("dsn-password"))
 
+	// define how long deleted players and bots are kept
+	rootCmd.PersistentFlags().Duration("retention", 30*24*time.Hour, "Retention of deleted players and bots before purge")
+	viper.BindPFlag("purge.retention", rootCmd.PersistentFlags().Lookup("retention"))
+	viper.SetDefault("purge.retention", 30*24*time.Hour)
+
+	// define where maze suites used to evaluate bots are
+	rootCmd.PersistentFlags().String("mazes", "data/mazes", "Directory of maze suites, a sub directory per suite")
+	viper.BindPFlag("mazes.dir", rootCmd.PersistentFlags().Lookup("mazes"))
+	viper.SetDefault("mazes.dir", "data/mazes")
+
+	// define whether remote bots may be on loopback or private addresses
+	rootCmd.PersistentFlags().Bool("remote-allow-private", false, "Allow remote bots on loopback or private addresses")
+	viper.BindPFlag("remote.allowprivate", rootCmd.PersistentFlags().Lookup("remote-allow-private"))
+
 	// define security parameters
 	rootCmd.PersistentFlags().StringP("security-mode", "s", "secured", "Security mode")
 	viper.BindPFlag("security.mode", rootCmd.PersistentFlags().Lookup("security-mode"))
@@ -101,6 +117,9 @@ func initConfig() {
 	authurl := viper.GetString("security.authurl")
 	api.KeycloakAuthURL = authurl
 	api.SecurityMode = viper.GetString("security.mode")
+	api.PurgeRetention = viper.GetDuration("purge.retention")
+	api.MazeDir = viper.GetString("mazes.dir")
+	engine.AllowPrivateRemotes = viper.GetBool("remote.allowprivate")
 }
 
 /* Build database connection string
@@ -4,6 +4,7 @@ import (
 	"jc.org/playermgr/api"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // serveCmd represents the serve command
@@ -13,10 +14,22 @@ var serveCmd = &cobra.Command{
 	Long:  `Player Manager REST API.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		dsn := getDSN()
+		api.MetricsPort = viper.GetInt("metrics.port")
+		api.JobWorkers = viper.GetInt("jobs.workers")
 		api.Serve(dsn)
 	},
 }
 
 func init() {
+	// define prometheus exporter port
+	serveCmd.Flags().IntP("metrics-port", "m", 9464, "Prometheus metrics port (0 to expose on API port)")
+	viper.BindPFlag("metrics.port", serveCmd.Flags().Lookup("metrics-port"))
+	viper.SetDefault("metrics.port", 9464)
+
+	// define number of job workers of the server
+	serveCmd.Flags().Int("workers", 2, "Number of job workers (0 to run jobs with playermgr worker only)")
+	viper.BindPFlag("jobs.workers", serveCmd.Flags().Lookup("workers"))
+	viper.SetDefault("jobs.workers", 2)
+
 	rootCmd.AddCommand(serveCmd)
 }
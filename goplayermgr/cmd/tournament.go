@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	"jc.org/playermgr/api"
+	"jc.org/playermgr/jobs"
+	"jc.org/playermgr/model"
+	"jc.org/playermgr/tournament"
+)
+
+var tournamentState string
+var tournamentJSON bool
+var tournamentName string
+var tournamentFormat string
+var tournamentSuite string
+var tournamentMazes []string
+var tournamentRounds int
+var tournamentDeadline string
+var tournamentPlayerId int32
+var tournamentBotId int32
+var tournamentRound int
+
+// tournamentCmd lists tournaments and groups tournament commands
+var tournamentCmd = &cobra.Command{
+	Use:   "tournament",
+	Short: "Manage bot tournaments",
+	Long: `List tournaments, optionally of a state: open, running, finished or cancelled.
+Players register a bot revision until the entry deadline, then rounds are played
+by job workers, or at once with tournament run.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := model.ConnectToDB(getDSN())
+		list := model.GetTournaments(db, tournamentState)
+		if list == nil {
+			log.Fatal("Cannot get tournaments")
+		}
+		if tournamentJSON {
+			printJSON(cmd.OutOrStdout(), list)
+			return
+		}
+		for _, t := range list {
+			fmt.Fprintf(cmd.OutOrStdout(), "%4d  %-20s %-12s %-10s round %d/%d  deadline %s\n",
+				t.Tid, t.Name, t.Format, t.State, t.Round, t.Rounds, t.EntryDeadline.Format(time.RFC3339))
+		}
+	},
+}
+
+var tournamentCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a tournament over mazes of a suite",
+	Run: func(cmd *cobra.Command, args []string) {
+		deadline, err := parseDeadline(tournamentDeadline, time.Now())
+		if err != nil {
+			log.Fatalf("Invalid deadline %v: %v", tournamentDeadline, err)
+		}
+		suite, err := readEvalSuite(tournamentSuite)
+		if err != nil {
+			log.Fatalf("Cannot read suite %v: %v", tournamentSuite, err)
+		}
+		db := model.ConnectToDB(getDSN())
+		t, err := tournament.Create(db, &model.Tournament{Name: tournamentName, Format: tournamentFormat, Rounds: tournamentRounds, EntryDeadline: deadline}, suite, tournamentMazes)
+		if err != nil {
+			log.Fatalf("Cannot create tournament: %v", err)
+		}
+		printJSON(cmd.OutOrStdout(), t)
+	},
+}
+
+var tournamentRegisterCmd = &cobra.Command{
+	Use:   "register <tournamentid>",
+	Short: "Register current revision of a bot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tid := tournamentArg(args)
+		db := model.ConnectToDB(getDSN())
+		code := model.GetBotCode(db, tournamentPlayerId, tournamentBotId)
+		if code == nil {
+			log.Fatalf("Cannot get bot %v of player %v", tournamentBotId, tournamentPlayerId)
+		}
+		entry, err := model.RegisterTournamentEntry(db, tid, code, time.Now())
+		if err != nil {
+			log.Fatalf("Cannot register bot: %v", err)
+		}
+		printJSON(cmd.OutOrStdout(), entry)
+	},
+}
+
+var tournamentStartCmd = &cobra.Command{
+	Use:   "start <tournamentid>",
+	Short: "Close entries now and queue the first round for job workers",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tid := tournamentArg(args)
+		db := model.ConnectToDB(getDSN())
+		t, err := tournament.Start(db, tid, time.Now())
+		if err != nil {
+			log.Fatalf("Cannot start tournament: %v", err)
+		}
+		if t.State == model.TournamentRunning {
+			_, err = jobs.Enqueue(db, api.JobTournament, 0, 0, api.TournamentPayload{TournamentId: tid})
+			if err != nil {
+				log.Fatalf("Cannot queue round: %v", err)
+			}
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "tournament %d %s, %d rounds\n", t.Tid, t.State, t.Rounds)
+	},
+}
+
+var tournamentRunCmd = &cobra.Command{
+	Use:   "run <tournamentid>",
+	Short: "Start a tournament and play its remaining rounds now",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tid := tournamentArg(args)
+		db := model.ConnectToDB(getDSN())
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		t, err := tournament.Run(ctx, db, tid, nil)
+		if err != nil {
+			log.Fatalf("Cannot run tournament: %v", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "tournament %d %s after %d rounds\n", t.Tid, t.State, t.Round)
+		printStandings(cmd.OutOrStdout(), db, tid)
+	},
+}
+
+var tournamentCancelCmd = &cobra.Command{
+	Use:   "cancel <tournamentid>",
+	Short: "Cancel an open or running tournament",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tid := tournamentArg(args)
+		db := model.ConnectToDB(getDSN())
+		err := model.CancelTournament(db, tid, time.Now())
+		if err != nil {
+			log.Fatalf("Cannot cancel tournament: %v", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "tournament %d cancelled\n", tid)
+	},
+}
+
+var tournamentMatchesCmd = &cobra.Command{
+	Use:   "matches <tournamentid>",
+	Short: "Show matches of a tournament",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tid := tournamentArg(args)
+		db := model.ConnectToDB(getDSN())
+		matches := model.GetTournamentMatches(db, tid, tournamentRound)
+		if matches == nil {
+			log.Fatal("Cannot get matches")
+		}
+		printJSON(cmd.OutOrStdout(), matches)
+	},
+}
+
+var tournamentStandingsCmd = &cobra.Command{
+	Use:   "standings <tournamentid>",
+	Short: "Show standings of a tournament",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tid := tournamentArg(args)
+		db := model.ConnectToDB(getDSN())
+		if tournamentJSON {
+			standings, err := model.TournamentStandings(db, tid)
+			if err != nil {
+				log.Fatalf("Cannot get standings: %v", err)
+			}
+			printJSON(cmd.OutOrStdout(), standings)
+			return
+		}
+		printStandings(cmd.OutOrStdout(), db, tid)
+	},
+}
+
+func tournamentArg(args []string) int32 {
+	tid, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil {
+		log.Fatalf("Invalid tournament id %v: %v", args[0], err)
+	}
+	return int32(tid)
+}
+
+// deadline is a RFC 3339 date or a duration from now
+func parseDeadline(deadline string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(deadline); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, deadline)
+}
+
+func printStandings(out io.Writer, db *gorm.DB, tid int32) {
+	standings, err := model.TournamentStandings(db, tid)
+	if err != nil {
+		log.Fatalf("Cannot get standings: %v", err)
+	}
+	for _, s := range standings {
+		fmt.Fprintf(out, "%4d  %-20s %-20s points: %.1f  W/D/L: %d/%d/%d  byes: %d  buchholz: %.1f\n",
+			s.Rank, s.PlayerName, s.BotName, s.Points, s.Wins, s.Draws, s.Losses, s.Byes, s.Buchholz)
+	}
+}
+
+func init() {
+	tournamentCmd.Flags().StringVar(&tournamentState, "state", "", "State of tournaments: open, running, finished or cancelled")
+	tournamentCmd.PersistentFlags().BoolVar(&tournamentJSON, "json", false, "Print as JSON")
+
+	tournamentCreateCmd.Flags().StringVar(&tournamentName, "name", "", "Name of tournament")
+	tournamentCreateCmd.Flags().StringVar(&tournamentFormat, "format", model.TournamentSwiss, "Format: round-robin or swiss")
+	tournamentCreateCmd.Flags().StringVar(&tournamentSuite, "suite", "", "Directory of mazes or name of a suite")
+	tournamentCreateCmd.Flags().StringSliceVar(&tournamentMazes, "maze", nil, "Maze of the suite in the pool, all mazes when not set")
+	tournamentCreateCmd.Flags().IntVar(&tournamentRounds, "rounds", 0, "Rounds of a Swiss tournament (default log2 of entries)")
+	tournamentCreateCmd.Flags().StringVar(&tournamentDeadline, "deadline", "", "Entry deadline, RFC 3339 date or duration from now")
+	tournamentCreateCmd.MarkFlagRequired("name")
+	tournamentCreateCmd.MarkFlagRequired("suite")
+	tournamentCreateCmd.MarkFlagRequired("deadline")
+
+	tournamentRegisterCmd.Flags().Int32Var(&tournamentPlayerId, "player", 0, "ID of player")
+	tournamentRegisterCmd.Flags().Int32Var(&tournamentBotId, "bot", 0, "ID of bot of the player")
+	tournamentRegisterCmd.MarkFlagRequired("player")
+	tournamentRegisterCmd.MarkFlagRequired("bot")
+
+	tournamentMatchesCmd.Flags().IntVar(&tournamentRound, "round", 0, "Round, all rounds when not set")
+
+	tournamentCmd.AddCommand(tournamentCreateCmd)
+	tournamentCmd.AddCommand(tournamentRegisterCmd)
+	tournamentCmd.AddCommand(tournamentStartCmd)
+	tournamentCmd.AddCommand(tournamentRunCmd)
+	tournamentCmd.AddCommand(tournamentCancelCmd)
+	tournamentCmd.AddCommand(tournamentMatchesCmd)
+	tournamentCmd.AddCommand(tournamentStandingsCmd)
+	rootCmd.AddCommand(tournamentCmd)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"jc.org/playermgr/api"
+	"jc.org/playermgr/model"
+)
+
+var workerCount int
+var workerOnce bool
+
+// workerCmd runs queued jobs without the REST API
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "Run background jobs",
+	Long: `Run evaluation, validation, render and tournament round jobs queued in the
+player database until interrupted, running jobs are finished before exiting.
+With --once, run the jobs ready to run and exit.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := model.ConnectToDB(getDSN())
+		if db == nil {
+			log.Fatal("Cannot connect to database")
+		}
+		pool := api.NewJobPool(db, workerCount)
+
+		if workerOnce {
+			n := pool.RunPending(context.Background())
+			fmt.Fprintf(cmd.OutOrStdout(), "%d jobs run\n", n)
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		log.Printf("Worker %v started with %d workers\n", pool.Owner, workerCount)
+		pool.Run(ctx)
+	},
+}
+
+func init() {
+	workerCmd.Flags().IntVar(&workerCount, "workers", 2, "Number of jobs run in parallel")
+	workerCmd.Flags().BoolVar(&workerOnce, "once", false, "Run jobs ready to run one after the other and exit")
+
+	rootCmd.AddCommand(workerCmd)
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"gorm.io/gorm"
+	"jc.org/playermgr/model"
+)
+
+/*
+	Load bot code written in language, WebAssembly code is base64 encoded like in database
+*/
+func NewBot(language string, code string, filename string, limits Limits, console io.Writer) (Bot, error) {
+	switch language {
+	case model.LanguageJavaScript, "":
+		return NewJSBot(code, filename, limits, console)
+	case model.LanguageWasm:
+		binary, err := base64.StdEncoding.DecodeString(code)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode module: %w", err)
+		}
+		return NewWasmBot(binary, limits, console)
+	}
+	return nil, fmt.Errorf("unknown bot language %q", language)
+}
+
+/*
+	Load bot code of player from database
+*/
+func LoadBot(db *gorm.DB, pid int32, bid int32, limits Limits, console io.Writer) (Bot, error) {
+	code := model.GetBotCode(db, pid, bid)
+	if code == nil {
+		return nil, fmt.Errorf("cannot get code of bot %v of player %v", bid, pid)
+	}
+	return newStoredBot(code, limits, console)
+}
+
+// load bot code stored in database
+func newStoredBot(code *model.BotCode, limits Limits, console io.Writer) (Bot, error) {
+	if code.Language == model.LanguageRemote {
+		return NewRemoteBot(code.URL, code.Secret, limits)
+	}
+	return NewBot(code.Language, code.Botcode, code.Filename, limits, console)
+}
+
+// release resources of bot, if any
+func CloseBot(bot Bot) {
+	if closer, ok := bot.(io.Closer); ok {
+		closer.Close()
+	}
+}
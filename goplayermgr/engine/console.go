@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dop251/goja"
+)
+
+// maximum number of console entries kept, older entries are dropped
+var MaxConsoleEntries = 1000
+
+// maximum length of a console message, longer messages are truncated
+var MaxConsoleMessage = 1024
+
+// level of entries recording an error stopping the bot
+const LevelException = "exception"
+
+/*
+	ConsoleEntry is a message written by the bot or an error it threw,
+	Step is 0 while the bot code is loaded
+*/
+type ConsoleEntry struct {
+	Step    int    `json:"step"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Stack   string `json:"stack,omitempty"`
+}
+
+/*
+	Console captures the output of a bot: console.log, console.info, ... of
+	JavaScript bots, env.log of WebAssembly bots, and the error ending a game.
+	Only the last MaxConsoleEntries entries are kept.
+*/
+type Console struct {
+	Entries []ConsoleEntry `json:"entries"`
+	// number of entries dropped to bound the console
+	Dropped int `json:"dropped,omitempty"`
+	// when set, messages are also written to Echo
+	Echo io.Writer `json:"-"`
+	step int
+}
+
+func NewConsole(echo io.Writer) *Console {
+	return &Console{Entries: []ConsoleEntry{}, Echo: echo}
+}
+
+// record message at level for current step
+func (c *Console) Log(level string, message string) {
+	c.add(ConsoleEntry{Step: c.step, Level: level, Message: message})
+	if c.Echo != nil {
+		fmt.Fprintln(c.Echo, message)
+	}
+}
+
+// console is also a writer for text output, each line is a message
+func (c *Console) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		c.Log("log", line)
+	}
+	return len(p), nil
+}
+
+/*
+	Record the error stopping the bot with the JavaScript stack when there is one
+*/
+func (c *Console) exception(err error) {
+	if c == nil {
+		return
+	}
+	entry := ConsoleEntry{Step: c.step, Level: LevelException, Message: err.Error()}
+	var ex *goja.Exception
+	if errors.As(err, &ex) {
+		entry.Stack = ex.String()
+	}
+	c.add(entry)
+	if c.Echo != nil {
+		// stack starts with the message
+		if entry.Stack != "" {
+			fmt.Fprintln(c.Echo, strings.TrimRight(entry.Stack, "\n"))
+		} else {
+			fmt.Fprintln(c.Echo, entry.Message)
+		}
+	}
+}
+
+// following messages belong to step n
+func (c *Console) setStep(n int) {
+	if c != nil {
+		c.step = n
+	}
+}
+
+func (c *Console) add(entry ConsoleEntry) {
+	if len(entry.Message) > MaxConsoleMessage {
+		entry.Message = entry.Message[:MaxConsoleMessage] + "..."
+	}
+	if len(entry.Stack) > MaxConsoleMessage {
+		entry.Stack = entry.Stack[:MaxConsoleMessage] + "..."
+	}
+	c.Entries = append(c.Entries, entry)
+	if over := len(c.Entries) - MaxConsoleEntries; over > 0 {
+		c.Entries = append(c.Entries[:0], c.Entries[over:]...)
+		c.Dropped += over
+	}
+}
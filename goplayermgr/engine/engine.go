@@ -0,0 +1,334 @@
+/*
+	Package engine runs a bot in a maze, like gamemgr engine.
+
+	At each step the bot receives the room where it is and answers an action,
+	{action: 'move', direction: 'up'|'down'|'left'|'right'} moves the bot through
+	a door, any other action keeps it in place. The game is a success when the bot
+	moves through the exit, a failure when it needs more than MaxSteps.
+*/
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"jc.org/playermgr/maze"
+)
+
+// game states, same values as gamemgr
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+const ActionMove = "move"
+
+var (
+	ErrStepTimeout  = errors.New("bot step exceeded time budget")
+	ErrTotalTimeout = errors.New("bot exceeded total time budget")
+)
+
+// Action decided by bot in a room
+type Action struct {
+	Action    string         `json:"action"`
+	Direction maze.Direction `json:"direction,omitempty"`
+}
+
+// Bot is implemented by each kind of bot runtime
+type Bot interface {
+	// Step returns the action of bot in room
+	Step(room maze.Room) (Action, error)
+}
+
+type Limits struct {
+	// maximum number of steps, 0 means rows*cols*4 like gamemgr
+	MaxSteps int
+	// time allowed to load bot code
+	LoadTimeout time.Duration
+	// time allowed for one step
+	StepTimeout time.Duration
+	// time allowed for the whole game
+	TotalTimeout time.Duration
+	// loop iterations and function calls allowed for loading and for each step
+	MaxOperations int64
+	// bytes the live heap may grow by while bot loads and during each step, garbage is
+	// not counted but objects held by other games running at the same time are,
+	// for WebAssembly bots the size of the bot memory
+	MaxMemory uint64
+	// maximum depth of function calls
+	MaxCallStackSize int
+}
+
+// limits used when not specified
+var DefaultLimits = Limits{
+	LoadTimeout:      5 * time.Second,
+	StepTimeout:      100 * time.Millisecond,
+	TotalTimeout:     30 * time.Second,
+	MaxOperations:    1000000,
+	MaxMemory:        64 * 1024 * 1024,
+	MaxCallStackSize: 1024,
+}
+
+type Options struct {
+	Limits Limits
+	// when set, maze with bot trail is written after each step
+	StepLog io.Writer
+	// seed of random numbers given to bot, same seed gives same game
+	Seed int64
+	// record game steps in Result.Replay
+	Record bool
+	// console given to bot, it also gets the error ending the game
+	Console *Console
+}
+
+type BotResult struct {
+	Maze []string `json:"maze"`
+}
+
+// Result has the same shape as gamemgr game result
+type Result struct {
+	State     string        `json:"state"`
+	Steps     int           `json:"steps"`
+	BotResult BotResult     `json:"bot_result"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Usage     *Usage        `json:"usage,omitempty"`
+	// steps of a shortest path and optimum divided by bot steps
+	OptimalSteps int     `json:"optimal_steps,omitempty"`
+	Score        float64 `json:"score"`
+	// recorded game when Options.Record is set
+	Replay *Replay `json:"-"`
+	// bot output when Options.Console is set
+	Console *Console `json:"-"`
+}
+
+/*
+	Run bot in maze until it finds the exit or exceeds its steps or time budget.
+	Unlike gamemgr a bot cannot move through walls.
+*/
+func Run(m *maze.Maze, bot Bot, opts Options) *Result {
+	game := NewGame(m, bot, opts)
+	for game.Step() {
+	}
+	return game.Result()
+}
+
+/*
+	Game is a game in progress, played one step at a time.
+	The total time budget only counts time spent playing, not pauses between steps.
+*/
+type Game struct {
+	maze     *maze.Maze
+	bot      Bot
+	opts     Options
+	limits   Limits
+	maxSteps int
+	trail    *maze.Trail
+	pos      maze.Cell
+	elapsed  time.Duration
+	result   *Result
+	done     bool
+	finished bool
+	// other bots seen by a Sighted bot in a race, nil otherwise
+	sight func() []Sighting
+}
+
+/*
+	Start a game, the bot is in the room of the maze entry
+*/
+func NewGame(m *maze.Maze, bot Bot, opts Options) *Game {
+	g := &Game{maze: m, bot: bot, opts: opts, limits: withDefaults(opts.Limits)}
+	g.maxSteps = g.limits.MaxSteps
+	if g.maxSteps <= 0 {
+		g.maxSteps = m.Rows * m.Columns * 4
+	}
+
+	if seedable, ok := bot.(Seedable); ok {
+		seedable.Seed(opts.Seed)
+	}
+
+	g.result = &Result{State: Failure}
+	g.trail = maze.NewTrail(m)
+	g.pos = m.Entry.Cell
+	g.trail.Bot = &g.pos
+
+	if opts.Record {
+		g.result.Replay = newReplay(m, g.maxSteps, opts.Seed)
+	}
+
+	logStep(opts.StepLog, 0, m, g.trail)
+	return g
+}
+
+/*
+	Play one step, returns false when the game is over
+*/
+func (g *Game) Step() bool {
+	if g.done {
+		return false
+	}
+	start := time.Now()
+	defer func() {
+		g.elapsed += time.Since(start)
+		timedOut := g.limits.TotalTimeout > 0 && g.elapsed > g.limits.TotalTimeout
+		// a replayed game times out where the recorded one did
+		if replay, ok := g.bot.(*ReplayBot); ok {
+			timedOut = replay.timedOut(g.result.Steps)
+		}
+		if !g.done && timedOut {
+			g.result.Error = ErrTotalTimeout.Error()
+			g.result.Replay.timeout()
+			g.done = true
+		}
+	}()
+
+	result := g.result
+	g.trail.Visit(g.pos)
+	room := g.maze.Room(g.pos)
+
+	g.opts.Console.setStep(result.Steps + 1)
+	stepStart := time.Now()
+	var action Action
+	var err error
+	if sighted, ok := g.bot.(Sighted); ok && g.sight != nil {
+		action, err = sighted.StepSighted(room, g.sight())
+	} else {
+		action, err = g.bot.Step(room)
+	}
+	result.Steps++
+	result.Replay.record(result.Steps, g.pos, room, action, err, time.Since(stepStart))
+	if err != nil {
+		g.opts.Console.exception(err)
+		result.Error = err.Error()
+		g.done = true
+		return false
+	}
+
+	if action.Action == ActionMove {
+		if room.Side(action.Direction) == maze.Exit {
+			g.trail.Exited = true
+		} else if g.maze.CanMove(g.pos, action.Direction) {
+			g.pos = g.pos.Next(action.Direction)
+			g.trail.Bot = &g.pos
+		}
+	}
+
+	logStep(g.opts.StepLog, result.Steps, g.maze, g.trail)
+
+	if g.trail.Exited {
+		result.State = Success
+		g.done = true
+	}
+	if result.Steps >= g.maxSteps {
+		g.done = true
+	}
+	return !g.done
+}
+
+// check if game is over
+func (g *Game) Done() bool {
+	return g.done
+}
+
+// number of steps played
+func (g *Game) Steps() int {
+	return g.result.Steps
+}
+
+// maximum number of steps of game
+func (g *Game) MaxSteps() int {
+	return g.maxSteps
+}
+
+// room where the bot is
+func (g *Game) Position() maze.Cell {
+	return g.pos
+}
+
+// room seen by bot at next step
+func (g *Game) Room() maze.Room {
+	return g.maze.Room(g.pos)
+}
+
+// check if bot moved out of the maze
+func (g *Game) Exited() bool {
+	return g.trail.Exited
+}
+
+// rooms where the bot played a step, in maze order
+func (g *Game) Visited() []maze.Cell {
+	visited := []maze.Cell{}
+	for i, row := range g.trail.Visited {
+		for j, v := range row {
+			if v {
+				visited = append(visited, maze.Cell{Row: i, Column: j})
+			}
+		}
+	}
+	return visited
+}
+
+// error stopping the game, if any
+func (g *Game) Error() string {
+	return g.result.Error
+}
+
+/*
+	End the game and compute its result, the game cannot be played any more
+*/
+func (g *Game) Result() *Result {
+	g.done = true
+	if g.finished {
+		return g.result
+	}
+	g.finished = true
+
+	result := g.result
+	result.BotResult.Maze = g.maze.RenderTrail(g.trail)
+	if solution, err := g.maze.Solve(); err == nil {
+		result.OptimalSteps = solution.Steps
+		result.Score = maze.Score(result.Steps, solution.Steps, result.State == Success)
+	}
+	result.Duration = g.elapsed
+	result.Replay.finish(result)
+	result.Console = g.opts.Console
+	if accountable, ok := g.bot.(Accountable); ok {
+		usage := accountable.Usage()
+		result.Usage = &usage
+	}
+
+	return result
+}
+
+func withDefaults(l Limits) Limits {
+	if l.LoadTimeout == 0 {
+		l.LoadTimeout = DefaultLimits.LoadTimeout
+	}
+	if l.StepTimeout == 0 {
+		l.StepTimeout = DefaultLimits.StepTimeout
+	}
+	if l.TotalTimeout == 0 {
+		l.TotalTimeout = DefaultLimits.TotalTimeout
+	}
+	if l.MaxOperations == 0 {
+		l.MaxOperations = DefaultLimits.MaxOperations
+	}
+	if l.MaxMemory == 0 {
+		l.MaxMemory = DefaultLimits.MaxMemory
+	}
+	if l.MaxCallStackSize == 0 {
+		l.MaxCallStackSize = DefaultLimits.MaxCallStackSize
+	}
+	return l
+}
+
+// write maze like gamemgr log file
+func logStep(w io.Writer, step int, m *maze.Maze, trail *maze.Trail) {
+	if w == nil {
+		return
+	}
+	fmt.Fprintf(w, "=== STEP %d ====\n%s\n", step, strings.Join(m.RenderTrail(trail), "\n"))
+}
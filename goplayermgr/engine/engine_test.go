@@ -0,0 +1,729 @@
+package engine_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+var basic = []string{
+	"+-+-+-+-+",
+	"| | | | |",
+	"+-+-+ +-+",
+	"x     | |",
+	"+-+ + +-+",
+	"|   |   X",
+	"+ +-+-+-+",
+	"|   | | |",
+	"+-+-+-+-+",
+}
+
+func loadBot(t *testing.T, file string, limits engine.Limits) *engine.JSBot {
+	code, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Cannot read bot: %v", err)
+	}
+	bot, err := engine.NewJSBot(string(code), file, limits, nil)
+	if err != nil {
+		t.Fatalf("Cannot load bot: %v", err)
+	}
+	return bot
+}
+
+func TestRun(t *testing.T) {
+	m, err := maze.Parse(basic)
+	if err != nil {
+		t.Fatalf("Cannot parse maze: %v", err)
+	}
+
+	var log bytes.Buffer
+	bot := loadBot(t, "../data/bots/bot3.js", engine.Limits{})
+	result := engine.Run(m, bot, engine.Options{StepLog: &log})
+
+	if result.State != engine.Success {
+		t.Fatalf("Bot should find exit: %+v", result)
+	}
+	if result.Steps == 0 || result.Steps > 64 {
+		t.Errorf("Unexpected number of steps %v", result.Steps)
+	}
+	if walk := m.WallFollower(64); !walk.Success || walk.Steps != result.Steps {
+		t.Errorf("bot3 should play like wall follower: %+v", walk)
+	}
+	if result.OptimalSteps != 5 || result.Score != 5/float64(result.Steps) {
+		t.Errorf("Unexpected score %v for optimum %v", result.Score, result.OptimalSteps)
+	}
+	if !strings.HasSuffix(result.BotResult.Maze[5], "B") {
+		t.Errorf("Exit should show bot: %v", result.BotResult.Maze)
+	}
+	if !strings.HasPrefix(log.String(), "=== STEP 0 ====\n") {
+		t.Errorf("Unexpected step log: %v", log.String())
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	m, _ := maze.Parse(basic)
+
+	bot, err := engine.NewJSBot(`function executeStep(room) { return { action: 'wait' }; }`, "wait.js", engine.Limits{}, nil)
+	if err != nil {
+		t.Fatalf("Cannot load bot: %v", err)
+	}
+	result := engine.Run(m, bot, engine.Options{})
+	if result.State != engine.Failure || result.Steps != 4*4*4 {
+		t.Errorf("Bot should fail after max steps: %+v", result)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	m, _ := maze.Parse(basic)
+
+	_, err := engine.NewJSBot(`while(true) {}`, "loop.js", engine.Limits{LoadTimeout: 50 * time.Millisecond}, nil)
+	if !errors.Is(err, engine.ErrStepTimeout) {
+		t.Errorf("Load should time out: %v", err)
+	}
+
+	bot, err := engine.NewJSBot(`function executeStep(room) { while(true) {} }`, "loop.js", engine.Limits{StepTimeout: 50 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("Cannot load bot: %v", err)
+	}
+	result := engine.Run(m, bot, engine.Options{})
+	if result.State != engine.Failure || result.Steps != 1 || result.Error != engine.ErrStepTimeout.Error() {
+		t.Errorf("Bot should time out: %+v", result)
+	}
+}
+
+func TestBadBot(t *testing.T) {
+	var console bytes.Buffer
+	_, err := engine.NewJSBot(`console.log('Loading', 1);`, "empty.js", engine.Limits{}, &console)
+	if err != engine.ErrNoEntryPoint {
+		t.Errorf("Expected missing entry point error: %v", err)
+	}
+	if console.String() != "Loading 1\n" {
+		t.Errorf("Unexpected console output: %q", console.String())
+	}
+
+	_, err = engine.NewJSBot(`function executeStep(room) {`, "syntax.js", engine.Limits{}, nil)
+	if err == nil {
+		t.Errorf("Expected syntax error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		code     string
+		valid    bool
+		diag     string
+		line     int
+		severity string
+	}{
+		{"", false, engine.DiagEmpty, 0, engine.SeverityError},
+		{strings.Repeat(" ", engine.MaxCodeSize+1), false, engine.DiagTooLarge, 0, engine.SeverityError},
+		{"// ok\nfunction executeStep(room) {\n  return {action: 'move' direction: 'up'};\n}", false, engine.DiagSyntax, 3, engine.SeverityError},
+		{"function step(room) {}", false, engine.DiagNoEntryPoint, 0, engine.SeverityError},
+		{"throw new Error('boom');", false, engine.DiagLoad, 0, engine.SeverityError},
+		{"function executeStep(room) { return room.foo.bar; }", false, engine.DiagRuntime, 0, engine.SeverityError},
+		{"function executeStep(room) { return {action: 'wait'}; }", true, engine.DiagNoExit, 0, engine.SeverityWarning},
+	}
+	for _, test := range tests {
+		v := engine.Validate(test.code, "bot.js", engine.Limits{})
+		if v.Valid != test.valid || len(v.Diagnostics) != 1 {
+			t.Errorf("Unexpected validation of %q: %+v", test.code, v)
+			continue
+		}
+		d := v.Diagnostics[0]
+		if d.Code != test.diag || d.Line != test.line || d.Severity != test.severity {
+			t.Errorf("Unexpected diagnostic of %q: %+v", test.code, d)
+		}
+	}
+
+	code, _ := ioutil.ReadFile("../data/bots/bot3.js")
+	v := engine.Validate(string(code), "bot3.js", engine.Limits{})
+	if !v.Valid || len(v.Diagnostics) != 0 || v.SmokeTest.State != engine.Success {
+		t.Errorf("bot3 should be valid: %+v", v)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	m, _ := maze.Parse(basic)
+	limits := engine.Limits{StepTimeout: 5 * time.Second, MaxOperations: 100000, MaxMemory: 1024 * 1024, MaxCallStackSize: 100}
+
+	tests := []struct {
+		code string
+		err  string
+	}{
+		{"function executeStep(room) { while(true) {} }", engine.ErrOperationLimit.Error()},
+		{"function executeStep(room) { return executeStep(room); }", engine.ErrStackOverflow.Error()},
+		{"function executeStep(room) { const a = []; for(;;) { a.push(new Array(1000).fill(a.length)); } }", engine.ErrMemoryLimit.Error()},
+		{"function executeStep(room) { (function(){})['constr' + 'uctor']('while(true) {}')(); }", engine.ErrOperationLimit.Error()},
+		{"function executeStep(room) { [].map['constr' + 'uctor']('a', 'return a; }); while(true) {}; (function() {')(1); }", "invalid function"},
+		{"function executeStep(room) { return this['ev' + 'al']('while(true) {}'); }", "TypeError"},
+		{"function executeStep(room) { return require('fs'); }", "ReferenceError"},
+		{"'use strict';\nfunction executeStep(room) { 'use strict'; undeclared = 1; }", "ReferenceError"},
+	}
+	for _, test := range tests {
+		bot, err := engine.NewJSBot(test.code, "bot.js", limits, nil)
+		if err != nil {
+			t.Fatalf("Cannot load bot %q: %v", test.code, err)
+		}
+		result := engine.Run(m, bot, engine.Options{Limits: limits})
+		if result.Steps != 1 || result.Error == "" || !strings.Contains(result.Error, test.err) {
+			t.Errorf("Bot %q should stop with %q: %+v", test.code, test.err, result)
+		}
+		if result.Usage == nil || result.Usage.Operations == 0 {
+			t.Errorf("Bot %q should report usage: %+v", test.code, result.Usage)
+		}
+	}
+
+	// allocations of the interpreter are garbage, they are not charged to the memory limit
+	long := engine.Limits{StepTimeout: 30 * time.Second, MaxOperations: 300000, MaxMemory: 1024 * 1024, MaxCallStackSize: 100}
+	bot, err := engine.NewJSBot("function executeStep(room) { let s = ''; while(true) { s = 'x' + s.length; } }", "bot.js", long, nil)
+	if err != nil {
+		t.Fatalf("Cannot load bot: %v", err)
+	}
+	result := engine.Run(m, bot, engine.Options{Limits: long})
+	if result.Error != engine.ErrOperationLimit.Error() {
+		t.Errorf("Bot allocating garbage should stop with %q: %+v", engine.ErrOperationLimit, result)
+	}
+
+	escapes := []string{
+		"function executeStep(room) { { let __playermgr_tick = () => 0; while(true) {} } }",
+		"function executeStep(room) { with({}) { while(true) {} } }",
+		"function executeStep(room) { eval('var __playermgr_tick = () => 0'); while(true) {} }",
+		"function executeStep(room) { Function('while(true) {}')(); }",
+		"function executeStep(room) { (function(){}).constructor('while(true) {}')(); }",
+		"function executeStep(room) { executeStep['constructor']('while(true) {}')(); }",
+	}
+	for _, code := range escapes {
+		_, err := engine.NewJSBot(code, "bot.js", limits, nil)
+		if !errors.Is(err, engine.ErrSandboxEscape) {
+			t.Errorf("Bot %q should be rejected: %v", code, err)
+		}
+		v := engine.Validate(code, "bot.js", engine.Limits{})
+		if v.Valid || v.Diagnostics[0].Code != engine.DiagForbidden {
+			t.Errorf("Bot %q should be invalid: %+v", code, v)
+		}
+	}
+
+	v := engine.Validate("function executeStep(room) { return process.exit(); }", "bot.js", engine.Limits{})
+	if v.Valid || v.Diagnostics[0].Code != engine.DiagForbidden {
+		t.Errorf("Bot using process should be rejected: %+v", v)
+	}
+	v = engine.Validate("function executeStep(room) { return {action: room.process}; }", "bot.js", engine.Limits{})
+	if !v.Valid {
+		t.Errorf("Property name is not a forbidden global: %+v", v)
+	}
+}
+
+func TestRandomSeed(t *testing.T) {
+	m, _ := maze.Parse(basic)
+	code := `
+		const directions = ['up', 'right', 'down', 'left'];
+		function executeStep(room) {
+			return {action: 'move', direction: directions[Math.floor(Math.random() * 4)]};
+		}`
+
+	play := func(seed int64) *engine.Result {
+		bot, err := engine.NewJSBot(code, "random.js", engine.Limits{}, nil)
+		if err != nil {
+			t.Fatalf("Cannot load bot: %v", err)
+		}
+		return engine.Run(m, bot, engine.Options{Seed: seed})
+	}
+
+	first, second, other := play(42), play(42), play(7)
+	if first.Steps != second.Steps || strings.Join(first.BotResult.Maze, "") != strings.Join(second.BotResult.Maze, "") {
+		t.Errorf("Same seed should give same game: %+v %+v", first, second)
+	}
+	if first.Steps == other.Steps && strings.Join(first.BotResult.Maze, "") == strings.Join(other.BotResult.Maze, "") {
+		t.Errorf("Different seeds should give different games: %+v", other)
+	}
+}
+
+// build a WebAssembly module exporting execute_step with type and body, importing functions of type 0
+func wasmModule(funcType []byte, body []byte, imports ...string) []byte {
+	section := func(id byte, content []byte) []byte {
+		return append([]byte{id, byte(len(content))}, content...)
+	}
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(1, append([]byte{1}, funcType...))...)
+	if len(imports) > 0 {
+		content := []byte{byte(len(imports) / 2)}
+		for i := 0; i < len(imports); i += 2 {
+			content = append(content, byte(len(imports[i])))
+			content = append(content, imports[i]...)
+			content = append(content, byte(len(imports[i+1])))
+			content = append(content, imports[i+1]...)
+			content = append(content, 0x00, 0x00)
+		}
+		module = append(module, section(2, content)...)
+	}
+	module = append(module, section(3, []byte{1, 0})...)
+	export := append([]byte{1, byte(len(engine.WasmEntryPoint))}, engine.WasmEntryPoint...)
+	module = append(module, section(7, append(export, 0x00, byte(len(imports)/2)))...)
+	module = append(module, section(10, append([]byte{1, byte(len(body))}, body...))...)
+	return module
+}
+
+var (
+	stepType  = []byte{0x60, 0x01, 0x7f, 0x01, 0x7f}
+	moveRight = []byte{0x00, 0x41, 0x02, 0x0b}
+	loop      = []byte{0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x41, 0x00, 0x0b}
+)
+
+func TestWasm(t *testing.T) {
+	m, _ := maze.Parse([]string{
+		"+-+-+",
+		"x   X",
+		"+-+-+",
+	})
+
+	bot, err := engine.NewWasmBot(wasmModule(stepType, moveRight), engine.Limits{}, nil)
+	if err != nil {
+		t.Fatalf("Cannot load module: %v", err)
+	}
+	result := engine.Run(m, bot, engine.Options{})
+	bot.Close()
+	if result.State != engine.Success || result.Steps != 2 {
+		t.Errorf("Bot should exit in 2 steps: %+v", result)
+	}
+
+	bot, err = engine.NewWasmBot(wasmModule(stepType, loop), engine.Limits{StepTimeout: 50 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("Cannot load module: %v", err)
+	}
+	result = engine.Run(m, bot, engine.Options{})
+	bot.Close()
+	if result.Steps != 1 || result.Error != engine.ErrStepTimeout.Error() {
+		t.Errorf("Bot should time out: %+v", result)
+	}
+}
+
+func TestValidateWasm(t *testing.T) {
+	tests := []struct {
+		module []byte
+		valid  bool
+		diag   string
+	}{
+		{[]byte{}, false, engine.DiagEmpty},
+		{[]byte("not a module"), false, engine.DiagLoad},
+		{wasmModule([]byte{0x60, 0x00, 0x01, 0x7f}, moveRight), false, engine.DiagNoEntryPoint},
+		{wasmModule(stepType, moveRight, "wasi_snapshot_preview1", "fd_write"), false, engine.DiagForbidden},
+		{wasmModule(stepType, moveRight, "env", "log"), false, engine.DiagLoad},
+		{wasmModule(stepType, moveRight), true, engine.DiagNoExit},
+	}
+	for _, test := range tests {
+		v := engine.ValidateBot(model.LanguageWasm, base64.StdEncoding.EncodeToString(test.module), "bot.wasm", engine.Limits{})
+		if v.Valid != test.valid || len(v.Diagnostics) != 1 || v.Diagnostics[0].Code != test.diag {
+			t.Errorf("Unexpected validation of module %x: %+v", test.module, v)
+		}
+	}
+
+	v := engine.ValidateBot(model.LanguageWasm, "not base64!", "bot.wasm", engine.Limits{})
+	if v.Valid || v.Diagnostics[0].Code != engine.DiagEncoding {
+		t.Errorf("Module should be base64 encoded: %+v", v)
+	}
+	v = engine.ValidateBot("cobol", "", "bot.cob", engine.Limits{})
+	if v.Valid || v.Diagnostics[0].Code != engine.DiagLanguage {
+		t.Errorf("Language should be rejected: %+v", v)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	m, _ := maze.Parse([]string{
+		"+-+-+",
+		"x   X",
+		"+-+-+",
+	})
+	secret := "0123456789abcdef"
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := atomic.AddInt32(&calls, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(engine.SignatureHeader) != engine.Sign(secret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var room maze.Room
+		if err := json.Unmarshal(body, &room); err != nil || room.Right == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// first call fails to check retries
+		if call == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/redirect":
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		case "/large":
+			w.Write([]byte(`{"action": "move", "direction": "right", "padding": "`))
+			w.Write(bytes.Repeat([]byte("x"), engine.MaxRemoteResponseSize))
+			w.Write([]byte(`"}`))
+			return
+		}
+		w.Write([]byte(`{"action": "move", "direction": "right"}`))
+	}))
+	defer server.Close()
+
+	// test server is on loopback
+	v := engine.ValidateRemote(server.URL, secret, engine.Limits{})
+	if v.Valid || v.Diagnostics[0].Code != engine.DiagUnreachable || v.Diagnostics[0].Message != engine.ErrUnreachable.Error() {
+		t.Errorf("Bot on loopback should be unreachable: %+v", v)
+	}
+	engine.AllowPrivateRemotes = true
+	defer func() { engine.AllowPrivateRemotes = false }()
+
+	bot, err := engine.NewRemoteBot(server.URL, secret, engine.Limits{})
+	if err != nil {
+		t.Fatalf("Cannot create bot: %v", err)
+	}
+	result := engine.Run(m, bot, engine.Options{})
+	if result.State != engine.Success || result.Steps != 2 || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Bot should exit in 2 steps after a retry: %+v %v calls", result, atomic.LoadInt32(&calls))
+	}
+
+	bot, _ = engine.NewRemoteBot(server.URL, "wrong secret", engine.Limits{})
+	result = engine.Run(m, bot, engine.Options{})
+	if result.Steps != 1 || !strings.Contains(result.Error, "status 401") {
+		t.Errorf("Unsigned request should fail: %+v", result)
+	}
+
+	bot, _ = engine.NewRemoteBot(server.URL+"/slow", secret, engine.Limits{StepTimeout: 50 * time.Millisecond})
+	result = engine.Run(m, bot, engine.Options{})
+	if result.Error != engine.ErrStepTimeout.Error() {
+		t.Errorf("Slow bot should time out: %+v", result)
+	}
+
+	tests := []struct {
+		url    string
+		secret string
+		valid  bool
+		diag   string
+	}{
+		{server.URL + "/redirect", secret, false, engine.DiagResponse},
+		{server.URL + "/large", secret, false, engine.DiagResponse},
+		{server.URL, "short", false, engine.DiagSecret},
+		{"ftp://bot", secret, false, engine.DiagURL},
+		{server.URL, "another secret key", false, engine.DiagResponse},
+		{"http://127.0.0.1:1", secret, false, engine.DiagUnreachable},
+		{server.URL, secret, true, engine.DiagNoExit},
+	}
+	for _, test := range tests {
+		v := engine.ValidateRemote(test.url, test.secret, engine.Limits{})
+		if v.Valid != test.valid || len(v.Diagnostics) != 1 || v.Diagnostics[0].Code != test.diag {
+			t.Errorf("Unexpected validation of %v: %+v", test.url, v)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	m, _ := maze.Parse(basic)
+
+	var log bytes.Buffer
+	bot := loadBot(t, "../data/bots/bot3.js", engine.Limits{})
+	result := engine.Run(m, bot, engine.Options{StepLog: &log, Record: true, Seed: 7})
+
+	replay := result.Replay
+	if replay == nil || len(replay.Steps) != result.Steps || replay.State != engine.Success {
+		t.Fatalf("Unexpected replay: %+v", replay)
+	}
+	if replay.Version != engine.ReplayVersion || replay.MazeHash != m.Hash() || replay.Seed != 7 || replay.MaxSteps != 64 {
+		t.Errorf("Unexpected replay header: %+v", replay)
+	}
+	first := replay.Steps[0]
+	if first.Step != 1 || first.Position != m.Entry.Cell || first.Room != "wdwe" || first.Action.Action != engine.ActionMove {
+		t.Errorf("Unexpected first step: %+v", first)
+	}
+
+	path := replay.Path(m)
+	if len(path) != result.Steps+1 || path[0] != m.Entry.Cell || path[len(path)-1] != m.Exit.Cell.Next(m.Exit.Side) {
+		t.Errorf("Path should go from entry to outside of exit: %v", path)
+	}
+
+	data, _ := json.Marshal(replay)
+	decoded, err := engine.ReadReplay(data)
+	if err != nil {
+		t.Fatalf("Cannot read replay: %v", err)
+	}
+	replayMaze, err := decoded.ParseMaze()
+	if err != nil || replayMaze.Hash() != m.Hash() {
+		t.Fatalf("Replay should hold maze: %v", err)
+	}
+
+	var replayLog bytes.Buffer
+	played, err := engine.PlayReplay(replayMaze, decoded, engine.Options{StepLog: &replayLog})
+	if err != nil {
+		t.Fatalf("Cannot play replay: %v", err)
+	}
+	if played.Steps != result.Steps || played.State != result.State || replayLog.String() != log.String() {
+		t.Errorf("Replay should give same game: %+v", played)
+	}
+
+	other, _ := maze.Parse([]string{"+-+", "x X", "+-+"})
+	_, err = engine.PlayReplay(other, decoded, engine.Options{})
+	if !errors.Is(err, engine.ErrReplayMaze) {
+		t.Errorf("Replay in other maze should fail: %v", err)
+	}
+
+	decoded.Steps[1].Room = "dddd"
+	_, err = engine.PlayReplay(replayMaze, decoded, engine.Options{})
+	if !errors.Is(err, engine.ErrReplayDiverged) {
+		t.Errorf("Replay with other room should diverge: %v", err)
+	}
+
+	_, err = engine.ReadReplay([]byte(`{"version": 99}`))
+	if !errors.Is(err, engine.ErrReplayVersion) {
+		t.Errorf("Unknown version should fail: %v", err)
+	}
+
+	// game stopped by total time budget after first step
+	bot = loadBot(t, "../data/bots/bot3.js", engine.Limits{})
+	result = engine.Run(m, bot, engine.Options{Record: true, Limits: engine.Limits{TotalTimeout: time.Nanosecond}})
+	if result.Error != engine.ErrTotalTimeout.Error() || result.Steps != 1 || !result.Replay.TimedOut {
+		t.Fatalf("Game should time out: %+v", result)
+	}
+	data, _ = json.Marshal(result.Replay)
+	decoded, _ = engine.ReadReplay(data)
+	played, err = engine.PlayReplay(m, decoded, engine.Options{})
+	if err != nil || played.Steps != 1 || played.Error != engine.ErrTotalTimeout.Error() {
+		t.Errorf("Replay should stop at total timeout: %+v %v", played, err)
+	}
+}
+
+func TestGame(t *testing.T) {
+	m, _ := maze.Parse(basic)
+	bot := loadBot(t, "../data/bots/bot3.js", engine.Limits{})
+	game := engine.NewGame(m, bot, engine.Options{})
+
+	if game.Steps() != 0 || game.Position() != m.Entry.Cell || game.Room().Left != maze.Entry || len(game.Visited()) != 0 {
+		t.Fatalf("Game should start at entry: %v %v", game.Position(), game.Room())
+	}
+	if !game.Step() || game.Steps() != 1 || game.Position() != (maze.Cell{Row: 1, Column: 1}) || len(game.Visited()) != 1 {
+		t.Errorf("Bot should move right: %v %v", game.Position(), game.Visited())
+	}
+	for game.Step() {
+	}
+	if !game.Done() || !game.Exited() || game.Steps() != game.Result().Steps {
+		t.Errorf("Game should be over: %v", game.Steps())
+	}
+	if game.Step() || game.Result().State != engine.Success {
+		t.Errorf("Finished game cannot be played: %+v", game.Result())
+	}
+}
+
+func TestConsole(t *testing.T) {
+	m, _ := maze.Parse(basic)
+	code := `console.log('Loading', 'bot');
+function fail(n) { throw new Error('lost at ' + n); }
+let n = 0;
+function executeStep(room) {
+	n++;
+	console.error('step', n);
+	if (n == 2) fail(n);
+	return { action: 'move', direction: 'right' };
+}`
+
+	var echo bytes.Buffer
+	console := engine.NewConsole(&echo)
+	bot, err := engine.NewJSBot(code, "lost.js", engine.Limits{}, console)
+	if err != nil {
+		t.Fatalf("Cannot load bot: %v", err)
+	}
+	result := engine.Run(m, bot, engine.Options{Console: console})
+
+	if result.Console != console || len(console.Entries) != 4 {
+		t.Fatalf("Unexpected console: %+v", console.Entries)
+	}
+	if console.Entries[0] != (engine.ConsoleEntry{Step: 0, Level: "log", Message: "Loading bot"}) ||
+		console.Entries[2] != (engine.ConsoleEntry{Step: 2, Level: "error", Message: "step 2"}) {
+		t.Errorf("Unexpected console entries: %+v", console.Entries)
+	}
+	ex := console.Entries[3]
+	if ex.Step != 2 || ex.Level != engine.LevelException || !strings.Contains(ex.Message, "lost at 2") ||
+		!strings.Contains(ex.Stack, "at fail (lost.js:2") || !strings.Contains(ex.Stack, "at executeStep (lost.js:7") {
+		t.Errorf("Unexpected exception: %+v", ex)
+	}
+	if !strings.HasPrefix(echo.String(), "Loading bot\nstep 1\n") {
+		t.Errorf("Console should be echoed: %v", echo.String())
+	}
+
+	// console keeps last entries
+	max := engine.MaxConsoleEntries
+	engine.MaxConsoleEntries = 2
+	defer func() { engine.MaxConsoleEntries = max }()
+	console = engine.NewConsole(nil)
+	console.Write([]byte("a\nb\nc\n"))
+	if len(console.Entries) != 2 || console.Entries[0].Message != "b" || console.Dropped != 1 {
+		t.Errorf("Console should be bounded: %+v", console)
+	}
+
+	// exception while loading
+	console = engine.NewConsole(nil)
+	_, err = engine.NewJSBot("throw new Error('broken');", "broken.js", engine.Limits{}, console)
+	if err == nil || len(console.Entries) != 1 || console.Entries[0].Step != 0 || !strings.Contains(console.Entries[0].Stack, "broken.js:1") {
+		t.Errorf("Load exception should be captured: %+v", console.Entries)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	suite := &maze.Suite{Name: "test"}
+	for _, rows := range [][]string{basic, {"+-+-+", "x   X", "+-+-+"}, {"+-+-+", "x | X", "+-+-+"}} {
+		m, err := maze.Parse(rows)
+		if err != nil {
+			t.Fatalf("Cannot parse maze: %v", err)
+		}
+		suite.Mazes = append(suite.Mazes, maze.SuiteMaze{Maze: m})
+	}
+	suite.Mazes[0].Name, suite.Mazes[1].Name, suite.Mazes[2].Name = "basic", "corridor", "closed"
+	code, _ := ioutil.ReadFile("../data/bots/bot3.js")
+	var loaded int32
+	load := func(console io.Writer) (engine.Bot, error) {
+		atomic.AddInt32(&loaded, 1)
+		return engine.NewJSBot(string(code), "bot3.js", engine.Limits{}, console)
+	}
+
+	e := engine.Evaluate(suite, load, engine.EvalOptions{Workers: 2, Record: true})
+	if loaded != 3 || e.Mazes != 3 || e.Successes != 2 || e.SuccessRate != 2.0/3 {
+		t.Fatalf("Bot should solve 2 mazes out of 3 with a bot per maze: %+v", e)
+	}
+	basicSteps := suite.Mazes[0].Maze.WallFollower(64).Steps
+	if e.P50Steps != 2 || e.P95Steps != basicSteps || e.MeanSteps != float64(2+basicSteps)/2 {
+		t.Errorf("Unexpected step statistics %+v", e)
+	}
+	if e.OptimalRatio != float64(2+5)/float64(2+basicSteps) {
+		t.Errorf("Unexpected optimal ratio %v", e.OptimalRatio)
+	}
+	if len(e.Failures) != 1 || e.Failures[0].Maze != "closed" || e.Failures[0].State != engine.Failure {
+		t.Errorf("Closed maze should fail: %+v", e.Failures)
+	}
+	for i, r := range e.Results {
+		if r.Maze != suite.Mazes[i].Name || r.Result == nil || r.Result.Replay == nil {
+			t.Errorf("Results should be in suite order with replays: %+v", r)
+		}
+	}
+
+	// no game is started once cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	loaded = 0
+	e = engine.Evaluate(suite, load, engine.EvalOptions{Workers: 2, Context: ctx})
+	if loaded != 0 || e.Mazes != 3 || e.Successes != 0 {
+		t.Errorf("Cancelled evaluation should not play: %+v", e)
+	}
+
+	e = engine.Evaluate(suite, func(console io.Writer) (engine.Bot, error) {
+		return nil, errors.New("no bot")
+	}, engine.EvalOptions{})
+	if e.Successes != 0 || len(e.Failures) != 3 || e.Failures[0].Error != "no bot" || e.P50Steps != 0 {
+		t.Errorf("Bot failing to load should fail everywhere: %+v", e)
+	}
+}
+
+func TestRace(t *testing.T) {
+	m, _ := maze.Parse(basic)
+	newBot := func(code string) engine.Bot {
+		bot, err := engine.NewJSBot(code, "race.js", engine.Limits{}, nil)
+		if err != nil {
+			t.Fatalf("Cannot load bot: %v", err)
+		}
+		return bot
+	}
+	fast := `var moves = ['right', 'right', 'down', 'right', 'right'], n = 0;
+		function executeStep(room) { return { action: 'move', direction: moves[n++] }; }`
+	wait := `function executeStep(room) { return { action: 'wait' }; }`
+	// all bots are at entry, then the wait bot is left of the others
+	sighter := `var n = 0;
+		function count(bots, direction) { return bots.filter(function(b) { return b.direction === direction; }).length; }
+		function executeStep(room) {
+			n++;
+			if (!room.bots) throw new Error('no bots');
+			if (n === 1 && count(room.bots, undefined) !== 4) throw new Error('step 1 saw ' + JSON.stringify(room.bots));
+			if (n === 2 && (count(room.bots, undefined) !== 3 || count(room.bots, 'left') !== 1)) throw new Error('step 2 saw ' + JSON.stringify(room.bots));
+			return { action: n === 1 ? 'move' : 'wait', direction: 'right' };
+		}`
+	racers := func() []engine.Bot {
+		return []engine.Bot{
+			loadBot(t, "../data/bots/bot3.js", engine.Limits{}),
+			newBot(fast),
+			newBot(wait),
+			loadBot(t, "../data/bots/bot3.js", engine.Limits{}),
+			newBot(sighter),
+		}
+	}
+
+	race := engine.Race(m, racers(), engine.RaceOptions{Sight: true, Record: true, Seed: 3})
+	if len(race.Racers) != 5 || race.Ticks != 64 {
+		t.Fatalf("Race should last until max steps of wait bot: %+v", race)
+	}
+	ranks := []int{}
+	for i, r := range race.Racers {
+		if r.Lane != i {
+			t.Errorf("Racers should be in lane order: %+v", r)
+		}
+		ranks = append(ranks, r.Rank)
+	}
+	if ranks[0] != 2 || ranks[1] != 1 || ranks[2] != 0 || ranks[3] != 2 || ranks[4] != 0 {
+		t.Errorf("Unexpected ranks %v", ranks)
+	}
+	if sighted := race.Racers[4].Result; sighted.Error != "" || sighted.Steps != 64 {
+		t.Errorf("Bot should see the others: %+v", sighted)
+	}
+
+	blind := engine.Race(m, racers(), engine.RaceOptions{})
+	if blind.Racers[1].Rank != 1 || !strings.Contains(blind.Racers[4].Result.Error, "no bots") {
+		t.Errorf("Bots should not see others without sight: %+v", blind.Racers[4].Result)
+	}
+
+	replay := &engine.RaceReplay{Version: engine.ReplayVersion, MazeHash: m.Hash(), Maze: m.Render(), Seed: 3, Sight: true}
+	for _, r := range race.Racers {
+		replay.Lanes = append(replay.Lanes, r.Result.Replay)
+	}
+	played, err := engine.PlayRaceReplay(m, replay)
+	if err != nil {
+		t.Fatalf("Cannot play race replay: %v", err)
+	}
+	for i, r := range played.Racers {
+		if r.Rank != race.Racers[i].Rank || r.Result.Steps != race.Racers[i].Result.Steps {
+			t.Errorf("Replay should give same race: %+v", r)
+		}
+	}
+	other, _ := maze.Parse([]string{"+-+", "x X", "+-+"})
+	_, err = engine.PlayRaceReplay(other, replay)
+	if !errors.Is(err, engine.ErrReplayMaze) {
+		t.Errorf("Race replay in other maze should fail: %v", err)
+	}
+
+	// bots stopped by total time budget after their first step
+	timedOut := engine.Race(m, racers(), engine.RaceOptions{Sight: true, Record: true, Seed: 3, Limits: engine.Limits{TotalTimeout: time.Nanosecond}})
+	replay.Lanes = nil
+	for _, r := range timedOut.Racers {
+		replay.Lanes = append(replay.Lanes, r.Result.Replay)
+	}
+	played, err = engine.PlayRaceReplay(m, replay)
+	if err != nil || played.Ticks != 1 {
+		t.Fatalf("Cannot play timed out race replay: %+v %v", played, err)
+	}
+	for _, r := range played.Racers {
+		if r.Result.Error != engine.ErrTotalTimeout.Error() {
+			t.Errorf("Replayed bot should time out: %+v", r.Result)
+		}
+	}
+}
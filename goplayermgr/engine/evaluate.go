@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+// BotLoader loads a new instance of a bot writing its output to console
+type BotLoader func(console io.Writer) (Bot, error)
+
+type EvalOptions struct {
+	Limits Limits
+	// number of games played in parallel, 0 means number of CPUs
+	Workers int
+	// seed of random numbers given to bot in each maze
+	Seed int64
+	// record game steps in each result replay
+	Record bool
+	// when set, called after each game with the number of games played
+	Progress func(done int, total int)
+	// when set and done, no more games are started and nothing is stored
+	Context context.Context
+}
+
+// MazeResult is the outcome of a bot in a maze of a suite
+type MazeResult struct {
+	Maze         string  `json:"maze"`
+	State        string  `json:"state"`
+	Steps        int     `json:"steps"`
+	OptimalSteps int     `json:"optimal_steps,omitempty"`
+	Score        float64 `json:"score"`
+	Error        string  `json:"error,omitempty"`
+	// id of stored game, if any
+	GameId int32 `json:"game_id,omitempty"`
+	// nil when the bot failed to load
+	Result *Result `json:"-"`
+}
+
+/*
+	Evaluation aggregates the games of a bot over a maze suite.
+	Step statistics only count successful games, percentiles use the nearest rank.
+*/
+type Evaluation struct {
+	Suite       string  `json:"suite"`
+	Mazes       int     `json:"mazes"`
+	Successes   int     `json:"successes"`
+	SuccessRate float64 `json:"success_rate"`
+	MeanSteps   float64 `json:"mean_steps"`
+	P50Steps    int     `json:"p50_steps"`
+	P90Steps    int     `json:"p90_steps"`
+	P95Steps    int     `json:"p95_steps"`
+	// optimal steps divided by bot steps of successful games
+	OptimalRatio float64 `json:"optimal_ratio"`
+	MeanScore    float64 `json:"mean_score"`
+	// mazes where bot failed, with the error if any
+	Failures []MazeResult `json:"failures"`
+	Results  []MazeResult `json:"results"`
+	// wall clock time of the evaluation
+	Duration time.Duration `json:"duration"`
+}
+
+/*
+	Play a game of a new bot in each maze of suite, opts.Workers games at a time.
+	Memory limits are checked against the live heap of the whole process,
+	parallel games may count objects held by each other.
+	Results of mazes not played because opts.Context is done are empty.
+*/
+func Evaluate(suite *maze.Suite, load BotLoader, opts EvalOptions) *Evaluation {
+	start := time.Now()
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(suite.Mazes) {
+		workers = len(suite.Mazes)
+	}
+
+	results := make([]MazeResult, len(suite.Mazes))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = play(suite.Mazes[i], load, opts)
+				if opts.Progress != nil {
+					mu.Lock()
+					done++
+					opts.Progress(done, len(results))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+dispatch:
+	for i := range suite.Mazes {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	evaluation := summarize(suite.Name, results)
+	evaluation.Duration = time.Since(start)
+	return evaluation
+}
+
+func play(sm maze.SuiteMaze, load BotLoader, opts EvalOptions) MazeResult {
+	console := NewConsole(nil)
+	bot, err := load(console)
+	if err != nil {
+		return MazeResult{Maze: sm.Name, State: Failure, Error: err.Error()}
+	}
+	defer CloseBot(bot)
+
+	result := Run(sm.Maze, bot, Options{Limits: opts.Limits, Seed: opts.Seed, Record: opts.Record, Console: console})
+	return MazeResult{
+		Maze:         sm.Name,
+		State:        result.State,
+		Steps:        result.Steps,
+		OptimalSteps: result.OptimalSteps,
+		Score:        result.Score,
+		Error:        result.Error,
+		Result:       result,
+	}
+}
+
+func summarize(suite string, results []MazeResult) *Evaluation {
+	e := &Evaluation{Suite: suite, Mazes: len(results), Failures: failures(results), Results: results}
+	steps := []int{}
+	optimal, total := 0, 0
+	score := 0.0
+	for _, r := range results {
+		score += r.Score
+		if r.State != Success {
+			continue
+		}
+		steps = append(steps, r.Steps)
+		optimal += r.OptimalSteps
+		total += r.Steps
+	}
+	e.Successes = len(steps)
+	if e.Mazes > 0 {
+		e.SuccessRate = float64(e.Successes) / float64(e.Mazes)
+		e.MeanScore = score / float64(e.Mazes)
+	}
+	if total > 0 {
+		e.MeanSteps = float64(total) / float64(len(steps))
+		e.OptimalRatio = float64(optimal) / float64(total)
+	}
+	sort.Ints(steps)
+	e.P50Steps = percentile(steps, 50)
+	e.P90Steps = percentile(steps, 90)
+	e.P95Steps = percentile(steps, 95)
+	return e
+}
+
+func failures(results []MazeResult) []MazeResult {
+	failed := []MazeResult{}
+	for _, r := range results {
+		if r.State != Success {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// nearest rank percentile p of sorted values
+func percentile(sorted []int, p float64) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+/*
+	Evaluate current revision of bot of player over suite, games are stored
+	as games of the bot and the report as an evaluation. Mazes of the suite are ranked.
+	When opts.Context is done before games are stored, its error is returned.
+*/
+func EvaluateBot(db *gorm.DB, pid int32, bid int32, suite *maze.Suite, opts EvalOptions) (*model.Evaluation, *Evaluation, error) {
+	code := model.GetBotCode(db, pid, bid)
+	if code == nil {
+		return nil, nil, fmt.Errorf("cannot get code of bot %v of player %v", bid, pid)
+	}
+	load := func(console io.Writer) (Bot, error) {
+		return newStoredBot(code, opts.Limits, console)
+	}
+	err := model.AddRankedMazes(db, rankedMazes(suite))
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.Record = true
+	evaluation := Evaluate(suite, load, opts)
+
+	// database writes are not done by workers, sqlite does not like them
+	for i := range evaluation.Results {
+		// another worker took the job over, it stores its own games
+		if opts.Context != nil && opts.Context.Err() != nil {
+			return nil, evaluation, opts.Context.Err()
+		}
+		r := &evaluation.Results[i]
+		if r.Result == nil {
+			continue
+		}
+		game, err := storeGame(db, code, r.Maze, r.Result)
+		if err != nil {
+			return nil, evaluation, err
+		}
+		r.GameId = game.Gid
+	}
+	// failures are copies of results, they get game ids too
+	evaluation.Failures = failures(evaluation.Results)
+
+	report, err := json.Marshal(evaluation)
+	if err != nil {
+		return nil, evaluation, err
+	}
+	stored := model.AddEvaluation(db, &model.Evaluation{
+		PlayerId:   pid,
+		BotId:      bid,
+		BotVersion: code.Version,
+		Suite:      suite.Name,
+		Report:     string(report),
+	})
+	if stored == nil {
+		return nil, evaluation, fmt.Errorf("cannot store evaluation of bot %v of player %v", bid, pid)
+	}
+	return stored, evaluation, nil
+}
+
+func rankedMazes(suite *maze.Suite) []model.RankedMaze {
+	ranked := []model.RankedMaze{}
+	for _, m := range suite.Mazes {
+		ranked = append(ranked, model.RankedMaze{Hash: m.Maze.Hash(), Name: m.Name})
+	}
+	return ranked
+}
+
+/*
+	Rank mazes of all suites of root directory, games in them count
+	for the global leaderboard and ratings
+*/
+func RankSuites(db *gorm.DB, root string) error {
+	ranked := []model.RankedMaze{}
+	for _, name := range maze.Suites(root) {
+		suite, err := maze.FindSuite(root, name)
+		if errors.Is(err, maze.ErrEmptySuite) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ranked = append(ranked, rankedMazes(suite)...)
+	}
+	return model.AddRankedMazes(db, ranked)
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gorm.io/gorm"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+/*
+	Play a game of bot of player in maze and store it with its replay and console,
+	console output is also written to console writer when not nil.
+	A bot failing to load is an error, a bot failing while playing is a failed game.
+*/
+func PlayGame(db *gorm.DB, pid int32, bid int32, m *maze.Maze, mazeName string, opts Options, console io.Writer) (*model.Game, *Result, error) {
+	code := model.GetBotCode(db, pid, bid)
+	if code == nil {
+		return nil, nil, fmt.Errorf("cannot get code of bot %v of player %v", bid, pid)
+	}
+	return PlayCode(db, code, m, mazeName, opts, console)
+}
+
+/*
+	Play a game of bot code, e.g. a revision registered in a tournament, and store it like PlayGame
+*/
+func PlayCode(db *gorm.DB, code *model.BotCode, m *maze.Maze, mazeName string, opts Options, console io.Writer) (*model.Game, *Result, error) {
+	opts.Console = NewConsole(console)
+	bot, err := newStoredBot(code, opts.Limits, opts.Console)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer CloseBot(bot)
+
+	opts.Record = true
+	result := Run(m, bot, opts)
+	game, err := storeGame(db, code, mazeName, result)
+	return game, result, err
+}
+
+/*
+	Store a recorded game of bot code
+*/
+func storeGame(db *gorm.DB, code *model.BotCode, mazeName string, result *Result) (*model.Game, error) {
+	game, err := newGame(code, mazeName, result)
+	if err != nil {
+		return nil, err
+	}
+	if model.AddGame(db, game) == nil {
+		return nil, fmt.Errorf("cannot store game of bot %v of player %v", code.Bid, code.PlayerId)
+	}
+	return game, nil
+}
+
+// game of a recorded result of bot code, result replay is completed with the bot
+func newGame(code *model.BotCode, mazeName string, result *Result) (*model.Game, error) {
+	pid, bid := code.PlayerId, code.Bid
+	result.Replay.PlayerId = pid
+	result.Replay.BotId = bid
+	result.Replay.BotVersion = code.Version
+	result.Replay.Language = code.Language
+
+	replay, err := json.Marshal(result.Replay)
+	if err != nil {
+		return nil, err
+	}
+	output, err := json.Marshal(result.Console)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Game{
+		PlayerId:     pid,
+		BotId:        bid,
+		BotVersion:   code.Version,
+		MazeName:     mazeName,
+		MazeHash:     result.Replay.MazeHash,
+		State:        result.State,
+		Steps:        result.Steps,
+		OptimalSteps: result.OptimalSteps,
+		Score:        result.Score,
+		Error:        result.Error,
+		Replay:       string(replay),
+		Console:      string(output),
+	}, nil
+}
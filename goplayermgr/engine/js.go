@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+	"jc.org/playermgr/maze"
+)
+
+// name of the function bot code must define
+const EntryPoint = "executeStep"
+
+var ErrNoEntryPoint = errors.New("cannot find BOT main function " + EntryPoint)
+
+// JSBot runs a JavaScript bot in an embedded interpreter
+type JSBot struct {
+	vm      *goja.Runtime
+	step    goja.Callable
+	limits  Limits
+	sandbox *sandbox
+}
+
+/*
+	Load JavaScript bot code, it must define function executeStep(room).
+	console output of bot is written to console, it may be nil.
+*/
+func NewJSBot(code string, filename string, limits Limits, console io.Writer) (*JSBot, error) {
+	limits = withDefaults(limits)
+	vm := goja.New()
+	sb := newSandbox(vm, limits)
+	installConsole(vm, console)
+
+	program, err := compile(code, filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load script: %w", err)
+	}
+
+	start := time.Now()
+	err = sb.run(limits.LoadTimeout, func() error {
+		_, err := vm.RunProgram(program)
+		return err
+	})
+	sb.usage.LoadDuration = time.Since(start)
+	if err != nil {
+		if c, ok := console.(*Console); ok {
+			c.exception(err)
+		}
+		return nil, fmt.Errorf("cannot load script: %w", err)
+	}
+
+	step, ok := goja.AssertFunction(vm.Get(EntryPoint))
+	if !ok {
+		return nil, ErrNoEntryPoint
+	}
+
+	return &JSBot{vm: vm, step: step, limits: limits, sandbox: sb}, nil
+}
+
+// parse code and instrument it to count operations
+func compile(code string, filename string) (*goja.Program, error) {
+	ast, err := parser.ParseFile(nil, filename, code, 0)
+	if err != nil {
+		return nil, err
+	}
+	if escapes := sandboxEscapes(ast); len(escapes) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSandboxEscape, strings.Join(escapes, ", "))
+	}
+	instrument(ast)
+	return goja.CompileAST(ast, false)
+}
+
+func (b *JSBot) Step(room maze.Room) (Action, error) {
+	return b.stepRoom(room, nil)
+}
+
+// StepSighted gives the bots seen from the room in room.bots
+func (b *JSBot) StepSighted(room maze.Room, bots []Sighting) (Action, error) {
+	if bots == nil {
+		bots = []Sighting{}
+	}
+	return b.stepRoom(room, bots)
+}
+
+func (b *JSBot) stepRoom(room maze.Room, bots []Sighting) (Action, error) {
+	var action Action
+
+	start := time.Now()
+	err := b.sandbox.run(b.limits.StepTimeout, func() error {
+		r := b.vm.NewObject()
+		r.Set("left", string(room.Left))
+		r.Set("right", string(room.Right))
+		r.Set("up", string(room.Up))
+		r.Set("down", string(room.Down))
+		if bots != nil {
+			seen := make([]interface{}, len(bots))
+			for i, s := range bots {
+				o := b.vm.NewObject()
+				o.Set("bot", s.Bot)
+				if s.Direction != "" {
+					o.Set("direction", string(s.Direction))
+				}
+				seen[i] = o
+			}
+			r.Set("bots", b.vm.NewArray(seen...))
+		}
+
+		res, err := b.step(goja.Undefined(), r)
+		if err != nil {
+			return err
+		}
+		action = toAction(res)
+		return nil
+	})
+	b.sandbox.stepDone(time.Since(start))
+
+	return action, err
+}
+
+// Seed random numbers of Math.random
+func (b *JSBot) Seed(seed int64) {
+	b.sandbox.seed(seed)
+}
+
+// Usage of resources since bot was loaded
+func (b *JSBot) Usage() Usage {
+	return b.sandbox.usage
+}
+
+func toAction(v goja.Value) Action {
+	if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+		return Action{}
+	}
+	obj, ok := v.Export().(map[string]interface{})
+	if !ok {
+		return Action{}
+	}
+	action, _ := obj["action"].(string)
+	direction, _ := obj["direction"].(string)
+	return Action{Action: action, Direction: maze.Direction(direction)}
+}
+
+/*
+	Define console object of bot, a Console records the level of messages,
+	other writers get one line per message
+*/
+func installConsole(vm *goja.Runtime, w io.Writer) {
+	console := vm.NewObject()
+	captured, _ := w.(*Console)
+	for _, name := range []string{"log", "info", "warn", "error", "debug"} {
+		level := name
+		console.Set(name, func(call goja.FunctionCall) goja.Value {
+			if w == nil {
+				return goja.Undefined()
+			}
+			args := make([]string, len(call.Arguments))
+			for i, arg := range call.Arguments {
+				args[i] = arg.String()
+			}
+			message := strings.Join(args, " ")
+			if captured != nil {
+				captured.Log(level, message)
+			} else {
+				fmt.Fprintln(w, message)
+			}
+			return goja.Undefined()
+		})
+	}
+	vm.Set("console", console)
+}
@@ -0,0 +1,268 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+/*
+	Sighting is another bot of a race seen by a bot, Bot is its lane.
+	Direction is empty when it is in the same room, else it is in the
+	neighbouring room through the door on that side.
+*/
+type Sighting struct {
+	Bot       int            `json:"bot"`
+	Direction maze.Direction `json:"direction,omitempty"`
+}
+
+// RaceRoom is the room posted to remote bots in a race with sight
+type RaceRoom struct {
+	maze.Room
+	Bots []Sighting `json:"bots"`
+}
+
+/*
+	Sighted bots can see the other bots of a race, JavaScript bots get them in
+	room.bots and remote bots in the bots field of the posted room.
+	WebAssembly bots only get the room.
+*/
+type Sighted interface {
+	StepSighted(room maze.Room, bots []Sighting) (Action, error)
+}
+
+type RaceOptions struct {
+	Limits Limits
+	// seed of random numbers given to all bots
+	Seed int64
+	// record game steps of each bot in its Result.Replay
+	Record bool
+	// bots see the others in the room descriptions, see Sighted
+	Sight bool
+	// console of each bot by lane, none when missing
+	Consoles []*Console
+}
+
+// Racer is the result of the bot of a lane, Rank is 0 when it did not exit
+type Racer struct {
+	Lane   int     `json:"lane"`
+	Rank   int     `json:"rank,omitempty"`
+	Result *Result `json:"result"`
+}
+
+// RaceResult has racers in lane order
+type RaceResult struct {
+	Racers []Racer `json:"racers"`
+	// lockstep rounds played, i.e. steps of the slowest bot
+	Ticks    int           `json:"ticks"`
+	Duration time.Duration `json:"duration"`
+}
+
+/*
+	Race bots from the entry of maze m. Bots step in lockstep: at each tick every bot
+	still in the maze plays one step, seeing the others where they were at the start
+	of the tick. A bot leaves the race when it exits or fails, so bots do not block
+	each other. Bots exiting at the same tick share their rank.
+*/
+func Race(m *maze.Maze, bots []Bot, opts RaceOptions) *RaceResult {
+	games := make([]*Game, len(bots))
+	for i, bot := range bots {
+		o := Options{Limits: opts.Limits, Seed: opts.Seed, Record: opts.Record}
+		if i < len(opts.Consoles) {
+			o.Console = opts.Consoles[i]
+		}
+		games[i] = NewGame(m, bot, o)
+	}
+
+	positions := make([]maze.Cell, len(games))
+	active := make([]bool, len(games))
+	if opts.Sight {
+		for i := range games {
+			lane := i
+			games[i].sight = func() []Sighting { return sightings(m, positions, active, lane) }
+		}
+	}
+
+	start := time.Now()
+	race := &RaceResult{Racers: make([]Racer, len(games))}
+	for running := true; running; {
+		running = false
+		for i, g := range games {
+			positions[i], active[i] = g.Position(), !g.Done()
+		}
+		for i, g := range games {
+			if active[i] {
+				g.Step()
+				running = true
+			}
+		}
+		if running {
+			race.Ticks++
+		}
+	}
+	race.Duration = time.Since(start)
+
+	for i, g := range games {
+		race.Racers[i] = Racer{Lane: i, Result: g.Result()}
+	}
+	rank(race.Racers)
+	return race
+}
+
+// bots seen by bot of lane: in its room or through one of its doors
+func sightings(m *maze.Maze, positions []maze.Cell, active []bool, lane int) []Sighting {
+	seen := []Sighting{}
+	pos := positions[lane]
+	for i, other := range positions {
+		if i == lane || !active[i] {
+			continue
+		}
+		if other == pos {
+			seen = append(seen, Sighting{Bot: i})
+			continue
+		}
+		for _, d := range maze.Directions {
+			if m.CanMove(pos, d) && pos.Next(d) == other {
+				seen = append(seen, Sighting{Bot: i, Direction: d})
+			}
+		}
+	}
+	return seen
+}
+
+// rank bots which exited by steps, ties share the best rank (1, 1, 3)
+func rank(racers []Racer) {
+	finishers := []*Racer{}
+	for i := range racers {
+		if racers[i].Result.State == Success {
+			finishers = append(finishers, &racers[i])
+		}
+	}
+	sort.SliceStable(finishers, func(i, j int) bool {
+		return finishers[i].Result.Steps < finishers[j].Result.Steps
+	})
+	for i, r := range finishers {
+		r.Rank = i + 1
+		if i > 0 && r.Result.Steps == finishers[i-1].Result.Steps {
+			r.Rank = finishers[i-1].Rank
+		}
+	}
+}
+
+/*
+	RaceReplay has the replays of all lanes of a race, it is played again
+	with PlayRaceReplay. A lane is null when its bot was purged.
+*/
+type RaceReplay struct {
+	Version  int       `json:"version"`
+	MazeHash string    `json:"maze_hash"`
+	Maze     []string  `json:"maze"`
+	Seed     int64     `json:"seed"`
+	Sight    bool      `json:"sight"`
+	Lanes    []*Replay `json:"lanes"`
+}
+
+/*
+	Play a race again in maze m from the replays of its lanes, the race is rejected
+	like in PlayReplay when the maze differs or when a lane does not end the same way.
+	Null lanes are skipped, racers keep the lane of their replay.
+*/
+func PlayRaceReplay(m *maze.Maze, r *RaceReplay) (*RaceResult, error) {
+	if r.Version != ReplayVersion {
+		return nil, fmt.Errorf("%w %d", ErrReplayVersion, r.Version)
+	}
+	if m.Hash() != r.MazeHash {
+		return nil, ErrReplayMaze
+	}
+	bots := []Bot{}
+	replayBots := []*ReplayBot{}
+	lanes := []int{}
+	opts := RaceOptions{Seed: r.Seed}
+	for i, lane := range r.Lanes {
+		if lane == nil {
+			continue
+		}
+		if lane.Version != ReplayVersion {
+			return nil, fmt.Errorf("%w %d in lane %d", ErrReplayVersion, lane.Version, i)
+		}
+		if lane.MazeHash != r.MazeHash {
+			return nil, ErrReplayMaze
+		}
+		replayBots = append(replayBots, NewReplayBot(lane))
+		bots = append(bots, replayBots[len(replayBots)-1])
+		lanes = append(lanes, i)
+		opts.Limits.MaxSteps = lane.MaxSteps
+	}
+
+	race := Race(m, bots, opts)
+	for j, i := range lanes {
+		race.Racers[j].Lane = i
+		if replayBots[j].err != nil {
+			return race, fmt.Errorf("lane %d: %w", i, replayBots[j].err)
+		}
+		lane := r.Lanes[i]
+		result := race.Racers[j].Result
+		if result.State != lane.State || result.Steps != len(lane.Steps) {
+			return race, fmt.Errorf("%w: lane %d %s after %d steps instead of %s after %d steps",
+				ErrReplayDiverged, i, result.State, result.Steps, lane.State, len(lane.Steps))
+		}
+	}
+	return race, nil
+}
+
+/*
+	Race bot codes of players in maze m, lanes are in order of codes, and store
+	the race with the game and replay of each bot at once. A bot failing to load is an error.
+*/
+func PlayRace(db *gorm.DB, codes []*model.BotCode, m *maze.Maze, mazeName string, opts RaceOptions, console io.Writer) (*model.Race, *RaceResult, error) {
+	bots := []Bot{}
+	defer func() {
+		for _, bot := range bots {
+			CloseBot(bot)
+		}
+	}()
+	opts.Consoles = make([]*Console, len(codes))
+	for i, code := range codes {
+		opts.Consoles[i] = NewConsole(console)
+		bot, err := newStoredBot(code, opts.Limits, opts.Consoles[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("bot %v of player %v: %w", code.Bid, code.PlayerId, err)
+		}
+		bots = append(bots, bot)
+	}
+
+	opts.Record = true
+	result := Race(m, bots, opts)
+	games := []*model.Game{}
+	participants := []model.RaceParticipant{}
+	for i, racer := range result.Racers {
+		game, err := newGame(codes[i], mazeName, racer.Result)
+		if err != nil {
+			return nil, result, err
+		}
+		games = append(games, game)
+		participants = append(participants, model.RaceParticipant{
+			Lane:       i,
+			PlayerId:   codes[i].PlayerId,
+			BotId:      codes[i].Bid,
+			BotVersion: codes[i].Version,
+			Rank:       racer.Rank,
+			State:      racer.Result.State,
+			Steps:      racer.Result.Steps,
+		})
+	}
+
+	race, err := model.AddRace(db, &model.Race{
+		MazeName: mazeName,
+		MazeHash: m.Hash(),
+		Sight:    opts.Sight,
+		Seed:     opts.Seed,
+		Ticks:    result.Ticks,
+	}, games, participants)
+	return race, result, err
+}
@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"jc.org/playermgr/maze"
+)
+
+// headers of requests sent to remote bots
+const (
+	SignatureHeader = "X-Bot-Signature"
+	StepHeader      = "X-Bot-Step"
+)
+
+// remote bots need more time than local ones
+var DefaultRemoteStepTimeout = 2 * time.Second
+
+// number of retries of a failed step request
+var DefaultRemoteRetries = 2
+
+// maximum size of the answer of a remote bot
+var MaxRemoteResponseSize = 4096
+
+/*
+	Remote bots on loopback, private or link-local addresses are refused,
+	players must not reach services of the server network. Allow them to run bots locally.
+*/
+var AllowPrivateRemotes = false
+
+var (
+	ErrBadResponse = errors.New("bad response from remote bot")
+	ErrUnreachable = errors.New("remote bot is unreachable")
+	errNotPublic   = errors.New("address is not public")
+)
+
+// networks not reachable by remote bots, net.IP.IsPrivate does not exist in go 1.16
+var nonPublicNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// dialer control refusing non public addresses, called after host name is resolved
+func publicOnly(network string, address string, _ syscall.RawConn) error {
+	if AllowPrivateRemotes {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errNotPublic
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range nonPublicNetworks {
+		if n.Contains(ip) {
+			return errNotPublic
+		}
+	}
+	return nil
+}
+
+/*
+	Client of remote bots: no proxy, no redirect and only public addresses,
+	so a bot URL cannot be used to reach the server network.
+*/
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: publicOnly}).DialContext,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// error of a request that must not be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+/*
+	RemoteBot is called over HTTP.
+
+	Each step the room is POSTed as JSON, {"left": "wall", "right": "door", "up": "entry", "down": "exit"},
+	and the bot answers an action {"action": "move", "direction": "up"}.
+	Body is signed with header X-Bot-Signature: sha256=<hex HMAC-SHA256 of body with bot secret>,
+	X-Bot-Step gives the step number so retried requests can be recognized.
+	In a race with sight the room also has the bots seen, {"bots": [{"bot": 1, "direction": "up"}]}.
+*/
+type RemoteBot struct {
+	URL     string
+	Secret  string
+	Retries int
+	Client  *http.Client
+	limits  Limits
+	step    int
+	usage   Usage
+}
+
+/*
+	Create bot called at url, limits.StepTimeout bounds each step including retries
+*/
+func NewRemoteBot(botURL string, secret string, limits Limits) (*RemoteBot, error) {
+	u, err := url.Parse(botURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("bot URL must be http or https: %v", botURL)
+	}
+
+	if limits.StepTimeout == 0 {
+		limits.StepTimeout = DefaultRemoteStepTimeout
+	}
+	return &RemoteBot{
+		URL:     botURL,
+		Secret:  secret,
+		Retries: DefaultRemoteRetries,
+		Client:  remoteClient,
+		limits:  withDefaults(limits),
+	}, nil
+}
+
+func (b *RemoteBot) Step(room maze.Room) (Action, error) {
+	return b.send(room)
+}
+
+// StepSighted posts the room with the bots seen from it, see RaceRoom
+func (b *RemoteBot) StepSighted(room maze.Room, bots []Sighting) (Action, error) {
+	if bots == nil {
+		bots = []Sighting{}
+	}
+	return b.send(RaceRoom{Room: room, Bots: bots})
+}
+
+// post room to bot URL, retrying transient errors within the step budget
+func (b *RemoteBot) send(room interface{}) (Action, error) {
+	body, err := json.Marshal(room)
+	if err != nil {
+		return Action{}, err
+	}
+	b.step++
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), b.limits.StepTimeout)
+	defer cancel()
+
+	var action Action
+	for attempt := 0; attempt <= b.Retries; attempt++ {
+		if attempt > 0 {
+			// short backoff, step budget is small
+			select {
+			case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+			case <-ctx.Done():
+			}
+		}
+		action, err = b.post(ctx, body)
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) || ctx.Err() != nil {
+			break
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = ErrStepTimeout
+	}
+
+	d := time.Since(start)
+	b.usage.StepsDuration += d
+	if d > b.usage.MaxStepDuration {
+		b.usage.MaxStepDuration = d
+	}
+	return action, err
+}
+
+func (b *RemoteBot) post(ctx context.Context, body []byte) (Action, error) {
+	var action Action
+
+	req, err := http.NewRequestWithContext(ctx, "POST", b.URL, bytes.NewReader(body))
+	if err != nil {
+		return action, &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(StepHeader, strconv.Itoa(b.step))
+	req.Header.Set(SignatureHeader, Sign(b.Secret, body))
+
+	resp, err := b.Client.Do(req)
+	if errors.Is(err, errNotPublic) {
+		return action, &permanentError{ErrUnreachable}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return action, ctx.Err()
+		}
+		return action, ErrUnreachable
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(MaxRemoteResponseSize)+1))
+	if err != nil {
+		if ctx.Err() != nil {
+			return action, ctx.Err()
+		}
+		return action, ErrUnreachable
+	}
+	if len(data) > MaxRemoteResponseSize {
+		return action, &permanentError{fmt.Errorf("%w: larger than %d bytes", ErrBadResponse, MaxRemoteResponseSize)}
+	}
+	if resp.StatusCode >= 500 {
+		return action, fmt.Errorf("%w: status %d", ErrBadResponse, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return action, &permanentError{fmt.Errorf("%w: status %d", ErrBadResponse, resp.StatusCode)}
+	}
+	if err := json.Unmarshal(data, &action); err != nil {
+		return action, &permanentError{fmt.Errorf("%w: %v", ErrBadResponse, err)}
+	}
+	return action, nil
+}
+
+// Usage of resources, only durations are known for remote bots
+func (b *RemoteBot) Usage() Usage {
+	return b.usage
+}
+
+/*
+	Signature of body with secret, value of X-Bot-Signature header
+*/
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
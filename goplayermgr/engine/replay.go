@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"jc.org/playermgr/maze"
+)
+
+// version of replay format written by Run
+const ReplayVersion = 1
+
+var (
+	ErrReplayVersion  = errors.New("unsupported replay version")
+	ErrReplayMaze     = errors.New("replay was recorded in another maze")
+	ErrReplayDiverged = errors.New("replay diverged from recorded game")
+)
+
+/*
+	Replay records a game step by step so it can be played again without the bot,
+	to debug it or animate it. The maze is identified by its hash, the bot by its
+	id and version (revision) when it comes from database. TimedOut is set when
+	the total time budget stopped the game after the last step.
+*/
+type Replay struct {
+	Version    int          `json:"version"`
+	MazeHash   string       `json:"maze_hash"`
+	Maze       []string     `json:"maze"`
+	PlayerId   int32        `json:"player_id,omitempty"`
+	BotId      int32        `json:"bot_id,omitempty"`
+	BotVersion int32        `json:"bot_version,omitempty"`
+	Language   string       `json:"language,omitempty"`
+	Seed       int64        `json:"seed"`
+	MaxSteps   int          `json:"max_steps"`
+	State      string       `json:"state"`
+	Error      string       `json:"error,omitempty"`
+	TimedOut   bool         `json:"timed_out,omitempty"`
+	Steps      []ReplayStep `json:"steps"`
+}
+
+/*
+	ReplayStep is one call of the bot: where it was, the room it saw
+	(sides up, right, down, left, see EncodeRoom), what it answered
+	and how long it took in nanoseconds.
+*/
+type ReplayStep struct {
+	Step     int           `json:"n"`
+	Position maze.Cell     `json:"pos"`
+	Room     string        `json:"room"`
+	Action   Action        `json:"action"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"t"`
+}
+
+func newReplay(m *maze.Maze, maxSteps int, seed int64) *Replay {
+	return &Replay{
+		Version:  ReplayVersion,
+		MazeHash: m.Hash(),
+		Maze:     m.Render(),
+		Seed:     seed,
+		MaxSteps: maxSteps,
+		Steps:    []ReplayStep{},
+	}
+}
+
+func (r *Replay) record(step int, pos maze.Cell, room maze.Room, action Action, err error, d time.Duration) {
+	if r == nil {
+		return
+	}
+	s := ReplayStep{Step: step, Position: pos, Room: EncodeRoom(room), Action: action, Duration: d}
+	if err != nil {
+		s.Action = Action{}
+		s.Error = err.Error()
+	}
+	r.Steps = append(r.Steps, s)
+}
+
+func (r *Replay) timeout() {
+	if r == nil {
+		return
+	}
+	r.TimedOut = true
+}
+
+func (r *Replay) finish(result *Result) {
+	if r == nil {
+		return
+	}
+	r.State = result.State
+	r.Error = result.Error
+}
+
+/*
+	Decode a replay, only replays of the current version can be played
+*/
+func ReadReplay(data []byte) (*Replay, error) {
+	var r Replay
+	err := json.Unmarshal(data, &r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Version != ReplayVersion {
+		return nil, fmt.Errorf("%w %d", ErrReplayVersion, r.Version)
+	}
+	return &r, nil
+}
+
+// Maze of the replay
+func (r *Replay) ParseMaze() (*maze.Maze, error) {
+	return maze.Parse(r.Maze)
+}
+
+/*
+	Play a replay again in maze m, opts.StepLog gets the same log as the
+	recorded game. The result is compared to the recorded one, the replay
+	is rejected when the maze differs or when the game does not end the same way.
+	A game stopped by the total time budget stops after its last recorded step.
+*/
+func PlayReplay(m *maze.Maze, r *Replay, opts Options) (*Result, error) {
+	if r.Version != ReplayVersion {
+		return nil, fmt.Errorf("%w %d", ErrReplayVersion, r.Version)
+	}
+	if m.Hash() != r.MazeHash {
+		return nil, ErrReplayMaze
+	}
+
+	bot := NewReplayBot(r)
+	opts.Limits.MaxSteps = r.MaxSteps
+	opts.Seed = r.Seed
+	result := Run(m, bot, opts)
+	if bot.err != nil {
+		return result, bot.err
+	}
+	if result.State != r.State || result.Steps != len(r.Steps) {
+		return result, fmt.Errorf("%w: %s after %d steps instead of %s after %d steps",
+			ErrReplayDiverged, result.State, result.Steps, r.State, len(r.Steps))
+	}
+	return result, nil
+}
+
+/*
+	Positions of the bot in maze m before each step and after the last one,
+	the last position is outside of the maze when the bot exited
+*/
+func (r *Replay) Path(m *maze.Maze) []maze.Cell {
+	path := make([]maze.Cell, 0, len(r.Steps)+1)
+	for _, s := range r.Steps {
+		path = append(path, s.Position)
+	}
+	if len(r.Steps) == 0 {
+		return path
+	}
+
+	last := r.Steps[len(r.Steps)-1]
+	pos := last.Position
+	if last.Action.Action == ActionMove && (r.State == Success || m.CanMove(pos, last.Action.Direction)) {
+		pos = pos.Next(last.Action.Direction)
+	}
+	return append(path, pos)
+}
+
+// ReplayBot answers the actions of a recorded game
+type ReplayBot struct {
+	replay *Replay
+	next   int
+	err    error
+}
+
+func NewReplayBot(r *Replay) *ReplayBot {
+	return &ReplayBot{replay: r}
+}
+
+/*
+	Check if recorded game was stopped by the total time budget after steps,
+	replays recorded before TimedOut existed only have the error
+*/
+func (b *ReplayBot) timedOut(steps int) bool {
+	stopped := b.replay.TimedOut || b.replay.Error == ErrTotalTimeout.Error()
+	return stopped && steps >= len(b.replay.Steps)
+}
+
+// Step answers the recorded action, the room must be the recorded one
+func (b *ReplayBot) Step(room maze.Room) (Action, error) {
+	if b.next >= len(b.replay.Steps) {
+		b.err = fmt.Errorf("%w: no step %d", ErrReplayDiverged, b.next+1)
+		return Action{}, b.err
+	}
+	s := b.replay.Steps[b.next]
+	b.next++
+	if EncodeRoom(room) != s.Room {
+		b.err = fmt.Errorf("%w: step %d room is %s instead of %s", ErrReplayDiverged, s.Step, EncodeRoom(room), s.Room)
+		return Action{}, b.err
+	}
+	if s.Error != "" {
+		return Action{}, errors.New(s.Error)
+	}
+	return s.Action, nil
+}
+
+/*
+	Encode room sides in order up, right, down, left, one letter each:
+	'w' wall, 'd' door, 'e' entry, 'x' exit
+*/
+func EncodeRoom(room maze.Room) string {
+	var b strings.Builder
+	for _, d := range maze.Directions {
+		switch room.Side(d) {
+		case maze.Door:
+			b.WriteByte('d')
+		case maze.Entry:
+			b.WriteByte('e')
+		case maze.Exit:
+			b.WriteByte('x')
+		default:
+			b.WriteByte('w')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,444 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+	"github.com/dop251/goja/unistring"
+)
+
+var (
+	ErrOperationLimit  = errors.New("bot exceeded operation limit")
+	ErrMemoryLimit     = errors.New("bot exceeded memory limit")
+	ErrStackOverflow   = errors.New("bot exceeded call stack size")
+	ErrForbiddenImport = errors.New("module imports a function not available to bots")
+	ErrSandboxEscape   = errors.New("bot code could escape the operation counter")
+)
+
+/*
+	Globals of Node or browsers giving access to the process, files or network.
+	They do not exist in the interpreter, they are reported by validation
+	and removed if ever defined.
+*/
+var ForbiddenGlobals = []string{
+	"require", "module", "exports", "process", "global", "Buffer",
+	"fetch", "XMLHttpRequest", "WebSocket", "importScripts",
+	"setTimeout", "setInterval", "setImmediate",
+}
+
+// name of the function counting operations, called at each loop iteration and function call
+const tickFunction = "__playermgr_tick"
+
+// expressions whose prototype holds a function constructor, unsupported ones fail to compile and are skipped
+var functionKinds = []string{"(function(){})", "(async function(){})", "(function*(){})"}
+
+// memory is checked every memoryCheckPeriod operations
+const memoryCheckPeriod = 1024
+
+var heapMetrics = []string{"/gc/heap/allocs:bytes", "/gc/heap/live:bytes", "/gc/cycles/total:gc-cycles"}
+
+// heap of whole process, other goroutines included
+type heapStats struct {
+	// bytes allocated since process start, garbage included
+	allocated uint64
+	// bytes held by live objects at end of last garbage collection
+	live uint64
+	// completed garbage collections
+	cycles uint64
+}
+
+// Usage of resources by a bot during a game
+type Usage struct {
+	// loop iterations and function calls
+	Operations int64 `json:"operations"`
+	// bytes allocated by the whole process while bot runs, not only by the bot
+	AllocatedBytes uint64 `json:"allocated_bytes"`
+	// time to load code
+	LoadDuration time.Duration `json:"load_duration"`
+	// time spent in bot steps
+	StepsDuration time.Duration `json:"steps_duration"`
+	// longest step
+	MaxStepDuration time.Duration `json:"max_step_duration"`
+}
+
+// Accountable bots report their resource usage
+type Accountable interface {
+	Usage() Usage
+}
+
+// Seedable bots have deterministic random numbers
+type Seedable interface {
+	Seed(seed int64)
+}
+
+// resource accounting and limits of a JavaScript bot
+type sandbox struct {
+	vm            *goja.Runtime
+	usage         Usage
+	maxOperations int64
+	maxMemory     uint64
+	// operations and heap at start of current run
+	operations int64
+	heapStart  heapStats
+	sample     []metrics.Sample
+}
+
+func newSandbox(vm *goja.Runtime, limits Limits) *sandbox {
+	s := &sandbox{
+		vm:            vm,
+		maxOperations: limits.MaxOperations,
+		maxMemory:     limits.MaxMemory,
+		sample:        make([]metrics.Sample, len(heapMetrics)),
+	}
+	for i, name := range heapMetrics {
+		s.sample[i].Name = name
+	}
+
+	global := vm.GlobalObject()
+	for _, name := range ForbiddenGlobals {
+		global.Delete(name)
+	}
+	global.DefineDataProperty(tickFunction, vm.ToValue(s.tick), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+
+	// code compiled at run time is not instrumented, function constructors are replaced
+	// by one instrumenting the function and eval is removed
+	constructor := vm.ToValue(s.newFunction).(*goja.Object)
+	for _, kind := range functionKinds {
+		f, err := vm.RunString(kind)
+		if err != nil {
+			continue
+		}
+		f.ToObject(vm).Prototype().DefineDataProperty("constructor", constructor, goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	}
+	constructor.DefineDataProperty("prototype", global.Get("Function").ToObject(vm).Get("prototype"), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	global.DefineDataProperty("Function", constructor, goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	global.Delete("eval")
+
+	vm.SetMaxCallStackSize(limits.MaxCallStackSize)
+	s.seed(0)
+
+	return s
+}
+
+func (s *sandbox) seed(seed int64) {
+	s.vm.SetRandSource(rand.New(rand.NewSource(seed)).Float64)
+}
+
+// start accounting of a run
+func (s *sandbox) start() {
+	s.operations = 0
+	s.heapStart = s.heap()
+}
+
+// stop accounting of a run
+func (s *sandbox) stop() {
+	s.usage.AllocatedBytes += s.heap().allocated - s.heapStart.allocated
+	s.usage.Operations += s.operations
+}
+
+func (s *sandbox) stepDone(duration time.Duration) {
+	s.usage.StepsDuration += duration
+	if duration > s.usage.MaxStepDuration {
+		s.usage.MaxStepDuration = duration
+	}
+}
+
+/*
+	Run f and interrupt the interpreter when it lasts more than timeout
+	or exceeds operation or memory limits
+*/
+func (s *sandbox) run(timeout time.Duration, f func() error) error {
+	s.start()
+	timer := time.AfterFunc(timeout, func() {
+		s.vm.Interrupt(ErrStepTimeout)
+	})
+	err := f()
+	timer.Stop()
+	s.vm.ClearInterrupt()
+	s.stop()
+
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		if e, ok := interrupted.Value().(error); ok {
+			return e
+		}
+	}
+	var overflow *goja.StackOverflowError
+	if errors.As(err, &overflow) {
+		return ErrStackOverflow
+	}
+	return err
+}
+
+// native signature so calls are not wrapped by reflection, which allocates
+func (s *sandbox) tick(goja.FunctionCall) goja.Value {
+	s.operations++
+	if s.maxOperations > 0 && s.operations > s.maxOperations {
+		s.vm.Interrupt(ErrOperationLimit)
+		return goja.Undefined()
+	}
+	if s.maxMemory > 0 && s.operations%memoryCheckPeriod == 0 {
+		if s.heap().exceeds(s.heapStart, s.maxMemory) {
+			s.vm.Interrupt(ErrMemoryLimit)
+		}
+	}
+	return goja.Undefined()
+}
+
+/*
+	Function constructor of bots, like Function(arg1, ..., body) but the function
+	is instrumented so its operations are counted.
+*/
+func (s *sandbox) newFunction(call goja.FunctionCall) goja.Value {
+	params := []string{}
+	body := ""
+	for i, arg := range call.Arguments {
+		if i == len(call.Arguments)-1 {
+			body = arg.String()
+		} else {
+			params = append(params, arg.String())
+		}
+	}
+	code := fmt.Sprintf("(function anonymous(%s\n) {\n%s\n})", strings.Join(params, ","), body)
+
+	program, err := parser.ParseFile(nil, "anonymous", code, 0)
+	if err != nil {
+		panic(s.vm.NewGoError(err))
+	}
+	// parameters or body closing the function early could add statements
+	if !isFunctionExpression(program) {
+		panic(s.vm.NewTypeError("invalid function parameters or body"))
+	}
+	if escapes := sandboxEscapes(program); len(escapes) > 0 {
+		panic(s.vm.NewGoError(fmt.Errorf("%w: %s", ErrSandboxEscape, strings.Join(escapes, ", "))))
+	}
+	instrument(program)
+
+	compiled, err := goja.CompileAST(program, false)
+	if err != nil {
+		panic(s.vm.NewGoError(err))
+	}
+	f, err := s.vm.RunProgram(compiled)
+	if err != nil {
+		panic(s.vm.NewGoError(err))
+	}
+	return f
+}
+
+// program is a single function expression
+func isFunctionExpression(program *ast.Program) bool {
+	if len(program.Body) != 1 {
+		return false
+	}
+	stmt, ok := program.Body[0].(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+	_, ok = stmt.Expression.(*ast.FunctionLiteral)
+	return ok
+}
+
+func (s *sandbox) heap() heapStats {
+	metrics.Read(s.sample)
+	value := func(i int) uint64 {
+		if s.sample[i].Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+		return s.sample[i].Value.Uint64()
+	}
+	return heapStats{allocated: value(0), live: value(1), cycles: value(2)}
+}
+
+/*
+	Check if live heap grew by more than max bytes since start.
+	Garbage is not counted. The live heap is only known at end of a garbage collection,
+	so it is checked once a whole collection ran since start: objects released before
+	start are then no longer counted.
+*/
+func (h heapStats) exceeds(start heapStats, max uint64) bool {
+	return h.cycles > start.cycles+1 && h.live > start.live && h.live-start.live > max
+}
+
+/*
+	Add a call to tick function at start of each loop iteration and each function body,
+	so operations can be counted and limited.
+*/
+func instrument(program *ast.Program) {
+	walk(reflect.ValueOf(program), func(node interface{}) bool {
+		instrumentNode(node)
+		return true
+	})
+}
+
+var astPackage = reflect.TypeOf(ast.Program{}).PkgPath()
+
+// walk AST nodes, children of a node are visited when visit returns true
+func walk(v reflect.Value, visit func(node interface{}) bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			walk(v.Elem(), visit)
+		}
+	case reflect.Ptr:
+		if v.IsNil() || v.Elem().Kind() != reflect.Struct || v.Elem().Type().PkgPath() != astPackage {
+			return
+		}
+		if visit(v.Interface()) {
+			walk(v.Elem(), visit)
+		}
+	case reflect.Struct:
+		if v.Type().PkgPath() != astPackage {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			walk(v.Field(i), visit)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walk(v.Index(i), visit)
+		}
+	}
+}
+
+func instrumentNode(node interface{}) {
+	switch n := node.(type) {
+	case *ast.ForStatement:
+		n.Body = withTick(n.Body)
+	case *ast.ForInStatement:
+		n.Body = withTick(n.Body)
+	case *ast.ForOfStatement:
+		n.Body = withTick(n.Body)
+	case *ast.WhileStatement:
+		n.Body = withTick(n.Body)
+	case *ast.DoWhileStatement:
+		n.Body = withTick(n.Body)
+	case *ast.FunctionLiteral:
+		prependTick(n.Body)
+	case *ast.ArrowFunctionLiteral:
+		if body, ok := n.Body.(*ast.BlockStatement); ok {
+			prependTick(body)
+		}
+	}
+}
+
+func tickStatement(idx ast.Node) ast.Statement {
+	return &ast.ExpressionStatement{
+		Expression: &ast.CallExpression{
+			Callee:           &ast.Identifier{Name: unistring.String(tickFunction), Idx: idx.Idx0()},
+			LeftParenthesis:  idx.Idx0(),
+			RightParenthesis: idx.Idx0(),
+		},
+	}
+}
+
+func withTick(body ast.Statement) ast.Statement {
+	if block, ok := body.(*ast.BlockStatement); ok {
+		prependTick(block)
+		return block
+	}
+	return &ast.BlockStatement{
+		LeftBrace:  body.Idx0(),
+		List:       []ast.Statement{tickStatement(body), body},
+		RightBrace: body.Idx1(),
+	}
+}
+
+// insert tick after directives like "use strict"
+func prependTick(block *ast.BlockStatement) {
+	i := 0
+	for ; i < len(block.List); i++ {
+		stmt, ok := block.List[i].(*ast.ExpressionStatement)
+		if !ok {
+			break
+		}
+		if _, ok := stmt.Expression.(*ast.StringLiteral); !ok {
+			break
+		}
+	}
+	list := make([]ast.Statement, 0, len(block.List)+1)
+	list = append(list, block.List[:i]...)
+	list = append(list, tickStatement(block))
+	block.List = append(list, block.List[i:]...)
+}
+
+/*
+	Names of forbidden globals used by program
+*/
+func forbiddenReferences(program *ast.Program) []string {
+	forbidden := map[string]bool{}
+	for _, name := range ForbiddenGlobals {
+		forbidden[name] = true
+	}
+
+	found := []string{}
+	seen := map[string]bool{}
+	var visit func(node interface{}) bool
+	visit = func(node interface{}) bool {
+		switch n := node.(type) {
+		case *ast.Identifier:
+			name := n.Name.String()
+			if forbidden[name] && !seen[name] {
+				seen[name] = true
+				found = append(found, name)
+			}
+			return false
+		case *ast.DotExpression:
+			// property names are not globals
+			walk(reflect.ValueOf(n.Left), visit)
+			return false
+		}
+		return true
+	}
+	walk(reflect.ValueOf(program), visit)
+	return found
+}
+
+/*
+	Constructs used by program able to shadow the tick function in instrumented code
+	or to compile code at run time, which would remove operation and memory limits:
+	any use of its name, with statements, eval, Function and constructor properties.
+*/
+var escapeNames = map[string]bool{tickFunction: true, "eval": true, "Function": true}
+
+func sandboxEscapes(program *ast.Program) []string {
+	found := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			found = append(found, name)
+		}
+	}
+	walk(reflect.ValueOf(program), func(node interface{}) bool {
+		switch n := node.(type) {
+		case *ast.Identifier:
+			if name := n.Name.String(); escapeNames[name] {
+				add(name)
+			}
+		case *ast.PropertyShort:
+			if name := n.Name.Name.String(); escapeNames[name] {
+				add(name)
+			}
+		case *ast.DotExpression:
+			if n.Identifier.Name == "constructor" {
+				add("constructor")
+			}
+		case *ast.BracketExpression:
+			if member, ok := n.Member.(*ast.StringLiteral); ok && member.Value == "constructor" {
+				add("constructor")
+			}
+		case *ast.WithStatement:
+			add("with")
+		}
+		return true
+	})
+	return found
+}
@@ -0,0 +1,268 @@
+package engine
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+)
+
+// maximum size of bot code in bytes
+var MaxCodeSize = 64 * 1024
+
+// maximum size of WebAssembly module in bytes
+var MaxWasmSize = 1024 * 1024
+
+// diagnostic severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// diagnostic codes
+const (
+	DiagEmpty        = "empty"
+	DiagTooLarge     = "too_large"
+	DiagSyntax       = "syntax"
+	DiagForbidden    = "forbidden"
+	DiagLoad         = "load"
+	DiagEncoding     = "encoding"
+	DiagURL          = "url"
+	DiagSecret       = "secret"
+	DiagUnreachable  = "unreachable"
+	DiagResponse     = "response"
+	DiagLanguage     = "language"
+	DiagNoEntryPoint = "no_entry_point"
+	DiagRuntime      = "runtime"
+	DiagNoExit       = "no_exit"
+)
+
+// Diagnostic is a problem found in bot code
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+// Validation of bot code, code is valid when there is no error diagnostic
+type Validation struct {
+	Valid       bool         `json:"valid"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	SmokeTest   *Result      `json:"smoke_test,omitempty"`
+}
+
+// tiny maze used to check a bot runs
+var smokeMaze = []string{
+	"+-+-+",
+	"x   |",
+	"+-+ +",
+	"|   X",
+	"+-+-+",
+}
+
+/*
+	Check bot code compiles, defines executeStep and plays a tiny maze without error.
+	Not finding the exit of the tiny maze is only a warning.
+*/
+func Validate(code string, filename string, limits Limits) *Validation {
+	v := &Validation{Diagnostics: []Diagnostic{}}
+
+	switch {
+	case len(code) == 0:
+		v.add(SeverityError, DiagEmpty, "bot code is empty")
+	case len(code) > MaxCodeSize:
+		v.add(SeverityError, DiagTooLarge, fmt.Sprintf("bot code is %d bytes, maximum is %d", len(code), MaxCodeSize))
+	}
+	if len(v.Diagnostics) > 0 {
+		return v
+	}
+
+	program, d := checkSyntax(code, filename)
+	if d != nil {
+		v.Diagnostics = append(v.Diagnostics, *d)
+		return v
+	}
+	for _, name := range forbiddenReferences(program) {
+		v.add(SeverityError, DiagForbidden, fmt.Sprintf("%s is not available to bots", name))
+	}
+	for _, name := range sandboxEscapes(program) {
+		v.add(SeverityError, DiagForbidden, fmt.Sprintf("%s is not allowed in bots", name))
+	}
+	if len(v.Diagnostics) > 0 {
+		return v
+	}
+
+	bot, err := NewJSBot(code, filename, limits, nil)
+	if err != nil {
+		if errors.Is(err, ErrNoEntryPoint) {
+			v.add(SeverityError, DiagNoEntryPoint, err.Error())
+		} else {
+			v.add(SeverityError, DiagLoad, err.Error())
+		}
+		return v
+	}
+
+	v.smokeTest(bot, limits)
+	return v
+}
+
+/*
+	Validate bot code written in language, WebAssembly code is base64 encoded like in database
+*/
+func ValidateBot(language string, code string, filename string, limits Limits) *Validation {
+	switch language {
+	case model.LanguageJavaScript, "":
+		return Validate(code, filename, limits)
+	case model.LanguageWasm:
+		binary, err := base64.StdEncoding.DecodeString(code)
+		if err != nil {
+			v := &Validation{Diagnostics: []Diagnostic{}}
+			v.add(SeverityError, DiagEncoding, "WebAssembly module must be base64 encoded: "+err.Error())
+			return v
+		}
+		return ValidateWasm(binary, limits)
+	}
+	v := &Validation{Diagnostics: []Diagnostic{}}
+	v.add(SeverityError, DiagLanguage, fmt.Sprintf("unknown bot language %q", language))
+	return v
+}
+
+/*
+	Check WebAssembly module compiles, exports execute_step, only imports
+	functions of the ABI and plays a tiny maze without error.
+*/
+func ValidateWasm(binary []byte, limits Limits) *Validation {
+	v := &Validation{Diagnostics: []Diagnostic{}}
+
+	switch {
+	case len(binary) == 0:
+		v.add(SeverityError, DiagEmpty, "bot module is empty")
+		return v
+	case len(binary) > MaxWasmSize:
+		v.add(SeverityError, DiagTooLarge, fmt.Sprintf("bot module is %d bytes, maximum is %d", len(binary), MaxWasmSize))
+		return v
+	}
+
+	bot, err := NewWasmBot(binary, limits, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoWasmEntryPoint):
+			v.add(SeverityError, DiagNoEntryPoint, err.Error())
+		case errors.Is(err, ErrForbiddenImport):
+			v.add(SeverityError, DiagForbidden, err.Error())
+		default:
+			v.add(SeverityError, DiagLoad, err.Error())
+		}
+		return v
+	}
+	defer bot.Close()
+
+	v.smokeTest(bot, limits)
+	return v
+}
+
+// minimum length of remote bot secrets
+var MinSecretLength = 16
+
+/*
+	Conformance check of a remote bot: it must answer a signed sample room
+	with a valid action and play a tiny maze without error.
+*/
+func ValidateRemote(botURL string, secret string, limits Limits) *Validation {
+	v := &Validation{Diagnostics: []Diagnostic{}}
+
+	if len(secret) < MinSecretLength {
+		v.add(SeverityError, DiagSecret, fmt.Sprintf("secret must have at least %d characters", MinSecretLength))
+		return v
+	}
+	bot, err := NewRemoteBot(botURL, secret, limits)
+	if err != nil {
+		v.add(SeverityError, DiagURL, err.Error())
+		return v
+	}
+
+	room := maze.Room{Left: maze.Entry, Right: maze.Door, Up: maze.Wall, Down: maze.Wall}
+	action, err := bot.Step(room)
+	switch {
+	case errors.Is(err, ErrBadResponse):
+		v.add(SeverityError, DiagResponse, err.Error())
+	case err != nil:
+		v.add(SeverityError, DiagUnreachable, ErrUnreachable.Error())
+	case action.Action == ActionMove && !validDirection(action.Direction):
+		v.add(SeverityError, DiagResponse, fmt.Sprintf("unknown direction %q", action.Direction))
+	}
+	if len(v.Diagnostics) > 0 {
+		return v
+	}
+
+	v.smokeTest(bot, limits)
+	return v
+}
+
+func validDirection(d maze.Direction) bool {
+	for _, direction := range maze.Directions {
+		if d == direction {
+			return true
+		}
+	}
+	return false
+}
+
+// play tiny maze, bot is valid when it has no error
+func (v *Validation) smokeTest(bot Bot, limits Limits) {
+	m, _ := maze.Parse(smokeMaze)
+	v.SmokeTest = Run(m, bot, Options{Limits: limits})
+	if v.SmokeTest.Error != "" {
+		v.add(SeverityError, DiagRuntime, v.SmokeTest.Error)
+	} else if v.SmokeTest.State != Success {
+		v.add(SeverityWarning, DiagNoExit, fmt.Sprintf("bot did not find the exit of a %dx%d maze", m.Rows, m.Columns))
+	}
+
+	v.Valid = true
+	for _, d := range v.Diagnostics {
+		if d.Severity == SeverityError {
+			v.Valid = false
+		}
+	}
+}
+
+func (v *Validation) add(severity string, code string, message string) {
+	v.Diagnostics = append(v.Diagnostics, Diagnostic{Severity: severity, Code: code, Message: message})
+}
+
+/*
+	Parse and compile code, only first parser error is reported
+	as following ones are often consequences of the first.
+*/
+func checkSyntax(code string, filename string) (*ast.Program, *Diagnostic) {
+	program, err := parser.ParseFile(nil, filename, code, 0)
+	if err != nil {
+		d := &Diagnostic{Severity: SeverityError, Code: DiagSyntax, Message: err.Error()}
+		var list parser.ErrorList
+		if errors.As(err, &list) && len(list) > 0 {
+			d.Message = list[0].Message
+			d.Line, d.Column = list[0].Position.Line, list[0].Position.Column
+		}
+		return nil, d
+	}
+
+	_, err = goja.Compile(filename, code, false)
+	if err != nil {
+		d := &Diagnostic{Severity: SeverityError, Code: DiagSyntax, Message: err.Error()}
+		var syntax *goja.CompilerSyntaxError
+		if errors.As(err, &syntax) && syntax.File != nil {
+			pos := syntax.File.Position(syntax.Offset)
+			d.Message = syntax.Message
+			d.Line, d.Column = pos.Line, pos.Column
+		}
+		return nil, d
+	}
+	return program, nil
+}
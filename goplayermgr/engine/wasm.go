@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"jc.org/playermgr/maze"
+)
+
+/*
+	WebAssembly bot ABI
+
+	The module exports execute_step(room i32) -> i32.
+	room packs the 4 sides on 2 bits each: bits 0-1 up, 2-3 right, 4-5 down, 6-7 left,
+	a side is 0 wall, 1 door, 2 entry or 3 exit.
+	The result is 0 to stay, 1 to move up, 2 right, 3 down and 4 left.
+
+	The module may export its memory as "memory" and init() called once after instantiation, like _initialize
+	of WASI reactors. It may import from module "env":
+		log(ptr i32, len i32)  writes UTF-8 text from its exported memory to the console
+		random() -> f64        random number in [0, 1), seeded per game
+*/
+const (
+	WasmEntryPoint = "execute_step"
+	WasmInit       = "init"
+	WasmMemory     = "memory"
+	WasmImports    = "env"
+)
+
+var ErrNoWasmEntryPoint = errors.New("module must export function " + WasmEntryPoint + "(i32) -> i32")
+
+var wasmSides = map[maze.WallType]uint32{maze.Wall: 0, maze.Door: 1, maze.Entry: 2, maze.Exit: 3}
+
+var wasmDirections = []maze.Direction{maze.Up, maze.Right, maze.Down, maze.Left}
+
+// WasmBot runs a WebAssembly bot
+type WasmBot struct {
+	runtime wazero.Runtime
+	module  api.Module
+	step    api.Function
+	limits  Limits
+	random  *rand.Rand
+	usage   Usage
+}
+
+/*
+	Load WebAssembly bot module, log output of bot is written to console, it may be nil.
+*/
+func NewWasmBot(binary []byte, limits Limits, console io.Writer) (*WasmBot, error) {
+	limits = withDefaults(limits)
+	bot := &WasmBot{limits: limits, random: rand.New(rand.NewSource(0))}
+
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(limits.MaxMemory / 65536))
+	bot.runtime = wazero.NewRuntimeWithConfig(ctx, config)
+
+	compiled, err := bot.runtime.CompileModule(ctx, binary)
+	if err != nil {
+		bot.Close()
+		return nil, fmt.Errorf("cannot compile module: %w", err)
+	}
+	if err := checkWasmModule(compiled); err != nil {
+		bot.Close()
+		return nil, err
+	}
+
+	_, err = bot.runtime.NewHostModuleBuilder(WasmImports).
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr uint32, size uint32) {
+		memory := m.ExportedMemory(WasmMemory)
+		if console == nil || memory == nil {
+			return
+		}
+		if text, ok := memory.Read(ptr, size); ok {
+			fmt.Fprintln(console, string(text))
+		}
+	}).Export("log").
+		NewFunctionBuilder().WithFunc(func() float64 {
+		return bot.random.Float64()
+	}).Export("random").
+		Instantiate(ctx)
+	if err != nil {
+		bot.Close()
+		return nil, err
+	}
+
+	start := time.Now()
+	err = bot.call(limits.LoadTimeout, func(ctx context.Context) error {
+		bot.module, err = bot.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithStartFunctions())
+		if err != nil {
+			return err
+		}
+		if init := bot.module.ExportedFunction(WasmInit); init != nil {
+			_, err = init.Call(ctx)
+		}
+		return err
+	})
+	bot.usage.LoadDuration = time.Since(start)
+	if err != nil {
+		bot.Close()
+		return nil, fmt.Errorf("cannot load module: %w", err)
+	}
+
+	bot.step = bot.module.ExportedFunction(WasmEntryPoint)
+	return bot, nil
+}
+
+// check entry point and imports of module
+func checkWasmModule(compiled wazero.CompiledModule) error {
+	step, ok := compiled.ExportedFunctions()[WasmEntryPoint]
+	if !ok || len(step.ParamTypes()) != 1 || step.ParamTypes()[0] != api.ValueTypeI32 ||
+		len(step.ResultTypes()) != 1 || step.ResultTypes()[0] != api.ValueTypeI32 {
+		return ErrNoWasmEntryPoint
+	}
+	for _, f := range compiled.ImportedFunctions() {
+		module, name, _ := f.Import()
+		if module != WasmImports || (name != "log" && name != "random") {
+			return fmt.Errorf("%w: %s.%s", ErrForbiddenImport, module, name)
+		}
+	}
+	return nil
+}
+
+func (b *WasmBot) Step(room maze.Room) (Action, error) {
+	var action Action
+
+	start := time.Now()
+	err := b.call(b.limits.StepTimeout, func(ctx context.Context) error {
+		res, err := b.step.Call(ctx, uint64(encodeRoom(room)))
+		if err != nil {
+			return err
+		}
+		action = decodeAction(uint32(res[0]))
+		return nil
+	})
+	b.usage.StepsDuration += time.Since(start)
+	if d := time.Since(start); d > b.usage.MaxStepDuration {
+		b.usage.MaxStepDuration = d
+	}
+	if memory := b.module.ExportedMemory(WasmMemory); memory != nil {
+		b.usage.AllocatedBytes = uint64(memory.Size())
+	}
+
+	return action, err
+}
+
+// call f with a context cancelled after timeout, which closes the module
+func (b *WasmBot) call(timeout time.Duration, f func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := f(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return ErrStepTimeout
+	}
+	return err
+}
+
+// Seed random numbers given by env.random
+func (b *WasmBot) Seed(seed int64) {
+	b.random.Seed(seed)
+}
+
+// Usage of resources since bot was loaded, allocated bytes is the size of bot memory
+func (b *WasmBot) Usage() Usage {
+	return b.usage
+}
+
+// Close releases the module
+func (b *WasmBot) Close() error {
+	return b.runtime.Close(context.Background())
+}
+
+func encodeRoom(room maze.Room) uint32 {
+	var encoded uint32
+	for i, d := range wasmDirections {
+		encoded |= wasmSides[room.Side(d)] << (2 * i)
+	}
+	return encoded
+}
+
+func decodeAction(res uint32) Action {
+	if res == 0 || int(res) > len(wasmDirections) {
+		return Action{}
+	}
+	return Action{Action: ActionMove, Direction: wasmDirections[res-1]}
+}
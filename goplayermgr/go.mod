@@ -3,14 +3,16 @@ module jc.org/playermgr
 go 1.16
 
 require (
+	github.com/dop251/goja v0.0.0-20211022113120-dc8c55024d06
 	github.com/gin-gonic/gin v1.9.1
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/mitchellh/go-homedir v1.1.0
-	github.com/prometheus/client_golang v1.11.1 // indirect
+	github.com/prometheus/client_golang v1.11.1
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/viper v1.9.0
 	github.com/stretchr/testify v1.8.3
+	github.com/tetratelabs/wazero v1.5.0
 	github.com/zsais/go-gin-prometheus v0.1.0
 	gorm.io/driver/postgres v1.2.1
 	gorm.io/driver/sqlite v1.2.3
@@ -0,0 +1,253 @@
+/*
+	Package jobs runs long operations in the background with a durable queue
+	stored in the player database.
+
+	A job is queued with Enqueue, a worker of a Pool leases it and runs the
+	handler of its kind while extending the lease with heartbeats. A failed job
+	is queued again after a backoff delay until it has no attempt left, it is
+	then dead. A job whose worker stopped is leased again when its lease expires.
+*/
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"jc.org/playermgr/model"
+)
+
+// attempts of a job before it is dead
+var MaxAttempts = 3
+
+// a worker holds a job for this duration, extended every third of it
+var LeaseDuration = time.Minute
+
+// delay between checks of an empty queue
+var PollInterval = time.Second
+
+// delay before the first retry, doubled at each attempt up to RetryMax
+var RetryBase = 10 * time.Second
+
+var RetryMax = 10 * time.Minute
+
+// Progress reports how much of a job is done
+type Progress func(done int, total int)
+
+/*
+	Handler does a job, its result is stored as JSON.
+	ctx is canceled when the worker lost the lease of the job.
+*/
+type Handler func(ctx context.Context, job *model.Job, progress Progress) (interface{}, error)
+
+// permanent errors are not retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error that retrying the job cannot fix
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+/*
+	Queue a job of kind for bot of player, payload is stored as JSON
+*/
+func Enqueue(db *gorm.DB, kind string, pid int32, bid int32, payload interface{}) (*model.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := model.AddJob(db, &model.Job{Kind: kind, PlayerId: pid, BotId: bid, Payload: string(data), MaxAttempts: MaxAttempts})
+	if job == nil {
+		return nil, fmt.Errorf("cannot queue %v job", kind)
+	}
+	return job, nil
+}
+
+// delay before retrying a job failed at attempt
+func Backoff(attempt int) time.Duration {
+	delay := RetryBase
+	for i := 1; i < attempt && delay < RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > RetryMax {
+		delay = RetryMax
+	}
+	return delay
+}
+
+/*
+	Pool is a set of workers running jobs of the kinds it handles
+*/
+type Pool struct {
+	DB      *gorm.DB
+	Workers int
+	// identifies the workers of the pool in leases
+	Owner    string
+	handlers map[string]Handler
+}
+
+func NewPool(db *gorm.DB, workers int) *Pool {
+	host, _ := os.Hostname()
+	return &Pool{
+		DB:       db,
+		Workers:  workers,
+		Owner:    fmt.Sprintf("%s-%d-%08x", host, os.Getpid(), rand.Uint32()),
+		handlers: map[string]Handler{},
+	}
+}
+
+// register handler of jobs of kind
+func (p *Pool) Handle(kind string, handler Handler) {
+	p.handlers[kind] = handler
+}
+
+func (p *Pool) kinds() []string {
+	kinds := []string{}
+	for kind := range p.handlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+/*
+	Run jobs until ctx is canceled, running jobs are finished before returning
+*/
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if p.runNext(context.Background()) {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(PollInterval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+/*
+	Run jobs ready to run one after the other until none is left, returns the number of jobs run
+*/
+func (p *Pool) RunPending(ctx context.Context) int {
+	n := 0
+	for ctx.Err() == nil && p.runNext(ctx) {
+		n++
+	}
+	return n
+}
+
+// lease and run next job, false when none is ready
+func (p *Pool) runNext(ctx context.Context) bool {
+	job := model.LeaseJob(p.DB, p.Owner, p.kinds(), LeaseDuration, time.Now())
+	if job == nil {
+		return false
+	}
+	p.execute(ctx, job)
+	return true
+}
+
+func (p *Pool) execute(ctx context.Context, job *model.Job) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	done, total := job.Done, job.Total
+	progress := func(d int, t int) {
+		mu.Lock()
+		done, total = d, t
+		mu.Unlock()
+	}
+
+	// heartbeats extend the lease and save progress
+	stop := make(chan struct{})
+	var heartbeats sync.WaitGroup
+	heartbeats.Add(1)
+	go func() {
+		defer heartbeats.Done()
+		ticker := time.NewTicker(LeaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				mu.Lock()
+				d, t := done, total
+				mu.Unlock()
+				err := model.HeartbeatJob(p.DB, job.Jid, p.Owner, time.Now().Add(LeaseDuration), d, t)
+				if errors.Is(err, model.ErrLeaseLost) {
+					log.Printf("Job %v: %v\n", job.Jid, err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	result, err := p.call(ctx, job, progress)
+	close(stop)
+	heartbeats.Wait()
+
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(result)
+		if err == nil {
+			mu.Lock()
+			err = model.CompleteJob(p.DB, job.Jid, p.Owner, string(data), done, total)
+			mu.Unlock()
+			if err != nil {
+				log.Printf("Job %v: cannot complete: %v\n", job.Jid, err)
+			}
+			return
+		}
+	}
+
+	var retryAt time.Time
+	var permanent *permanentError
+	if !errors.As(err, &permanent) {
+		retryAt = time.Now().Add(Backoff(job.Attempts))
+	}
+	log.Printf("Job %v %v attempt %v failed: %v\n", job.Jid, job.Kind, job.Attempts, err)
+	err = model.FailJob(p.DB, job, p.Owner, err.Error(), retryAt)
+	if err != nil {
+		log.Printf("Job %v: cannot record failure: %v\n", job.Jid, err)
+	}
+}
+
+// run handler of job, a panic fails the job
+func (p *Pool) call(ctx context.Context, job *model.Job, progress Progress) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	handler := p.handlers[job.Kind]
+	if handler == nil {
+		return nil, Permanent(fmt.Errorf("unknown job kind %q", job.Kind))
+	}
+	return handler(ctx, job, progress)
+}
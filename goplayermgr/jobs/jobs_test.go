@@ -0,0 +1,88 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"jc.org/playermgr/jobs"
+	"jc.org/playermgr/model"
+)
+
+var InMemoryDSN = "file::memory:"
+
+func TestBackoff(t *testing.T) {
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second}
+	for i, d := range expected {
+		if jobs.Backoff(i+1) != d {
+			t.Errorf("Unexpected backoff %v of attempt %v", jobs.Backoff(i+1), i+1)
+		}
+	}
+	if jobs.Backoff(30) != jobs.RetryMax {
+		t.Errorf("Backoff should be bounded: %v", jobs.Backoff(30))
+	}
+}
+
+func TestPool(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	pool := jobs.NewPool(db, 1)
+	pool.Handle("sum", func(ctx context.Context, job *model.Job, progress jobs.Progress) (interface{}, error) {
+		progress(1, 2)
+		progress(2, 2)
+		return map[string]string{"payload": job.Payload}, nil
+	})
+	pool.Handle("flaky", func(ctx context.Context, job *model.Job, progress jobs.Progress) (interface{}, error) {
+		return nil, errors.New("try again")
+	})
+	pool.Handle("broken", func(ctx context.Context, job *model.Job, progress jobs.Progress) (interface{}, error) {
+		return nil, jobs.Permanent(errors.New("cannot work"))
+	})
+	pool.Handle("panic", func(ctx context.Context, job *model.Job, progress jobs.Progress) (interface{}, error) {
+		panic("boom")
+	})
+
+	sum, _ := jobs.Enqueue(db, "sum", 1, 2, []int{1, 2})
+	flaky, _ := jobs.Enqueue(db, "flaky", 1, 2, nil)
+	broken, _ := jobs.Enqueue(db, "broken", 1, 2, nil)
+	panicking, _ := jobs.Enqueue(db, "panic", 1, 2, nil)
+	other, _ := jobs.Enqueue(db, "unhandled", 1, 2, nil)
+
+	if n := pool.RunPending(context.Background()); n != 4 {
+		t.Errorf("Pool should run the 4 jobs it handles, ran %v", n)
+	}
+
+	job := model.GetJob(db, sum.Jid)
+	if job.State != model.JobSucceeded || job.Result != `{"payload":"[1,2]"}` || job.Done != 2 || job.Total != 2 {
+		t.Errorf("Job should succeed with progress: %+v", job)
+	}
+	job = model.GetJob(db, flaky.Jid)
+	if job.State != model.JobQueued || job.Attempts != 1 || job.Error != "try again" || !job.RunAt.After(time.Now().Add(5*time.Second)) {
+		t.Errorf("Failed job should be retried after backoff: %+v", job)
+	}
+	job = model.GetJob(db, broken.Jid)
+	if job.State != model.JobDead || job.Error != "cannot work" {
+		t.Errorf("Permanent failure should kill job: %+v", job)
+	}
+	job = model.GetJob(db, panicking.Jid)
+	if job.State != model.JobQueued || job.Error != "job panicked: boom" {
+		t.Errorf("Panic should fail job: %+v", job)
+	}
+	if job = model.GetJob(db, other.Jid); job.State != model.JobQueued || job.Attempts != 0 {
+		t.Errorf("Job without handler should wait: %+v", job)
+	}
+
+	// workers stop when context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(stopped)
+	}()
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Pool should stop")
+	}
+}
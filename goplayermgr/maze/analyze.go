@@ -0,0 +1,200 @@
+package maze
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// number of random walks of an analysis
+var RandomWalkTrials = 100
+
+// Walk is the outcome of a strategy in a maze
+type Walk struct {
+	Success bool `json:"success"`
+	Steps   int  `json:"steps"`
+}
+
+// RandomWalks summarizes random walks in a maze
+type RandomWalks struct {
+	Trials      int     `json:"trials"`
+	SuccessRate float64 `json:"success_rate"`
+	// steps of successful walks
+	MeanSteps   float64 `json:"mean_steps"`
+	MedianSteps int     `json:"median_steps"`
+}
+
+// Analysis of maze difficulty
+type Analysis struct {
+	Rows    int `json:"rows"`
+	Columns int `json:"columns"`
+	// minimum number of steps of a bot, 0 when exit cannot be reached
+	ShortestPath int `json:"shortest_path"`
+	// rooms with a single door
+	DeadEnds int `json:"dead_ends"`
+	// rooms with more than 2 doors
+	Junctions int `json:"junctions"`
+	// mean number of new directions offered when entering a reachable room
+	BranchingFactor float64 `json:"branching_factor"`
+	// independent cycles, 0 for a perfect maze
+	Loops int `json:"loops"`
+	// rooms reachable from entry
+	Reachable int `json:"reachable"`
+	// rooms of the solution divided by rooms of the maze
+	SolutionRatio float64 `json:"solution_ratio"`
+	// left hand wall follower, like bot3.js
+	WallFollower Walk        `json:"wall_follower"`
+	RandomWalk   RandomWalks `json:"random_walk"`
+}
+
+/*
+	Compute difficulty metrics of maze, random walks are reproducible from seed.
+	Strategies have rows*cols*4 steps like gamemgr.
+*/
+func (m *Maze) Analyze(seed int64) *Analysis {
+	a := &Analysis{Rows: m.Rows, Columns: m.Columns}
+	rooms := m.Rows * m.Columns
+
+	if solution, err := m.Solve(); err == nil {
+		a.ShortestPath = solution.Steps
+		a.SolutionRatio = float64(len(solution.Path)) / float64(rooms)
+	}
+
+	reachable := m.Distances(m.Entry.Cell)
+	doors, branching := 0, 0
+	for r := 0; r < m.Rows; r++ {
+		for c := 0; c < m.Columns; c++ {
+			n := m.doors(Cell{r, c})
+			doors += n
+			switch {
+			case n == 1:
+				a.DeadEnds++
+			case n > 2:
+				a.Junctions++
+			}
+			if reachable[r][c] >= 0 {
+				a.Reachable++
+				if n > 1 {
+					branching += n - 1
+				}
+			}
+		}
+	}
+	// each door is counted from both rooms
+	doors /= 2
+	if a.Reachable > 0 {
+		a.BranchingFactor = float64(branching) / float64(a.Reachable)
+	}
+
+	components := 0
+	seen := newGrid(m)
+	for r := 0; r < m.Rows; r++ {
+		for c := 0; c < m.Columns; c++ {
+			if seen[r][c] {
+				continue
+			}
+			components++
+			distances := m.Distances(Cell{r, c})
+			for i := range distances {
+				for j := range distances[i] {
+					if distances[i][j] >= 0 {
+						seen[i][j] = true
+					}
+				}
+			}
+		}
+	}
+	a.Loops = doors - rooms + components
+
+	maxSteps := rooms * 4
+	a.WallFollower = m.WallFollower(maxSteps)
+	a.RandomWalk = m.randomWalks(rand.New(rand.NewSource(seed)), RandomWalkTrials, maxSteps)
+
+	return a
+}
+
+/*
+	Left hand wall follower with the rules of bot3.js: move out when the exit is
+	in the room, otherwise take the first door on the left of the entry side.
+*/
+func (m *Maze) WallFollower(maxSteps int) Walk {
+	pos := m.Entry.Cell
+	// bot3 behaves as if it entered from left
+	from := Left
+
+	for steps := 1; steps <= maxSteps; steps++ {
+		room := m.Room(pos)
+		for _, d := range Directions {
+			if room.Side(d) == Exit {
+				return Walk{Success: true, Steps: steps}
+			}
+		}
+
+		for _, d := range leftHandOrder(from) {
+			if room.Side(d) == Door && m.Contains(pos.Next(d)) {
+				pos = pos.Next(d)
+				from = d.Opposite()
+				break
+			}
+		}
+	}
+	return Walk{Steps: maxSteps}
+}
+
+// directions to try when entering a room from side from, like searchOrder of bot3.js
+func leftHandOrder(from Direction) []Direction {
+	i := 0
+	for j, d := range Directions {
+		if d == from {
+			i = j
+		}
+	}
+	// clockwise after entry side, entry side last
+	return []Direction{Directions[(i+1)%4], Directions[(i+2)%4], Directions[(i+3)%4], from}
+}
+
+// random walk choosing uniformly among doors and exit of each room
+func (m *Maze) randomWalk(rnd *rand.Rand, maxSteps int) Walk {
+	pos := m.Entry.Cell
+	for steps := 1; steps <= maxSteps; steps++ {
+		room := m.Room(pos)
+		choices := make([]Direction, 0, 4)
+		for _, d := range Directions {
+			if room.Side(d) == Exit || m.CanMove(pos, d) {
+				choices = append(choices, d)
+			}
+		}
+		if len(choices) == 0 {
+			break
+		}
+		d := choices[rnd.Intn(len(choices))]
+		if room.Side(d) == Exit {
+			return Walk{Success: true, Steps: steps}
+		}
+		pos = pos.Next(d)
+	}
+	return Walk{Steps: maxSteps}
+}
+
+func (m *Maze) randomWalks(rnd *rand.Rand, trials int, maxSteps int) RandomWalks {
+	stats := RandomWalks{Trials: trials}
+	steps := []int{}
+	for i := 0; i < trials; i++ {
+		walk := m.randomWalk(rnd, maxSteps)
+		if walk.Success {
+			steps = append(steps, walk.Steps)
+		}
+	}
+	if len(steps) == 0 {
+		return stats
+	}
+
+	sort.Ints(steps)
+	total := 0
+	for _, s := range steps {
+		total += s
+	}
+	stats.SuccessRate = float64(len(steps)) / float64(trials)
+	stats.MeanSteps = float64(total) / float64(len(steps))
+	stats.MedianSteps = steps[len(steps)/2]
+	return stats
+}
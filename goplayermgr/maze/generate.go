@@ -0,0 +1,380 @@
+package maze
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// generation algorithms
+const (
+	Backtracker = "backtracker"
+	Prim        = "prim"
+	Kruskal     = "kruskal"
+	Eller       = "eller"
+)
+
+var Algorithms = []string{Backtracker, Prim, Kruskal, Eller}
+
+// placements of entry and exit
+const (
+	// entry on left border and exit on right border, on random rows
+	PlaceSides = "sides"
+	// entry on left border, exit on the border room farthest from entry
+	PlaceFarthest = "farthest"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown maze generation algorithm")
+	ErrUnknownPlacement = errors.New("unknown entry and exit placement")
+	ErrGenerateSize     = errors.New("maze must have at least one row and one column")
+)
+
+type GenerateOptions struct {
+	Algorithm string
+	Seed      int64
+	// probability to remove a dead end by opening a wall, 0 gives a perfect maze, 1 no dead end
+	Braid float64
+	// PlaceSides by default
+	Placement string
+}
+
+/*
+	Generate a maze, the same options always give the same maze
+*/
+func Generate(rows int, columns int, opts GenerateOptions) (*Maze, error) {
+	if rows < 1 || columns < 1 {
+		return nil, ErrGenerateSize
+	}
+
+	m := New(rows, columns)
+	rnd := rand.New(rand.NewSource(opts.Seed))
+
+	switch opts.Algorithm {
+	case Backtracker, "":
+		generateBacktracker(m, rnd)
+	case Prim:
+		generatePrim(m, rnd)
+	case Kruskal:
+		generateKruskal(m, rnd)
+	case Eller:
+		generateEller(m, rnd)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownAlgorithm, opts.Algorithm)
+	}
+
+	braid(m, rnd, opts.Braid)
+
+	switch opts.Placement {
+	case PlaceSides, "":
+		m.Open(Cell{rnd.Intn(rows), 0}, Left, Entry)
+		m.Open(Cell{rnd.Intn(rows), columns - 1}, Right, Exit)
+	case PlaceFarthest:
+		entry := Cell{rnd.Intn(rows), 0}
+		m.Open(entry, Left, Entry)
+		placeFarthestExit(m, entry)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownPlacement, opts.Placement)
+	}
+
+	return m, nil
+}
+
+// neighbours of c inside maze, in Directions order
+func (m *Maze) neighbours(c Cell) []Direction {
+	list := make([]Direction, 0, 4)
+	for _, d := range Directions {
+		if m.Contains(c.Next(d)) {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+/*
+	Depth first search carving passages to unvisited neighbours, long corridors
+*/
+func generateBacktracker(m *Maze, rnd *rand.Rand) {
+	visited := newGrid(m)
+	start := Cell{rnd.Intn(m.Rows), rnd.Intn(m.Columns)}
+	stack := []Cell{start}
+	visited[start.Row][start.Column] = true
+
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+
+		candidates := []Direction{}
+		for _, d := range m.neighbours(c) {
+			n := c.Next(d)
+			if !visited[n.Row][n.Column] {
+				candidates = append(candidates, d)
+			}
+		}
+		if len(candidates) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		d := candidates[rnd.Intn(len(candidates))]
+		n := c.Next(d)
+		m.SetWall(c, d, Door)
+		visited[n.Row][n.Column] = true
+		stack = append(stack, n)
+	}
+}
+
+/*
+	Randomized Prim, grows the maze from a random frontier cell, many short dead ends
+*/
+func generatePrim(m *Maze, rnd *rand.Rand) {
+	in := newGrid(m)
+	frontier := []Cell{}
+	inFrontier := newGrid(m)
+
+	add := func(c Cell) {
+		in[c.Row][c.Column] = true
+		for _, d := range m.neighbours(c) {
+			n := c.Next(d)
+			if !in[n.Row][n.Column] && !inFrontier[n.Row][n.Column] {
+				inFrontier[n.Row][n.Column] = true
+				frontier = append(frontier, n)
+			}
+		}
+	}
+
+	add(Cell{rnd.Intn(m.Rows), rnd.Intn(m.Columns)})
+	for len(frontier) > 0 {
+		i := rnd.Intn(len(frontier))
+		c := frontier[i]
+		frontier[i] = frontier[len(frontier)-1]
+		frontier = frontier[:len(frontier)-1]
+
+		// connect to a random neighbour already in maze
+		connections := []Direction{}
+		for _, d := range m.neighbours(c) {
+			n := c.Next(d)
+			if in[n.Row][n.Column] {
+				connections = append(connections, d)
+			}
+		}
+		m.SetWall(c, connections[rnd.Intn(len(connections))], Door)
+		add(c)
+	}
+}
+
+/*
+	Randomized Kruskal, opens walls in random order between unconnected sets
+*/
+func generateKruskal(m *Maze, rnd *rand.Rand) {
+	sets := newDisjointSets(m.Rows * m.Columns)
+
+	type edge struct {
+		c Cell
+		d Direction
+	}
+	edges := []edge{}
+	for r := 0; r < m.Rows; r++ {
+		for c := 0; c < m.Columns; c++ {
+			if r+1 < m.Rows {
+				edges = append(edges, edge{Cell{r, c}, Down})
+			}
+			if c+1 < m.Columns {
+				edges = append(edges, edge{Cell{r, c}, Right})
+			}
+		}
+	}
+	rnd.Shuffle(len(edges), func(i, j int) { edges[i], edges[j] = edges[j], edges[i] })
+
+	for _, e := range edges {
+		n := e.c.Next(e.d)
+		if sets.union(m.index(e.c), m.index(n)) {
+			m.SetWall(e.c, e.d, Door)
+		}
+	}
+}
+
+/*
+	Eller, builds the maze row by row keeping only the sets of the current row
+*/
+func generateEller(m *Maze, rnd *rand.Rand) {
+	sets := newDisjointSets(m.Rows * m.Columns)
+
+	for r := 0; r < m.Rows; r++ {
+		last := r == m.Rows-1
+
+		// join adjacent cells of different sets, always on last row
+		for c := 0; c+1 < m.Columns; c++ {
+			a, b := Cell{r, c}, Cell{r, c + 1}
+			if sets.find(m.index(a)) != sets.find(m.index(b)) && (last || rnd.Intn(2) == 0) {
+				sets.union(m.index(a), m.index(b))
+				m.SetWall(a, Right, Door)
+			}
+		}
+		if last {
+			break
+		}
+
+		// each set goes down at least once
+		members := map[int][]int{}
+		order := []int{}
+		for c := 0; c < m.Columns; c++ {
+			set := sets.find(m.index(Cell{r, c}))
+			if _, ok := members[set]; !ok {
+				order = append(order, set)
+			}
+			members[set] = append(members[set], c)
+		}
+		for _, set := range order {
+			columns := members[set]
+			down := columns[rnd.Intn(len(columns))]
+			for _, c := range columns {
+				if c == down || rnd.Intn(3) == 0 {
+					sets.union(m.index(Cell{r, c}), m.index(Cell{r + 1, c}))
+					m.SetWall(Cell{r, c}, Down, Door)
+				}
+			}
+		}
+	}
+}
+
+/*
+	Remove dead ends with probability factor, adding loops to the maze
+*/
+func braid(m *Maze, rnd *rand.Rand, factor float64) {
+	if factor <= 0 {
+		return
+	}
+	for r := 0; r < m.Rows; r++ {
+		for c := 0; c < m.Columns; c++ {
+			cell := Cell{r, c}
+			walls := []Direction{}
+			for _, d := range m.neighbours(cell) {
+				if m.Room(cell).Side(d) == Wall {
+					walls = append(walls, d)
+				}
+			}
+			if len(m.neighbours(cell))-len(walls) != 1 || len(walls) == 0 || rnd.Float64() >= factor {
+				continue
+			}
+
+			// prefer joining another dead end
+			best := []Direction{}
+			for _, d := range walls {
+				if m.doors(cell.Next(d)) == 1 {
+					best = append(best, d)
+				}
+			}
+			if len(best) == 0 {
+				best = walls
+			}
+			m.SetWall(cell, best[rnd.Intn(len(best))], Door)
+		}
+	}
+}
+
+// number of doors of room c
+func (m *Maze) doors(c Cell) int {
+	count := 0
+	for _, d := range m.neighbours(c) {
+		if m.Room(c).Side(d) == Door {
+			count++
+		}
+	}
+	return count
+}
+
+/*
+	Exit on the border room at the longest distance from entry,
+	ties are broken by Directions order of borders
+*/
+func placeFarthestExit(m *Maze, entry Cell) {
+	distances := m.Distances(entry)
+
+	best, bestSide, bestDistance := Cell{}, Direction(""), -1
+	for r := 0; r < m.Rows; r++ {
+		for c := 0; c < m.Columns; c++ {
+			cell := Cell{r, c}
+			for _, d := range Directions {
+				if m.Contains(cell.Next(d)) || (cell == entry && d == m.Entry.Side) {
+					continue
+				}
+				if distances[r][c] > bestDistance {
+					best, bestSide, bestDistance = cell, d, distances[r][c]
+				}
+			}
+		}
+	}
+	m.Open(best, bestSide, Exit)
+}
+
+/*
+	Number of moves from c to each room through doors, -1 when not reachable
+*/
+func (m *Maze) Distances(c Cell) [][]int {
+	distances := make([][]int, m.Rows)
+	for i := range distances {
+		distances[i] = make([]int, m.Columns)
+		for j := range distances[i] {
+			distances[i][j] = -1
+		}
+	}
+
+	distances[c.Row][c.Column] = 0
+	queue := []Cell{c}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, d := range Directions {
+			if !m.CanMove(cur, d) {
+				continue
+			}
+			n := cur.Next(d)
+			if distances[n.Row][n.Column] == -1 {
+				distances[n.Row][n.Column] = distances[cur.Row][cur.Column] + 1
+				queue = append(queue, n)
+			}
+		}
+	}
+	return distances
+}
+
+func (m *Maze) index(c Cell) int {
+	return c.Row*m.Columns + c.Column
+}
+
+func newGrid(m *Maze) [][]bool {
+	grid := make([][]bool, m.Rows)
+	for i := range grid {
+		grid[i] = make([]bool, m.Columns)
+	}
+	return grid
+}
+
+// union-find of cell indexes
+type disjointSets []int
+
+func newDisjointSets(n int) disjointSets {
+	sets := make(disjointSets, n)
+	for i := range sets {
+		sets[i] = i
+	}
+	return sets
+}
+
+func (s disjointSets) find(i int) int {
+	for s[i] != i {
+		s[i] = s[s[i]]
+		i = s[i]
+	}
+	return i
+}
+
+// join sets of i and j, false when already joined
+func (s disjointSets) union(i int, j int) bool {
+	a, b := s.find(i), s.find(j)
+	if a == b {
+		return false
+	}
+	s[b] = a
+	return true
+}
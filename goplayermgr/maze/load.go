@@ -0,0 +1,77 @@
+package maze
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+)
+
+// maze definition as stored by mazemgr, only the ASCII maze is used
+type definition struct {
+	Maze          []string `json:"maze"`
+	Configuration *struct {
+		Maze []string `json:"maze"`
+	} `json:"configuration"`
+}
+
+/*
+	Read maze from a mazemgr JSON maze, a JSON object with a maze field
+	or an ASCII maze file
+*/
+func ReadFile(filename string) (*Maze, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Load(data)
+}
+
+/*
+	Load maze from a mazemgr JSON maze, a JSON object with a maze field
+	or an ASCII maze
+*/
+func Load(data []byte) (*Maze, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return ParseString(strings.ReplaceAll(string(data), "\r", ""))
+	}
+
+	var def definition
+	if err := json.Unmarshal(trimmed, &def); err != nil {
+		return nil, err
+	}
+	if def.Configuration != nil {
+		return Parse(def.Configuration.Maze)
+	}
+	return Parse(def.Maze)
+}
+
+// Configuration of a maze in mazemgr, entry and exit are the cells outside of the maze
+type Configuration struct {
+	Maze  []string `json:"maze"`
+	Entry Cell     `json:"entry"`
+	Exit  Cell     `json:"exit"`
+}
+
+// Definition of a maze in mazemgr
+type Definition struct {
+	Name          string        `json:"name"`
+	Description   string        `json:"description"`
+	Configuration Configuration `json:"configuration"`
+}
+
+/*
+	mazemgr definition of maze
+*/
+func (m *Maze) Definition(name string, description string) *Definition {
+	return &Definition{
+		Name:        name,
+		Description: description,
+		Configuration: Configuration{
+			Maze:  m.Render(),
+			Entry: m.Entry.Next(m.Entry.Side),
+			Exit:  m.Exit.Next(m.Exit.Side),
+		},
+	}
+}
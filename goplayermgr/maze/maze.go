@@ -0,0 +1,194 @@
+/*
+	Package maze reads and writes the ASCII maze format used by mazemgr.
+
+	A maze of R rows and C columns is described by 2R+1 lines of 2C+1 characters:
+
+		+-+-+-+-+
+		x     | |
+		+-+-+ +-+
+		| | |   X
+		+-+-+-+-+
+
+	'+' are corners, '-' and '|' are walls, ' ' is a door between two rooms,
+	'x' is the maze entry and 'X' its exit. Digits are accepted on the border
+	as row/column labels and are read as walls.
+*/
+package maze
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// type of a room side, same values as the room given to bots
+type WallType string
+
+const (
+	Entry WallType = "entry"
+	Exit  WallType = "exit"
+	Wall  WallType = "wall"
+	Door  WallType = "door"
+)
+
+type Direction string
+
+const (
+	Up    Direction = "up"
+	Right Direction = "right"
+	Down  Direction = "down"
+	Left  Direction = "left"
+)
+
+// directions in clockwise order
+var Directions = []Direction{Up, Right, Down, Left}
+
+func (d Direction) Opposite() Direction {
+	switch d {
+	case Up:
+		return Down
+	case Down:
+		return Up
+	case Left:
+		return Right
+	case Right:
+		return Left
+	}
+	return d
+}
+
+// Room is what a bot sees of its position
+type Room struct {
+	Left  WallType `json:"left"`
+	Right WallType `json:"right"`
+	Up    WallType `json:"up"`
+	Down  WallType `json:"down"`
+}
+
+// type of wall on side d of the room
+func (r Room) Side(d Direction) WallType {
+	switch d {
+	case Up:
+		return r.Up
+	case Down:
+		return r.Down
+	case Left:
+		return r.Left
+	case Right:
+		return r.Right
+	}
+	return Wall
+}
+
+func (r *Room) setSide(d Direction, w WallType) {
+	switch d {
+	case Up:
+		r.Up = w
+	case Down:
+		r.Down = w
+	case Left:
+		r.Left = w
+	case Right:
+		r.Right = w
+	}
+}
+
+// Cell locates a room in maze
+type Cell struct {
+	Row    int `json:"r"`
+	Column int `json:"c"`
+}
+
+// neighbour cell in direction d, it may be outside of the maze
+func (c Cell) Next(d Direction) Cell {
+	switch d {
+	case Up:
+		return Cell{c.Row - 1, c.Column}
+	case Down:
+		return Cell{c.Row + 1, c.Column}
+	case Left:
+		return Cell{c.Row, c.Column - 1}
+	case Right:
+		return Cell{c.Row, c.Column + 1}
+	}
+	return c
+}
+
+// Opening is the entry or exit of the maze, on side Side of room Cell
+type Opening struct {
+	Cell
+	Side Direction `json:"side"`
+}
+
+type Maze struct {
+	Rows    int      `json:"rows"`
+	Columns int      `json:"columns"`
+	Rooms   [][]Room `json:"rooms"`
+	Entry   Opening  `json:"entry"`
+	Exit    Opening  `json:"exit"`
+}
+
+/*
+	Create a maze where all rooms are closed, entry and exit must be set with Open
+*/
+func New(rows int, columns int) *Maze {
+	m := &Maze{Rows: rows, Columns: columns}
+	m.Rooms = make([][]Room, rows)
+	for i := range m.Rooms {
+		m.Rooms[i] = make([]Room, columns)
+		for j := range m.Rooms[i] {
+			m.Rooms[i][j] = Room{Left: Wall, Right: Wall, Up: Wall, Down: Wall}
+		}
+	}
+	return m
+}
+
+func (m *Maze) Contains(c Cell) bool {
+	return c.Row >= 0 && c.Row < m.Rows && c.Column >= 0 && c.Column < m.Columns
+}
+
+func (m *Maze) Room(c Cell) Room {
+	return m.Rooms[c.Row][c.Column]
+}
+
+/*
+	Set wall type on side d of room c, the neighbour room is updated accordingly
+*/
+func (m *Maze) SetWall(c Cell, d Direction, w WallType) {
+	m.Rooms[c.Row][c.Column].setSide(d, w)
+	n := c.Next(d)
+	if m.Contains(n) {
+		m.Rooms[n.Row][n.Column].setSide(d.Opposite(), w)
+	}
+}
+
+// check if bot can go from room c to its neighbour in direction d
+func (m *Maze) CanMove(c Cell, d Direction) bool {
+	return m.Room(c).Side(d) == Door && m.Contains(c.Next(d))
+}
+
+/*
+	Place entry or exit on side d of border room c
+*/
+func (m *Maze) Open(c Cell, d Direction, w WallType) {
+	m.SetWall(c, d, w)
+	if w == Entry {
+		m.Entry = Opening{Cell: c, Side: d}
+	} else if w == Exit {
+		m.Exit = Opening{Cell: c, Side: d}
+	}
+}
+
+// text representation of maze
+func (m *Maze) String() string {
+	return strings.Join(m.Render(), "\n")
+}
+
+/*
+	Identify a maze by the SHA-256 of its text representation,
+	labels and bot trail of the definition are not part of it
+*/
+func (m *Maze) Hash() string {
+	sum := sha256.Sum256([]byte(m.String()))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
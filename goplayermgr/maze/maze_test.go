@@ -0,0 +1,339 @@
+package maze_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"jc.org/playermgr/maze"
+)
+
+// mazes from mazemgr sample data
+var veryBasic = []string{
+	"+0+1+2+3+",
+	"0 | | | |",
+	"+-+-+-+-+",
+	"x     | |",
+	"+-+-+ +-+",
+	"2 | |   X",
+	"+-+-+-+-+",
+	"3 | | | |",
+	"+-+-+-+-+",
+}
+
+var basic = []string{
+	"+-+-+-+-+",
+	"| | | | |",
+	"+-+-+ +-+",
+	"x     | |",
+	"+-+ + +-+",
+	"|   |   X",
+	"+ +-+-+-+",
+	"|   | | |",
+	"+-+-+-+-+",
+}
+
+func TestParse(t *testing.T) {
+	m, err := maze.Parse(veryBasic)
+	if err != nil {
+		t.Fatalf("Cannot parse maze: %v", err)
+	}
+
+	if m.Rows != 4 || m.Columns != 4 {
+		t.Errorf("Expected 4x4 maze, got %vx%v", m.Rows, m.Columns)
+	}
+	if m.Entry != (maze.Opening{Cell: maze.Cell{Row: 1, Column: 0}, Side: maze.Left}) {
+		t.Errorf("Bad entry %v", m.Entry)
+	}
+	if m.Exit != (maze.Opening{Cell: maze.Cell{Row: 2, Column: 3}, Side: maze.Right}) {
+		t.Errorf("Bad exit %v", m.Exit)
+	}
+
+	room := m.Room(maze.Cell{Row: 1, Column: 2})
+	expected := maze.Room{Left: maze.Door, Right: maze.Wall, Up: maze.Wall, Down: maze.Door}
+	if room != expected {
+		t.Errorf("Expected %v got %v", expected, room)
+	}
+
+	// labels are walls
+	if m.Room(maze.Cell{Row: 0, Column: 0}).Left != maze.Wall || m.Room(maze.Cell{Row: 0, Column: 1}).Up != maze.Wall {
+		t.Error("Labels are not read as walls")
+	}
+
+	if !m.CanMove(maze.Cell{Row: 1, Column: 2}, maze.Down) || m.CanMove(maze.Cell{Row: 1, Column: 0}, maze.Left) {
+		t.Error("Bad moves")
+	}
+}
+
+func TestRender(t *testing.T) {
+	m, err := maze.Parse(basic)
+	if err != nil {
+		t.Fatalf("Cannot parse maze: %v", err)
+	}
+	if strings.Join(m.Render(), "\n") != strings.Join(basic, "\n") {
+		t.Errorf("Render differs from definition:\n%v", m)
+	}
+
+	m, _ = maze.ParseString(strings.Join(veryBasic, "\n") + "\n")
+	if m.Render()[3] != "x     | |" || m.Render()[0] != "+-+-+-+-+" {
+		t.Errorf("Bad render of labeled maze:\n%v", m)
+	}
+
+	// trail of bot
+	trail := maze.NewTrail(m)
+	trail.Visit(maze.Cell{Row: 1, Column: 0})
+	trail.Visit(maze.Cell{Row: 1, Column: 1})
+	trail.Visit(maze.Cell{Row: 1, Column: 2})
+	lines := m.RenderTrail(trail)
+	if lines[3] != "xb b B| |" {
+		t.Errorf("Bad trail %q", lines[3])
+	}
+
+	trail.Visit(maze.Cell{Row: 2, Column: 2})
+	trail.Visit(maze.Cell{Row: 2, Column: 3})
+	trail.Exited = true
+	lines = m.RenderTrail(trail)
+	// like gamemgr the bot stays in the room it left
+	if lines[3] != "xb b b| |" || lines[5] != "| | |b BB" {
+		t.Errorf("Bad trail after exit %q %q", lines[3], lines[5])
+	}
+
+	// exit of bottom line is drawn 'b' like gamemgr
+	bottom, err := maze.Parse([]string{"+-+-+", "x   |", "+-+X+"})
+	if err != nil {
+		t.Fatalf("Cannot parse maze: %v", err)
+	}
+	trail2 := maze.NewTrail(bottom)
+	trail2.Visit(maze.Cell{Row: 0, Column: 0})
+	trail2.Visit(maze.Cell{Row: 0, Column: 1})
+	trail2.Exited = true
+	if lines := bottom.RenderTrail(trail2); lines[1] != "xb B|" || lines[2] != "+-+b+" {
+		t.Errorf("Bad trail out of bottom exit %q", lines)
+	}
+
+	// trail can be parsed back
+	m2, err := maze.Parse(m.RenderTrail(&maze.Trail{Visited: trail.Visited, Bot: trail.Bot}))
+	if err != nil || m2.String() != m.String() {
+		t.Errorf("Cannot parse maze with trail: %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		def  []string
+		err  error
+		line int
+	}{
+		{"empty", []string{}, maze.ErrEmpty, 0},
+		{"even", []string{"+-+-+", "x   X"}, maze.ErrBadSize, 0},
+		{"ragged", []string{"+-+-+", "x   X", "+-+"}, maze.ErrRaggedLine, 3},
+		{"corner", []string{"+-+-+", "x   X", "+---+"}, maze.ErrBadCorner, 3},
+		{"char", []string{"+-+-+", "x ? X", "+-+-+"}, maze.ErrUnknownChar, 2},
+		{"inner", []string{"+-+-+", "x x X", "+-+-+"}, maze.ErrInnerOpening, 2},
+		{"no entry", []string{"+-+-+", "|   X", "+-+-+"}, maze.ErrMissingEntry, 0},
+		{"no exit", []string{"+-+-+", "x   |", "+-+-+"}, maze.ErrMissingExit, 0},
+		{"entries", []string{"+x+-+", "x   X", "+-+-+"}, maze.ErrMultipleEntries, 0},
+		{"exits", []string{"+-+X+", "x   X", "+-+-+"}, maze.ErrMultipleExits, 0},
+	}
+
+	for _, tc := range tests {
+		_, err := maze.Parse(tc.def)
+		if err == nil {
+			t.Errorf("%v: no error", tc.name)
+			continue
+		}
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%v: expected %v got %v", tc.name, tc.err, err)
+		}
+		var list maze.ErrorList
+		if errors.As(err, &list) && list[0].Line != tc.line {
+			t.Errorf("%v: expected error on line %v got %v", tc.name, tc.line, list[0].Line)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, algo := range maze.Algorithms {
+		m, err := maze.Generate(10, 15, maze.GenerateOptions{Algorithm: algo, Seed: 42})
+		if err != nil {
+			t.Fatalf("Cannot generate %v maze: %v", algo, err)
+		}
+
+		// perfect maze: all rooms reachable and no loop
+		doors := 0
+		distances := m.Distances(m.Entry.Cell)
+		for r := 0; r < m.Rows; r++ {
+			for c := 0; c < m.Columns; c++ {
+				if distances[r][c] < 0 {
+					t.Errorf("%v: room %v,%v is not reachable", algo, r, c)
+				}
+				if m.Rooms[r][c].Right == maze.Door {
+					doors++
+				}
+				if m.Rooms[r][c].Down == maze.Door {
+					doors++
+				}
+			}
+		}
+		if doors != m.Rows*m.Columns-1 {
+			t.Errorf("%v: perfect maze should have %v doors, got %v", algo, m.Rows*m.Columns-1, doors)
+		}
+
+		parsed, err := maze.Parse(m.Render())
+		if err != nil || parsed.String() != m.String() {
+			t.Errorf("%v: generated maze should parse: %v\n%v", algo, err, m)
+		}
+
+		same, _ := maze.Generate(10, 15, maze.GenerateOptions{Algorithm: algo, Seed: 42})
+		other, _ := maze.Generate(10, 15, maze.GenerateOptions{Algorithm: algo, Seed: 43})
+		if same.String() != m.String() || other.String() == m.String() {
+			t.Errorf("%v: maze should only depend on seed", algo)
+		}
+
+		braided, _ := maze.Generate(10, 15, maze.GenerateOptions{Algorithm: algo, Seed: 42, Braid: 1, Placement: maze.PlaceFarthest})
+		for r := 0; r < braided.Rows; r++ {
+			for c := 0; c < braided.Columns; c++ {
+				count := 0
+				for _, d := range maze.Directions {
+					if braided.Rooms[r][c].Side(d) == maze.Door {
+						count++
+					}
+				}
+				if count < 2 {
+					t.Errorf("%v: braided maze has dead end at %v,%v\n%v", algo, r, c, braided)
+				}
+			}
+		}
+	}
+
+	if _, err := maze.Generate(5, 5, maze.GenerateOptions{Algorithm: "wilson"}); !errors.Is(err, maze.ErrUnknownAlgorithm) {
+		t.Errorf("Expected unknown algorithm error: %v", err)
+	}
+	if _, err := maze.Generate(0, 5, maze.GenerateOptions{}); err != maze.ErrGenerateSize {
+		t.Errorf("Expected size error: %v", err)
+	}
+}
+
+func TestSolve(t *testing.T) {
+	// solutions from mazemgr sample data
+	solutions := []struct {
+		maze     []string
+		solution []string
+	}{
+		{veryBasic, []string{
+			"+-+-+-+-+",
+			"| | | | |",
+			"+-+-+-+-+",
+			"xbbbbb| |",
+			"+-+-+b+-+",
+			"| | |bbbX",
+			"+-+-+-+-+",
+			"| | | | |",
+			"+-+-+-+-+",
+		}},
+		{basic, []string{
+			"+-+-+-+-+",
+			"| | | | |",
+			"+-+-+ +-+",
+			"xbbbbb| |",
+			"+-+ +b+-+",
+			"|   |bbbX",
+			"+ +-+-+-+",
+			"|   | | |",
+			"+-+-+-+-+",
+		}},
+	}
+	for _, s := range solutions {
+		m, _ := maze.Parse(s.maze)
+		solution, err := m.Solve()
+		if err != nil {
+			t.Fatalf("Cannot solve maze: %v", err)
+		}
+		if solution.Steps != 5 {
+			t.Errorf("Expected 5 steps, got %v", solution.Steps)
+		}
+		if got := m.RenderSolution(solution); strings.Join(got, "\n") != strings.Join(s.solution, "\n") {
+			t.Errorf("Unexpected solution\n%v", strings.Join(got, "\n"))
+		}
+	}
+
+	// A* finds shortest path in mazes with loops
+	for seed := int64(0); seed < 10; seed++ {
+		m, _ := maze.Generate(12, 12, maze.GenerateOptions{Seed: seed, Braid: 0.5})
+		solution, err := m.Solve()
+		if err != nil {
+			t.Fatalf("Cannot solve maze: %v", err)
+		}
+		distance := m.Distances(m.Entry.Cell)[m.Exit.Row][m.Exit.Column]
+		if solution.Steps != distance+1 {
+			t.Errorf("Seed %v: expected %v steps, got %v", seed, distance+1, solution.Steps)
+		}
+	}
+
+	closed, _ := maze.Parse([]string{
+		"+-+-+",
+		"x | X",
+		"+-+-+",
+	})
+	if _, err := closed.Solve(); err != maze.ErrNoSolution {
+		t.Errorf("Expected no solution: %v", err)
+	}
+
+	if maze.Score(10, 5, true) != 0.5 || maze.Score(5, 5, true) != 1 || maze.Score(10, 5, false) != 0 {
+		t.Errorf("Unexpected score")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	m, _ := maze.Parse(basic)
+	a := m.Analyze(1)
+
+	expected := maze.Analysis{
+		Rows:            4,
+		Columns:         4,
+		ShortestPath:    5,
+		DeadEnds:        4,
+		Junctions:       2,
+		BranchingFactor: 8.0 / 10,
+		Loops:           0,
+		Reachable:       10,
+		SolutionRatio:   5.0 / 16,
+		WallFollower:    maze.Walk{Success: true, Steps: 7},
+	}
+	a.RandomWalk = maze.RandomWalks{}
+	if *a != expected {
+		t.Errorf("Unexpected analysis %+v", a)
+	}
+
+	braided, _ := maze.Generate(8, 8, maze.GenerateOptions{Seed: 3, Braid: 1})
+	a = braided.Analyze(1)
+	if a.Loops == 0 || a.DeadEnds != 0 || a.Reachable != 64 {
+		t.Errorf("Braided maze should have loops and no dead end: %+v", a)
+	}
+	if a.RandomWalk.Trials != maze.RandomWalkTrials || a.RandomWalk != braided.Analyze(1).RandomWalk {
+		t.Errorf("Random walks should be reproducible: %+v", a.RandomWalk)
+	}
+}
+
+func TestSuite(t *testing.T) {
+	suite, err := maze.FindSuite("../data/mazes", "basic")
+	if err != nil {
+		t.Fatalf("Cannot read suite: %v", err)
+	}
+	if suite.Name != "basic" || len(suite.Mazes) != 5 || suite.Mazes[0].Name != "backtracker-10x10" || suite.Mazes[0].Maze.Rows != 10 {
+		t.Errorf("Unexpected suite %+v", suite)
+	}
+	if names := maze.Suites("../data/mazes"); len(names) != 1 || names[0] != "basic" {
+		t.Errorf("Unexpected suites %v", names)
+	}
+
+	for _, name := range []string{"", "..", "../mazes/basic", "unknown"} {
+		if _, err := maze.FindSuite("../data/mazes", name); !errors.Is(err, maze.ErrUnknownSuite) {
+			t.Errorf("Suite %q should be unknown: %v", name, err)
+		}
+	}
+	if _, err := maze.ReadSuite(t.TempDir()); !errors.Is(err, maze.ErrEmptySuite) {
+		t.Errorf("Suite without maze should fail: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package maze
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrEmpty           = errors.New("maze definition is empty")
+	ErrBadSize         = errors.New("maze definition must have an odd number of lines and columns")
+	ErrRaggedLine      = errors.New("line length differs from first line")
+	ErrBadCorner       = errors.New("corner must be '+'")
+	ErrUnknownChar     = errors.New("unknown character")
+	ErrInnerOpening    = errors.New("entry and exit must be on maze border")
+	ErrMissingEntry    = errors.New("maze has no entry")
+	ErrMissingExit     = errors.New("maze has no exit")
+	ErrMultipleEntries = errors.New("maze has several entries")
+	ErrMultipleExits   = errors.New("maze has several exits")
+)
+
+// ParseError locates a problem in a maze definition, Line and Column start at 1
+type ParseError struct {
+	Line   int   `json:"line,omitempty"`
+	Column int   `json:"column,omitempty"`
+	Err    error `json:"-"`
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	if e.Column == 0 {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d column %d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList holds all the problems found in a maze definition
+type ErrorList []*ParseError
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// check if one of the errors is target
+func (l ErrorList) Is(target error) bool {
+	for _, e := range l {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	Parse a maze given as a multi line string
+*/
+func ParseString(def string) (*Maze, error) {
+	return Parse(strings.Split(strings.TrimRight(def, "\n"), "\n"))
+}
+
+/*
+	Parse and validate a maze given as an array of lines, like mazemgr configuration.
+	Bot trail markers 'b' and 'B' are accepted and ignored.
+*/
+func Parse(lines []string) (*Maze, error) {
+	if len(lines) == 0 || len(lines[0]) == 0 {
+		return nil, ErrorList{{Err: ErrEmpty}}
+	}
+
+	var errs ErrorList
+	width := len(lines[0])
+	if len(lines)%2 == 0 || width%2 == 0 || len(lines) < 3 || width < 3 {
+		errs = append(errs, &ParseError{Err: ErrBadSize})
+	}
+	for i, l := range lines {
+		if len(l) != width {
+			errs = append(errs, &ParseError{Line: i + 1, Err: ErrRaggedLine})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	m := New((len(lines)-1)/2, (width-1)/2)
+	entries := 0
+	exits := 0
+
+	for i, l := range lines {
+		for j := 0; j < width; j++ {
+			ch := l[j]
+			pos := &ParseError{Line: i + 1, Column: j + 1}
+
+			if i%2 == 0 && j%2 == 0 {
+				if ch != '+' {
+					pos.Err = ErrBadCorner
+					errs = append(errs, pos)
+				}
+				continue
+			}
+			if i%2 == 1 && j%2 == 1 {
+				// room content
+				if ch != ' ' && ch != 'b' && ch != 'B' {
+					pos.Err = ErrUnknownChar
+					errs = append(errs, pos)
+				}
+				continue
+			}
+
+			border := i == 0 || i == len(lines)-1 || j == 0 || j == width-1
+			w, ok := wallFromChar(ch, border)
+			if !ok {
+				pos.Err = ErrUnknownChar
+				errs = append(errs, pos)
+				continue
+			}
+
+			if (w == Entry || w == Exit) && !border {
+				pos.Err = ErrInnerOpening
+				errs = append(errs, pos)
+				continue
+			}
+
+			// wall between rooms or on border
+			var c Cell
+			var d Direction
+			if i%2 == 0 {
+				// horizontal wall above room (i/2, j/2)
+				c = Cell{i / 2, (j - 1) / 2}
+				d = Up
+				if i == len(lines)-1 {
+					c.Row = m.Rows - 1
+					d = Down
+				}
+			} else {
+				// vertical wall left of room
+				c = Cell{(i - 1) / 2, j / 2}
+				d = Left
+				if j == width-1 {
+					c.Column = m.Columns - 1
+					d = Right
+				}
+			}
+
+			switch w {
+			case Entry:
+				entries++
+				m.Open(c, d, Entry)
+			case Exit:
+				exits++
+				m.Open(c, d, Exit)
+			default:
+				m.SetWall(c, d, w)
+			}
+		}
+	}
+
+	if entries == 0 {
+		errs = append(errs, &ParseError{Err: ErrMissingEntry})
+	} else if entries > 1 {
+		errs = append(errs, &ParseError{Err: ErrMultipleEntries})
+	}
+	if exits == 0 {
+		errs = append(errs, &ParseError{Err: ErrMissingExit})
+	} else if exits > 1 {
+		errs = append(errs, &ParseError{Err: ErrMultipleExits})
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return m, nil
+}
+
+// decode a wall character, digits are labels only allowed on border
+func wallFromChar(ch byte, border bool) (WallType, bool) {
+	switch {
+	case ch == 'x':
+		return Entry, true
+	case ch == 'X':
+		return Exit, true
+	case ch == ' ' || ch == 'b' || ch == 'B':
+		return Door, true
+	case ch == '-' || ch == '|':
+		return Wall, true
+	case ch >= '0' && ch <= '9' && border:
+		return Wall, true
+	}
+	return Wall, false
+}
@@ -0,0 +1,96 @@
+package maze
+
+/*
+	Trail of a bot in maze.
+	Visited rooms are drawn 'b', current bot room 'B' and
+	the exit is replaced by 'B' once the bot is out, 'b' on the bottom line.
+	The bot stays drawn in the last room it left through the exit.
+*/
+type Trail struct {
+	Visited [][]bool
+	Bot     *Cell
+	Exited  bool
+}
+
+func NewTrail(m *Maze) *Trail {
+	t := &Trail{Visited: make([][]bool, m.Rows)}
+	for i := range t.Visited {
+		t.Visited[i] = make([]bool, m.Columns)
+	}
+	return t
+}
+
+// record bot position
+func (t *Trail) Visit(c Cell) {
+	t.Visited[c.Row][c.Column] = true
+	pos := c
+	t.Bot = &pos
+}
+
+// Render maze as array of lines
+func (m *Maze) Render() []string {
+	return m.RenderTrail(nil)
+}
+
+/*
+	Render maze with bot trail as array of lines, with a trail it is the
+	same output as showBot of gamemgr engine bot_result, including its
+	bottom line where doors are drawn as walls
+*/
+func (m *Maze) RenderTrail(t *Trail) []string {
+	exited := t != nil && t.Exited
+	lines := make([]string, 0, 2*m.Rows+1)
+
+	for i := 0; i < m.Rows; i++ {
+		top := make([]byte, 0, 2*m.Columns+1)
+		line := make([]byte, 0, 2*m.Columns+1)
+		for j := 0; j < m.Columns; j++ {
+			room := m.Rooms[i][j]
+			top = append(top, '+', wallChar(room.Up, '-', exited))
+			line = append(line, wallChar(room.Left, '|', exited))
+
+			if t != nil && t.Bot != nil && t.Bot.Row == i && t.Bot.Column == j {
+				line = append(line, 'B')
+			} else if t != nil && t.Visited[i][j] {
+				line = append(line, 'b')
+			} else {
+				line = append(line, ' ')
+			}
+		}
+		top = append(top, '+')
+		line = append(line, wallChar(m.Rooms[i][m.Columns-1].Right, '|', exited))
+		lines = append(lines, string(top), string(line))
+	}
+
+	bottom := make([]byte, 0, 2*m.Columns+1)
+	for j := 0; j < m.Columns; j++ {
+		side := m.Rooms[m.Rows-1][j].Down
+		switch {
+		case t != nil && side == Exit && exited:
+			bottom = append(bottom, '+', 'b')
+		case t != nil && side == Door:
+			bottom = append(bottom, '+', '-')
+		default:
+			bottom = append(bottom, '+', wallChar(side, '-', exited))
+		}
+	}
+	bottom = append(bottom, '+')
+	lines = append(lines, string(bottom))
+
+	return lines
+}
+
+func wallChar(w WallType, wall byte, exited bool) byte {
+	switch w {
+	case Entry:
+		return 'x'
+	case Exit:
+		if exited {
+			return 'B'
+		}
+		return 'X'
+	case Door:
+		return ' '
+	}
+	return wall
+}
@@ -0,0 +1,140 @@
+package maze
+
+import (
+	"container/heap"
+	"errors"
+)
+
+var ErrNoSolution = errors.New("exit cannot be reached from entry")
+
+// Solution is a shortest path from entry room to exit room
+type Solution struct {
+	Path []Cell `json:"path"`
+	// minimum number of steps of a bot: moves between rooms plus the move out
+	Steps int `json:"steps"`
+}
+
+/*
+	Find a shortest path from entry to exit with A*, Manhattan distance
+	to exit is the heuristic as each move costs 1
+*/
+func (m *Maze) Solve() (*Solution, error) {
+	start, goal := m.Entry.Cell, m.Exit.Cell
+	if !m.Contains(start) || !m.Contains(goal) {
+		return nil, ErrNoSolution
+	}
+
+	cost := map[Cell]int{start: 0}
+	from := map[Cell]Cell{}
+	open := &cellQueue{}
+	heap.Push(open, queued{start, manhattan(start, goal), 0})
+
+	for open.Len() > 0 {
+		cur := heap.Pop(open).(queued)
+		if cur.cell == goal {
+			return m.solution(from, start, goal), nil
+		}
+		if cur.cost > cost[cur.cell] {
+			// stale entry
+			continue
+		}
+		for _, d := range Directions {
+			if !m.CanMove(cur.cell, d) {
+				continue
+			}
+			n := cur.cell.Next(d)
+			c := cost[cur.cell] + 1
+			if known, ok := cost[n]; !ok || c < known {
+				cost[n] = c
+				from[n] = cur.cell
+				heap.Push(open, queued{n, c + manhattan(n, goal), c})
+			}
+		}
+	}
+	return nil, ErrNoSolution
+}
+
+func (m *Maze) solution(from map[Cell]Cell, start Cell, goal Cell) *Solution {
+	path := []Cell{goal}
+	for c := goal; c != start; {
+		c = from[c]
+		path = append(path, c)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return &Solution{Path: path, Steps: len(path)}
+}
+
+/*
+	Render maze with solution like mazemgr solution field:
+	rooms of the path and doors between them are drawn 'b'
+*/
+func (m *Maze) RenderSolution(s *Solution) []string {
+	lines := m.Render()
+	grid := make([][]byte, len(lines))
+	for i, l := range lines {
+		grid[i] = []byte(l)
+	}
+
+	for i, c := range s.Path {
+		grid[2*c.Row+1][2*c.Column+1] = 'b'
+		if i > 0 {
+			p := s.Path[i-1]
+			grid[c.Row+p.Row+1][c.Column+p.Column+1] = 'b'
+		}
+	}
+
+	for i := range grid {
+		lines[i] = string(grid[i])
+	}
+	return lines
+}
+
+/*
+	Score of a game, optimum steps divided by bot steps:
+	1 for an optimal bot, 0 when bot did not exit
+*/
+func Score(steps int, optimum int, success bool) float64 {
+	if !success || steps <= 0 || optimum <= 0 {
+		return 0
+	}
+	if steps < optimum {
+		// bot went through walls, gamemgr allows it
+		return 1
+	}
+	return float64(optimum) / float64(steps)
+}
+
+func manhattan(a Cell, b Cell) int {
+	dr, dc := a.Row-b.Row, a.Column-b.Column
+	if dr < 0 {
+		dr = -dr
+	}
+	if dc < 0 {
+		dc = -dc
+	}
+	return dr + dc
+}
+
+type queued struct {
+	cell     Cell
+	priority int
+	cost     int
+}
+
+// priority queue of cells for A*
+type cellQueue []queued
+
+func (q cellQueue) Len() int { return len(q) }
+func (q cellQueue) Less(i, j int) bool {
+	return q[i].priority < q[j].priority
+}
+func (q cellQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *cellQueue) Push(x interface{}) { *q = append(*q, x.(queued)) }
+func (q *cellQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
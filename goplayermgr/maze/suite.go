@@ -0,0 +1,81 @@
+package maze
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnknownSuite = errors.New("unknown maze suite")
+	ErrEmptySuite   = errors.New("maze suite has no maze")
+)
+
+// Suite is a named set of mazes used to evaluate bots
+type Suite struct {
+	Name  string      `json:"name"`
+	Mazes []SuiteMaze `json:"mazes"`
+}
+
+type SuiteMaze struct {
+	Name string `json:"name"`
+	Maze *Maze  `json:"-"`
+}
+
+/*
+	Read all maze files of directory dir, named after the file without extension.
+	The suite is named after the directory and its mazes are sorted by name.
+*/
+func ReadSuite(dir string) (*Suite, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	suite := &Suite{Name: filepath.Base(dir)}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		m, err := ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		name := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		suite.Mazes = append(suite.Mazes, SuiteMaze{Name: name, Maze: m})
+	}
+	if len(suite.Mazes) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptySuite, dir)
+	}
+	sort.Slice(suite.Mazes, func(i, j int) bool { return suite.Mazes[i].Name < suite.Mazes[j].Name })
+	return suite, nil
+}
+
+/*
+	Read suite name, a sub directory of root. Names cannot designate another directory.
+*/
+func FindSuite(root string, name string) (*Suite, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSuite, name)
+	}
+	suite, err := ReadSuite(filepath.Join(root, name))
+	if err != nil && !errors.Is(err, ErrEmptySuite) {
+		return nil, fmt.Errorf("%w %q: %v", ErrUnknownSuite, name, err)
+	}
+	return suite, err
+}
+
+// names of suites found in root
+func Suites(root string) []string {
+	names := []string{}
+	files, _ := ioutil.ReadDir(root)
+	for _, f := range files {
+		if f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
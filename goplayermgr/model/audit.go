@@ -0,0 +1,136 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// audit actions
+const (
+	AuditPlayerCreate  = "player.create"
+	AuditPlayerDelete  = "player.delete"
+	AuditPlayerRestore = "player.restore"
+	AuditPlayerPurge   = "player.purge"
+	AuditBotCreate     = "bot.create"
+	AuditBotUpdate     = "bot.update"
+	AuditBotDelete     = "bot.delete"
+	AuditBotRestore    = "bot.restore"
+	AuditBotPurge      = "bot.purge"
+	AuditPurgeDeleted  = "deleted.purge"
+)
+
+var ErrAuditAppendOnly = errors.New("audit log is append-only")
+
+type AuditEntry struct {
+	Aid       int64     `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"time"`
+	Actor     string    `gorm:"index" json:"actor"`
+	Action    string    `gorm:"index" json:"action"`
+	PlayerId  int32     `gorm:"index" json:"player_id,omitempty"`
+	BotId     int32     `json:"bot_id,omitempty"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	RequestId string    `json:"request_id,omitempty"`
+}
+
+func (AuditEntry) TableName() string {
+	return "audit"
+}
+
+// audit entries can never be changed or removed
+func (AuditEntry) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (AuditEntry) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+// criteria to select audit entries, zero values are ignored
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	PlayerId int32
+	BotId    int32
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func AddAuditEntry(db *gorm.DB, entry *AuditEntry) *AuditEntry {
+	if db == nil {
+		return nil
+	}
+
+	result := db.Create(entry)
+	if result.Error != nil {
+		fmt.Printf("Error AddAuditEntry(%v): %v\n", entry.Action, result.Error)
+		return nil
+	}
+	return entry
+}
+
+/*
+	JSON summary of an object stored as before/after state of an audit entry
+*/
+func AuditSummary(obj interface{}) string {
+	if obj == nil {
+		return ""
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return ""
+	}
+	summary, err := json.Marshal(obj)
+	if err != nil {
+		return ""
+	}
+	return string(summary)
+}
+
+/*
+	Get audit entries matching filter, most recent first
+*/
+func GetAuditEntries(db *gorm.DB, filter AuditFilter) []AuditEntry {
+	if db == nil {
+		return nil
+	}
+
+	query := db.Order("aid desc")
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.PlayerId != 0 {
+		query = query.Where("player_id = ?", filter.PlayerId)
+	}
+	if filter.BotId != 0 {
+		query = query.Where("bot_id = ?", filter.BotId)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	entries := []AuditEntry{}
+	result := query.Find(&entries)
+	if result.Error != nil {
+		fmt.Printf("Error GetAuditEntries: %v\n", result.Error)
+		return nil
+	}
+
+	return entries
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/*
+	Evaluation of a bot revision over a maze suite, Report holds the
+	engine evaluation in JSON, its games are stored as games of the bot
+*/
+type Evaluation struct {
+	Eid        int32     `gorm:"primaryKey" json:"id"`
+	PlayerId   int32     `gorm:"index" json:"player_id"`
+	BotId      int32     `gorm:"index" json:"bot_id"`
+	BotVersion int32     `json:"bot_version"`
+	Suite      string    `json:"suite"`
+	Report     string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (Evaluation) TableName() string {
+	return "evaluation"
+}
+
+func AddEvaluation(db *gorm.DB, evaluation *Evaluation) *Evaluation {
+	if db == nil {
+		return nil
+	}
+
+	result := db.Create(evaluation)
+	if result.Error != nil {
+		fmt.Printf("Error AddEvaluation(%v): %v\n", evaluation.BotId, result.Error)
+		return nil
+	}
+	return evaluation
+}
+
+/*
+	Get evaluations of a bot, most recent first, without their report
+*/
+func GetEvaluations(db *gorm.DB, pid int32, bid int32) []Evaluation {
+	if db == nil {
+		return nil
+	}
+
+	evaluations := []Evaluation{}
+	result := db.Omit("report").Where("player_id = ? AND bot_id = ?", pid, bid).Order("eid desc").Find(&evaluations)
+	if result.Error != nil {
+		fmt.Printf("Error GetEvaluations(%v): %v\n", bid, result.Error)
+		return nil
+	}
+	return evaluations
+}
+
+func GetEvaluation(db *gorm.DB, pid int32, bid int32, eid int32) *Evaluation {
+	if db == nil {
+		return nil
+	}
+
+	var evaluation *Evaluation
+	result := db.Where("player_id = ? AND bot_id = ?", pid, bid).First(&evaluation, eid)
+	if result.Error != nil {
+		fmt.Printf("Error GetEvaluation(%v): %v\n", eid, result.Error)
+		return nil
+	}
+	return evaluation
+}
+
+// evaluations are permanently deleted with their bot
+func purgeEvaluations(tx *gorm.DB, bots interface{}) error {
+	return tx.Where("bot_id IN (?)", bots).Delete(&Evaluation{}).Error
+}
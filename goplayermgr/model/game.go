@@ -0,0 +1,106 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/*
+	Game played by a bot in a maze, Replay holds the recorded steps
+	in the engine replay format and Console the output of the bot
+*/
+type Game struct {
+	Gid          int32     `gorm:"primaryKey" json:"id"`
+	PlayerId     int32     `gorm:"index" json:"player_id"`
+	BotId        int32     `gorm:"index" json:"bot_id"`
+	BotVersion   int32     `json:"bot_version"`
+	MazeName     string    `json:"maze_name,omitempty"`
+	MazeHash     string    `gorm:"index" json:"maze_hash"`
+	State        string    `json:"state"`
+	Steps        int       `json:"steps"`
+	OptimalSteps int       `json:"optimal_steps,omitempty"`
+	Score        float64   `json:"score"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+	Replay       string    `json:"-"`
+	Console      string    `json:"-"`
+}
+
+func (Game) TableName() string {
+	return "game"
+}
+
+/*
+	Store a game, it is rated against other bots of its maze and
+	a successful game enters the leaderboard of the maze
+*/
+func AddGame(db *gorm.DB, game *Game) *Game {
+	if db == nil {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return addGame(tx, game)
+	})
+	if err != nil {
+		fmt.Printf("Error AddGame(%v): %v\n", game.BotId, err)
+		return nil
+	}
+	return game
+}
+
+// create game, rate it and record it in leaderboard
+func addGame(tx *gorm.DB, game *Game) error {
+	err := tx.Create(game).Error
+	if err != nil {
+		return err
+	}
+	err = rateGame(tx, game)
+	if err != nil {
+		return err
+	}
+	return recordLeaderboard(tx, game)
+}
+
+/*
+	Get games of a bot, most recent first, without their replay and console
+*/
+func GetGames(db *gorm.DB, pid int32, bid int32) []Game {
+	if db == nil {
+		return nil
+	}
+
+	games := []Game{}
+	result := db.Omit("replay", "console").Where("player_id = ? AND bot_id = ?", pid, bid).Order("gid desc").Find(&games)
+	if result.Error != nil {
+		fmt.Printf("Error GetGames(%v): %v\n", bid, result.Error)
+		return nil
+	}
+	return games
+}
+
+/*
+	Get a game of a bot with its replay and console
+*/
+func GetGame(db *gorm.DB, pid int32, bid int32, gid int32) *Game {
+	if db == nil {
+		return nil
+	}
+
+	var game *Game
+	result := db.Where("player_id = ? AND bot_id = ?", pid, bid).First(&game, gid)
+	if result.Error != nil {
+		fmt.Printf("Error GetGame(%v): %v\n", gid, result.Error)
+		return nil
+	}
+	return game
+}
+
+/*
+	Games are permanently deleted with their bot, bots is a query selecting bot ids
+*/
+func purgeGames(tx *gorm.DB, bots interface{}) error {
+	return tx.Where("bot_id IN (?)", bots).Delete(&Game{}).Error
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// bot row seen without soft delete filtering
+type BotRef struct {
+	Bid      int32  `gorm:"primaryKey" json:"id"`
+	Name     string `json:"name"`
+	PlayerId int32  `json:"player_id"`
+}
+
+func (BotRef) TableName() string {
+	return "bot"
+}
+
+type IntegrityReport struct {
+	// bots referencing a player that does not exist
+	OrphanBots []BotRef `json:"orphan_bots"`
+	// bots still visible while their player is deleted
+	DeletedPlayerBots []BotRef `json:"deleted_player_bots"`
+	ForeignKey        bool     `json:"foreign_key"`
+	Repaired          bool     `json:"repaired"`
+}
+
+/*
+	Search bots without valid player, when repair is true orphan bots are
+	permanently deleted and bots of deleted players are soft deleted with them.
+*/
+func CheckIntegrity(db *gorm.DB, repair bool) *IntegrityReport {
+	if db == nil {
+		return nil
+	}
+
+	report := &IntegrityReport{OrphanBots: []BotRef{}, DeletedPlayerBots: []BotRef{}}
+
+	players := db.Unscoped().Model(&Player{}).Select("pid")
+	result := db.Where("player_id IS NULL OR player_id NOT IN (?)", players).Find(&report.OrphanBots)
+	if result.Error != nil {
+		fmt.Printf("Error CheckIntegrity: %v\n", result.Error)
+		return nil
+	}
+
+	deletedPlayers := db.Unscoped().Model(&Player{}).Select("pid").Where("deleted_at IS NOT NULL")
+	result = db.Where("deleted_at IS NULL AND player_id IN (?)", deletedPlayers).Find(&report.DeletedPlayerBots)
+	if result.Error != nil {
+		fmt.Printf("Error CheckIntegrity: %v\n", result.Error)
+		return nil
+	}
+
+	if repair && (len(report.OrphanBots) > 0 || len(report.DeletedPlayerBots) > 0) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, b := range report.OrphanBots {
+				err := tx.Delete(&BotRef{}, b.Bid).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, b := range report.DeletedPlayerBots {
+				err := tx.Exec("UPDATE bot SET deleted_at = (SELECT deleted_at FROM player WHERE player.pid = bot.player_id) WHERE bid = ?", b.Bid).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		// notest
+		if err != nil {
+			fmt.Printf("Error CheckIntegrity repair: %v\n", err)
+			return nil
+		}
+		report.Repaired = true
+	}
+
+	if repair {
+		report.ForeignKey = ensureForeignKeys(db)
+	} else {
+		report.ForeignKey = db.Migrator().HasConstraint(&Player{}, "Bots")
+	}
+
+	return report
+}
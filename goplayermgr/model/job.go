@@ -0,0 +1,226 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// job states, a failed job is queued again until it has no attempt left and is dead
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+var ErrLeaseLost = errors.New("job lease lost")
+
+/*
+	Job is a long running operation done by a worker, Payload and Result are JSON.
+	A worker leases a job until LeaseUntil and extends the lease while working,
+	a job whose lease expired is leased again by another worker.
+*/
+type Job struct {
+	Jid         int32      `gorm:"primaryKey" json:"id"`
+	Kind        string     `gorm:"index" json:"kind"`
+	PlayerId    int32      `gorm:"index" json:"player_id"`
+	BotId       int32      `gorm:"index" json:"bot_id"`
+	Payload     string     `json:"-"`
+	State       string     `gorm:"index" json:"state"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	Done        int        `json:"done"`
+	Total       int        `json:"total"`
+	Error       string     `json:"error,omitempty"`
+	Result      string     `json:"-"`
+	RunAt       time.Time  `gorm:"index" json:"run_at"`
+	LeaseOwner  string     `json:"-"`
+	LeaseUntil  time.Time  `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+func (Job) TableName() string {
+	return "job"
+}
+
+/*
+	Queue a job to run now or at job.RunAt
+*/
+func AddJob(db *gorm.DB, job *Job) *Job {
+	if db == nil {
+		return nil
+	}
+
+	job.State = JobQueued
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	job.RunAt = job.RunAt.UTC()
+	result := db.Create(job)
+	if result.Error != nil {
+		fmt.Printf("Error AddJob(%v): %v\n", job.Kind, result.Error)
+		return nil
+	}
+	return job
+}
+
+/*
+	Lease the next job of one of kinds for owner until now+lease, nil when none is ready.
+	A running job whose lease expired is leased again, or is dead when it has no attempt left.
+*/
+func LeaseJob(db *gorm.DB, owner string, kinds []string, lease time.Duration, now time.Time) *Job {
+	if db == nil {
+		return nil
+	}
+	now = now.UTC()
+
+	for {
+		var job *Job
+		result := db.Where("kind IN ? AND ((state = ? AND run_at <= ?) OR (state = ? AND lease_until < ?))", kinds, JobQueued, now, JobRunning, now).
+			Order("run_at, jid").First(&job)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if result.Error != nil {
+			fmt.Printf("Error LeaseJob(%v): %v\n", owner, result.Error)
+			return nil
+		}
+
+		// another worker may lease the same job, attempts are a version
+		update := map[string]interface{}{"state": JobRunning, "attempts": job.Attempts + 1, "lease_owner": owner, "lease_until": now.Add(lease)}
+		if job.State == JobRunning && job.Attempts >= job.MaxAttempts {
+			update = map[string]interface{}{"state": JobDead, "error": "lease expired", "finished_at": now}
+		}
+		result = db.Model(&Job{}).Where("jid = ? AND state = ? AND attempts = ?", job.Jid, job.State, job.Attempts).Updates(update)
+		if result.Error != nil {
+			fmt.Printf("Error LeaseJob(%v): %v\n", owner, result.Error)
+			return nil
+		}
+		if result.RowsAffected == 0 || update["state"] == JobDead {
+			continue
+		}
+		db.First(job, job.Jid)
+		return job
+	}
+}
+
+/*
+	Extend lease of a running job and record its progress,
+	returns ErrLeaseLost when owner does not hold the lease any more
+*/
+func HeartbeatJob(db *gorm.DB, jid int32, owner string, until time.Time, done int, total int) error {
+	result := db.Model(&Job{}).Where("jid = ? AND state = ? AND lease_owner = ?", jid, JobRunning, owner).
+		Updates(map[string]interface{}{"lease_until": until.UTC(), "done": done, "total": total})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+/*
+	Record result of a job leased by owner
+*/
+func CompleteJob(db *gorm.DB, jid int32, owner string, output string, done int, total int) error {
+	now := time.Now().UTC()
+	result := db.Model(&Job{}).Where("jid = ? AND state = ? AND lease_owner = ?", jid, JobRunning, owner).
+		Updates(map[string]interface{}{"state": JobSucceeded, "result": output, "error": "", "done": done, "total": total, "finished_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+/*
+	Record failure of a job leased by owner, it runs again at retryAt.
+	The job is dead when retryAt is zero or it has no attempt left.
+*/
+func FailJob(db *gorm.DB, job *Job, owner string, message string, retryAt time.Time) error {
+	update := map[string]interface{}{"state": JobQueued, "error": message, "run_at": retryAt.UTC(), "lease_owner": ""}
+	if retryAt.IsZero() || job.Attempts >= job.MaxAttempts {
+		update = map[string]interface{}{"state": JobDead, "error": message, "finished_at": time.Now().UTC()}
+	}
+	result := db.Model(&Job{}).Where("jid = ? AND state = ? AND lease_owner = ?", job.Jid, JobRunning, owner).Updates(update)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+/*
+	Queue a dead job again with all its attempts
+*/
+func RetryJob(db *gorm.DB, jid int32) *Job {
+	if db == nil {
+		return nil
+	}
+
+	result := db.Model(&Job{}).Where("jid = ? AND state = ?", jid, JobDead).
+		Updates(map[string]interface{}{"state": JobQueued, "attempts": 0, "run_at": time.Now().UTC(), "finished_at": nil})
+	if result.Error != nil {
+		fmt.Printf("Error RetryJob(%v): %v\n", jid, result.Error)
+		return nil
+	}
+	if result.RowsAffected == 0 {
+		fmt.Printf("Warn RetryJob(%v): job is not dead\n", jid)
+		return nil
+	}
+	return GetJob(db, jid)
+}
+
+func GetJob(db *gorm.DB, jid int32) *Job {
+	if db == nil {
+		return nil
+	}
+
+	var job *Job
+	result := db.First(&job, jid)
+	if result.Error != nil {
+		fmt.Printf("Error GetJob(%v): %v\n", jid, result.Error)
+		return nil
+	}
+	return job
+}
+
+/*
+	Get jobs without their payload and result, most recent first.
+	Jobs of all players when pid is 0, of all states when state is empty.
+*/
+func GetJobs(db *gorm.DB, pid int32, state string) []Job {
+	if db == nil {
+		return nil
+	}
+
+	query := db.Omit("payload", "result").Order("jid desc")
+	if pid != 0 {
+		query = query.Where("player_id = ?", pid)
+	}
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	jobs := []Job{}
+	result := query.Find(&jobs)
+	if result.Error != nil {
+		fmt.Printf("Error GetJobs(%v): %v\n", pid, result.Error)
+		return nil
+	}
+	return jobs
+}
+
+// jobs are permanently deleted with their bot
+func purgeJobs(tx *gorm.DB, bots interface{}) error {
+	return tx.Where("bot_id IN (?)", bots).Delete(&Job{}).Error
+}
@@ -0,0 +1,449 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// time windows of leaderboards, week starts on Monday 00:00 UTC
+const (
+	WindowAll  = "all"
+	WindowWeek = "week"
+)
+
+var ErrUnknownWindow = errors.New("unknown leaderboard window, use all or week")
+
+/*
+	LeaderboardEntry is the best game of a bot revision in a maze: fewest steps,
+	SubmittedAt is when the revision first reached them
+*/
+type LeaderboardEntry struct {
+	Lid          int32     `gorm:"primaryKey" json:"-"`
+	MazeHash     string    `gorm:"uniqueIndex:idx_leaderboard_submission" json:"maze_hash"`
+	MazeName     string    `json:"maze_name,omitempty"`
+	PlayerId     int32     `gorm:"index" json:"player_id"`
+	BotId        int32     `gorm:"uniqueIndex:idx_leaderboard_submission" json:"bot_id"`
+	BotVersion   int32     `gorm:"uniqueIndex:idx_leaderboard_submission" json:"bot_version"`
+	GameId       int32     `json:"game_id"`
+	Steps        int       `json:"steps"`
+	OptimalSteps int       `json:"optimal_steps"`
+	Score        float64   `json:"score"`
+	SubmittedAt  time.Time `gorm:"index" json:"submitted_at"`
+}
+
+func (LeaderboardEntry) TableName() string {
+	return "leaderboard_entry"
+}
+
+/*
+	Ranking is the place of a bot in a leaderboard. In a maze leaderboard Score
+	is the optimum divided by bot steps, in the global leaderboard it is the mean
+	of best scores over all ranked mazes of the window, unsolved mazes counting 0.
+*/
+type Ranking struct {
+	Rank         int       `json:"rank"`
+	PlayerId     int32     `json:"player_id"`
+	PlayerName   string    `json:"player_name"`
+	BotId        int32     `json:"bot_id"`
+	BotName      string    `json:"bot_name"`
+	BotVersion   int32     `json:"bot_version,omitempty"`
+	GameId       int32     `json:"game_id,omitempty"`
+	Steps        int       `json:"steps,omitempty"`
+	OptimalSteps int       `json:"optimal_steps,omitempty"`
+	Score        float64   `json:"score"`
+	Mazes        int       `json:"mazes,omitempty"`
+	SubmittedAt  time.Time `json:"submitted_at"`
+}
+
+/*
+	RankedMaze is a maze of a suite or of a tournament pool. Only games in ranked
+	mazes count for the global leaderboard and ratings, so mazes made up by players
+	do not change them.
+*/
+type RankedMaze struct {
+	Hash string `gorm:"primaryKey" json:"hash"`
+	Name string `json:"name"`
+}
+
+func (RankedMaze) TableName() string {
+	return "ranked_maze"
+}
+
+// Leaderboard is a page of rankings
+type Leaderboard struct {
+	MazeHash string     `json:"maze_hash,omitempty"`
+	MazeName string     `json:"maze_name,omitempty"`
+	Window   string     `json:"window"`
+	Since    *time.Time `json:"since,omitempty"`
+	Page     int        `json:"page"`
+	PerPage  int        `json:"per_page"`
+	Total    int        `json:"total"`
+	Entries  []Ranking  `json:"entries"`
+}
+
+// LeaderboardMaze is a maze with leaderboard entries
+type LeaderboardMaze struct {
+	MazeHash string `json:"maze_hash"`
+	MazeName string `json:"maze_name"`
+	Bots     int    `json:"bots"`
+}
+
+/*
+	Start of window at date now, zero for all time
+*/
+func WindowStart(window string, now time.Time) (time.Time, error) {
+	switch window {
+	case WindowAll, "":
+		return time.Time{}, nil
+	case WindowWeek:
+		now = now.UTC()
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		// Sunday is day 0, weeks start on Monday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrUnknownWindow, window)
+}
+
+/*
+	Rank mazes, mazes already ranked are kept with their name
+*/
+func AddRankedMazes(db *gorm.DB, mazes []RankedMaze) error {
+	if db == nil {
+		return errors.New("no database")
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return addRankedMazes(tx, mazes)
+	})
+	if err != nil {
+		fmt.Printf("Error AddRankedMazes(%v): %v\n", len(mazes), err)
+	}
+	return err
+}
+
+func addRankedMazes(tx *gorm.DB, mazes []RankedMaze) error {
+	for _, m := range mazes {
+		var count int64
+		err := tx.Model(&RankedMaze{}).Where("hash = ?", m.Hash).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		err = tx.Create(&m).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check if games in maze count for global leaderboard and ratings
+func isRanked(tx *gorm.DB, mazeHash string) (bool, error) {
+	var count int64
+	err := tx.Model(&RankedMaze{}).Where("hash = ?", mazeHash).Count(&count).Error
+	return count > 0, err
+}
+
+/*
+	Record a successful game in leaderboard of its maze when it improves
+	the best game of the bot revision
+*/
+func recordLeaderboard(tx *gorm.DB, game *Game) error {
+	// same state as engine.Success
+	if game.State != "success" || game.MazeHash == "" {
+		return nil
+	}
+
+	var entry LeaderboardEntry
+	result := tx.Where("maze_hash = ? AND bot_id = ? AND bot_version = ?", game.MazeHash, game.BotId, game.BotVersion).Limit(1).Find(&entry)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 && entry.Steps <= game.Steps {
+		return nil
+	}
+
+	entry.MazeHash = game.MazeHash
+	if game.MazeName != "" {
+		entry.MazeName = game.MazeName
+	}
+	entry.PlayerId = game.PlayerId
+	entry.BotId = game.BotId
+	entry.BotVersion = game.BotVersion
+	entry.GameId = game.Gid
+	entry.Steps = game.Steps
+	entry.OptimalSteps = game.OptimalSteps
+	entry.Score = game.Score
+	entry.SubmittedAt = game.CreatedAt.UTC()
+	return tx.Save(&entry).Error
+}
+
+/*
+	Build leaderboard and ratings again from stored games, e.g. for games
+	played before leaderboards. Games are rated again in the order they were played.
+*/
+func RebuildLeaderboard(db *gorm.DB) int {
+	if db == nil {
+		return -1
+	}
+
+	count := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range []interface{}{&LeaderboardEntry{}, &RatingHistory{}, &Rating{}} {
+			err := tx.Where("1 = 1").Delete(table).Error
+			if err != nil {
+				return err
+			}
+		}
+		games := []Game{}
+		err := tx.Omit("replay", "console").Order("gid").Find(&games).Error
+		if err != nil {
+			return err
+		}
+		for i := range games {
+			err = rateGame(tx, &games[i])
+			if err != nil {
+				return err
+			}
+			err = recordLeaderboard(tx, &games[i])
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&LeaderboardEntry{}).Select("count(*)").Scan(&count).Error
+	})
+	if err != nil {
+		fmt.Printf("Error RebuildLeaderboard: %v\n", err)
+		return -1
+	}
+	return count
+}
+
+// entry with names of its bot and player
+type namedEntry struct {
+	LeaderboardEntry
+	BotName    string
+	PlayerName string
+}
+
+/*
+	Entries submitted since date, of a maze when mazeHash is not empty,
+	only of ranked mazes when ranked is set.
+	Entries of deleted bots and players are left out.
+*/
+func leaderboardEntries(db *gorm.DB, mazeHash string, since time.Time, ranked bool) ([]namedEntry, error) {
+	query := db.Model(&LeaderboardEntry{}).
+		Select("leaderboard_entry.*, bot.name AS bot_name, player.name AS player_name").
+		Joins("JOIN bot ON bot.bid = leaderboard_entry.bot_id AND bot.deleted_at IS NULL").
+		Joins("JOIN player ON player.pid = leaderboard_entry.player_id AND player.deleted_at IS NULL").
+		Where("leaderboard_entry.submitted_at >= ?", since.UTC())
+	if ranked {
+		query = query.Joins("JOIN ranked_maze ON ranked_maze.hash = leaderboard_entry.maze_hash")
+	}
+	if mazeHash != "" {
+		query = query.Where("leaderboard_entry.maze_hash = ?", mazeHash)
+	}
+	entries := []namedEntry{}
+	err := query.Scan(&entries).Error
+	return entries, err
+}
+
+// entry e ranks before entry o: fewest steps, then earliest submission
+func (e *namedEntry) before(o *namedEntry) bool {
+	if e.Steps != o.Steps {
+		return e.Steps < o.Steps
+	}
+	if !e.SubmittedAt.Equal(o.SubmittedAt) {
+		return e.SubmittedAt.Before(o.SubmittedAt)
+	}
+	return e.BotId < o.BotId
+}
+
+// best entry of each bot of each maze, by maze hash
+func bestEntries(entries []namedEntry) map[string][]namedEntry {
+	best := map[string]map[int32]*namedEntry{}
+	for i := range entries {
+		e := &entries[i]
+		if best[e.MazeHash] == nil {
+			best[e.MazeHash] = map[int32]*namedEntry{}
+		}
+		if b := best[e.MazeHash][e.BotId]; b == nil || e.before(b) {
+			best[e.MazeHash][e.BotId] = e
+		}
+	}
+	mazes := map[string][]namedEntry{}
+	for hash, bots := range best {
+		for _, e := range bots {
+			mazes[hash] = append(mazes[hash], *e)
+		}
+		sort.Slice(mazes[hash], func(i, j int) bool { return mazes[hash][i].before(&mazes[hash][j]) })
+	}
+	return mazes
+}
+
+func newLeaderboard(window string, since time.Time) *Leaderboard {
+	if window == "" {
+		window = WindowAll
+	}
+	board := &Leaderboard{Window: window}
+	if !since.IsZero() {
+		board.Since = &since
+	}
+	return board
+}
+
+// keep page of rankings and number them
+func paginate(board *Leaderboard, rankings []Ranking, page int, perPage int) {
+	board.Page, board.PerPage, board.Total = page, perPage, len(rankings)
+	for i := range rankings {
+		rankings[i].Rank = i + 1
+	}
+	start := (page - 1) * perPage
+	if start > len(rankings) {
+		start = len(rankings)
+	}
+	end := start + perPage
+	if end > len(rankings) {
+		end = len(rankings)
+	}
+	board.Entries = rankings[start:end]
+}
+
+/*
+	Rank bots of a maze by fewest steps then earliest submission, a bot appears once
+	with its best revision. page starts at 1.
+*/
+func MazeLeaderboard(db *gorm.DB, mazeHash string, window string, page int, perPage int) (*Leaderboard, error) {
+	since, err := WindowStart(window, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	entries, err := leaderboardEntries(db, mazeHash, since, false)
+	if err != nil {
+		fmt.Printf("Error MazeLeaderboard(%v): %v\n", mazeHash, err)
+		return nil, err
+	}
+
+	board := newLeaderboard(window, since)
+	board.MazeHash = mazeHash
+	rankings := []Ranking{}
+	for _, e := range bestEntries(entries)[mazeHash] {
+		if board.MazeName == "" {
+			board.MazeName = e.MazeName
+		}
+		rankings = append(rankings, Ranking{
+			PlayerId:     e.PlayerId,
+			PlayerName:   e.PlayerName,
+			BotId:        e.BotId,
+			BotName:      e.BotName,
+			BotVersion:   e.BotVersion,
+			GameId:       e.GameId,
+			Steps:        e.Steps,
+			OptimalSteps: e.OptimalSteps,
+			Score:        e.Score,
+			SubmittedAt:  e.SubmittedAt,
+		})
+	}
+	paginate(board, rankings, page, perPage)
+	return board, nil
+}
+
+/*
+	Rank bots by mean of their best score over all ranked mazes of the window,
+	then by number of solved mazes, then by earliest date they reached their score
+*/
+func GlobalLeaderboard(db *gorm.DB, window string, page int, perPage int) (*Leaderboard, error) {
+	since, err := WindowStart(window, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	entries, err := leaderboardEntries(db, "", since, true)
+	if err != nil {
+		fmt.Printf("Error GlobalLeaderboard(%v): %v\n", window, err)
+		return nil, err
+	}
+
+	mazes := bestEntries(entries)
+	bots := map[int32]*Ranking{}
+	for _, best := range mazes {
+		for _, e := range best {
+			r := bots[e.BotId]
+			if r == nil {
+				r = &Ranking{PlayerId: e.PlayerId, PlayerName: e.PlayerName, BotId: e.BotId, BotName: e.BotName}
+				bots[e.BotId] = r
+			}
+			r.Score += e.Score
+			r.Mazes++
+			if e.SubmittedAt.After(r.SubmittedAt) {
+				r.SubmittedAt = e.SubmittedAt
+			}
+		}
+	}
+
+	rankings := []Ranking{}
+	for _, r := range bots {
+		r.Score /= float64(len(mazes))
+		rankings = append(rankings, *r)
+	}
+	sort.Slice(rankings, func(i, j int) bool {
+		a, b := rankings[i], rankings[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Mazes != b.Mazes {
+			return a.Mazes > b.Mazes
+		}
+		if !a.SubmittedAt.Equal(b.SubmittedAt) {
+			return a.SubmittedAt.Before(b.SubmittedAt)
+		}
+		return a.BotId < b.BotId
+	})
+
+	board := newLeaderboard(window, since)
+	paginate(board, rankings, page, perPage)
+	return board, nil
+}
+
+/*
+	Mazes with entries submitted in window, most played first
+*/
+func LeaderboardMazes(db *gorm.DB, window string) ([]LeaderboardMaze, error) {
+	since, err := WindowStart(window, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	entries, err := leaderboardEntries(db, "", since, false)
+	if err != nil {
+		fmt.Printf("Error LeaderboardMazes(%v): %v\n", window, err)
+		return nil, err
+	}
+
+	mazes := []LeaderboardMaze{}
+	for hash, best := range bestEntries(entries) {
+		m := LeaderboardMaze{MazeHash: hash, Bots: len(best)}
+		for _, e := range best {
+			if m.MazeName == "" {
+				m.MazeName = e.MazeName
+			}
+		}
+		mazes = append(mazes, m)
+	}
+	sort.Slice(mazes, func(i, j int) bool {
+		if mazes[i].Bots != mazes[j].Bots {
+			return mazes[i].Bots > mazes[j].Bots
+		}
+		return mazes[i].MazeHash < mazes[j].MazeHash
+	})
+	return mazes, nil
+}
+
+// leaderboard entries are permanently deleted with their bot
+func purgeLeaderboard(tx *gorm.DB, bots interface{}) error {
+	return tx.Where("bot_id IN (?)", bots).Delete(&LeaderboardEntry{}).Error
+}
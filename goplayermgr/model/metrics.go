@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+// latency of database queries, labeled by gorm operation
+var dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "playermgr_db_query_duration_seconds",
+	Help:    "Latency of player database queries by operation.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation"})
+
+const queryStartKey = "playermgr:query_start"
+
+/*
+	Register gorm callbacks measuring the duration of each database operation
+*/
+func registerMetricsCallbacks(db *gorm.DB) {
+	cb := db.Callback()
+
+	cb.Create().Before("gorm:create").Register("playermgr:start_create", startQueryTimer)
+	cb.Create().After("gorm:create").Register("playermgr:observe_create", observeQuery("create"))
+
+	cb.Query().Before("gorm:query").Register("playermgr:start_query", startQueryTimer)
+	cb.Query().After("gorm:query").Register("playermgr:observe_query", observeQuery("query"))
+
+	cb.Update().Before("gorm:update").Register("playermgr:start_update", startQueryTimer)
+	cb.Update().After("gorm:update").Register("playermgr:observe_update", observeQuery("update"))
+
+	cb.Delete().Before("gorm:delete").Register("playermgr:start_delete", startQueryTimer)
+	cb.Delete().After("gorm:delete").Register("playermgr:observe_delete", observeQuery("delete"))
+
+	cb.Row().Before("gorm:row").Register("playermgr:start_row", startQueryTimer)
+	cb.Row().After("gorm:row").Register("playermgr:observe_row", observeQuery("row"))
+
+	cb.Raw().Before("gorm:raw").Register("playermgr:start_raw", startQueryTimer)
+	cb.Raw().After("gorm:raw").Register("playermgr:observe_raw", observeQuery("raw"))
+}
+
+func startQueryTimer(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+func observeQuery(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(queryStartKey)
+		if !ok {
+			return
+		}
+		if start, ok := value.(time.Time); ok {
+			dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		}
+	}
+}
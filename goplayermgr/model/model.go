@@ -1,10 +1,13 @@
 package model
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -13,9 +16,11 @@ import (
 )
 
 type Player struct {
-	Pid  int32  `gorm:"primaryKey" json:"id"`
-	Name string `gorm:"unique" json:"name"`
-	Bots []Bot  `gorm:"foreignKey:PlayerId" json:"bots,omitempty"`
+	Pid       int32          `gorm:"primaryKey" json:"id"`
+	Name      string         `gorm:"unique" json:"name"`
+	Bots      []Bot          `gorm:"foreignKey:PlayerId;constraint:OnDelete:CASCADE" json:"bots,omitempty"`
+	Version   int32          `gorm:"not null;default:1" json:"version"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (Player) TableName() string {
@@ -23,9 +28,11 @@ func (Player) TableName() string {
 }
 
 type BotBase struct {
-	Bid      int32  `gorm:"primaryKey" json:"id"`
-	Name     string `json:"name"`
-	PlayerId int32  `json:"-"`
+	Bid       int32          `gorm:"primaryKey" json:"id"`
+	Name      string         `json:"name"`
+	PlayerId  int32          `json:"-"`
+	Version   int32          `gorm:"not null;default:1" json:"version"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (BotBase) TableName() string {
@@ -33,24 +40,39 @@ func (BotBase) TableName() string {
 }
 
 type Bot struct {
-	Bid      int32  `gorm:"primaryKey" json:"id"`
-	Name     string `json:"name"`
-	URL      string `json:"url,omitempty"`
-	Filename string `json:"filename,omitempty"`
-	PlayerId int32  `json:"-"`
+	Bid       int32          `gorm:"primaryKey" json:"id"`
+	Name      string         `json:"name"`
+	URL       string         `json:"url,omitempty"`
+	Filename  string         `json:"filename,omitempty"`
+	Language  string         `gorm:"not null;default:javascript" json:"language"`
+	PlayerId  int32          `json:"-"`
+	Version   int32          `gorm:"not null;default:1" json:"version"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (Bot) TableName() string {
 	return "bot"
 }
 
+// bot languages
+const (
+	LanguageJavaScript = "javascript"
+	LanguageWasm       = "wasm"
+	// remote bots are called at their URL
+	LanguageRemote = "remote"
+)
+
 type BotCode struct {
-	Bid      int32  `gorm:"primaryKey" json:"id"`
-	Name     string `json:"name"`
-	URL      string `json:"url,omitempty"`
-	Filename string `json:"filename,omitempty"`
-	Botcode  string `json:"botcode,omitempty"`
-	PlayerId int32  `json:"-"`
+	Bid       int32          `gorm:"primaryKey" json:"id"`
+	Name      string         `json:"name"`
+	URL       string         `json:"url,omitempty"`
+	Filename  string         `json:"filename,omitempty"`
+	Language  string         `gorm:"not null;default:javascript" json:"language"`
+	Botcode   string         `json:"botcode,omitempty"`
+	Secret    string         `json:"-"`
+	PlayerId  int32          `json:"-"`
+	Version   int32          `gorm:"not null;default:1" json:"version"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (BotCode) TableName() string {
@@ -62,9 +84,16 @@ type BotWithPlayer struct {
 	Name       string `json:"name"`
 	URL        string `json:"url,omitempty"`
 	Filename   string `json:"filename,omitempty"`
+	Language   string `json:"language"`
 	PlayerName string `json:"player_name"`
+	Version    int32  `json:"version"`
 }
 
+// expected version of a player or bot, AnyVersion skips the check
+const AnyVersion int32 = 0
+
+var ErrVersionConflict = errors.New("version conflict")
+
 func GetPlayers(db *gorm.DB) []Player {
 	if db == nil {
 		return nil
@@ -119,7 +148,9 @@ func GetBot(db *gorm.DB, pid int32, bid int32) *BotWithPlayer {
 		Name:       bot.Name,
 		URL:        bot.URL,
 		Filename:   bot.Filename,
+		Language:   bot.Language,
 		PlayerName: player.Name,
+		Version:    bot.Version,
 	}
 
 	return botwp
@@ -130,7 +161,7 @@ func AddPlayer(db *gorm.DB, name string) *Player {
 		return nil
 	}
 
-	player := &Player{Name: name}
+	player := &Player{Name: name, Version: 1}
 
 	result := db.Create(player)
 	if result.Error != nil {
@@ -140,23 +171,166 @@ func AddPlayer(db *gorm.DB, name string) *Player {
 	return player
 }
 
+/*
+	Soft delete a player and its bots, they can be restored until purged
+*/
 func DeletePlayer(db *gorm.DB, pid int32) *Player {
+	player, _ := DeletePlayerVersion(db, pid, AnyVersion)
+	return player
+}
+
+/*
+	Soft delete a player if it is still at version,
+	returns ErrVersionConflict when player has been modified
+*/
+func DeletePlayerVersion(db *gorm.DB, pid int32, version int32) (*Player, error) {
 	if db == nil {
-		return nil
+		return nil, gorm.ErrInvalidDB
 	}
 
 	player := &Player{Pid: pid}
+	now := time.Now()
 
-	result := db.Delete(player)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(player).Where("deleted_at IS NULL")
+		if version != AnyVersion {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(map[string]interface{}{"deleted_at": now, "version": gorm.Expr("version + 1")})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return versionOrNotFound(tx, &Player{}, "pid", pid, version)
+		}
+
+		// bots deleted with player share its deletion date
+		return tx.Model(&BotBase{}).Where("player_id = ? AND deleted_at IS NULL", pid).Update("deleted_at", now).Error
+	})
+
+	if err == gorm.ErrRecordNotFound {
+		fmt.Printf("Warn DeletePlayer(%v): player does not exist\n", pid)
+		return nil, err
+	}
 
 	// notest
+	if err != nil {
+		fmt.Printf("Error DeletePlayer(%v): %v\n", pid, err)
+		return nil, err
+	}
+
+	return player, nil
+}
+
+/*
+	Restore a soft deleted player with the bots deleted at the same time
+*/
+func RestorePlayer(db *gorm.DB, pid int32) *Player {
+	player, _ := RestorePlayerVersion(db, pid, AnyVersion)
+	return player
+}
+
+/*
+	Get a soft deleted player, e.g. to check its version before restoring it
+*/
+func GetDeletedPlayer(db *gorm.DB, pid int32) *Player {
+	if db == nil {
+		return nil
+	}
+
+	var player Player
+	result := db.Unscoped().Where("deleted_at IS NOT NULL").First(&player, pid)
 	if result.Error != nil {
-		fmt.Printf("Error DeletePlayer(%v): %v\n", pid, result.Error)
 		return nil
 	}
+	return &player
+}
 
-	if result.RowsAffected == 0 {
-		fmt.Printf("Warn DeletePlayer(%v): player does not exist\n", pid)
+/*
+	Restore a soft deleted player if it is still at version,
+	returns ErrVersionConflict when player has been modified
+*/
+func RestorePlayerVersion(db *gorm.DB, pid int32, version int32) (*Player, error) {
+	if db == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+
+	player := GetDeletedPlayer(db, pid)
+	if player == nil {
+		fmt.Printf("Error RestorePlayer(%v) cannot find deleted player\n", pid)
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	// bots deleted with player share its deletion date
+	deletedAt := player.DeletedAt.Time
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Unscoped().Model(player).Where("deleted_at IS NOT NULL")
+		if version != AnyVersion {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return versionOrNotFound(tx.Unscoped().Where("deleted_at IS NOT NULL"), &Player{}, "pid", pid, version)
+		}
+		return tx.Unscoped().Model(&BotBase{}).Where("player_id = ? AND deleted_at = ?", pid, deletedAt).Update("deleted_at", nil).Error
+	})
+
+	// notest
+	if err != nil {
+		fmt.Printf("Error RestorePlayer(%v): %v\n", pid, err)
+		return nil, err
+	}
+
+	return GetPlayer(db, pid), nil
+}
+
+/*
+	Permanently delete a player and all its bots
+*/
+func PurgePlayer(db *gorm.DB, pid int32) *Player {
+	if db == nil {
+		return nil
+	}
+
+	player := &Player{Pid: pid}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := purgeBotRecords(tx, tx.Unscoped().Model(&BotBase{}).Select("bid").Where("player_id = ?", pid))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Where("player_id = ?", pid).Delete(&BotBase{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = purgeRatings(tx, RatingPlayer, []int32{pid})
+		if err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Delete(player)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+
+	if err == gorm.ErrRecordNotFound {
+		fmt.Printf("Warn PurgePlayer(%v): player does not exist\n", pid)
+		return nil
+	}
+
+	// notest
+	if err != nil {
+		fmt.Printf("Error PurgePlayer(%v): %v\n", pid, err)
 		return nil
 	}
 
@@ -164,37 +338,284 @@ func DeletePlayer(db *gorm.DB, pid int32) *Player {
 }
 
 func DeleteBot(db *gorm.DB, pid int32, bid int32) *BotBase {
+	bot, _ := DeleteBotVersion(db, pid, bid, AnyVersion)
+	return bot
+}
+
+/*
+	Soft delete a bot if it is still at version,
+	returns ErrVersionConflict when bot has been modified
+*/
+func DeleteBotVersion(db *gorm.DB, pid int32, bid int32, version int32) (*BotBase, error) {
+	if db == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+
+	bot := &BotBase{Bid: bid}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// check if player exists
+		var player *Player
+		result := tx.First(&player, pid)
+		if result.Error != nil {
+			fmt.Printf("Error DeleteBot(%v) cannot find player: %v\n", pid, result.Error)
+			return result.Error
+		}
+
+		// delete bot
+		query := tx.Model(bot).Where("player_id = ?", pid)
+		if version != AnyVersion {
+			query = query.Where("version = ?", version)
+		}
+		result = query.Updates(map[string]interface{}{"deleted_at": time.Now(), "version": gorm.Expr("version + 1")})
+
+		// notest
+		if result.Error != nil {
+			fmt.Printf("Error DeleteBot(%v): %v\n", bid, result.Error)
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			err := versionOrNotFound(tx.Where("player_id = ?", pid), &BotBase{}, "bid", bid, version)
+			if err == gorm.ErrRecordNotFound {
+				fmt.Printf("Warn DeleteBot(%v): bot does not exist\n", bid)
+			}
+			return err
+		}
+		return bumpPlayerVersion(tx, pid)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bot, nil
+}
+
+/*
+	Update name, filename or code of a bot if it is still at version,
+	empty values are left unchanged
+*/
+func UpdateBot(db *gorm.DB, pid int32, bid int32, version int32, name string, filename string, language string, code string) (*BotBase, error) {
+	if db == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+
+	changes := map[string]interface{}{"version": gorm.Expr("version + 1")}
+	if language != "" {
+		changes["language"] = language
+	}
+	if name != "" {
+		changes["name"] = name
+	}
+	if filename != "" {
+		changes["filename"] = filepath.Base(filename)
+	}
+	if code != "" {
+		changes["botcode"] = code
+	}
+
+	bot := &BotBase{}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&BotCode{Bid: bid}).Where("player_id = ?", pid)
+		if version != AnyVersion {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(changes)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return versionOrNotFound(tx.Where("player_id = ?", pid), &BotBase{}, "bid", bid, version)
+		}
+
+		err := tx.Where("player_id = ?", pid).First(bot, bid).Error
+		if err != nil {
+			return err
+		}
+		return bumpPlayerVersion(tx, pid)
+	})
+
+	if err != nil {
+		fmt.Printf("Error UpdateBot(%v): %v\n", bid, err)
+		return nil, err
+	}
+
+	return bot, nil
+}
+
+/*
+	Restore a soft deleted bot of an existing player
+*/
+func RestoreBot(db *gorm.DB, pid int32, bid int32) *BotBase {
+	bot, _ := RestoreBotVersion(db, pid, bid, AnyVersion)
+	return bot
+}
+
+/*
+	Get a soft deleted bot of a player, e.g. to check its version before restoring it
+*/
+func GetDeletedBot(db *gorm.DB, pid int32, bid int32) *BotBase {
 	if db == nil {
 		return nil
 	}
 
+	var bot BotBase
+	result := db.Unscoped().Where("player_id = ? AND deleted_at IS NOT NULL", pid).First(&bot, bid)
+	if result.Error != nil {
+		return nil
+	}
+	return &bot
+}
+
+/*
+	Restore a soft deleted bot if it is still at version,
+	returns ErrVersionConflict when bot has been modified
+*/
+func RestoreBotVersion(db *gorm.DB, pid int32, bid int32, version int32) (*BotBase, error) {
+	if db == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+
 	// check if player exists
 	var player *Player
 	result := db.First(&player, pid)
 	if result.Error != nil {
-		fmt.Printf("Error DeleteBot(%v) cannot find player: %v\n", pid, result.Error)
+		fmt.Printf("Error RestoreBot(%v) cannot find player: %v\n", pid, result.Error)
+		return nil, result.Error
+	}
+
+	bot := &BotBase{Bid: bid}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Unscoped().Model(bot).Where("player_id = ? AND deleted_at IS NOT NULL", pid)
+		if version != AnyVersion {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+
+		// notest
+		if result.Error != nil {
+			fmt.Printf("Error RestoreBot(%v): %v\n", bid, result.Error)
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			err := versionOrNotFound(tx.Unscoped().Where("player_id = ? AND deleted_at IS NOT NULL", pid), &BotBase{}, "bid", bid, version)
+			if err == gorm.ErrRecordNotFound {
+				fmt.Printf("Warn RestoreBot(%v): deleted bot does not exist\n", bid)
+			}
+			return err
+		}
+		return bumpPlayerVersion(tx, pid)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.First(bot)
+	return bot, nil
+}
+
+/*
+	Permanently delete games, evaluations, jobs, leaderboard entries, ratings,
+	tournament entries and race participations of bots, bots is a query selecting bot ids
+*/
+func purgeBotRecords(tx *gorm.DB, bots interface{}) error {
+	for _, purge := range []func(*gorm.DB, interface{}) error{purgeGames, purgeEvaluations, purgeJobs, purgeLeaderboard, purgeBotRatings, purgeTournamentEntries, purgeRaceParticipants} {
+		err := purge(tx, bots)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Permanently delete a bot, even if it is already soft deleted
+*/
+func PurgeBot(db *gorm.DB, pid int32, bid int32) *BotBase {
+	if db == nil {
 		return nil
 	}
 
-	// delete bot
 	bot := &BotBase{Bid: bid}
 
-	result = db.Where("player_id = ?", pid).Delete(bot)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("player_id = ?", pid).Delete(bot)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return purgeBotRecords(tx, []int32{bid})
+	})
 
-	// notest
-	if result.Error != nil {
-		fmt.Printf("Error DeleteBot(%v): %v\n", bid, result.Error)
+	if err == gorm.ErrRecordNotFound {
+		fmt.Printf("Warn PurgeBot(%v): bot does not exist\n", bid)
 		return nil
 	}
 
-	if result.RowsAffected == 0 {
-		fmt.Printf("Warn DeleteBot(%v): bot does not exist\n", bid)
+	// notest
+	if err != nil {
+		fmt.Printf("Error PurgeBot(%v): %v\n", bid, err)
 		return nil
 	}
 
 	return bot
 }
 
+type PurgeResult struct {
+	Players int64 `json:"players"`
+	Bots    int64 `json:"bots"`
+}
+
+/*
+	Permanently delete players and bots soft deleted before date
+*/
+func PurgeDeleted(db *gorm.DB, before time.Time) *PurgeResult {
+	if db == nil {
+		return nil
+	}
+
+	purged := &PurgeResult{}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// bots of purged players have been deleted at the same time
+		err := purgeBotRecords(tx, tx.Unscoped().Model(&BotBase{}).Select("bid").Where("deleted_at < ?", before))
+		if err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Where("deleted_at < ?", before).Delete(&BotBase{})
+		if result.Error != nil {
+			return result.Error
+		}
+		purged.Bots = result.RowsAffected
+
+		err = purgeRatings(tx, RatingPlayer, tx.Unscoped().Model(&Player{}).Select("pid").Where("deleted_at < ?", before))
+		if err != nil {
+			return err
+		}
+
+		result = tx.Unscoped().Where("deleted_at < ?", before).Delete(&Player{})
+		if result.Error != nil {
+			return result.Error
+		}
+		purged.Players = result.RowsAffected
+		return nil
+	})
+
+	if err != nil {
+		fmt.Printf("Error PurgeDeleted(%v): %v\n", before, err)
+		return nil
+	}
+
+	return purged
+}
+
 func GetPlayerByName(db *gorm.DB, name string) *Player {
 	if db == nil {
 		return nil
@@ -202,6 +623,11 @@ func GetPlayerByName(db *gorm.DB, name string) *Player {
 	var player *Player
 	result := db.Preload("Bots").Where("name = ?", name).First(&player)
 	if result.Error != nil {
+		// a deleted player keeps its name until it is restored or purged
+		if IsPlayerDeleted(db, name) {
+			fmt.Printf("Error GetPlayerByName(%v): player is deleted\n", name)
+			return nil
+		}
 		// player does not exist, create it
 		return AddPlayer(db, name)
 	}
@@ -209,6 +635,22 @@ func GetPlayerByName(db *gorm.DB, name string) *Player {
 	return player
 }
 
+/*
+	Check if player named name is soft deleted
+*/
+func IsPlayerDeleted(db *gorm.DB, name string) bool {
+	if db == nil {
+		return false
+	}
+	var count int64
+	result := db.Unscoped().Model(&Player{}).Where("name = ? AND deleted_at IS NOT NULL", name).Count(&count)
+	if result.Error != nil {
+		fmt.Printf("Error IsPlayerDeleted(%v): %v\n", name, result.Error)
+		return false
+	}
+	return count > 0
+}
+
 func GetPlayersWithBots(db *gorm.DB) []Player {
 	if db == nil {
 		return nil
@@ -224,6 +666,34 @@ func GetPlayersWithBots(db *gorm.DB) []Player {
 	return players
 }
 
+func CountPlayers(db *gorm.DB) int64 {
+	if db == nil {
+		return 0
+	}
+	var count int64
+	result := db.Model(&Player{}).Count(&count)
+	if result.Error != nil {
+		fmt.Printf("Error CountPlayers: %v\n", result.Error)
+		return 0
+	}
+
+	return count
+}
+
+func CountBots(db *gorm.DB) int64 {
+	if db == nil {
+		return 0
+	}
+	var count int64
+	result := db.Model(&BotBase{}).Count(&count)
+	if result.Error != nil {
+		fmt.Printf("Error CountBots: %v\n", result.Error)
+		return 0
+	}
+
+	return count
+}
+
 func GetPlayerBots(db *gorm.DB, pid int32) []Bot {
 	if db == nil {
 		return nil
@@ -247,14 +717,15 @@ func GetPlayerBots(db *gorm.DB, pid int32) []Bot {
 }
 
 func AddBot(db *gorm.DB, pid int32, botname string, codefilename string, code string) *BotBase {
-	if db == nil {
-		return nil
-	}
+	return AddBotWithLanguage(db, pid, botname, codefilename, LanguageJavaScript, code)
+}
 
-	// check if player exist
-	p := GetPlayer(db, pid)
-	if p == nil {
-		fmt.Printf("Error AddBot(%v): cannot add bot to non existing player\n", botname)
+/*
+	Add a bot written in language, WebAssembly code is stored base64 encoded.
+	When code is empty it is read from codefilename.
+*/
+func AddBotWithLanguage(db *gorm.DB, pid int32, botname string, codefilename string, language string, code string) *BotBase {
+	if db == nil {
 		return nil
 	}
 
@@ -265,18 +736,52 @@ func AddBot(db *gorm.DB, pid int32, botname string, codefilename string, code st
 			fmt.Printf("Error AddBot(%v): %v\n", botname, err)
 			return nil
 		}
-		code = string(dat)
+		if language == LanguageWasm {
+			code = base64.StdEncoding.EncodeToString(dat)
+		} else {
+			code = string(dat)
+		}
 	}
 
-	// create bot
-	bot := &BotCode{Name: botname, Filename: filepath.Base(codefilename), Botcode: code, PlayerId: pid}
+	bot := &BotCode{Name: botname, Filename: filepath.Base(codefilename), Language: language, Botcode: code, PlayerId: pid, Version: 1}
+	return createBot(db, bot)
+}
 
-	result := db.Create(bot)
-	if result.Error != nil {
-		fmt.Printf("Error AddBot(%v): %v\n", botname, result.Error)
+/*
+	Add a remote bot called at url, requests are signed with secret
+*/
+func AddRemoteBot(db *gorm.DB, pid int32, botname string, url string, secret string) *BotBase {
+	if db == nil {
+		return nil
+	}
+
+	bot := &BotCode{Name: botname, URL: url, Language: LanguageRemote, Secret: secret, PlayerId: pid, Version: 1}
+	return createBot(db, bot)
+}
+
+func createBot(db *gorm.DB, bot *BotCode) *BotBase {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// check if player exist
+		var player *Player
+		result := tx.First(&player, bot.PlayerId)
+		if result.Error != nil {
+			fmt.Printf("Error AddBot(%v): cannot add bot to non existing player\n", bot.Name)
+			return result.Error
+		}
+
+		// create bot
+		err := tx.Create(bot).Error
+		if err != nil {
+			return err
+		}
+		return bumpPlayerVersion(tx, bot.PlayerId)
+	})
+
+	if err != nil {
+		fmt.Printf("Error AddBot(%v): %v\n", bot.Name, err)
 		return nil
 	}
-	return &BotBase{Bid: bot.Bid, Name: bot.Name}
+	return &BotBase{Bid: bot.Bid, Name: bot.Name, Version: bot.Version}
 }
 
 func GetBotCode(db *gorm.DB, pid int32, bid int32) *BotCode {
@@ -302,38 +807,111 @@ func GetBotCode(db *gorm.DB, pid int32, bid int32) *BotCode {
 	return bot
 }
 
+/*
+	A bot change is a change of its player
+*/
+func bumpPlayerVersion(db *gorm.DB, pid int32) error {
+	return db.Model(&Player{Pid: pid}).Update("version", gorm.Expr("version + 1")).Error
+}
+
+/*
+	Explain why a conditional change did not affect any row
+*/
+func versionOrNotFound(db *gorm.DB, model interface{}, key string, id int32, version int32) error {
+	var count int64
+	err := db.Model(model).Where(key+" = ?", id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 && version != AnyVersion {
+		return ErrVersionConflict
+	}
+	return gorm.ErrRecordNotFound
+}
+
 func ConnectToDB(dsn string) *gorm.DB {
 
+	// foreign keys are created after tables, see migrateSchema
+	config := &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Info),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	}
+
 	if strings.HasPrefix(dsn, "postgres:") {
-		db, err := gorm.Open(postgres.New(postgres.Config{DSN: dsn, PreferSimpleProtocol: true}),
-			&gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
+		db, err := gorm.Open(postgres.New(postgres.Config{DSN: dsn, PreferSimpleProtocol: true}), config)
 
 		if err != nil {
 			fmt.Printf("ConnectToDB error: %v\n", err)
 			return nil
 		}
 
-		err = db.AutoMigrate(&Player{}, &BotCode{})
+		err = migrateSchema(db)
 		if err != nil {
 			fmt.Printf("AutoMigrate DB error: %v\n", err.Error())
 			return nil
 		}
 
+		registerMetricsCallbacks(db)
+
 		return db
 	} else if strings.HasPrefix(dsn, "file:") {
-		sl := sqlite.Open(dsn)
-		db, err := gorm.Open(sl,
-			&gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
+		sl := sqlite.Open(withSQLiteForeignKeys(dsn))
+		db, err := gorm.Open(sl, config)
 
 		if err != nil {
 			fmt.Printf("ConnectToDB error: %v\n", err)
 			return nil
 		}
 
-		db.AutoMigrate(&Player{}, &BotCode{})
+		migrateSchema(db)
+
+		registerMetricsCallbacks(db)
 
 		return db
 	}
 
 	return nil
 }
+
+/*
+	SQLite does not check foreign keys unless enabled on each connection
+*/
+func withSQLiteForeignKeys(dsn string) string {
+	if strings.Contains(dsn, "_foreign_keys=") || strings.Contains(dsn, "_fk=") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_foreign_keys=on"
+	}
+	return dsn + "?_foreign_keys=on"
+}
+
+func migrateSchema(db *gorm.DB) error {
+	err := db.AutoMigrate(&Player{}, &Bot{}, &BotCode{}, &AuditEntry{}, &Game{}, &Evaluation{}, &Job{}, &LeaderboardEntry{}, &Rating{}, &RatingHistory{},
+		&RankedMaze{}, &Tournament{}, &TournamentMaze{}, &TournamentEntry{}, &TournamentMatch{}, &Race{}, &RaceParticipant{})
+	if err != nil {
+		return err
+	}
+
+	ensureForeignKeys(db)
+
+	return nil
+}
+
+/*
+	Create foreign key between bot and player.
+	It cannot be created while orphan bots exist, use CheckIntegrity to remove them.
+*/
+func ensureForeignKeys(db *gorm.DB) bool {
+	if db.Migrator().HasConstraint(&Player{}, "Bots") {
+		return true
+	}
+
+	err := db.Migrator().CreateConstraint(&Player{}, "Bots")
+	if err != nil {
+		fmt.Printf("Warn cannot create bot foreign key, run fsck to repair orphan bots: %v\n", err)
+		return false
+	}
+
+	return true
+}
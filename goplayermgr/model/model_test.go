@@ -1,7 +1,10 @@
 package model_test
 
 import (
+	"errors"
+	"math"
 	"testing"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -184,6 +187,195 @@ func TestDelete(t *testing.T) {
 
 }
 
+func TestSoftDelete(t *testing.T) {
+	p := model.AddPlayer(db, "Lucky")
+	b1 := model.AddBot(db, p.Pid, "LuckyBot", "lb.js", "// some code")
+	b2 := model.AddBot(db, p.Pid, "LuckyBot2", "lb2.js", "// some code")
+
+	// deleted bot is hidden then restored
+	if model.DeleteBot(db, p.Pid, b1.Bid) == nil {
+		t.Fatal("Cannot delete bot")
+	}
+	if model.GetBot(db, p.Pid, b1.Bid) != nil {
+		t.Error("Deleted bot is still visible")
+	}
+	if model.RestoreBot(db, p.Pid, b1.Bid) == nil {
+		t.Error("Cannot restore deleted bot")
+	}
+	if model.RestoreBot(db, p.Pid, b1.Bid) != nil {
+		t.Error("Restore a non deleted bot")
+	}
+
+	// deleted player is hidden with its bots
+	if model.DeletePlayer(db, p.Pid) == nil {
+		t.Fatal("Cannot delete player")
+	}
+	if model.DeletePlayer(db, p.Pid) != nil {
+		t.Error("Delete twice the same player")
+	}
+	if model.GetPlayer(db, p.Pid) != nil {
+		t.Error("Deleted player is still visible")
+	}
+	if model.RestoreBot(db, p.Pid, b2.Bid) != nil {
+		t.Error("Restore bot of deleted player")
+	}
+	for _, pl := range model.GetPlayers(db) {
+		if pl.Pid == p.Pid {
+			t.Error("Deleted player is listed")
+		}
+	}
+
+	// restore player with its bots
+	restored := model.RestorePlayer(db, p.Pid)
+	if restored == nil || len(restored.Bots) != 2 {
+		t.Fatalf("Cannot restore player with bots: %v", restored)
+	}
+	if model.RestorePlayer(db, p.Pid) != nil {
+		t.Error("Restore a non deleted player")
+	}
+
+	// purge only what is older than retention
+	model.DeleteBot(db, p.Pid, b2.Bid)
+	purged := model.PurgeDeleted(db, time.Now().Add(-time.Hour))
+	if purged == nil || purged.Bots != 0 {
+		t.Errorf("Purge recent deleted bot: %v", purged)
+	}
+	purged = model.PurgeDeleted(db, time.Now().Add(time.Second))
+	if purged == nil || purged.Bots < 1 {
+		t.Errorf("Cannot purge deleted bot: %v", purged)
+	}
+	if model.RestoreBot(db, p.Pid, b2.Bid) != nil {
+		t.Error("Restore purged bot")
+	}
+
+	// hard delete
+	if model.PurgeBot(db, p.Pid, b1.Bid) == nil {
+		t.Error("Cannot purge bot")
+	}
+	if model.PurgePlayer(db, p.Pid) == nil {
+		t.Error("Cannot purge player")
+	}
+	if model.PurgePlayer(db, p.Pid) != nil {
+		t.Error("Purge non existing player")
+	}
+	if model.RestorePlayer(db, p.Pid) != nil {
+		t.Error("Restore purged player")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	p := model.AddPlayer(db, "Versioned")
+	b := model.AddBot(db, p.Pid, "VersionedBot", "vb.js", "// some code")
+	if p.Version != 1 || b.Version != 1 {
+		t.Fatalf("Bad initial versions %v %v", p.Version, b.Version)
+	}
+
+	ub, err := model.UpdateBot(db, p.Pid, b.Bid, 1, "Renamed", "", "", "// new code")
+	if err != nil || ub.Version != 2 || ub.Name != "Renamed" {
+		t.Fatalf("Cannot update bot %v: %v", ub, err)
+	}
+	code := model.GetBotCode(db, p.Pid, b.Bid)
+	if code.Botcode != "// new code" || code.Filename != "vb.js" {
+		t.Errorf("Bot not updated %v", code)
+	}
+
+	_, err = model.UpdateBot(db, p.Pid, b.Bid, 1, "Conflict", "", "", "")
+	if err != model.ErrVersionConflict {
+		t.Errorf("Expected version conflict, got %v", err)
+	}
+	_, err = model.UpdateBot(db, p.Pid, 1234, 1, "Missing", "", "", "")
+	if err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	_, err = model.DeleteBotVersion(db, p.Pid, b.Bid, 1)
+	if err != model.ErrVersionConflict {
+		t.Errorf("Expected version conflict, got %v", err)
+	}
+	if _, err = model.DeleteBotVersion(db, p.Pid, b.Bid, 2); err != nil {
+		t.Errorf("Cannot delete bot at version: %v", err)
+	}
+
+	// player version follows bot changes
+	pl := model.GetPlayer(db, p.Pid)
+	if pl.Version != 4 {
+		t.Errorf("Expected player version 4, found %v", pl.Version)
+	}
+	_, err = model.DeletePlayerVersion(db, p.Pid, 1)
+	if err != model.ErrVersionConflict {
+		t.Errorf("Expected version conflict, got %v", err)
+	}
+	if _, err = model.DeletePlayerVersion(db, p.Pid, pl.Version); err != nil {
+		t.Errorf("Cannot delete player at version: %v", err)
+	}
+	_, err = model.DeletePlayerVersion(db, p.Pid, model.AnyVersion)
+	if err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	deleted := model.GetDeletedPlayer(db, p.Pid)
+	if deleted == nil || deleted.Version != pl.Version+1 {
+		t.Fatalf("Cannot get deleted player %+v", deleted)
+	}
+	if _, err = model.RestorePlayerVersion(db, p.Pid, pl.Version); err != model.ErrVersionConflict {
+		t.Errorf("Expected version conflict, got %v", err)
+	}
+	if _, err = model.RestorePlayerVersion(db, p.Pid, deleted.Version); err != nil {
+		t.Errorf("Cannot restore player at version: %v", err)
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	// database created before foreign key with orphan bots
+	dsn := "file:" + t.TempDir() + "/legacy.db"
+	legacy, _ := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
+	legacy.Exec("CREATE TABLE `player` (`pid` integer,`name` text UNIQUE,PRIMARY KEY (`pid`))")
+	legacy.Exec("CREATE TABLE `bot` (`bid` integer,`name` text,`url` text,`filename` text,`botcode` text,`player_id` integer,PRIMARY KEY (`bid`))")
+	legacy.Exec("INSERT INTO player (pid, name) VALUES (1, 'Jack'), (2, 'Joe')")
+	legacy.Exec("INSERT INTO bot (bid, name, player_id) VALUES (1, 'JackBot', 1), (2, 'LostBot', 42), (3, 'JoeBot', 2)")
+	sqlDB, _ := legacy.DB()
+	sqlDB.Close()
+
+	idb := model.ConnectToDB(dsn)
+	if idb == nil {
+		t.Fatal("Cannot open legacy database")
+	}
+	idb.Exec("UPDATE player SET deleted_at = ? WHERE pid = 2", time.Now())
+
+	report := model.CheckIntegrity(idb, false)
+	if report == nil || len(report.OrphanBots) != 1 || len(report.DeletedPlayerBots) != 1 || report.Repaired {
+		t.Fatalf("Bad integrity report %+v", report)
+	}
+
+	report = model.CheckIntegrity(idb, true)
+	if report == nil || !report.Repaired || !report.ForeignKey {
+		t.Fatalf("Cannot repair database %+v", report)
+	}
+
+	report = model.CheckIntegrity(idb, false)
+	if len(report.OrphanBots) != 0 || len(report.DeletedPlayerBots) != 0 || !report.ForeignKey {
+		t.Errorf("Database not repaired %+v", report)
+	}
+
+	// bot of deleted player is restored with it
+	p := model.RestorePlayer(idb, 2)
+	if p == nil || len(p.Bots) != 1 {
+		t.Errorf("Cannot restore player with repaired bot %v", p)
+	}
+
+	// foreign key removes bots with their player
+	idb.Exec("DELETE FROM player WHERE pid = 1")
+	var count int64
+	idb.Unscoped().Model(&model.Bot{}).Where("player_id = 1").Count(&count)
+	if count != 0 {
+		t.Errorf("Bots not deleted with player, found %v", count)
+	}
+
+	if model.CheckIntegrity(nil, true) != nil {
+		t.Error("Do something with not existing DB")
+	}
+}
+
 // test defensive prog
 func TestErrorCase(t *testing.T) {
 	var badDB *gorm.DB = nil
@@ -244,6 +436,49 @@ func TestErrorCase(t *testing.T) {
 	}
 }
 
+func TestAudit(t *testing.T) {
+
+	e := model.AddAuditEntry(db, &model.AuditEntry{Actor: "admin", Action: model.AuditPlayerDelete, PlayerId: 12, Before: model.AuditSummary(&model.Player{Pid: 12, Name: "Old"})})
+	if e == nil || e.Aid == 0 {
+		t.Fatal("Cannot add audit entry")
+	}
+	model.AddAuditEntry(db, &model.AuditEntry{Actor: "joe", Action: model.AuditBotCreate, PlayerId: 1, BotId: 3})
+
+	entries := model.GetAuditEntries(db, model.AuditFilter{})
+	if len(entries) < 2 {
+		t.Errorf("Expected at least 2 audit entries, found %v", len(entries))
+	}
+
+	entries = model.GetAuditEntries(db, model.AuditFilter{Actor: "admin", Action: model.AuditPlayerDelete})
+	if len(entries) != 1 || entries[0].Before != `{"id":12,"name":"Old","version":0}` {
+		t.Errorf("Expected 1 audit entry for admin, found %v", entries)
+	}
+
+	entries = model.GetAuditEntries(db, model.AuditFilter{BotId: 3, Since: time.Now().Add(-time.Minute)})
+	if len(entries) != 1 || entries[0].Actor != "joe" {
+		t.Errorf("Expected 1 audit entry for bot 3, found %v", entries)
+	}
+
+	entries = model.GetAuditEntries(db, model.AuditFilter{Until: time.Now().Add(-time.Minute)})
+	if len(entries) != 0 {
+		t.Errorf("Expected no old audit entry, found %v", entries)
+	}
+
+	// audit log cannot be changed
+	result := db.Model(e).Update("actor", "nobody")
+	if result.Error == nil {
+		t.Error("Audit entry has been updated")
+	}
+	result = db.Delete(e)
+	if result.Error == nil {
+		t.Error("Audit entry has been deleted")
+	}
+
+	if model.AuditSummary((*model.Player)(nil)) != "" {
+		t.Error("Summary of nil object is not empty")
+	}
+}
+
 // Define struct to create a test database with a bad schema
 type SimplePlayer struct {
 	Pid  int64  `gorm:"primaryKey" json:"id"`
@@ -350,3 +585,345 @@ func TestDBConnectionError(t *testing.T) {
 		t.Error("Return non nil DB connection from invalid postgres DSN")
 	}
 }
+
+func TestGame(t *testing.T) {
+	p := model.AddPlayer(db, "Gamer")
+	b := model.AddBot(db, p.Pid, "GameBot", "gb.js", "// some code")
+
+	game := model.AddGame(db, &model.Game{PlayerId: p.Pid, BotId: b.Bid, BotVersion: b.Version, MazeHash: "sha256:00", State: "success", Steps: 7, Replay: `{"version":1}`})
+	if game == nil || game.Gid == 0 {
+		t.Fatal("Cannot add game")
+	}
+
+	games := model.GetGames(db, p.Pid, b.Bid)
+	if len(games) != 1 || games[0].Steps != 7 || games[0].Replay != "" {
+		t.Errorf("Unexpected games: %+v", games)
+	}
+	if got := model.GetGame(db, p.Pid, b.Bid, game.Gid); got == nil || got.Replay != `{"version":1}` {
+		t.Errorf("Game should have replay: %+v", got)
+	}
+	if model.GetGame(db, p.Pid+1, b.Bid, game.Gid) != nil {
+		t.Error("Game of another player should not be found")
+	}
+
+	// games are purged with their bot
+	model.PurgeBot(db, p.Pid, b.Bid)
+	if model.GetGame(db, p.Pid, b.Bid, game.Gid) != nil {
+		t.Error("Game should be purged with bot")
+	}
+	model.PurgePlayer(db, p.Pid)
+}
+
+func TestJob(t *testing.T) {
+	job := model.AddJob(db, &model.Job{Kind: "test", PlayerId: 1, BotId: 1, Payload: `{}`, MaxAttempts: 2})
+	now := time.Now()
+	if job == nil || job.State != model.JobQueued {
+		t.Fatalf("Cannot add job: %+v", job)
+	}
+	if model.LeaseJob(db, "w1", []string{"other"}, time.Minute, now) != nil {
+		t.Errorf("Job of another kind should not be leased")
+	}
+
+	leased := model.LeaseJob(db, "w1", []string{"test"}, time.Minute, now)
+	if leased == nil || leased.Jid != job.Jid || leased.State != model.JobRunning || leased.Attempts != 1 {
+		t.Fatalf("Job should be leased: %+v", leased)
+	}
+	if model.LeaseJob(db, "w2", []string{"test"}, time.Minute, now) != nil {
+		t.Errorf("Leased job cannot be leased twice")
+	}
+	if err := model.HeartbeatJob(db, job.Jid, "w2", now.Add(time.Minute), 1, 2); err != model.ErrLeaseLost {
+		t.Errorf("Only lease owner can extend lease: %v", err)
+	}
+	if err := model.HeartbeatJob(db, job.Jid, "w1", now.Add(time.Minute), 1, 2); err != nil {
+		t.Errorf("Cannot extend lease: %v", err)
+	}
+
+	// failed job runs again later
+	if err := model.FailJob(db, leased, "w1", "oops", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Cannot fail job: %v", err)
+	}
+	if model.LeaseJob(db, "w1", []string{"test"}, time.Minute, now) != nil {
+		t.Errorf("Job should wait for retry")
+	}
+	leased = model.LeaseJob(db, "w1", []string{"test"}, time.Minute, now.Add(2*time.Hour))
+	if leased == nil || leased.Attempts != 2 || leased.Error != "oops" || leased.Done != 1 {
+		t.Fatalf("Job should be retried: %+v", leased)
+	}
+
+	// expired lease of a job without attempt left kills it
+	if model.LeaseJob(db, "w2", []string{"test"}, time.Minute, now.Add(3*time.Hour)) != nil {
+		t.Errorf("Job without attempt left should not be leased again")
+	}
+	if j := model.GetJob(db, job.Jid); j.State != model.JobDead || j.FinishedAt == nil {
+		t.Errorf("Job should be dead: %+v", j)
+	}
+	if err := model.CompleteJob(db, job.Jid, "w1", `{}`, 2, 2); err != model.ErrLeaseLost {
+		t.Errorf("Dead job cannot complete: %v", err)
+	}
+
+	// dead letter is retried on request
+	if j := model.RetryJob(db, job.Jid); j == nil || j.State != model.JobQueued || j.Attempts != 0 {
+		t.Fatalf("Dead job should be queued again: %+v", j)
+	}
+	leased = model.LeaseJob(db, "w3", []string{"test"}, time.Minute, time.Now())
+	if leased == nil || model.CompleteJob(db, job.Jid, "w3", `{"ok":true}`, 2, 2) != nil {
+		t.Fatalf("Cannot complete job: %+v", leased)
+	}
+	if j := model.GetJob(db, job.Jid); j.State != model.JobSucceeded || j.Result != `{"ok":true}` || j.Error != "" {
+		t.Errorf("Job should succeed: %+v", j)
+	}
+	if model.RetryJob(db, job.Jid) != nil {
+		t.Errorf("Only dead jobs can be retried")
+	}
+	if jobs := model.GetJobs(db, 1, model.JobSucceeded); len(jobs) != 1 || jobs[0].Result != "" {
+		t.Errorf("Unexpected jobs %+v", jobs)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	p := model.AddPlayer(db, "Ranked")
+	fast := model.AddBot(db, p.Pid, "Fast", "fast.js", "// some code")
+	slow := model.AddBot(db, p.Pid, "Slow", "slow.js", "// some code")
+	late := model.AddBot(db, p.Pid, "Late", "late.js", "// some code")
+	play := func(bid int32, version int32, hash string, steps int, state string) {
+		model.AddGame(db, &model.Game{PlayerId: p.Pid, BotId: bid, BotVersion: version, MazeName: "lb", MazeHash: hash, State: state, Steps: steps, OptimalSteps: 4, Score: 4 / float64(steps)})
+	}
+	model.AddRankedMazes(db, []model.RankedMaze{{Hash: "sha256:lb1", Name: "lb"}, {Hash: "sha256:lb2", Name: "lb"}})
+	play(fast.Bid, 1, "sha256:lb1", 8, "success")
+	play(fast.Bid, 2, "sha256:lb1", 4, "success")
+	play(slow.Bid, 1, "sha256:lb1", 6, "success")
+	play(slow.Bid, 1, "sha256:lb1", 5, "failure")
+	play(late.Bid, 1, "sha256:lb1", 6, "success")
+	play(slow.Bid, 1, "sha256:lb2", 4, "success")
+	// mazes made up by players do not count for global leaderboard
+	play(late.Bid, 1, "sha256:lbprivate1", 4, "success")
+	play(late.Bid, 1, "sha256:lbprivate2", 4, "success")
+
+	board, err := model.MazeLeaderboard(db, "sha256:lb1", model.WindowAll, 1, 2)
+	if err != nil || board.Total != 3 || len(board.Entries) != 2 || board.MazeName != "lb" {
+		t.Fatalf("Unexpected leaderboard %+v %v", board, err)
+	}
+	first, second := board.Entries[0], board.Entries[1]
+	if first.BotId != fast.Bid || first.BotVersion != 2 || first.Steps != 4 || first.Rank != 1 || first.BotName != "Fast" || first.PlayerName != "Ranked" {
+		t.Errorf("Best revision of fastest bot should be first: %+v", first)
+	}
+	// same steps, earliest submission first
+	if second.BotId != slow.Bid || second.Steps != 6 {
+		t.Errorf("Earliest bot should be second: %+v", second)
+	}
+	board, _ = model.MazeLeaderboard(db, "sha256:lb1", model.WindowWeek, 2, 2)
+	if board.Since == nil || board.Page != 2 || len(board.Entries) != 1 || board.Entries[0].BotId != late.Bid || board.Entries[0].Rank != 3 {
+		t.Errorf("Unexpected second page %+v", board)
+	}
+	if _, err := model.MazeLeaderboard(db, "sha256:lb1", "year", 1, 2); !errors.Is(err, model.ErrUnknownWindow) {
+		t.Errorf("Unknown window should fail: %v", err)
+	}
+
+	// slow solved both mazes with a better mean score
+	global, err := model.GlobalLeaderboard(db, model.WindowAll, 1, 100)
+	ranks := map[int32]model.Ranking{}
+	for _, r := range global.Entries {
+		ranks[r.BotId] = r
+	}
+	if err != nil || ranks[slow.Bid].Mazes != 2 || ranks[late.Bid].Mazes != 1 || ranks[slow.Bid].Rank >= ranks[fast.Bid].Rank || ranks[fast.Bid].Rank >= ranks[late.Bid].Rank {
+		t.Errorf("Unexpected global leaderboard %+v %v", global, err)
+	}
+
+	// deleted bots leave the leaderboard
+	model.DeleteBot(db, p.Pid, fast.Bid)
+	board, _ = model.MazeLeaderboard(db, "sha256:lb1", model.WindowAll, 1, 10)
+	if board.Total != 2 || board.Entries[0].BotId != slow.Bid {
+		t.Errorf("Deleted bot should not be ranked %+v", board)
+	}
+	if n := model.RebuildLeaderboard(db); n < 4 {
+		t.Errorf("Leaderboard should be rebuilt from games, got %v entries", n)
+	}
+	mazes, _ := model.LeaderboardMazes(db, model.WindowAll)
+	if len(mazes) < 2 {
+		t.Errorf("Unexpected mazes %+v", mazes)
+	}
+
+	monday, _ := model.WindowStart(model.WindowWeek, time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC))
+	if !monday.Equal(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Week of Sunday should start on previous Monday: %v", monday)
+	}
+}
+
+func TestRating(t *testing.T) {
+	alice := model.AddPlayer(db, "RatedAlice")
+	bob := model.AddPlayer(db, "RatedBob")
+	a := model.AddBot(db, alice.Pid, "A", "a.js", "// some code")
+	a2 := model.AddBot(db, alice.Pid, "A2", "a2.js", "// some code")
+	b := model.AddBot(db, bob.Pid, "B", "b.js", "// some code")
+	play := func(pid int32, bid int32, version int32, steps int, state string) {
+		model.AddGame(db, &model.Game{PlayerId: pid, BotId: bid, BotVersion: version, MazeName: "rated", MazeHash: "sha256:rated", State: state, Steps: steps})
+	}
+
+	model.AddRankedMazes(db, []model.RankedMaze{{Hash: "sha256:rated", Name: "rated"}})
+
+	// games in mazes made up by players are not rated
+	model.AddGame(db, &model.Game{PlayerId: alice.Pid, BotId: a.Bid, BotVersion: 1, MazeHash: "sha256:unrated", State: "success", Steps: 2})
+	model.AddGame(db, &model.Game{PlayerId: bob.Pid, BotId: b.Bid, BotVersion: 1, MazeHash: "sha256:unrated", State: "failure", Steps: 2})
+	if r := model.GetRating(db, model.RatingBot, alice.Pid, a.Bid); r != nil && r.Matches != 0 {
+		t.Errorf("Game in unranked maze should not be rated %+v", r)
+	}
+
+	// first bot of a maze has no opponent
+	play(alice.Pid, a.Bid, 1, 10, "success")
+	if r := model.GetRating(db, model.RatingBot, alice.Pid, a.Bid); r == nil || r.Rating != 1500 || r.Matches != 0 || !r.Provisional {
+		t.Fatalf("Unrated bot should have provisional default rating %+v", r)
+	}
+
+	// b wins against a, then only first game of a revision is rated
+	play(bob.Pid, b.Bid, 1, 8, "success")
+	play(bob.Pid, b.Bid, 1, 20, "failure")
+	ra := model.GetRating(db, model.RatingBot, alice.Pid, a.Bid)
+	rb := model.GetRating(db, model.RatingBot, bob.Pid, b.Bid)
+	if rb.Rating <= 1500 || ra.Rating >= 1500 || rb.Matches != 1 || ra.Matches != 1 || rb.PlayerId != bob.Pid {
+		t.Errorf("Winner should gain rating %+v, loser should lose %+v", rb, ra)
+	}
+	if pb := model.GetRating(db, model.RatingPlayer, bob.Pid, bob.Pid); pb.Rating <= 1500 || pb.Matches != 1 {
+		t.Errorf("Player of winner should gain rating %+v", pb)
+	}
+
+	// a2 fails: it loses against a and b, only b counts for its player
+	play(alice.Pid, a2.Bid, 1, 3, "failure")
+	if r := model.GetRating(db, model.RatingBot, alice.Pid, a2.Bid); r.Matches != 2 || r.Rating >= 1500 {
+		t.Errorf("Failing bot should lose against each opponent %+v", r)
+	}
+	if pa := model.GetRating(db, model.RatingPlayer, alice.Pid, alice.Pid); pa.Matches != 2 {
+		t.Errorf("Bots of the same player should not be compared for player rating %+v", pa)
+	}
+
+	history := model.GetRatingHistory(db, model.RatingBot, b.Bid)
+	if len(history) != 2 || history[0].Wins != 1 || history[0].BotId != b.Bid || history[1].Wins != 1 || history[1].BotId != a2.Bid || history[1].GameId == 0 {
+		t.Errorf("Unexpected history %+v", history)
+	}
+
+	// ratings are rebuilt in the same order
+	before := model.GetRating(db, model.RatingBot, bob.Pid, b.Bid)
+	model.RebuildLeaderboard(db)
+	if r := model.GetRating(db, model.RatingBot, bob.Pid, b.Bid); math.Abs(r.Rating-before.Rating) > 1e-9 || r.Matches != 2 {
+		t.Errorf("Rebuilt rating %+v differs from %+v", r, before)
+	}
+
+	model.PurgePlayer(db, bob.Pid)
+	if len(model.GetRatingHistory(db, model.RatingBot, b.Bid)) != 0 || len(model.GetRatingHistory(db, model.RatingPlayer, bob.Pid)) != 0 {
+		t.Errorf("Ratings should be purged with their player")
+	}
+}
+
+func TestTournament(t *testing.T) {
+	p := model.AddPlayer(db, "Entrant")
+	q := model.AddPlayer(db, "Opponent")
+	bp := model.AddBot(db, p.Pid, "First", "first.js", "// some code")
+	bq := model.AddBot(db, q.Pid, "Second", "second.js", "// some code")
+	now := time.Now().UTC()
+
+	if _, err := model.AddTournament(db, &model.Tournament{Name: "Bad", Format: "knockout"}, []model.TournamentMaze{{Name: "m"}}); !errors.Is(err, model.ErrUnknownFormat) {
+		t.Errorf("Unknown format should fail: %v", err)
+	}
+	cup, err := model.AddTournament(db, &model.Tournament{Name: "Cup", Format: model.TournamentSwiss, EntryDeadline: now.Add(time.Hour)},
+		[]model.TournamentMaze{{Name: "first", Maze: "x X"}, {Name: "second", Maze: "x X"}})
+	if err != nil || cup.State != model.TournamentOpen || len(model.GetTournamentMazes(db, cup.Tid)) != 2 {
+		t.Fatalf("Cannot add tournament %+v %v", cup, err)
+	}
+
+	// registering again replaces the entry
+	model.RegisterTournamentEntry(db, cup.Tid, model.GetBotCode(db, p.Pid, bp.Bid), now)
+	model.UpdateBot(db, p.Pid, bp.Bid, model.AnyVersion, "", "", "", "// better code")
+	entry, err := model.RegisterTournamentEntry(db, cup.Tid, model.GetBotCode(db, p.Pid, bp.Bid), now)
+	if err != nil || entry.BotVersion != 2 || entry.Botcode != "// better code" {
+		t.Errorf("Entry should be replaced %+v %v", entry, err)
+	}
+	model.RegisterTournamentEntry(db, cup.Tid, model.GetBotCode(db, q.Pid, bq.Bid), now)
+	if err := model.WithdrawTournamentEntry(db, cup.Tid, 999999, now); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Withdrawing a missing entry should fail: %v", err)
+	}
+	if entries := model.GetTournamentEntries(db, cup.Tid); len(entries) != 2 {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	if err := model.StartTournament(db, cup.Tid, 2, now); err != nil {
+		t.Fatalf("Cannot start tournament: %v", err)
+	}
+	if _, err := model.RegisterTournamentEntry(db, cup.Tid, model.GetBotCode(db, q.Pid, bq.Bid), now); !errors.Is(err, model.ErrEntriesClosed) {
+		t.Errorf("Started tournament should not accept entries: %v", err)
+	}
+	err = model.ScheduleTournamentRound(db, cup.Tid, 1, []model.TournamentMatch{{PlayerA: p.Pid, BotA: bp.Bid, PlayerB: q.Pid, BotB: bq.Bid}})
+	if err != nil || model.ScheduleTournamentRound(db, cup.Tid, 1, nil) != model.ErrTournamentState {
+		t.Errorf("Round should be scheduled once: %v", err)
+	}
+	match := model.GetTournamentMatches(db, cup.Tid, 1)[0]
+	match.Result = model.MatchWinB
+	model.RecordTournamentMatch(db, &match)
+	model.ScheduleTournamentRound(db, cup.Tid, 2, []model.TournamentMatch{{PlayerA: p.Pid, BotA: bp.Bid, Result: model.MatchBye}})
+
+	standings, err := model.TournamentStandings(db, cup.Tid)
+	if err != nil || len(standings) != 2 || standings[0].PlayerId != q.Pid || standings[0].Wins != 1 || standings[0].Buchholz != 1 ||
+		standings[1].Points != 1 || standings[1].Byes != 1 || standings[1].Losses != 1 || standings[1].BotName != "First" {
+		t.Errorf("Unexpected standings %+v %v", standings, err)
+	}
+	if model.FinishTournament(db, cup.Tid, now) != nil || model.CancelTournament(db, cup.Tid, now) != model.ErrTournamentState {
+		t.Errorf("Finished tournament should not be cancelled")
+	}
+
+	model.PurgeBot(db, p.Pid, bp.Bid)
+	if entries := model.GetTournamentEntries(db, cup.Tid); len(entries) != 1 {
+		t.Errorf("Entry should be purged with its bot %+v", entries)
+	}
+}
+
+func TestRace(t *testing.T) {
+	p := model.AddPlayer(db, "Racer")
+	q := model.AddPlayer(db, "Rival")
+	bp := model.AddBot(db, p.Pid, "Quick", "quick.js", "// some code")
+	bq := model.AddBot(db, q.Pid, "Slow", "slow.js", "// some code")
+
+	if _, err := model.AddRace(db, &model.Race{MazeName: "empty"}, nil, nil); err == nil {
+		t.Errorf("Race without participants should fail")
+	}
+	games := func() []*model.Game {
+		return []*model.Game{
+			{PlayerId: p.Pid, BotId: bp.Bid, BotVersion: 1, MazeName: "basic", MazeHash: "abc", State: "success", Steps: 7},
+			{PlayerId: q.Pid, BotId: bq.Bid, BotVersion: 1, MazeName: "basic", MazeHash: "abc", State: "failure", Steps: 12},
+		}
+	}
+	race, err := model.AddRace(db, &model.Race{MazeName: "basic", MazeHash: "abc", Sight: true, Ticks: 12}, games(), []model.RaceParticipant{
+		{PlayerId: p.Pid, BotId: bp.Bid, BotVersion: 1, Rank: 1, State: "success", Steps: 7},
+		{PlayerId: q.Pid, BotId: bq.Bid, BotVersion: 1, State: "failure", Steps: 12},
+	})
+	if err != nil || race.Rid == 0 {
+		t.Fatalf("Cannot add race %+v %v", race, err)
+	}
+
+	// games are not kept when race cannot be added
+	_, err = model.AddRace(db, &model.Race{Rid: race.Rid, MazeName: "basic"}, games(), []model.RaceParticipant{
+		{PlayerId: p.Pid, BotId: bp.Bid, BotVersion: 1},
+		{PlayerId: q.Pid, BotId: bq.Bid, BotVersion: 1},
+	})
+	if err == nil {
+		t.Errorf("Race with existing id should fail")
+	}
+	if games := model.GetGames(db, p.Pid, bp.Bid); len(games) != 1 {
+		t.Errorf("Games of failed race should be rolled back %+v", games)
+	}
+	if got := model.GetRace(db, race.Rid); got == nil || !got.Sight || got.Ticks != 12 {
+		t.Errorf("Unexpected race %+v", got)
+	}
+	participants := model.GetRaceParticipants(db, race.Rid)
+	if len(participants) != 2 || participants[1].Lane != 1 || participants[1].PlayerId != q.Pid || participants[0].Rank != 1 || participants[0].GameId == 0 {
+		t.Errorf("Unexpected participants %+v", participants)
+	}
+	if races := model.GetRaces(db, q.Pid); len(races) != 1 || races[0].Rid != race.Rid {
+		t.Errorf("Race of player should be listed %+v", races)
+	}
+	if races := model.GetRaces(db, 999999); len(races) != 0 {
+		t.Errorf("Unknown player should have no race %+v", races)
+	}
+
+	model.PurgeBot(db, q.Pid, bq.Bid)
+	if participants := model.GetRaceParticipants(db, race.Rid); len(participants) != 1 {
+		t.Errorf("Participant should be purged with its bot %+v", participants)
+	}
+}
@@ -0,0 +1,144 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/*
+	Race of several bots started together from the entry of a maze, stepping in lockstep.
+	With Sight, bots see the others in their room descriptions. Ticks is the number of
+	lockstep rounds played.
+*/
+type Race struct {
+	Rid       int32     `gorm:"primaryKey" json:"id"`
+	MazeName  string    `json:"maze_name"`
+	MazeHash  string    `gorm:"index" json:"maze_hash"`
+	Sight     bool      `json:"sight"`
+	Seed      int64     `json:"seed"`
+	Ticks     int       `json:"ticks"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Race) TableName() string {
+	return "race"
+}
+
+/*
+	RaceParticipant is the bot revision racing in a lane with the game it played,
+	its replay is the replay of the game. Rank is 0 when the bot did not exit.
+*/
+type RaceParticipant struct {
+	RaceId     int32  `gorm:"primaryKey;autoIncrement:false" json:"race_id"`
+	Lane       int    `gorm:"primaryKey;autoIncrement:false" json:"lane"`
+	PlayerId   int32  `gorm:"index" json:"player_id"`
+	BotId      int32  `gorm:"index" json:"bot_id"`
+	BotVersion int32  `json:"bot_version"`
+	GameId     int32  `json:"game_id"`
+	Rank       int    `json:"rank"`
+	State      string `json:"state"`
+	Steps      int    `json:"steps"`
+}
+
+func (RaceParticipant) TableName() string {
+	return "race_participant"
+}
+
+/*
+	Add a race with its participants and the game played by each of them, like AddGame,
+	all or nothing. Lanes are set from the order of participants, games are in the same order.
+*/
+func AddRace(db *gorm.DB, race *Race, games []*Game, participants []RaceParticipant) (*Race, error) {
+	if db == nil {
+		return nil, errors.New("no database")
+	}
+	if len(participants) == 0 {
+		return nil, errors.New("race needs participants")
+	}
+	if len(games) != len(participants) {
+		return nil, errors.New("race needs a game per participant")
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i, game := range games {
+			err := addGame(tx, game)
+			if err != nil {
+				return err
+			}
+			participants[i].GameId = game.Gid
+		}
+		err := tx.Create(race).Error
+		if err != nil {
+			return err
+		}
+		for i := range participants {
+			participants[i].RaceId = race.Rid
+			participants[i].Lane = i
+		}
+		return tx.Create(&participants).Error
+	})
+	if err != nil {
+		fmt.Printf("Error AddRace(%v): %v\n", race.MazeName, err)
+		return nil, err
+	}
+	return race, nil
+}
+
+/*
+	Get races, most recent first, only races of a player when pid is not 0
+*/
+func GetRaces(db *gorm.DB, pid int32) []Race {
+	if db == nil {
+		return nil
+	}
+
+	query := db.Order("rid desc")
+	if pid != 0 {
+		query = query.Where("rid IN (?)", db.Model(&RaceParticipant{}).Select("race_id").Where("player_id = ?", pid))
+	}
+	races := []Race{}
+	result := query.Find(&races)
+	if result.Error != nil {
+		fmt.Printf("Error GetRaces(%v): %v\n", pid, result.Error)
+		return nil
+	}
+	return races
+}
+
+func GetRace(db *gorm.DB, rid int32) *Race {
+	if db == nil {
+		return nil
+	}
+
+	var race Race
+	result := db.First(&race, rid)
+	if result.Error != nil {
+		fmt.Printf("Error GetRace(%v): %v\n", rid, result.Error)
+		return nil
+	}
+	return &race
+}
+
+/*
+	Get participants of a race in lane order, participants of purged bots are gone
+*/
+func GetRaceParticipants(db *gorm.DB, rid int32) []RaceParticipant {
+	if db == nil {
+		return nil
+	}
+
+	participants := []RaceParticipant{}
+	result := db.Where("race_id = ?", rid).Order("lane").Find(&participants)
+	if result.Error != nil {
+		fmt.Printf("Error GetRaceParticipants(%v): %v\n", rid, result.Error)
+		return nil
+	}
+	return participants
+}
+
+func purgeRaceParticipants(tx *gorm.DB, bots interface{}) error {
+	return tx.Where("bot_id IN (?)", bots).Delete(&RaceParticipant{}).Error
+}
@@ -0,0 +1,295 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"jc.org/playermgr/rating"
+)
+
+// kinds of rated subjects
+const (
+	RatingBot    = "bot"
+	RatingPlayer = "player"
+)
+
+// a rating stays provisional until it has enough matches and a low deviation
+var (
+	ProvisionalMatches   = 10
+	ProvisionalDeviation = 110.0
+)
+
+// deviation of ratings grows for each period without match
+var RatingPeriod = 7 * 24 * time.Hour
+
+/*
+	Rating is the Glicko-2 rating of a bot or a player. Bots running the same
+	maze are compared pairwise: fewer steps wins, failing loses.
+*/
+type Rating struct {
+	Kind        string    `gorm:"primaryKey" json:"kind"`
+	SubjectId   int32     `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	PlayerId    int32     `gorm:"index" json:"player_id"`
+	Rating      float64   `json:"rating"`
+	Deviation   float64   `json:"deviation"`
+	Volatility  float64   `json:"volatility"`
+	Matches     int       `json:"matches"`
+	Provisional bool      `gorm:"-" json:"provisional"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime:false" json:"updated_at"`
+}
+
+func (Rating) TableName() string {
+	return "rating"
+}
+
+/*
+	RatingHistory is a rating after a rated game, of the subject or of an opponent.
+	BotId and BotVersion are the bot revision which played the game.
+*/
+type RatingHistory struct {
+	Hid        int32     `gorm:"primaryKey" json:"-"`
+	Kind       string    `gorm:"index:idx_rating_history_subject" json:"-"`
+	SubjectId  int32     `gorm:"index:idx_rating_history_subject" json:"-"`
+	MazeHash   string    `json:"maze_hash"`
+	MazeName   string    `json:"maze_name,omitempty"`
+	GameId     int32     `json:"game_id"`
+	BotId      int32     `json:"bot_id"`
+	BotVersion int32     `json:"bot_version"`
+	Rating     float64   `json:"rating"`
+	Deviation  float64   `json:"deviation"`
+	Volatility float64   `json:"volatility"`
+	Wins       int       `json:"wins"`
+	Draws      int       `json:"draws"`
+	Losses     int       `json:"losses"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (RatingHistory) TableName() string {
+	return "rating_history"
+}
+
+func (r *Rating) value() rating.Rating {
+	return rating.Rating{Rating: r.Rating, Deviation: r.Deviation, Volatility: r.Volatility}
+}
+
+func (r *Rating) set(v rating.Rating) {
+	r.Rating, r.Deviation, r.Volatility = v.Rating, v.Deviation, v.Volatility
+	r.Provisional = r.Matches < ProvisionalMatches || r.Deviation > ProvisionalDeviation
+}
+
+/*
+	Stored rating at date now, deviation grown by idle periods,
+	default rating when the subject has never been rated
+*/
+func loadRating(db *gorm.DB, kind string, id int32, pid int32, now time.Time) (*Rating, error) {
+	r := &Rating{}
+	result := db.Where("kind = ? AND subject_id = ?", kind, id).Limit(1).Find(r)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		r = &Rating{Kind: kind, SubjectId: id, PlayerId: pid}
+		r.set(rating.Default)
+		return r, nil
+	}
+	periods := math.Floor(float64(now.Sub(r.UpdatedAt)) / float64(RatingPeriod))
+	r.set(rating.Idle(r.value(), periods))
+	return r, nil
+}
+
+// score of game against best game of an opponent in the same maze
+func gameScore(game *Game, opponent *namedEntry) float64 {
+	// same state as engine.Success
+	if game.State != "success" {
+		return rating.Loss
+	}
+	return rating.Compare(game.Steps, opponent.Steps)
+}
+
+// update rating with outcomes of game and record it in history
+func applyOutcomes(tx *gorm.DB, r *Rating, outcomes []rating.Outcome, game *Game) error {
+	history := RatingHistory{
+		Kind:       r.Kind,
+		SubjectId:  r.SubjectId,
+		MazeHash:   game.MazeHash,
+		MazeName:   game.MazeName,
+		GameId:     game.Gid,
+		BotId:      game.BotId,
+		BotVersion: game.BotVersion,
+		CreatedAt:  game.CreatedAt.UTC(),
+	}
+	for _, o := range outcomes {
+		switch o.Score {
+		case rating.Win:
+			history.Wins++
+		case rating.Draw:
+			history.Draws++
+		default:
+			history.Losses++
+		}
+	}
+
+	r.Matches += len(outcomes)
+	r.set(rating.Update(r.value(), outcomes))
+	r.UpdatedAt = game.CreatedAt.UTC()
+	err := tx.Save(r).Error
+	if err != nil {
+		return err
+	}
+	history.Rating, history.Deviation, history.Volatility = r.Rating, r.Deviation, r.Volatility
+	return tx.Create(&history).Error
+}
+
+/*
+	Rate the first game of a bot revision in a ranked maze against the best game of
+	each other bot in the maze. The bot, its opponents and their players are updated,
+	bots of the same player are not compared for player ratings.
+*/
+func rateGame(tx *gorm.DB, game *Game) error {
+	if game.MazeHash == "" {
+		return nil
+	}
+	ranked, err := isRanked(tx, game.MazeHash)
+	if err != nil || !ranked {
+		return err
+	}
+
+	var rated int64
+	err = tx.Model(&RatingHistory{}).
+		Where("kind = ? AND subject_id = ? AND bot_id = ? AND bot_version = ? AND maze_hash = ?", RatingBot, game.BotId, game.BotId, game.BotVersion, game.MazeHash).
+		Count(&rated).Error
+	if err != nil || rated > 0 {
+		return err
+	}
+
+	entries, err := leaderboardEntries(tx, game.MazeHash, time.Time{}, true)
+	if err != nil {
+		return err
+	}
+	opponents := []namedEntry{}
+	for _, e := range bestEntries(entries)[game.MazeHash] {
+		if e.BotId != game.BotId {
+			opponents = append(opponents, e)
+		}
+	}
+	if len(opponents) == 0 {
+		return nil
+	}
+
+	// all outcomes use ratings from before the game
+	now := game.CreatedAt.UTC()
+	bot, err := loadRating(tx, RatingBot, game.BotId, game.PlayerId, now)
+	if err != nil {
+		return err
+	}
+	player, err := loadRating(tx, RatingPlayer, game.PlayerId, game.PlayerId, now)
+	if err != nil {
+		return err
+	}
+	botOutcomes, playerOutcomes := []rating.Outcome{}, []rating.Outcome{}
+	opponentBots, opponentPlayers := []*Rating{}, []*Rating{}
+	botReplies, playerReplies := map[*Rating][]rating.Outcome{}, map[*Rating][]rating.Outcome{}
+	players := map[int32]*Rating{}
+	for i := range opponents {
+		e := &opponents[i]
+		score := gameScore(game, e)
+
+		o, err := loadRating(tx, RatingBot, e.BotId, e.PlayerId, now)
+		if err != nil {
+			return err
+		}
+		botOutcomes = append(botOutcomes, rating.Outcome{Opponent: o.value(), Score: score})
+		opponentBots = append(opponentBots, o)
+		botReplies[o] = []rating.Outcome{{Opponent: bot.value(), Score: 1 - score}}
+
+		if e.PlayerId == game.PlayerId {
+			continue
+		}
+		p := players[e.PlayerId]
+		if p == nil {
+			p, err = loadRating(tx, RatingPlayer, e.PlayerId, e.PlayerId, now)
+			if err != nil {
+				return err
+			}
+			players[e.PlayerId] = p
+			opponentPlayers = append(opponentPlayers, p)
+		}
+		playerOutcomes = append(playerOutcomes, rating.Outcome{Opponent: p.value(), Score: score})
+		playerReplies[p] = append(playerReplies[p], rating.Outcome{Opponent: player.value(), Score: 1 - score})
+	}
+
+	err = applyOutcomes(tx, bot, botOutcomes, game)
+	if err != nil {
+		return err
+	}
+	for _, o := range opponentBots {
+		err = applyOutcomes(tx, o, botReplies[o], game)
+		if err != nil {
+			return err
+		}
+	}
+	if len(playerOutcomes) == 0 {
+		return nil
+	}
+	err = applyOutcomes(tx, player, playerOutcomes, game)
+	if err != nil {
+		return err
+	}
+	for _, p := range opponentPlayers {
+		err = applyOutcomes(tx, p, playerReplies[p], game)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Current rating of a bot or a player, provisional default rating when not yet rated.
+	pid is the player of the bot, id is pid for a player.
+*/
+func GetRating(db *gorm.DB, kind string, pid int32, id int32) *Rating {
+	if db == nil {
+		return nil
+	}
+
+	r, err := loadRating(db, kind, id, pid, time.Now().UTC())
+	if err != nil {
+		fmt.Printf("Error GetRating(%v %v): %v\n", kind, id, err)
+		return nil
+	}
+	return r
+}
+
+/*
+	Ratings of a bot or a player after each rated game, oldest first
+*/
+func GetRatingHistory(db *gorm.DB, kind string, id int32) []RatingHistory {
+	if db == nil {
+		return nil
+	}
+
+	history := []RatingHistory{}
+	result := db.Where("kind = ? AND subject_id = ?", kind, id).Order("hid").Find(&history)
+	if result.Error != nil {
+		fmt.Printf("Error GetRatingHistory(%v %v): %v\n", kind, id, result.Error)
+		return nil
+	}
+	return history
+}
+
+// delete ratings and history of subjects
+func purgeRatings(tx *gorm.DB, kind string, ids interface{}) error {
+	err := tx.Where("kind = ? AND subject_id IN (?)", kind, ids).Delete(&RatingHistory{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("kind = ? AND subject_id IN (?)", kind, ids).Delete(&Rating{}).Error
+}
+
+// bot ratings are permanently deleted with their bot
+func purgeBotRatings(tx *gorm.DB, bots interface{}) error {
+	return purgeRatings(tx, RatingBot, bots)
+}
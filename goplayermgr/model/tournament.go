@@ -0,0 +1,519 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// tournament formats
+const (
+	TournamentRoundRobin = "round-robin"
+	TournamentSwiss      = "swiss"
+)
+
+// tournament states, entries are accepted while open and before the deadline
+const (
+	TournamentOpen      = "open"
+	TournamentRunning   = "running"
+	TournamentFinished  = "finished"
+	TournamentCancelled = "cancelled"
+)
+
+// match results, a bye is a match without opponent
+const (
+	MatchPending = "pending"
+	MatchWinA    = "a"
+	MatchWinB    = "b"
+	MatchDraw    = "draw"
+	MatchBye     = "bye"
+)
+
+var (
+	ErrUnknownFormat   = errors.New("unknown tournament format, use round-robin or swiss")
+	ErrEntriesClosed   = errors.New("tournament entries are closed")
+	ErrTournamentState = errors.New("tournament state does not allow it")
+)
+
+/*
+	Tournament between bot revisions registered by players, over a pool of mazes.
+	Rounds is the number of rounds, set when the tournament starts, Round the last scheduled one.
+*/
+type Tournament struct {
+	Tid           int32      `gorm:"primaryKey" json:"id"`
+	Name          string     `json:"name"`
+	Format        string     `json:"format"`
+	Suite         string     `json:"suite"`
+	Rounds        int        `json:"rounds"`
+	Round         int        `json:"round"`
+	State         string     `gorm:"index" json:"state"`
+	EntryDeadline time.Time  `json:"entry_deadline"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+func (Tournament) TableName() string {
+	return "tournament"
+}
+
+/*
+	TournamentMaze is a maze of the pool, copied from its suite when the
+	tournament is created. Maze is the ASCII maze.
+*/
+type TournamentMaze struct {
+	TournamentId int32  `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	Position     int    `gorm:"primaryKey;autoIncrement:false" json:"position"`
+	Name         string `json:"name"`
+	Hash         string `json:"hash"`
+	Maze         string `json:"-"`
+}
+
+func (TournamentMaze) TableName() string {
+	return "tournament_maze"
+}
+
+/*
+	TournamentEntry is the bot revision registered by a player, its code is
+	copied so that later changes of the bot do not change the entry
+*/
+type TournamentEntry struct {
+	TournamentId int32     `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	PlayerId     int32     `gorm:"primaryKey;autoIncrement:false" json:"player_id"`
+	BotId        int32     `gorm:"index" json:"bot_id"`
+	BotVersion   int32     `json:"bot_version"`
+	Language     string    `json:"language"`
+	Filename     string    `json:"-"`
+	URL          string    `json:"-"`
+	Secret       string    `json:"-"`
+	Botcode      string    `json:"-"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+func (TournamentEntry) TableName() string {
+	return "tournament_entry"
+}
+
+// code of the registered bot revision
+func (e *TournamentEntry) Code() *BotCode {
+	return &BotCode{
+		Bid:      e.BotId,
+		PlayerId: e.PlayerId,
+		Version:  e.BotVersion,
+		Language: e.Language,
+		Filename: e.Filename,
+		URL:      e.URL,
+		Secret:   e.Secret,
+		Botcode:  e.Botcode,
+	}
+}
+
+/*
+	TournamentMatch is a match of a round: both bots play the maze of the round,
+	fewer steps wins and failing loses. PlayerB is 0 for a bye.
+*/
+type TournamentMatch struct {
+	Mid          int32      `gorm:"primaryKey" json:"id"`
+	TournamentId int32      `gorm:"index" json:"tournament_id"`
+	Round        int        `json:"round"`
+	MazePosition int        `json:"maze_position"`
+	PlayerA      int32      `json:"player_a"`
+	BotA         int32      `json:"bot_a"`
+	GameA        int32      `json:"game_a,omitempty"`
+	StateA       string     `json:"state_a,omitempty"`
+	StepsA       int        `json:"steps_a,omitempty"`
+	PlayerB      int32      `json:"player_b,omitempty"`
+	BotB         int32      `json:"bot_b,omitempty"`
+	GameB        int32      `json:"game_b,omitempty"`
+	StateB       string     `json:"state_b,omitempty"`
+	StepsB       int        `json:"steps_b,omitempty"`
+	Result       string     `json:"result"`
+	PlayedAt     *time.Time `json:"played_at,omitempty"`
+}
+
+func (TournamentMatch) TableName() string {
+	return "tournament_match"
+}
+
+/*
+	Standing is the place of a player in a tournament: a win or a bye scores 1 point,
+	a draw 0.5. Buchholz is the sum of points of the opponents met.
+*/
+type Standing struct {
+	Rank       int     `json:"rank"`
+	PlayerId   int32   `json:"player_id"`
+	PlayerName string  `json:"player_name"`
+	BotId      int32   `json:"bot_id"`
+	BotName    string  `json:"bot_name"`
+	BotVersion int32   `json:"bot_version"`
+	Points     float64 `json:"points"`
+	Wins       int     `json:"wins"`
+	Draws      int     `json:"draws"`
+	Losses     int     `json:"losses"`
+	Byes       int     `json:"byes"`
+	Buchholz   float64 `json:"buchholz"`
+}
+
+/*
+	Create an open tournament with its maze pool, mazes of the pool are ranked
+*/
+func AddTournament(db *gorm.DB, tournament *Tournament, mazes []TournamentMaze) (*Tournament, error) {
+	if db == nil {
+		return nil, errors.New("no database")
+	}
+	if tournament.Format != TournamentRoundRobin && tournament.Format != TournamentSwiss {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, tournament.Format)
+	}
+	if len(mazes) == 0 {
+		return nil, errors.New("tournament needs at least one maze")
+	}
+
+	tournament.State = TournamentOpen
+	tournament.EntryDeadline = tournament.EntryDeadline.UTC()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(tournament).Error
+		if err != nil {
+			return err
+		}
+		ranked := []RankedMaze{}
+		for i := range mazes {
+			mazes[i].TournamentId = tournament.Tid
+			mazes[i].Position = i
+			ranked = append(ranked, RankedMaze{Hash: mazes[i].Hash, Name: mazes[i].Name})
+		}
+		err = addRankedMazes(tx, ranked)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&mazes).Error
+	})
+	if err != nil {
+		fmt.Printf("Error AddTournament(%v): %v\n", tournament.Name, err)
+		return nil, err
+	}
+	return tournament, nil
+}
+
+/*
+	Get tournaments, optionally of a state, most recent first
+*/
+func GetTournaments(db *gorm.DB, state string) []Tournament {
+	if db == nil {
+		return nil
+	}
+
+	query := db.Order("tid desc")
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	tournaments := []Tournament{}
+	result := query.Find(&tournaments)
+	if result.Error != nil {
+		fmt.Printf("Error GetTournaments(%v): %v\n", state, result.Error)
+		return nil
+	}
+	return tournaments
+}
+
+func GetTournament(db *gorm.DB, tid int32) *Tournament {
+	if db == nil {
+		return nil
+	}
+
+	var tournament Tournament
+	result := db.First(&tournament, tid)
+	if result.Error != nil {
+		fmt.Printf("Error GetTournament(%v): %v\n", tid, result.Error)
+		return nil
+	}
+	return &tournament
+}
+
+/*
+	Get maze pool of a tournament with the mazes, in order
+*/
+func GetTournamentMazes(db *gorm.DB, tid int32) []TournamentMaze {
+	if db == nil {
+		return nil
+	}
+
+	mazes := []TournamentMaze{}
+	result := db.Where("tournament_id = ?", tid).Order("position").Find(&mazes)
+	if result.Error != nil {
+		fmt.Printf("Error GetTournamentMazes(%v): %v\n", tid, result.Error)
+		return nil
+	}
+	return mazes
+}
+
+// tournament accepting entries at date now
+func openTournament(tx *gorm.DB, tid int32, now time.Time) error {
+	var tournament Tournament
+	err := tx.First(&tournament, tid).Error
+	if err != nil {
+		return err
+	}
+	if tournament.State != TournamentOpen || now.UTC().After(tournament.EntryDeadline) {
+		return ErrEntriesClosed
+	}
+	return nil
+}
+
+/*
+	Register current revision of a bot for its player, a player registering
+	again before the deadline replaces its entry. Returns ErrEntriesClosed
+	when the tournament is not open.
+*/
+func RegisterTournamentEntry(db *gorm.DB, tid int32, code *BotCode, now time.Time) (*TournamentEntry, error) {
+	entry := &TournamentEntry{
+		TournamentId: tid,
+		PlayerId:     code.PlayerId,
+		BotId:        code.Bid,
+		BotVersion:   code.Version,
+		Language:     code.Language,
+		Filename:     code.Filename,
+		URL:          code.URL,
+		Secret:       code.Secret,
+		Botcode:      code.Botcode,
+		RegisteredAt: now.UTC(),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := openTournament(tx, tid, now)
+		if err != nil {
+			return err
+		}
+		return tx.Save(entry).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+/*
+	Withdraw entry of a player before the deadline
+*/
+func WithdrawTournamentEntry(db *gorm.DB, tid int32, pid int32, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := openTournament(tx, tid, now)
+		if err != nil {
+			return err
+		}
+		result := tx.Where("tournament_id = ? AND player_id = ?", tid, pid).Delete(&TournamentEntry{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+/*
+	Get entries of a tournament with their code, by player
+*/
+func GetTournamentEntries(db *gorm.DB, tid int32) []TournamentEntry {
+	if db == nil {
+		return nil
+	}
+
+	entries := []TournamentEntry{}
+	result := db.Where("tournament_id = ?", tid).Order("player_id").Find(&entries)
+	if result.Error != nil {
+		fmt.Printf("Error GetTournamentEntries(%v): %v\n", tid, result.Error)
+		return nil
+	}
+	return entries
+}
+
+// change state of a tournament when it is in one of states
+func setTournamentState(db *gorm.DB, tid int32, states []string, update map[string]interface{}) error {
+	result := db.Model(&Tournament{}).Where("tid = ? AND state IN ?", tid, states).Updates(update)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTournamentState
+	}
+	return nil
+}
+
+/*
+	Close entries of an open tournament and start it with a number of rounds,
+	returns ErrTournamentState when it is not open, e.g. already started by another server
+*/
+func StartTournament(db *gorm.DB, tid int32, rounds int, now time.Time) error {
+	return setTournamentState(db, tid, []string{TournamentOpen},
+		map[string]interface{}{"state": TournamentRunning, "rounds": rounds, "started_at": now.UTC()})
+}
+
+func FinishTournament(db *gorm.DB, tid int32, now time.Time) error {
+	return setTournamentState(db, tid, []string{TournamentRunning},
+		map[string]interface{}{"state": TournamentFinished, "finished_at": now.UTC()})
+}
+
+/*
+	Cancel an open or running tournament, played matches are kept
+*/
+func CancelTournament(db *gorm.DB, tid int32, now time.Time) error {
+	return setTournamentState(db, tid, []string{TournamentOpen, TournamentRunning},
+		map[string]interface{}{"state": TournamentCancelled, "finished_at": now.UTC()})
+}
+
+/*
+	Schedule matches of the round following the last scheduled one,
+	returns ErrTournamentState when the round has already been scheduled
+*/
+func ScheduleTournamentRound(db *gorm.DB, tid int32, round int, matches []TournamentMatch) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Tournament{}).Where("tid = ? AND state = ? AND round = ?", tid, TournamentRunning, round-1).Update("round", round)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTournamentState
+		}
+		for i := range matches {
+			matches[i].TournamentId = tid
+			matches[i].Round = round
+			if matches[i].Result == "" {
+				matches[i].Result = MatchPending
+			}
+		}
+		if len(matches) == 0 {
+			return nil
+		}
+		return tx.Create(&matches).Error
+	})
+}
+
+/*
+	Get matches of a tournament, of a round when round is not 0
+*/
+func GetTournamentMatches(db *gorm.DB, tid int32, round int) []TournamentMatch {
+	if db == nil {
+		return nil
+	}
+
+	query := db.Where("tournament_id = ?", tid).Order("round, mid")
+	if round != 0 {
+		query = query.Where("round = ?", round)
+	}
+	matches := []TournamentMatch{}
+	result := query.Find(&matches)
+	if result.Error != nil {
+		fmt.Printf("Error GetTournamentMatches(%v): %v\n", tid, result.Error)
+		return nil
+	}
+	return matches
+}
+
+func RecordTournamentMatch(db *gorm.DB, match *TournamentMatch) error {
+	return db.Save(match).Error
+}
+
+// entry with names of its bot and player, they are kept when deleted
+type namedTournamentEntry struct {
+	TournamentEntry
+	BotName    string
+	PlayerName string
+}
+
+/*
+	Rank players of a tournament by points, then Buchholz, then wins
+*/
+func TournamentStandings(db *gorm.DB, tid int32) ([]Standing, error) {
+	entries := []namedTournamentEntry{}
+	err := db.Model(&TournamentEntry{}).
+		Select("tournament_entry.*, bot.name AS bot_name, player.name AS player_name").
+		Joins("LEFT JOIN bot ON bot.bid = tournament_entry.bot_id").
+		Joins("LEFT JOIN player ON player.pid = tournament_entry.player_id").
+		Where("tournament_entry.tournament_id = ?", tid).
+		Scan(&entries).Error
+	if err != nil {
+		fmt.Printf("Error TournamentStandings(%v): %v\n", tid, err)
+		return nil, err
+	}
+	matches := GetTournamentMatches(db, tid, 0)
+	if matches == nil {
+		return nil, fmt.Errorf("cannot get matches of tournament %v", tid)
+	}
+
+	players := map[int32]*Standing{}
+	standings := make([]Standing, len(entries))
+	for i, e := range entries {
+		standings[i] = Standing{PlayerId: e.PlayerId, PlayerName: e.PlayerName, BotId: e.BotId, BotName: e.BotName, BotVersion: e.BotVersion}
+		players[e.PlayerId] = &standings[i]
+	}
+	// players of purged bots are left out
+	score := func(pid int32, points float64) {
+		s := players[pid]
+		if s == nil {
+			return
+		}
+		s.Points += points
+		switch points {
+		case 1:
+			s.Wins++
+		case 0.5:
+			s.Draws++
+		default:
+			s.Losses++
+		}
+	}
+	for _, m := range matches {
+		switch m.Result {
+		case MatchBye:
+			if s := players[m.PlayerA]; s != nil {
+				s.Points++
+				s.Byes++
+			}
+		case MatchWinA:
+			score(m.PlayerA, 1)
+			score(m.PlayerB, 0)
+		case MatchWinB:
+			score(m.PlayerA, 0)
+			score(m.PlayerB, 1)
+		case MatchDraw:
+			score(m.PlayerA, 0.5)
+			score(m.PlayerB, 0.5)
+		}
+	}
+	for _, m := range matches {
+		if m.Result == MatchPending || m.Result == MatchBye {
+			continue
+		}
+		a, b := players[m.PlayerA], players[m.PlayerB]
+		if a != nil && b != nil {
+			a.Buchholz += b.Points
+			b.Buchholz += a.Points
+		}
+	}
+
+	sort.Slice(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		return a.PlayerId < b.PlayerId
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings, nil
+}
+
+// tournament entries hold bot code, they are permanently deleted with their bot
+func purgeTournamentEntries(tx *gorm.DB, bots interface{}) error {
+	return tx.Where("bot_id IN (?)", bots).Delete(&TournamentEntry{}).Error
+}
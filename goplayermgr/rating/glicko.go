@@ -0,0 +1,144 @@
+/*
+	Package rating implements Glicko-2 ratings, see
+	http://www.glicko.net/glicko/glicko2.pdf
+
+	A rating is updated after a rating period with the outcomes of the
+	matches played during the period against opponents with their rating
+	at the start of the period.
+*/
+package rating
+
+import (
+	"math"
+)
+
+// scale between Glicko and Glicko-2 ratings
+const scale = 173.7178
+
+// convergence tolerance of volatility
+const epsilon = 0.000001
+
+// constrains the change of volatility, 0.3 to 1.2
+var Tau = 0.5
+
+type Rating struct {
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+}
+
+// rating of a new player
+var Default = Rating{Rating: 1500, Deviation: 350, Volatility: 0.06}
+
+// match scores
+const (
+	Loss = 0.0
+	Draw = 0.5
+	Win  = 1.0
+)
+
+// Outcome of a match against an opponent
+type Outcome struct {
+	Opponent Rating
+	Score    float64
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func expected(mu float64, muj float64, phij float64) float64 {
+	return 1 / (1 + math.Exp(-g(phij)*(mu-muj)))
+}
+
+/*
+	Rating after a period with outcomes, the deviation grows when there is none
+*/
+func Update(r Rating, outcomes []Outcome) Rating {
+	mu := (r.Rating - 1500) / scale
+	phi := r.Deviation / scale
+	sigma := r.Volatility
+
+	if len(outcomes) == 0 {
+		return Idle(r, 1)
+	}
+
+	// estimated variance and improvement
+	var vInv, sum float64
+	for _, o := range outcomes {
+		muj := (o.Opponent.Rating - 1500) / scale
+		phij := o.Opponent.Deviation / scale
+		e := expected(mu, muj, phij)
+		vInv += g(phij) * g(phij) * e * (1 - e)
+		sum += g(phij) * (o.Score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	sigma = volatility(phi, sigma, v, delta)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+
+	return Rating{Rating: scale*mu + 1500, Deviation: scale * phi, Volatility: sigma}
+}
+
+// new volatility computed with the Illinois algorithm
+func volatility(phi float64, sigma float64, v float64, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(Tau*Tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*Tau) < 0 {
+			k++
+		}
+		B = a - k*Tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
+
+/*
+	Rating after periods without match, the deviation grows up to the one of a new player
+*/
+func Idle(r Rating, periods float64) Rating {
+	if periods <= 0 {
+		return r
+	}
+	phi := r.Deviation / scale
+	phi = math.Sqrt(phi*phi + periods*r.Volatility*r.Volatility)
+	r.Deviation = math.Min(scale*phi, Default.Deviation)
+	return r
+}
+
+// score of a match where lower is better, e.g. steps
+func Compare(a int, b int) float64 {
+	switch {
+	case a < b:
+		return Win
+	case a > b:
+		return Loss
+	}
+	return Draw
+}
@@ -0,0 +1,46 @@
+package rating_test
+
+import (
+	"math"
+	"testing"
+
+	"jc.org/playermgr/rating"
+)
+
+func near(a float64, b float64, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestUpdate(t *testing.T) {
+	// example of Glicko-2 paper
+	r := rating.Update(rating.Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}, []rating.Outcome{
+		{Opponent: rating.Rating{Rating: 1400, Deviation: 30, Volatility: 0.06}, Score: rating.Win},
+		{Opponent: rating.Rating{Rating: 1550, Deviation: 100, Volatility: 0.06}, Score: rating.Loss},
+		{Opponent: rating.Rating{Rating: 1700, Deviation: 300, Volatility: 0.06}, Score: rating.Loss},
+	})
+	if !near(r.Rating, 1464.06, 0.01) || !near(r.Deviation, 151.52, 0.01) || !near(r.Volatility, 0.05999, 0.00001) {
+		t.Errorf("Unexpected rating %+v", r)
+	}
+
+	win := rating.Update(rating.Default, []rating.Outcome{{Opponent: rating.Default, Score: rating.Win}})
+	if win.Rating <= 1500 || win.Deviation >= 350 {
+		t.Errorf("Win should raise rating and lower deviation: %+v", win)
+	}
+	draw := rating.Update(rating.Default, []rating.Outcome{{Opponent: rating.Default, Score: rating.Draw}})
+	if !near(draw.Rating, 1500, 0.0001) {
+		t.Errorf("Draw between equals should keep rating: %+v", draw)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	r := rating.Rating{Rating: 1600, Deviation: 50, Volatility: 0.06}
+	if idle := rating.Update(r, nil); idle.Rating != 1600 || idle.Deviation <= 50 {
+		t.Errorf("Deviation should grow without match: %+v", idle)
+	}
+	if idle := rating.Idle(r, 1e6); idle.Deviation != rating.Default.Deviation {
+		t.Errorf("Deviation should be bounded: %+v", idle)
+	}
+	if rating.Compare(3, 5) != rating.Win || rating.Compare(5, 5) != rating.Draw || rating.Compare(6, 5) != rating.Loss {
+		t.Errorf("Fewer steps should win")
+	}
+}
@@ -0,0 +1,348 @@
+/*
+	Package render draws a maze and the path of a bot, as an animated GIF
+	showing the bot step by step or as a SVG heatmap of visited rooms.
+
+	A path is the position of the bot before each step and after the last one,
+	a last position outside of the maze means the bot exited.
+*/
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"strings"
+
+	"jc.org/playermgr/maze"
+)
+
+// output formats
+const (
+	FormatGIF = "gif"
+	FormatSVG = "svg"
+)
+
+var ErrUnknownFormat = errors.New("unknown image format, use gif or svg")
+
+var ErrTooLarge = errors.New("image is too large")
+
+type Options struct {
+	// size of a room in pixels, walls included
+	CellSize int
+	// delay between GIF frames in 100ths of second
+	Delay int
+	// maximum number of GIF frames, longer paths are sampled
+	MaxFrames int
+}
+
+// options used when not specified
+var DefaultOptions = Options{
+	CellSize:  16,
+	Delay:     10,
+	MaxFrames: 2000,
+}
+
+// maximum width or height of an image in pixels
+var MaxImageSize = 4096
+
+// colors of the GIF palette
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	wallColor  = color.RGBA{0x21, 0x21, 0x21, 0xff}
+	entryColor = color.RGBA{0x43, 0xa0, 0x47, 0xff}
+	exitColor  = color.RGBA{0xe5, 0x39, 0x35, 0xff}
+	trailColor = color.RGBA{0xbb, 0xde, 0xfb, 0xff}
+	botColor   = color.RGBA{0x1e, 0x88, 0xe5, 0xff}
+)
+
+var palette = color.Palette{background, wallColor, entryColor, exitColor, trailColor, botColor}
+
+func withDefaults(o Options) Options {
+	if o.CellSize <= 0 {
+		o.CellSize = DefaultOptions.CellSize
+	}
+	if o.Delay <= 0 {
+		o.Delay = DefaultOptions.Delay
+	}
+	if o.MaxFrames <= 0 {
+		o.MaxFrames = DefaultOptions.MaxFrames
+	} else if o.MaxFrames < 2 {
+		// maze and last position
+		o.MaxFrames = 2
+	}
+	return o
+}
+
+/*
+	Render maze and path in format gif or svg
+*/
+func Render(w io.Writer, format string, m *maze.Maze, path []maze.Cell, opts Options) error {
+	switch format {
+	case FormatGIF:
+		return GIF(w, m, path, opts)
+	case FormatSVG:
+		return SVG(w, m, path, opts)
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+}
+
+// content type of format
+func ContentType(format string) string {
+	if format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/gif"
+}
+
+// pixel geometry of a maze
+type layout struct {
+	cell, wall    int
+	width, height int
+}
+
+func newLayout(m *maze.Maze, opts Options) (layout, error) {
+	l := layout{cell: opts.CellSize, wall: opts.CellSize / 8}
+	if l.wall < 1 {
+		l.wall = 1
+	}
+	l.width = m.Columns*l.cell + l.wall
+	l.height = m.Rows*l.cell + l.wall
+	if l.width > MaxImageSize || l.height > MaxImageSize {
+		return l, fmt.Errorf("%w: %dx%d pixels, maximum is %d", ErrTooLarge, l.width, l.height, MaxImageSize)
+	}
+	return l, nil
+}
+
+// inside of room c, without walls
+func (l layout) room(c maze.Cell) image.Rectangle {
+	x, y := c.Column*l.cell+l.wall, c.Row*l.cell+l.wall
+	return image.Rect(x, y, x+l.cell-l.wall, y+l.cell-l.wall)
+}
+
+// wall on side d of room c, without corners
+func (l layout) side(c maze.Cell, d maze.Direction) image.Rectangle {
+	r := l.room(c)
+	switch d {
+	case maze.Up:
+		return image.Rect(r.Min.X, r.Min.Y-l.wall, r.Max.X, r.Min.Y)
+	case maze.Down:
+		return image.Rect(r.Min.X, r.Max.Y, r.Max.X, r.Max.Y+l.wall)
+	case maze.Left:
+		return image.Rect(r.Min.X-l.wall, r.Min.Y, r.Min.X, r.Max.Y)
+	}
+	return image.Rect(r.Max.X, r.Min.Y, r.Max.X+l.wall, r.Max.Y)
+}
+
+func sideColor(w maze.WallType) (color.RGBA, bool) {
+	switch w {
+	case maze.Wall:
+		return wallColor, true
+	case maze.Entry:
+		return entryColor, true
+	case maze.Exit:
+		return exitColor, true
+	}
+	return background, false
+}
+
+func fill(img *image.Paletted, r image.Rectangle, c color.Color) {
+	index := uint8(palette.Index(c))
+	r = r.Intersect(img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetColorIndex(x, y, index)
+		}
+	}
+}
+
+// first frame: maze without bot
+func (l layout) drawMaze(m *maze.Maze) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, l.width, l.height), palette)
+	for i := 0; i <= m.Rows; i++ {
+		for j := 0; j <= m.Columns; j++ {
+			fill(img, image.Rect(j*l.cell, i*l.cell, j*l.cell+l.wall, i*l.cell+l.wall), wallColor)
+		}
+	}
+	for i := 0; i < m.Rows; i++ {
+		for j := 0; j < m.Columns; j++ {
+			c := maze.Cell{Row: i, Column: j}
+			for _, d := range maze.Directions {
+				if col, ok := sideColor(m.Room(c).Side(d)); ok {
+					fill(img, l.side(c, d), col)
+				}
+			}
+		}
+	}
+	return img
+}
+
+/*
+	Animated GIF of the bot moving along path, a frame per step. Frames after
+	the first one only hold the rooms left and entered by the bot.
+*/
+func GIF(w io.Writer, m *maze.Maze, path []maze.Cell, opts Options) error {
+	opts = withDefaults(opts)
+	l, err := newLayout(m, opts)
+	if err != nil {
+		return err
+	}
+
+	anim := &gif.GIF{}
+	add := func(img *image.Paletted) {
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, opts.Delay)
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+	}
+
+	// canvas holds the whole picture, frames are the changed part of it
+	canvas := l.drawMaze(m)
+	add(crop(canvas, canvas.Rect))
+
+	// when path is too long, several steps are drawn per frame
+	stride := (len(path) + opts.MaxFrames - 2) / (opts.MaxFrames - 1)
+	if stride < 1 {
+		stride = 1
+	}
+
+	bounds := image.Rectangle{}
+	var previous *maze.Cell
+	for i, c := range path {
+		if previous != nil {
+			fill(canvas, l.room(*previous), trailColor)
+			bounds = bounds.Union(l.room(*previous))
+			for _, d := range maze.Directions {
+				if previous.Next(d) == c && m.Contains(c) {
+					fill(canvas, l.side(*previous, d), trailColor)
+				}
+			}
+			previous = nil
+		}
+		if m.Contains(c) {
+			fill(canvas, l.room(c), botColor)
+			bounds = bounds.Union(l.room(c))
+			pos := c
+			previous = &pos
+		}
+		if (i+1)%stride != 0 && i != len(path)-1 {
+			continue
+		}
+		if bounds.Empty() {
+			continue
+		}
+		add(crop(canvas, bounds))
+		bounds = image.Rectangle{}
+	}
+
+	return gif.EncodeAll(w, anim)
+}
+
+// copy of part r of img
+func crop(img *image.Paletted, r image.Rectangle) *image.Paletted {
+	frame := image.NewPaletted(r, img.Palette)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(frame.Pix[frame.PixOffset(r.Min.X, y):frame.PixOffset(r.Max.X, y)], img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)])
+	}
+	return frame
+}
+
+/*
+	Static SVG of the maze with a heatmap of visits of each room and the path of the bot
+*/
+func SVG(w io.Writer, m *maze.Maze, path []maze.Cell, opts Options) error {
+	opts = withDefaults(opts)
+	l, err := newLayout(m, opts)
+	if err != nil {
+		return err
+	}
+
+	visits := make([][]int, m.Rows)
+	for i := range visits {
+		visits[i] = make([]int, m.Columns)
+	}
+	max := 0
+	for _, c := range path {
+		if m.Contains(c) {
+			visits[c.Row][c.Column]++
+			if visits[c.Row][c.Column] > max {
+				max = visits[c.Row][c.Column]
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", l.width, l.height, l.width, l.height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="%s"/>`+"\n", l.width, l.height, hex(background))
+
+	// heatmap, opacity grows with number of visits
+	for i := 0; i < m.Rows; i++ {
+		for j := 0; j < m.Columns; j++ {
+			if visits[i][j] == 0 {
+				continue
+			}
+			r := l.room(maze.Cell{Row: i, Column: j})
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" fill-opacity="%.2f"><title>%d</title></rect>`+"\n",
+				r.Min.X, r.Min.Y, r.Dx(), r.Dy(), hex(exitColor), 0.15+0.85*float64(visits[i][j])/float64(max), visits[i][j])
+		}
+	}
+
+	// walls, entry and exit
+	for i := 0; i <= m.Rows; i++ {
+		for j := 0; j <= m.Columns; j++ {
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", j*l.cell, i*l.cell, l.wall, l.wall, hex(wallColor))
+		}
+	}
+	for i := 0; i < m.Rows; i++ {
+		for j := 0; j < m.Columns; j++ {
+			c := maze.Cell{Row: i, Column: j}
+			for _, d := range maze.Directions {
+				// shared sides are drawn once
+				if (d == maze.Down && i < m.Rows-1) || (d == maze.Right && j < m.Columns-1) {
+					continue
+				}
+				if col, ok := sideColor(m.Room(c).Side(d)); ok {
+					r := l.side(c, d)
+					fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy(), hex(col))
+				}
+			}
+		}
+	}
+
+	// path through room centers
+	if len(path) > 0 {
+		points := make([]string, len(path))
+		for i, c := range path {
+			center := l.center(c)
+			points[i] = fmt.Sprintf("%d,%d", center.X, center.Y)
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%d" stroke-linejoin="round" stroke-opacity="0.8"/>`+"\n",
+			strings.Join(points, " "), hex(botColor), l.wall)
+	}
+	b.WriteString("</svg>\n")
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// center of room c, rooms outside of the maze are clamped to the border
+func (l layout) center(c maze.Cell) image.Point {
+	x := c.Column*l.cell + (l.cell+l.wall)/2
+	y := c.Row*l.cell + (l.cell+l.wall)/2
+	return image.Pt(clamp(x, 0, l.width), clamp(y, 0, l.height))
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
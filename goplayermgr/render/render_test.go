@@ -0,0 +1,78 @@
+package render_test
+
+import (
+	"bytes"
+	"errors"
+	"image/gif"
+	"strings"
+	"testing"
+
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/render"
+)
+
+var corridor = []string{
+	"+-+-+-+",
+	"x     X",
+	"+-+-+-+",
+}
+
+// path of a bot going right, it exits after 3 moves
+var path = []maze.Cell{{Row: 0, Column: 0}, {Row: 0, Column: 1}, {Row: 0, Column: 1}, {Row: 0, Column: 2}, {Row: 0, Column: 3}}
+
+func TestGIF(t *testing.T) {
+	m, _ := maze.Parse(corridor)
+
+	var b bytes.Buffer
+	err := render.GIF(&b, m, path, render.Options{CellSize: 8})
+	if err != nil {
+		t.Fatalf("Cannot render GIF: %v", err)
+	}
+	anim, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatalf("Cannot decode GIF: %v", err)
+	}
+	if len(anim.Image) != len(path)+1 {
+		t.Errorf("Expected a frame per position and maze, got %v", len(anim.Image))
+	}
+	if anim.Image[0].Bounds().Dx() != 3*8+1 || anim.Image[0].Bounds().Dy() != 8+1 {
+		t.Errorf("Unexpected size %v", anim.Image[0].Bounds())
+	}
+	if anim.Image[2].Bounds().Dx() >= anim.Image[0].Bounds().Dx() {
+		t.Errorf("Frames should only hold changed rooms: %v", anim.Image[2].Bounds())
+	}
+
+	b.Reset()
+	render.GIF(&b, m, path, render.Options{MaxFrames: 3})
+	anim, _ = gif.DecodeAll(&b)
+	if len(anim.Image) != 3 {
+		t.Errorf("Expected frames to be sampled, got %v", len(anim.Image))
+	}
+}
+
+func TestSVG(t *testing.T) {
+	m, _ := maze.Parse(corridor)
+
+	var b bytes.Buffer
+	err := render.Render(&b, render.FormatSVG, m, path, render.Options{})
+	if err != nil {
+		t.Fatalf("Cannot render SVG: %v", err)
+	}
+	svg := b.String()
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, "<polyline") {
+		t.Errorf("Unexpected SVG: %v", svg)
+	}
+	// room visited twice is the most opaque
+	if !strings.Contains(svg, `fill-opacity="1.00"><title>2</title>`) || !strings.Contains(svg, `<title>1</title>`) {
+		t.Errorf("Unexpected heatmap: %v", svg)
+	}
+
+	err = render.Render(&b, "png", m, path, render.Options{})
+	if !errors.Is(err, render.ErrUnknownFormat) {
+		t.Errorf("Expected unknown format: %v", err)
+	}
+	err = render.Render(&b, render.FormatGIF, maze.New(10, 1000), nil, render.Options{})
+	if !errors.Is(err, render.ErrTooLarge) {
+		t.Errorf("Expected too large image: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package tournament
+
+import (
+	"math"
+
+	"jc.org/playermgr/model"
+)
+
+// pairings tried by a Swiss round before falling back to greedy pairing
+var MaxPairingAttempts = 10000
+
+// Pair of players meeting in a round, B is 0 for a bye
+type Pair struct {
+	A int32
+	B int32
+}
+
+/*
+	Number of rounds of a tournament between players: every player meets every
+	other in a round-robin, a Swiss tournament has log2(players) rounds unless
+	its rounds are set, never more than a round-robin
+*/
+func Rounds(format string, players int, rounds int) int {
+	if players < 2 {
+		return 0
+	}
+	all := players - 1
+	if players%2 == 1 {
+		all = players
+	}
+	if format == model.TournamentRoundRobin {
+		return all
+	}
+	if rounds <= 0 {
+		rounds = int(math.Ceil(math.Log2(float64(players))))
+	}
+	if rounds > all {
+		rounds = all
+	}
+	return rounds
+}
+
+/*
+	Pairs of a round-robin round with the circle method: the first player
+	stays in place and the others rotate by one each round. With an odd number
+	of players, the player meeting the missing one gets a bye. round starts at 1.
+*/
+func RoundRobin(players []int32, round int) []Pair {
+	circle := append([]int32{}, players...)
+	if len(circle)%2 == 1 {
+		circle = append(circle, 0)
+	}
+	n := len(circle)
+	if n < 2 {
+		return nil
+	}
+
+	k := (round - 1) % (n - 1)
+	rest := append([]int32{}, circle[1:]...)
+	circle = append(circle[:1], append(rest[len(rest)-k:], rest[:len(rest)-k]...)...)
+
+	pairs := []Pair{}
+	for i := 0; i < n/2; i++ {
+		a, b := circle[i], circle[n-1-i]
+		if a == 0 {
+			a, b = b, a
+		}
+		pairs = append(pairs, Pair{A: a, B: b})
+	}
+	return pairs
+}
+
+/*
+	Pairs of a Swiss round: players ranked by standings meet the next ranked
+	player they have not met yet. With an odd number of players, the lowest
+	ranked player without a bye gets one. Players meet again only when there
+	is no other way, or when no pairing without rematch is found within
+	MaxPairingAttempts: players are then paired greedily.
+*/
+func Swiss(ranked []int32, met func(a int32, b int32) bool, byes map[int32]bool) []Pair {
+	players := append([]int32{}, ranked...)
+	var bye *Pair
+	if len(players)%2 == 1 {
+		i := len(players) - 1
+		for i > 0 && byes[players[i]] {
+			i--
+		}
+		bye = &Pair{A: players[i]}
+		players = append(players[:i], players[i+1:]...)
+	}
+
+	attempts := MaxPairingAttempts
+	pairs := pairUnmet(players, met, &attempts)
+	if pairs == nil {
+		pairs = pairGreedy(players, met)
+	}
+	if bye != nil {
+		pairs = append(pairs, *bye)
+	}
+	return pairs
+}
+
+/*
+	Pair players who have not met, nil when it is not possible or when
+	more than attempts pairings were tried
+*/
+func pairUnmet(players []int32, met func(a int32, b int32) bool, attempts *int) []Pair {
+	if len(players) == 0 {
+		return []Pair{}
+	}
+	a := players[0]
+	for i := 1; i < len(players); i++ {
+		b := players[i]
+		if met(a, b) {
+			continue
+		}
+		if *attempts <= 0 {
+			return nil
+		}
+		*attempts--
+		rest := append(append([]int32{}, players[1:i]...), players[i+1:]...)
+		if pairs := pairUnmet(rest, met, attempts); pairs != nil {
+			return append([]Pair{{A: a, B: b}}, pairs...)
+		}
+	}
+	return nil
+}
+
+// each player in rank order meets the next player it has not met, or the next one
+func pairGreedy(players []int32, met func(a int32, b int32) bool) []Pair {
+	rest := append([]int32{}, players...)
+	pairs := []Pair{}
+	for len(rest) > 1 {
+		a, j := rest[0], 1
+		for i := 1; i < len(rest); i++ {
+			if !met(a, rest[i]) {
+				j = i
+				break
+			}
+		}
+		pairs = append(pairs, Pair{A: a, B: rest[j]})
+		rest = append(rest[1:j], rest[j+1:]...)
+	}
+	return pairs
+}
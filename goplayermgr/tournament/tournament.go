@@ -0,0 +1,314 @@
+/*
+	Package tournament schedules and plays tournament rounds between the bot
+	revisions registered by players, see model.Tournament.
+*/
+package tournament
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"jc.org/playermgr/engine"
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+	"jc.org/playermgr/rating"
+)
+
+// limits of tournament games, engine defaults when zero
+var Limits = engine.Limits{}
+
+// state of a side which could not play, e.g. its bot does not load
+const stateForfeit = "forfeit"
+
+/*
+	Create an open tournament over mazes of suite, all of them when names is empty
+*/
+func Create(db *gorm.DB, t *model.Tournament, suite *maze.Suite, names []string) (*model.Tournament, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+	mazes := []model.TournamentMaze{}
+	for _, sm := range suite.Mazes {
+		if len(names) > 0 && !selected[sm.Name] {
+			continue
+		}
+		delete(selected, sm.Name)
+		mazes = append(mazes, model.TournamentMaze{Name: sm.Name, Hash: sm.Maze.Hash(), Maze: strings.Join(sm.Maze.Render(), "\n")})
+	}
+	for _, name := range names {
+		if !selected[name] {
+			continue
+		}
+		return nil, fmt.Errorf("%w: maze %q of suite %v", maze.ErrUnknownSuite, name, suite.Name)
+	}
+	t.Suite = suite.Name
+	return model.AddTournament(db, t, mazes)
+}
+
+/*
+	Close entries of an open tournament and start it, a tournament with less
+	than 2 entries is cancelled
+*/
+func Start(db *gorm.DB, tid int32, now time.Time) (*model.Tournament, error) {
+	t := model.GetTournament(db, tid)
+	if t == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if t.State != model.TournamentOpen {
+		return t, model.ErrTournamentState
+	}
+	entries := model.GetTournamentEntries(db, tid)
+	if entries == nil {
+		return nil, fmt.Errorf("cannot get entries of tournament %v", tid)
+	}
+
+	var err error
+	if len(entries) < 2 {
+		err = model.CancelTournament(db, tid, now)
+	} else {
+		err = model.StartTournament(db, tid, Rounds(t.Format, len(entries), t.Rounds), now)
+	}
+	if err != nil {
+		return t, err
+	}
+	return model.GetTournament(db, tid), nil
+}
+
+/*
+	Start open tournaments whose entry deadline passed at date now,
+	returns the running ones
+*/
+func StartDue(db *gorm.DB, now time.Time) []model.Tournament {
+	started := []model.Tournament{}
+	for _, t := range model.GetTournaments(db, model.TournamentOpen) {
+		if t.EntryDeadline.After(now.UTC()) {
+			continue
+		}
+		// another server may have started it
+		s, err := Start(db, t.Tid, now)
+		if err != nil {
+			continue
+		}
+		if s.State == model.TournamentRunning {
+			started = append(started, *s)
+		}
+	}
+	return started
+}
+
+/*
+	Play pending matches of the current round of a running tournament, or schedule
+	and play the next round when the current one is over. The tournament is finished
+	after its last round. progress gets played and total matches of the round.
+*/
+func PlayRound(ctx context.Context, db *gorm.DB, tid int32, progress func(done int, total int)) (*model.Tournament, error) {
+	t := model.GetTournament(db, tid)
+	if t == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if t.State != model.TournamentRunning {
+		return t, model.ErrTournamentState
+	}
+
+	pending, err := pendingMatches(db, tid, t.Round)
+	if err != nil {
+		return t, err
+	}
+	if len(pending) == 0 && t.Round < t.Rounds {
+		err = schedule(db, t)
+		if err != nil {
+			return t, err
+		}
+		t.Round++
+		pending, err = pendingMatches(db, tid, t.Round)
+		if err != nil {
+			return t, err
+		}
+	}
+
+	err = play(ctx, db, t, pending, progress)
+	if err != nil {
+		return t, err
+	}
+	if t.Round >= t.Rounds {
+		err = model.FinishTournament(db, tid, time.Now())
+		if err != nil {
+			return t, err
+		}
+	}
+	return model.GetTournament(db, tid), nil
+}
+
+/*
+	Start a tournament if it is still open and play all its rounds
+*/
+func Run(ctx context.Context, db *gorm.DB, tid int32, progress func(done int, total int)) (*model.Tournament, error) {
+	t, err := Start(db, tid, time.Now())
+	if err != nil && !errors.Is(err, model.ErrTournamentState) {
+		return t, err
+	}
+	for t != nil && t.State == model.TournamentRunning {
+		t, err = PlayRound(ctx, db, tid, progress)
+		if err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+func pendingMatches(db *gorm.DB, tid int32, round int) ([]model.TournamentMatch, error) {
+	if round == 0 {
+		return nil, nil
+	}
+	matches := model.GetTournamentMatches(db, tid, round)
+	if matches == nil {
+		return nil, fmt.Errorf("cannot get matches of tournament %v", tid)
+	}
+	pending := []model.TournamentMatch{}
+	for _, m := range matches {
+		if m.Result == model.MatchPending {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// pair players of next round, rounds go through the maze pool in order
+func schedule(db *gorm.DB, t *model.Tournament) error {
+	round := t.Round + 1
+	entries := model.GetTournamentEntries(db, t.Tid)
+	mazes := model.GetTournamentMazes(db, t.Tid)
+	if entries == nil || len(mazes) == 0 {
+		return fmt.Errorf("cannot get entries and mazes of tournament %v", t.Tid)
+	}
+	bots := map[int32]int32{}
+	players := []int32{}
+	for _, e := range entries {
+		bots[e.PlayerId] = e.BotId
+		players = append(players, e.PlayerId)
+	}
+
+	var pairs []Pair
+	if t.Format == model.TournamentSwiss {
+		standings, err := model.TournamentStandings(db, t.Tid)
+		if err != nil {
+			return err
+		}
+		ranked := []int32{}
+		for _, s := range standings {
+			ranked = append(ranked, s.PlayerId)
+		}
+		met, byes := map[Pair]bool{}, map[int32]bool{}
+		for _, m := range model.GetTournamentMatches(db, t.Tid, 0) {
+			met[Pair{A: m.PlayerA, B: m.PlayerB}] = true
+			met[Pair{A: m.PlayerB, B: m.PlayerA}] = true
+			if m.Result == model.MatchBye {
+				byes[m.PlayerA] = true
+			}
+		}
+		pairs = Swiss(ranked, func(a int32, b int32) bool { return met[Pair{A: a, B: b}] }, byes)
+	} else {
+		pairs = RoundRobin(players, round)
+	}
+
+	matches := []model.TournamentMatch{}
+	for _, p := range pairs {
+		m := model.TournamentMatch{MazePosition: (round - 1) % len(mazes), PlayerA: p.A, BotA: bots[p.A], PlayerB: p.B, BotB: bots[p.B]}
+		if p.B == 0 {
+			m.Result = model.MatchBye
+		}
+		matches = append(matches, m)
+	}
+	return model.ScheduleTournamentRound(db, t.Tid, round, matches)
+}
+
+// play and record matches in the maze of the round
+func play(ctx context.Context, db *gorm.DB, t *model.Tournament, matches []model.TournamentMatch, progress func(done int, total int)) error {
+	if len(matches) == 0 {
+		return nil
+	}
+	entries := map[int32]*model.TournamentEntry{}
+	for _, e := range model.GetTournamentEntries(db, t.Tid) {
+		e := e
+		entries[e.PlayerId] = &e
+	}
+	mazes := model.GetTournamentMazes(db, t.Tid)
+	if len(mazes) == 0 {
+		return fmt.Errorf("cannot get mazes of tournament %v", t.Tid)
+	}
+	pool := mazes[matches[0].MazePosition]
+	m, err := maze.ParseString(pool.Maze)
+	if err != nil {
+		return err
+	}
+
+	for i := range matches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		match := &matches[i]
+		// same seed for both bots of a match
+		opts := engine.Options{Limits: Limits, Seed: int64(match.Mid)}
+		match.GameA, match.StateA, match.StepsA, err = playSide(db, entries[match.PlayerA], m, pool.Name, opts)
+		if err != nil {
+			return err
+		}
+		match.GameB, match.StateB, match.StepsB, err = playSide(db, entries[match.PlayerB], m, pool.Name, opts)
+		if err != nil {
+			return err
+		}
+		match.Result = result(match)
+		now := time.Now().UTC()
+		match.PlayedAt = &now
+		err = model.RecordTournamentMatch(db, match)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(i+1, len(matches))
+		}
+	}
+	return nil
+}
+
+/*
+	Play a game of the registered revision of a player, a missing entry
+	or a bot which does not load forfeits the game
+*/
+func playSide(db *gorm.DB, entry *model.TournamentEntry, m *maze.Maze, name string, opts engine.Options) (int32, string, int, error) {
+	if entry == nil {
+		return 0, stateForfeit, 0, nil
+	}
+	game, result, err := engine.PlayCode(db, entry.Code(), m, name, opts, nil)
+	if err != nil && result == nil {
+		return 0, stateForfeit, 0, nil
+	}
+	if err != nil {
+		return 0, "", 0, err
+	}
+	return game.Gid, game.State, game.Steps, nil
+}
+
+// fewer steps wins, failing loses, draw when both fail
+func result(m *model.TournamentMatch) string {
+	successA, successB := m.StateA == engine.Success, m.StateB == engine.Success
+	switch {
+	case successA && successB:
+		switch rating.Compare(m.StepsA, m.StepsB) {
+		case rating.Win:
+			return model.MatchWinA
+		case rating.Loss:
+			return model.MatchWinB
+		}
+	case successA:
+		return model.MatchWinA
+	case successB:
+		return model.MatchWinB
+	}
+	return model.MatchDraw
+}
@@ -0,0 +1,165 @@
+package tournament_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"jc.org/playermgr/maze"
+	"jc.org/playermgr/model"
+	"jc.org/playermgr/tournament"
+)
+
+var InMemoryDSN = "file::memory:"
+
+func TestRoundRobin(t *testing.T) {
+	for _, n := range []int{2, 3, 4, 5} {
+		players := []int32{}
+		for i := 1; i <= n; i++ {
+			players = append(players, int32(i))
+		}
+		met := map[tournament.Pair]int{}
+		byes := map[int32]int{}
+		rounds := tournament.Rounds(model.TournamentRoundRobin, n, 0)
+		for round := 1; round <= rounds; round++ {
+			seen := map[int32]bool{}
+			for _, p := range tournament.RoundRobin(players, round) {
+				if seen[p.A] || seen[p.B] {
+					t.Errorf("Player plays twice in round %v of %v: %+v", round, n, p)
+				}
+				seen[p.A], seen[p.B] = true, true
+				if p.B == 0 {
+					byes[p.A]++
+					continue
+				}
+				if p.A > p.B {
+					p.A, p.B = p.B, p.A
+				}
+				met[p]++
+			}
+		}
+		if len(met) != n*(n-1)/2 {
+			t.Errorf("Every pair of %v players should meet: %v", n, met)
+		}
+		for p, count := range met {
+			if count != 1 {
+				t.Errorf("Pair %+v should meet once, met %v times", p, count)
+			}
+		}
+		if n%2 == 1 && len(byes) != n {
+			t.Errorf("Every player of %v should get a bye: %v", n, byes)
+		}
+	}
+	if tournament.Rounds(model.TournamentSwiss, 8, 0) != 3 || tournament.Rounds(model.TournamentSwiss, 3, 10) != 3 {
+		t.Errorf("Unexpected Swiss rounds")
+	}
+}
+
+func TestSwiss(t *testing.T) {
+	met := func(a int32, b int32) bool { return (a == 1 && b == 2) || (a == 2 && b == 1) }
+	pairs := tournament.Swiss([]int32{1, 2, 3, 4, 5}, met, map[int32]bool{5: true})
+	expected := []tournament.Pair{{A: 1, B: 3}, {A: 2, B: 5}, {A: 4}}
+	if len(pairs) != len(expected) {
+		t.Fatalf("Unexpected pairs %+v", pairs)
+	}
+	for i := range expected {
+		if pairs[i] != expected[i] {
+			t.Errorf("Players should not meet again and 4 should get the bye: %+v", pairs)
+		}
+	}
+
+	// players 38 to 40 have only not met each other, pairing without rematch is impossible
+	// and an exhaustive search would try every pairing of the others
+	ranked := []int32{}
+	for i := int32(1); i <= 40; i++ {
+		ranked = append(ranked, i)
+	}
+	met = func(a int32, b int32) bool { return (a > 37) != (b > 37) }
+	done := make(chan []tournament.Pair)
+	go func() { done <- tournament.Swiss(ranked, met, map[int32]bool{}) }()
+	select {
+	case pairs = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Swiss pairing should be bounded")
+	}
+	seen := map[int32]bool{}
+	for _, p := range pairs {
+		if seen[p.A] || seen[p.B] || p.B == 0 {
+			t.Errorf("Every player should play once: %+v", pairs)
+		}
+		seen[p.A], seen[p.B] = true, true
+	}
+	if len(pairs) != 20 {
+		t.Errorf("Unexpected greedy pairs %+v", pairs)
+	}
+}
+
+func TestRun(t *testing.T) {
+	db := model.ConnectToDB(InMemoryDSN)
+	corridor, _ := maze.ParseString("+-+-+-+\nx     X\n+-+-+-+")
+	suite := &maze.Suite{Name: "corridors", Mazes: []maze.SuiteMaze{{Name: "corridor", Maze: corridor}}}
+
+	now := time.Now().UTC()
+	cup, err := tournament.Create(db, &model.Tournament{Name: "Cup", Format: model.TournamentRoundRobin, EntryDeadline: now.Add(time.Hour)}, suite, nil)
+	if err != nil || cup.State != model.TournamentOpen {
+		t.Fatalf("Cannot create tournament %+v %v", cup, err)
+	}
+	if _, err := tournament.Create(db, &model.Tournament{Name: "Bad", Format: model.TournamentSwiss}, suite, []string{"nowhere"}); !errors.Is(err, maze.ErrUnknownSuite) {
+		t.Errorf("Unknown maze should fail: %v", err)
+	}
+
+	codes := map[string]string{
+		"Right":  "function executeStep(room) { return { action: 'move', direction: 'right' }; }",
+		"Left":   "function executeStep(room) { return { action: 'move', direction: 'left' }; }",
+		"Broken": "function executeStep(room) {",
+	}
+	players, bots := map[string]int32{}, map[string]int32{}
+	for _, name := range []string{"Right", "Left", "Broken"} {
+		p := model.AddPlayer(db, "Cup"+name)
+		b := model.AddBot(db, p.Pid, name, "bot.js", codes[name])
+		players[name], bots[name] = p.Pid, b.Bid
+		if _, err := model.RegisterTournamentEntry(db, cup.Tid, model.GetBotCode(db, p.Pid, b.Bid), now); err != nil {
+			t.Fatalf("Cannot register %v: %v", name, err)
+		}
+	}
+	if _, err := model.RegisterTournamentEntry(db, cup.Tid, model.GetBotCode(db, players["Right"], bots["Right"]), now.Add(2*time.Hour)); !errors.Is(err, model.ErrEntriesClosed) {
+		t.Errorf("Entries after deadline should fail: %v", err)
+	}
+
+	if started := tournament.StartDue(db, now); len(started) != 0 {
+		t.Errorf("Tournament should not start before its deadline")
+	}
+	done, err := tournament.Run(context.Background(), db, cup.Tid, nil)
+	if err != nil || done.State != model.TournamentFinished || done.Rounds != 3 || done.Round != 3 {
+		t.Fatalf("Tournament should be finished %+v %v", done, err)
+	}
+
+	// 3 matches and a bye for each player
+	matches := model.GetTournamentMatches(db, cup.Tid, 0)
+	if len(matches) != 6 {
+		t.Errorf("Unexpected matches %+v", matches)
+	}
+	standings, err := model.TournamentStandings(db, cup.Tid)
+	if err != nil || len(standings) != 3 {
+		t.Fatalf("Unexpected standings %+v %v", standings, err)
+	}
+	first, last := standings[0], standings[2]
+	if first.PlayerId != players["Right"] || first.Points != 3 || first.Wins != 2 || first.Byes != 1 || first.BotName != "Right" {
+		t.Errorf("Right should win all its matches %+v", first)
+	}
+	// failing and forfeit draw, failing loses against Right
+	if last.Points != 1.5 || last.Draws != 1 || last.Losses != 1 {
+		t.Errorf("Unexpected last standing %+v", last)
+	}
+	if _, err := tournament.PlayRound(context.Background(), db, cup.Tid, nil); !errors.Is(err, model.ErrTournamentState) {
+		t.Errorf("Finished tournament should not play: %v", err)
+	}
+
+	// Swiss tournament without enough entries is cancelled
+	empty, _ := tournament.Create(db, &model.Tournament{Name: "Empty", Format: model.TournamentSwiss, EntryDeadline: now.Add(-time.Minute)}, suite, []string{"corridor"})
+	tournament.StartDue(db, now)
+	if s := model.GetTournament(db, empty.Tid); s.State != model.TournamentCancelled {
+		t.Errorf("Tournament without entries should be cancelled %+v", s)
+	}
+}
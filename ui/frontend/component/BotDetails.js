@@ -38,7 +38,8 @@ export default function BotDetails(props) {
     const handleDelete = (event, bot_id) => {
         LOGGER.info(`Delete bot ${bot_id}`);
         playerService
-            .delete("api/players/" + props.playerid + "/bot/" + bot_id)
+            // bot must not have changed since it was loaded
+            .delete("api/players/" + props.playerid + "/bot/" + bot_id, { headers: { 'If-Match': '"' + bot.version + '"' } })
             .then((response) => {
                 LOGGER.info(`Bot ${bot_id} deleted.`);
                 props.reload();
@@ -78,7 +78,8 @@ export default function PlayerDetails(props) {
     const handleDelete = (event, player_id) => {
         LOGGER.info(`Delete player ${player_id}`);
         playerService
-            .delete("api/players/" + player_id)
+            // player must not have changed since it was loaded
+            .delete("api/players/" + player_id, { headers: { 'If-Match': '"' + player.version + '"' } })
             .then((response) => {
                 LOGGER.info(`Player ${player_id} deleted.`);
                 props.reload();